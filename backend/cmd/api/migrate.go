@@ -0,0 +1,91 @@
+package main
+
+import (
+	"complain/internal/migrations"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `usage: api [flags] migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [n]      revert the last n migrations (default 1)
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to exactly <version> (0 reverts everything)`
+
+// runMigrate implements the "migrate" subcommand.
+func runMigrate(migrator *migrations.Migrator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", migrateUsage)
+	}
+
+	var ran []migrations.Status
+	var err error
+	switch args[0] {
+	case "up":
+		ran, err = migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("down: %q is not a positive number", args[1])
+			}
+		}
+		ran, err = migrator.Down(steps)
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("to: missing version\n%s", migrateUsage)
+		}
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil || version < 0 {
+			return fmt.Errorf("to: %q is not a valid version", args[1])
+		}
+		ran, err = migrator.To(version)
+	case "status":
+		return printMigrationStatus(migrator)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], migrateUsage)
+	}
+
+	for _, m := range ran {
+		direction := "reverted"
+		if m.AppliedAt != nil {
+			direction = "applied"
+		}
+		fmt.Printf("%s %04d_%s\n", direction, m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ran) == 0 {
+		fmt.Println("nothing to do")
+	}
+	current, err := migrator.Current()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version %d (latest %d)\n", current, migrator.Latest())
+	return nil
+}
+
+func printMigrationStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	testJWTSecret     = "jwt-secret-of-at-least-32-characters"
+	testSigningSecret = "signing-secret-of-at-least-32-characters"
+)
+
+// deployed are the settings staging and production have no defaults for.
+var deployed = map[string]string{
+	"APP_URL":          "https://complaints.example.org",
+	"CORS_ORIGINS":     "https://complaints.example.org",
+	"DATABASE_URL":     "postgres://complaints@db.internal/complaints",
+	"JWT_SECRET":       testJWTSecret,
+	"SMTP_HOST":        "smtp.example.org",
+	"SMTP_PORT":        "587",
+	"SMTP_FROM":        "complaints@example.org",
+	"MINIO_ENDPOINT":   "minio.internal:9000",
+	"MINIO_ACCESS_KEY": "complaints",
+	"MINIO_SECRET_KEY": "minio-secret",
+	"MINIO_BUCKET":     "evidence",
+}
+
+// load loads the profile with settings as environment variables on top of
+// the deployed ones. Empty settings are unset, so the profile's default
+// applies.
+func load(t *testing.T, env string, settings map[string]string) error {
+	t.Helper()
+	for key, v := range withDefaults(deployed, settings) {
+		t.Setenv(key, v)
+		if v == "" {
+			os.Unsetenv(key)
+		}
+	}
+	_, err := Load([]string{"-env", env})
+	return err
+}
+
+func TestValidate(t *testing.T) {
+	dev := map[string]string{"DATABASE_URL": "", "JWT_SECRET": "", "APP_URL": "", "CORS_ORIGINS": ""}
+	for _, tt := range []struct {
+		name     string
+		env      string
+		settings map[string]string
+		// wantErr is the key the error is about, or empty if the
+		// configuration is valid.
+		wantErr string
+	}{
+		{name: "development defaults", env: EnvDevelopment, settings: withDefaults(dev, nil)},
+		{name: "development memory storage", env: EnvDevelopment, settings: withDefaults(dev, map[string]string{"STORAGE_BACKEND": StorageMemory})},
+		{name: "development fake SMS", env: EnvDevelopment, settings: withDefaults(dev, map[string]string{"SMS_BACKEND": SMSFake})},
+		{name: "development signing with the JWT secret", env: EnvDevelopment,
+			settings: withDefaults(dev, map[string]string{"STORAGE_BACKEND": StorageMemory, "STORAGE_SIGNING_SECRET": insecureDevSecret}),
+			wantErr:  "STORAGE_SIGNING_SECRET"},
+
+		{name: "staging", env: EnvStaging},
+		{name: "production", env: EnvProduction},
+		{name: "production without a database", env: EnvProduction, settings: map[string]string{"DATABASE_URL": ""}, wantErr: "DATABASE_URL"},
+		{name: "production without a JWT secret", env: EnvProduction, settings: map[string]string{"JWT_SECRET": ""}, wantErr: "JWT_SECRET"},
+		{name: "production with the development JWT secret", env: EnvProduction, settings: map[string]string{"JWT_SECRET": insecureDevSecret}, wantErr: "JWT_SECRET"},
+		{name: "staging with a short JWT secret", env: EnvStaging, settings: map[string]string{"JWT_SECRET": "short"}, wantErr: "JWT_SECRET"},
+		{name: "production without an app URL", env: EnvProduction, settings: map[string]string{"APP_URL": ""}, wantErr: "APP_URL"},
+		{name: "production without SMTP", env: EnvProduction, settings: map[string]string{"SMTP_HOST": ""}, wantErr: "SMTP_HOST"},
+		{name: "production without an S3 bucket", env: EnvProduction, settings: map[string]string{"MINIO_BUCKET": ""}, wantErr: "MINIO_BUCKET"},
+		{name: "staging memory storage", env: EnvStaging, settings: map[string]string{"STORAGE_BACKEND": StorageMemory}, wantErr: "STORAGE_BACKEND"},
+		{name: "production fake SMS", env: EnvProduction, settings: map[string]string{"SMS_BACKEND": SMSFake}, wantErr: "SMS_BACKEND"},
+
+		{name: "production local storage", env: EnvProduction, settings: map[string]string{"STORAGE_BACKEND": StorageLocal,
+			"STORAGE_LOCAL_DIR": "/srv/evidence", "STORAGE_PUBLIC_URL": "https://api.example.org/files", "STORAGE_SIGNING_SECRET": testSigningSecret}},
+		{name: "production local storage without a signing secret", env: EnvProduction, settings: map[string]string{"STORAGE_BACKEND": StorageLocal,
+			"STORAGE_LOCAL_DIR": "/srv/evidence", "STORAGE_PUBLIC_URL": "https://api.example.org/files"},
+			wantErr: "STORAGE_SIGNING_SECRET"},
+		{name: "production local storage signed with the JWT secret", env: EnvProduction, settings: map[string]string{"STORAGE_BACKEND": StorageLocal,
+			"STORAGE_LOCAL_DIR": "/srv/evidence", "STORAGE_PUBLIC_URL": "https://api.example.org/files", "STORAGE_SIGNING_SECRET": testJWTSecret},
+			wantErr: "STORAGE_SIGNING_SECRET"},
+		{name: "staging local storage with a short signing secret", env: EnvStaging, settings: map[string]string{"STORAGE_BACKEND": StorageLocal,
+			"STORAGE_LOCAL_DIR": "/srv/evidence", "STORAGE_PUBLIC_URL": "https://api.example.org/files", "STORAGE_SIGNING_SECRET": "short"},
+			wantErr: "STORAGE_SIGNING_SECRET"},
+
+		{name: "production replies without a secret", env: EnvProduction, settings: map[string]string{"REPLY_ADDRESS": "replies@example.org"}, wantErr: "REPLY_SECRET"},
+		{name: "production replies signed with the JWT secret", env: EnvProduction,
+			settings: map[string]string{"REPLY_ADDRESS": "replies@example.org", "REPLY_SECRET": testJWTSecret}, wantErr: "REPLY_SECRET"},
+		{name: "production maildir without an authserv-id", env: EnvProduction, settings: map[string]string{"INBOUND_BACKEND": InboundMaildir,
+			"INBOUND_MAILDIR": "/var/mail/replies", "REPLY_ADDRESS": "replies@example.org", "REPLY_SECRET": testSigningSecret},
+			wantErr: "INBOUND_AUTHSERV_ID"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := load(t, tt.env, tt.settings)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Load() = %v, want a valid configuration", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr+":")):
+				t.Fatalf("Load() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfileDefaults(t *testing.T) {
+	for env, defaults := range profileDefaults {
+		for key, v := range baseDefaults {
+			if _, ok := defaults[key]; !ok {
+				t.Errorf("%s has no default for %s (%q)", env, key, v)
+			}
+		}
+	}
+	for _, env := range []string{EnvStaging, EnvProduction} {
+		for _, key := range []string{"DATABASE_URL", "JWT_SECRET", "STORAGE_SIGNING_SECRET", "APP_URL"} {
+			if v, ok := profileDefaults[env][key]; ok {
+				t.Errorf("%s has a default for %s (%q)", env, key, v)
+			}
+		}
+	}
+}
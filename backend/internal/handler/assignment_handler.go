@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Assign makes an official the one working on a complaint within the
+// caller's jurisdiction, tells them in their in-app inbox and tells the
+// citizen who is looking into it.
+func (h *ComplaintHandler) Assign(c *gin.Context) {
+	var req models.AssignComplaintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	h.assign(c, req.AssigneeID, "")
+}
+
+// Escalate hands a complaint the caller works on to another official,
+// usually a more senior one, raising its escalation level.
+func (h *ComplaintHandler) Escalate(c *gin.Context) {
+	var req models.EscalateComplaintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	h.assign(c, req.AssigneeID, req.Reason)
+}
+
+// assign gives the complaint named by the :id parameter to assigneeID,
+// escalating it if reason is set.
+func (h *ComplaintHandler) assign(c *gin.Context, assigneeID int64, reason string) {
+	id := c.Param("id")
+	complaintID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+		return
+	}
+	escalate := reason != ""
+
+	filter := repository.ComplaintFilter{ScopeUserID: complaintScope(c)}
+	complaint, err := h.Complaints.Get(complaintID, filter)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch complaint", "details": err.Error()})
+		return
+	}
+	if complaint.AssignedTo != nil && *complaint.AssignedTo == assigneeID {
+		c.JSON(http.StatusConflict, gin.H{"error": "The complaint is already assigned to this official"})
+		return
+	}
+
+	assignee, ok := h.assignee(c, complaint, assigneeID)
+	if !ok {
+		return
+	}
+	owner, err := h.Users.GetByID(complaint.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	caller, err := h.Users.GetByID(c.GetInt64("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	notifications := services.AssignedNotifications(owner, complaint, assignee.Name)
+	if assignee.ID != caller.ID {
+		notifications = append(notifications, services.AssignmentNotification(assignee, complaint, caller.Name, owner.Name, reason))
+	}
+
+	assigned, err := h.Complaints.Assign(complaintID, assignee.ID, escalate, filter, notifications)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+		case errors.Is(err, repository.ErrUnknownReference):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown assignee"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign complaint", "details": err.Error()})
+		}
+		return
+	}
+	if len(notifications) > 0 {
+		h.Notifier.Wake()
+	}
+	h.Events.Publish(services.ComplaintEvent{Type: services.EventComplaintAssigned, ComplaintID: complaintID, OwnerID: complaint.UserID, At: assigned.UpdatedAt})
+
+	action, message := "complaint.assign", "complaint assigned"
+	after := gin.H{"assigned_to": assignee.ID, "escalation_level": assigned.EscalationLevel}
+	if escalate {
+		action, message = "complaint.escalate", "complaint escalated"
+		after["reason"] = reason
+	}
+	auditChange(c, action, "complaint", id,
+		gin.H{"assigned_to": complaint.AssignedTo, "escalation_level": complaint.EscalationLevel}, after)
+	c.JSON(http.StatusOK, gin.H{"message": message, "complaint": assigned})
+}
+
+// assignee loads the official a complaint is to be assigned to and checks
+// they could work on it: they are active, may update complaints, and the
+// complaint is within their jurisdiction and visible to them. On failure it
+// writes the response and returns false.
+func (h *ComplaintHandler) assignee(c *gin.Context, complaint models.Complaint, userID int64) (models.User, bool) {
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown assignee"})
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return user, false
+	}
+	perms, err := h.Roles.Permissions(user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions", "details": err.Error()})
+		return user, false
+	}
+	if !user.IsActive || !perms[services.PermComplaintUpdate] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The assignee cannot work on complaints"})
+		return user, false
+	}
+	if !complaint.IsPublic && !perms[services.PermComplaintViewPrivate] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The assignee cannot see private complaints"})
+		return user, false
+	}
+	if perms[services.PermComplaintScopeAll] {
+		return user, true
+	}
+	_, err = h.Complaints.Get(complaint.ID, repository.ComplaintFilter{ScopeUserID: user.ID})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The complaint is outside %s's jurisdiction", user.Name)})
+		return user, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch complaint", "details": err.Error()})
+		return user, false
+	}
+	return user, true
+}
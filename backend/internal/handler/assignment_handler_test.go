@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAssignComplaint(t *testing.T) {
+	env := newTestEnv(t)
+	env.Complaints.Jurisdictions[2] = repository.Jurisdiction{CategoryIDs: []int{1}}
+	inside, outside := env.fileComplaint(t, 1), env.fileComplaint(t, 2)
+	events := services.NewMemoryEventBus()
+	subscription := events.Subscribe()
+	defer subscription.Close()
+	r := env.complaintRoutes(t, 1, events)
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		assigneeID int64
+		want       int
+	}{
+		{"citizen", "/complaints/1/assign", 3, http.StatusBadRequest},
+		{"unknown user", "/complaints/1/assign", 9, http.StatusBadRequest},
+		{"outside jurisdiction", "/complaints/2/assign", 2, http.StatusBadRequest},
+		{"missing complaint", "/complaints/7/assign", 2, http.StatusNotFound},
+	} {
+		if w := serve(r, "POST", tc.path, models.AssignComplaintRequest{AssigneeID: tc.assigneeID}); w.Code != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	if w := serve(r, "POST", "/complaints/1/assign", models.AssignComplaintRequest{AssigneeID: 2}); w.Code != http.StatusOK {
+		t.Fatalf("assign: %d %s", w.Code, w.Body)
+	}
+	select {
+	case event := <-subscription.Events:
+		if event.Type != services.EventComplaintAssigned || event.ComplaintID != inside.ID || event.OwnerID != 3 {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	default:
+		t.Fatal("no event published for the assignment")
+	}
+	if w := serve(r, "POST", "/complaints/1/assign", models.AssignComplaintRequest{AssigneeID: 2}); w.Code != http.StatusConflict {
+		t.Fatalf("assigning again: %d, want 409", w.Code)
+	}
+	complaint, _ := env.Complaints.Get(inside.ID, repository.ComplaintFilter{})
+	if complaint.AssignedTo == nil || *complaint.AssignedTo != 2 || complaint.EscalationLevel != 0 {
+		t.Fatalf("assigned complaint %+v", complaint)
+	}
+	if inbox := env.inbox(t, 2); len(inbox) != 1 || inbox[0].Kind != services.NotifyStaffAssigned || *inbox[0].ComplaintID != inside.ID {
+		t.Fatalf("official's inbox %+v", inbox)
+	}
+	if inbox := env.inbox(t, 3); len(inbox) != 1 || inbox[0].Kind != services.NotifyComplaintAssigned || !strings.Contains(string(inbox[0].Payload), `"official":"Official"`) {
+		t.Fatalf("citizen's inbox %+v", inbox)
+	}
+	if assigned, _ := env.Complaints.List(repository.ComplaintFilter{AssignedTo: 2}); len(assigned) != 1 || assigned[0].ID != inside.ID {
+		t.Fatalf("complaints assigned to the official %+v", assigned)
+	}
+	if staff, _ := env.Complaints.Staff(outside.ID); len(staff) != 0 {
+		t.Fatalf("staff following the unassigned complaint %v", staff)
+	}
+}
+
+func TestEscalateComplaint(t *testing.T) {
+	env := newTestEnv(t)
+	env.Complaints.Jurisdictions[2] = repository.Jurisdiction{CategoryIDs: []int{1}}
+	complaint := env.fileComplaint(t, 1)
+	if _, err := env.Complaints.Assign(complaint.ID, 2, false, repository.ComplaintFilter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	r := env.complaintRoutes(t, 2, services.NewMemoryEventBus())
+
+	if w := serve(r, "POST", "/complaints/1/assign", models.AssignComplaintRequest{AssigneeID: 1}); w.Code != http.StatusForbidden {
+		t.Fatalf("official assigning: %d, want 403", w.Code)
+	}
+	if w := serve(r, "POST", "/complaints/1/escalate", models.EscalateComplaintRequest{AssigneeID: 1}); w.Code != http.StatusBadRequest {
+		t.Fatalf("escalating without a reason: %d, want 400", w.Code)
+	}
+	req := models.EscalateComplaintRequest{AssigneeID: 1, Reason: "Needs a budget decision"}
+	if w := serve(r, "POST", "/complaints/1/escalate", req); w.Code != http.StatusOK {
+		t.Fatalf("escalate: %d %s", w.Code, w.Body)
+	}
+
+	complaint, _ = env.Complaints.Get(complaint.ID, repository.ComplaintFilter{})
+	if complaint.AssignedTo == nil || *complaint.AssignedTo != 1 || complaint.EscalationLevel != 1 {
+		t.Fatalf("escalated complaint %+v", complaint)
+	}
+	inbox := env.inbox(t, 1)
+	if len(inbox) != 1 || inbox[0].Kind != services.NotifyStaffEscalated {
+		t.Fatalf("admin's inbox %+v", inbox)
+	}
+	if staff, _ := env.Complaints.Staff(complaint.ID); len(staff) != 1 || staff[0] != 1 {
+		t.Fatalf("staff following the escalated complaint %v", staff)
+	}
+}
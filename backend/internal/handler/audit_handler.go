@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/services"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditHandler records privileged actions and serves the audit log to admins.
+type AuditHandler struct {
+	Log *services.AuditLog
+}
+
+func NewAuditHandler(log *services.AuditLog) *AuditHandler {
+	return &AuditHandler{
+		Log: log,
+	}
+}
+
+// RequestIDMiddleware tags every request with an ID, reusing the caller's
+// X-Request-ID if present, so log lines and audit entries can be correlated.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 {
+			token, _, err := services.NewOpaqueToken()
+			if err == nil {
+				requestID = token[:32]
+			}
+		}
+		c.Set("requestID", requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
+
+// auditSensitiveRead marks a read-only request as worth auditing.
+func auditSensitiveRead(c *gin.Context, action string) {
+	c.Set("auditAction", action)
+}
+
+// auditChange records what a mutating handler changed. target identifies the
+// affected object; before and after are any JSON-encodable snapshots.
+func auditChange(c *gin.Context, action, targetType, targetID string, before, after interface{}) {
+	c.Set("auditAction", action)
+	c.Set("auditTargetType", targetType)
+	c.Set("auditTargetID", targetID)
+	c.Set("auditBefore", before)
+	c.Set("auditAfter", after)
+}
+
+// AuditRecorder appends entries to the audit log; *services.AuditLog is one.
+type AuditRecorder interface {
+	Record(e *models.AuditEntry) error
+}
+
+// AuditAttempts marks the routes after it as privileged: every mutating
+// request to them is audited, including ones denied before reaching the
+// handler. Other requests are only audited if their handler calls
+// auditChange or auditSensitiveRead, which keeps logins, registrations and
+// upload chunks out of the chain.
+func AuditAttempts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("auditAttempts", true)
+		c.Next()
+	}
+}
+
+// isRead reports whether the request only reads.
+func isRead(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions
+}
+
+// Middleware writes an audit entry after requests whose handler named an
+// audit action, and after mutating requests to routes behind
+// AuditAttempts. Failed and denied attempts are recorded too, with their
+// status code. The entry is written once the handler is done; by then its
+// change is committed, so failing to write the entry is logged rather than
+// reported to the caller as a failed change.
+func (h *AuditHandler) Middleware() gin.HandlerFunc {
+	return auditMiddleware(h.Log)
+}
+
+func auditMiddleware(log AuditRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		action := c.GetString("auditAction")
+		if action == "" {
+			if isRead(c) || !c.GetBool("auditAttempts") {
+				return
+			}
+			action = c.Request.Method + " " + c.FullPath()
+		}
+
+		entry := models.AuditEntry{
+			ActorRole:  c.GetString("userRole"),
+			Action:     action,
+			TargetType: c.GetString("auditTargetType"),
+			TargetID:   c.GetString("auditTargetID"),
+			Status:     c.Writer.Status(),
+			IP:         c.ClientIP(),
+			RequestID:  c.GetString("requestID"),
+		}
+		if entry.TargetID == "" {
+			entry.TargetID = c.Param("id")
+		}
+		if userID, ok := c.Get("userID"); ok {
+			id := userID.(int64)
+			entry.ActorID = &id
+		}
+		if before, ok := c.Get("auditBefore"); ok {
+			entry.Before = services.MarshalAuditState(before)
+		}
+		if after, ok := c.Get("auditAfter"); ok {
+			entry.After = services.MarshalAuditState(after)
+		}
+		if err := log.Record(&entry); err != nil {
+			fmt.Printf("Failed to write audit entry for %s (request %s): %v\n", action, entry.RequestID, err)
+		}
+	}
+}
+
+// parseAuditFilter reads the common query parameters of the audit endpoints.
+func parseAuditFilter(c *gin.Context) (models.AuditFilter, error) {
+	f := models.AuditFilter{
+		ActorID:    c.Query("actor_id"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+	if f.ActorID != "" {
+		if _, err := strconv.ParseInt(f.ActorID, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid actor_id")
+		}
+	}
+	for name, dest := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s, expected RFC 3339 time", name)
+			}
+			*dest = &t
+		}
+	}
+	return f, nil
+}
+
+func (h *AuditHandler) GetAuditLog(c *gin.Context) {
+	auditSensitiveRead(c, "audit.view")
+
+	f, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "100"))
+	f.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if f.Limit <= 0 || f.Limit > 1000 {
+		f.Limit = 100
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+
+	entries, err := h.Log.Query(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "limit": f.Limit, "offset": f.Offset})
+}
+
+// ExportAuditLog downloads all matching entries as CSV (default) or JSON,
+// streamed from the database as they are read.
+func (h *AuditHandler) ExportAuditLog(c *gin.Context) {
+	auditSensitiveRead(c, "audit.export")
+
+	f, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z")
+	var write func(e models.AuditEntry) error
+	var finish func() error
+	if c.DefaultQuery("format", "csv") == "json" {
+		c.Header("Content-Type", "application/json")
+		c.Header("Content-Disposition", "attachment; filename="+filename+".json")
+		encoder := json.NewEncoder(c.Writer)
+		separator := "["
+		write = func(e models.AuditEntry) error {
+			if _, err := io.WriteString(c.Writer, separator); err != nil {
+				return err
+			}
+			separator = ","
+			return encoder.Encode(e)
+		}
+		finish = func() error {
+			if separator == "[" {
+				_, err := io.WriteString(c.Writer, "[]")
+				return err
+			}
+			_, err := io.WriteString(c.Writer, "]")
+			return err
+		}
+	} else {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "occurred_at", "actor_id", "actor_role", "action", "target_type", "target_id",
+			"before", "after", "status", "ip", "request_id", "prev_hash", "hash"})
+		write = func(e models.AuditEntry) error {
+			actor := ""
+			if e.ActorID != nil {
+				actor = strconv.FormatInt(*e.ActorID, 10)
+			}
+			return w.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.OccurredAt.UTC().Format(time.RFC3339Nano), actor, e.ActorRole,
+				e.Action, e.TargetType, e.TargetID, string(e.Before), string(e.After),
+				strconv.Itoa(e.Status), e.IP, e.RequestID, e.PrevHash, e.Hash,
+			})
+		}
+		finish = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	}
+
+	c.Status(http.StatusOK)
+	err = h.Log.Each(f, write)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		// Part of the file has been sent; cut it short so it is not taken
+		// for a complete export.
+		fmt.Printf("Failed to export audit log (request %s): %v\n", c.GetString("requestID"), err)
+		c.Abort()
+		if hijacker, ok := c.Writer.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// VerifyAuditLog checks the hash chain and reports the first broken entry.
+func (h *AuditHandler) VerifyAuditLog(c *gin.Context) {
+	auditSensitiveRead(c, "audit.verify")
+
+	brokenAt, checked, err := h.Log.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log", "details": err.Error()})
+		return
+	}
+	if brokenAt != 0 {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "broken_at": brokenAt, "checked": checked})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true, "checked": checked})
+}
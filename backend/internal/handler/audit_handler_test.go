@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testAuditLog remembers the entries recorded, or fails every write if err
+// is set.
+type testAuditLog struct {
+	entries []models.AuditEntry
+	err     error
+}
+
+func (l *testAuditLog) Record(e *models.AuditEntry) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.entries = append(l.entries, *e)
+	return nil
+}
+
+func auditRouter(log AuditRecorder) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(auditMiddleware(log))
+	r.POST("/login", func(c *gin.Context) { c.JSON(http.StatusUnauthorized, gin.H{}) })
+	r.PATCH("/uploads/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.POST("/roles", func(c *gin.Context) {
+		auditChange(c, "role.create", "role", "clerk", nil, gin.H{"name": "clerk"})
+		c.JSON(http.StatusCreated, gin.H{"message": "role created"})
+	})
+	admin := r.Group("/admin").Use(AuditAttempts())
+	admin.POST("/users/:id/demote", func(c *gin.Context) { c.AbortWithStatus(http.StatusForbidden) })
+	admin.GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func TestAuditMiddlewareRecordsOnlyAuditedRoutes(t *testing.T) {
+	log := &testAuditLog{}
+	r := auditRouter(log)
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/login"},
+		{"PATCH", "/uploads/abc"},
+		{"GET", "/admin/users"},
+		{"POST", "/roles"},
+		{"POST", "/admin/users/7/demote"},
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	if len(log.entries) != 2 {
+		t.Fatalf("recorded %+v, want the role change and the denied demotion", log.entries)
+	}
+	if e := log.entries[0]; e.Action != "role.create" || e.TargetID != "clerk" || e.Status != http.StatusCreated {
+		t.Errorf("role change recorded as %+v", e)
+	}
+	if e := log.entries[1]; e.Action != "POST /admin/users/:id/demote" || e.TargetID != "7" || e.Status != http.StatusForbidden {
+		t.Errorf("denied demotion recorded as %+v", e)
+	}
+}
+
+func TestAuditMiddlewareKeepsResponseWhenLogFails(t *testing.T) {
+	r := auditRouter(&testAuditLog{err: errors.New("database is down")})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/roles", nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("committed change answered %d %s, want 201", w.Code, w.Body)
+	}
+}
@@ -2,6 +2,8 @@
 package handler
 
 import (
+	"complain/internal/repository"
+	"complain/internal/services"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,30 +14,54 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// mfaTokenPurpose marks the short-lived token handed out between the password
+// step and the TOTP step of a login. It must never be accepted as an access token.
+const mfaTokenPurpose = "mfa"
+
 // AppClaims is our custom claims struct.
 type AppClaims struct {
 	UserID int64  `json:"user_id"`
 	Role   string `json:"role"`
+	// MFA is true when the session was established with a second factor.
+	MFA     bool   `json:"mfa"`
+	Purpose string `json:"purpose,omitempty"`
+	// TokenVersion must match users.token_version for the token to be accepted.
+	TokenVersion int `json:"ver"`
 	jwt.RegisteredClaims
 }
 
 // AuthService holds the secret key and all auth-related methods.
 type AuthService struct {
 	secretKey []byte
+	// Users is used to check that a token's account is still active.
+	Users repository.UserRepository
+	// Roles resolves a user's role to its permissions and 2FA policy.
+	Roles *services.RoleStore
 }
 
 // NewAuthService is the constructor for our service.
-func NewAuthService(secret string) *AuthService {
+func NewAuthService(secret string, users repository.UserRepository, roles *services.RoleStore) *AuthService {
 	return &AuthService{
 		secretKey: []byte(secret),
+		Users:     users,
+		Roles:     roles,
 	}
 }
 
+// MFARequired reports whether the given role must use two-factor authentication.
+// If the role table cannot be read we assume it does.
+func (s *AuthService) MFARequired(role string) bool {
+	required, err := s.Roles.RequiresMFA(role)
+	return err != nil || required
+}
+
 // GenerateToken is a method on AuthService.
-func (s *AuthService) GenerateToken(userID int64, role string) (string, error) {
+func (s *AuthService) GenerateToken(userID int64, role string, tokenVersion int, mfa bool) (string, error) {
 	claims := AppClaims{
-		UserID: userID,
-		Role:   role,
+		UserID:       userID,
+		Role:         role,
+		MFA:          mfa,
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
 		},
@@ -44,6 +70,35 @@ func (s *AuthService) GenerateToken(userID int64, role string) (string, error) {
 	return token.SignedString(s.secretKey)
 }
 
+// GenerateMFAToken issues the short-lived token a client exchanges, together
+// with a TOTP or recovery code, for a real access token. It carries nonce,
+// which the login stores so that the token completes one login only.
+func (s *AuthService) GenerateMFAToken(userID int64, nonce string) (string, error) {
+	claims := AppClaims{
+		UserID:  userID,
+		Purpose: mfaTokenPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        nonce,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(s.secretKey)
+}
+
+// VerifyMFAToken validates a token issued by GenerateMFAToken and returns the
+// user ID and the nonce.
+func (s *AuthService) VerifyMFAToken(tokenString string) (int64, string, error) {
+	claims, err := s.VerifyToken(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+	if claims.Purpose != mfaTokenPurpose || claims.ID == "" {
+		return 0, "", errors.New("invalid token")
+	}
+	return claims.UserID, claims.ID, nil
+}
+
 // VerifyToken is a method on AuthService.
 func (s *AuthService) VerifyToken(tokenString string) (*AppClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &AppClaims{}, func(t *jwt.Token) (interface{}, error) {
@@ -79,35 +134,105 @@ func (s *AuthService) AuthMiddleware() gin.HandlerFunc {
 		tokenString := parts[1]
 		// It now correctly calls the VerifyToken method on the service instance.
 		claims, err := s.VerifyToken(tokenString)
-		if err != nil {
+		if err != nil || claims.Purpose != "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
 
+		if !s.sessionValid(claims.UserID, claims.TokenVersion) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session is no longer valid, please log in again"})
+			return
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("userRole", claims.Role)
+		c.Set("mfaVerified", claims.MFA)
+		c.Set("tokenVersion", claims.TokenVersion)
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 		c.Next()
 	}
 }
 
-// RoleAuthMiddleware is also a method on AuthService for consistency.
-func (s *AuthService) RoleAuthMiddleware(requiredRole ...string) gin.HandlerFunc {
+// sessionValid reports whether tokens of the given version still let the
+// user in. Deactivating an account, changing its role or forcing a password
+// reset bumps token_version, which revokes every token issued before.
+func (s *AuthService) sessionValid(userID int64, tokenVersion int) bool {
+	account, err := s.Users.GetByID(userID)
+	return err == nil && account.IsActive && account.TokenVersion == tokenVersion
+}
+
+// StillAuthenticated re-checks, for a long-lived request such as an event
+// stream, that the token AuthMiddleware accepted has neither expired nor
+// been revoked since.
+func (s *AuthService) StillAuthenticated(c *gin.Context) bool {
+	if expires := c.GetTime("tokenExpiresAt"); !expires.IsZero() && time.Now().After(expires) {
+		return false
+	}
+	return s.sessionValid(c.GetInt64("userID"), c.GetInt("tokenVersion"))
+}
+
+// TokenFromQuery lets a request carry its access token as ?access_token=,
+// for browser EventSource streams, which cannot set headers. The token is
+// removed from the URL so it is not logged.
+func TokenFromQuery() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole_i, exists := c.Get("userRole")
-		if !exists {
+		query := c.Request.URL.Query()
+		if token := query.Get("access_token"); token != "" {
+			if c.GetHeader("Authorization") == "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del("access_token")
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission lets the request through only if the caller's role grants
+// every listed permission. The resolved permission set is stored in the context
+// as "userPermissions" so handlers can make finer checks with hasPermission.
+func (s *AuthService) RequirePermission(required ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRole := c.GetString("userRole")
+		if userRole == "" {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "User role not found in context"})
 			return
 		}
-		userRole := userRole_i.(string)
-		for _, roles:= range requiredRole{
-			if roles==userRole{
-				c.Next()
+
+		granted, err := s.Roles.Permissions(userRole)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions", "details": err.Error()})
+			return
+		}
+		for _, perm := range required {
+			if !granted[perm] {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not authorized to perform this action"})
 				return
 			}
+		}
 
+		// Privileged roles only get through once they have used a second factor.
+		if s.MFARequired(userRole) && !c.GetBool("mfaVerified") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":        "Two-factor authentication is required for this role",
+				"mfa_required": true,
+			})
+			return
 		}
-		
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not authorized to perform this action"})
-		
+
+		c.Set("userPermissions", granted)
+		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// hasPermission reports whether RequirePermission resolved perm for this request.
+func hasPermission(c *gin.Context, perm string) bool {
+	granted, ok := c.Get("userPermissions")
+	if !ok {
+		return false
+	}
+	perms, ok := granted.(map[string]bool)
+	return ok && perms[perm]
+}
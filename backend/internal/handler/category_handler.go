@@ -2,24 +2,24 @@ package handler
 
 import (
 	"complain/internal/models"
+	"complain/internal/repository"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
 )
 
 type CategoryHandler struct {
-	DB          *sqlx.DB
+	Categories  repository.CategoryRepository
 	cache       []models.Category
 	cacheExpiry time.Time
 	mutex       sync.Mutex
 }
 
-func NewCategoryHandler(db *sqlx.DB) *CategoryHandler {
+func NewCategoryHandler(categories repository.CategoryRepository) *CategoryHandler {
 	return &CategoryHandler{
-		DB:          db,
+		Categories:  categories,
 		cache:       make([]models.Category, 0),
 		cacheExpiry: time.Time{}, // Zero time
 		mutex:       sync.Mutex{},
@@ -39,10 +39,7 @@ func (h *CategoryHandler) GetCategories(c *gin.Context) {
 		return
 	}
 
-	var categories []models.Category
-	query := `SELECT id, category_name FROM category ORDER BY category_name`
-
-	err := h.DB.Select(&categories, query)
+	categories, err := h.Categories.List()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to fetch categories",
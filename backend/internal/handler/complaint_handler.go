@@ -2,67 +2,225 @@ package handler
 
 import (
 	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"complain/internal/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
 )
 
 type ComplaintHandler struct {
-	DB       *sqlx.DB
-	Mailer   *services.Mailer
-	Uploader *services.Uploader
+	Complaints  repository.ComplaintRepository
+	Attachments repository.AttachmentRepository
+	Users       repository.UserRepository
+	Notifier    *services.Notifier
+	Uploader    *services.Uploader
+	Uploads     *services.ResumableUploads
+	Events      services.EventBus
+	// Roles tells whether an official may be assigned a complaint.
+	Roles *services.RoleStore
 }
 
-func NewComplaintHandler(db *sqlx.DB, mailer *services.Mailer, uploader *services.Uploader) *ComplaintHandler {
+func NewComplaintHandler(complaints repository.ComplaintRepository, attachments repository.AttachmentRepository, users repository.UserRepository, notifier *services.Notifier, uploader *services.Uploader, uploads *services.ResumableUploads, events services.EventBus, roles *services.RoleStore) *ComplaintHandler {
 	return &ComplaintHandler{
-		DB:       db,
-		Mailer:   mailer,
-		Uploader: uploader,
+		Complaints:  complaints,
+		Attachments: attachments,
+		Users:       users,
+		Notifier:    notifier,
+		Uploader:    uploader,
+		Uploads:     uploads,
+		Events:      events,
+		Roles:       roles,
 	}
 }
 
+// signAttachments gives each attachment and its renditions short-lived
+// URLs, and flags photos taken too far from the complaint. It must only be
+// called on attachments the caller may see.
+func (h *ComplaintHandler) signAttachments(attachments []models.Attachment) {
+	for i := range attachments {
+		a := &attachments[i]
+		for _, u := range []struct {
+			key string
+			url *string
+		}{{a.ObjectKey, &a.URL}, {a.ThumbnailKey, &a.ThumbnailURL}, {a.WebKey, &a.WebURL}} {
+			if u.key == "" {
+				continue
+			}
+			url, err := h.Uploader.SignedURL(u.key)
+			if err != nil {
+				fmt.Printf("Failed to sign URL for attachment %d: %v\n", a.ID, err)
+				continue
+			}
+			*u.url = url
+		}
+		a.LocationMismatch = a.PhotoDistance != nil && *a.PhotoDistance > h.Uploader.Images.LocationTolerance
+	}
+}
+
+// loadAttachments fills in the attachments filed with each complaint, with
+// signed URLs. It must only be called on complaints the caller may see.
+func (h *ComplaintHandler) loadAttachments(complaints []models.Complaint) {
+	ids := make([]int64, len(complaints))
+	byID := make(map[int64]*models.Complaint, len(complaints))
+	for i := range complaints {
+		ids[i] = complaints[i].ID
+		byID[complaints[i].ID] = &complaints[i]
+		complaints[i].Attachments = []models.Attachment{}
+	}
+	attachments, err := h.Attachments.ListForComplaints(ids)
+	if err != nil {
+		fmt.Printf("Failed to load attachments: %v\n", err)
+		return
+	}
+	h.signAttachments(attachments)
+	for _, a := range attachments {
+		// Files posted with updates are listed per complaint instead.
+		if a.UpdateID != nil {
+			continue
+		}
+		complaint := byID[a.ComplaintID]
+		complaint.Attachments = append(complaint.Attachments, a)
+		if complaint.EvidenceURL == "" {
+			complaint.EvidenceURL = a.URL
+		}
+	}
+}
+
+// limitRequestBody caps the request body at the attachment request limit.
+func (h *ComplaintHandler) limitRequestBody(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.Uploader.Limits.MaxRequestSize)
+}
+
+// formError reports a multipart form that could not be parsed, telling
+// clients apart that sent too much.
+func formError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request too large", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form", "details": err.Error()})
+}
+
+// receiveAttachments uploads the files of a parsed multipart form. Files go
+// in "attachments", with optional captions at the same positions in
+// "captions"; a single "evidence" file is still accepted from older
+// clients. Files sent earlier with resumable uploads are named by their IDs
+// in "upload_ids" and captioned after the files in the form. The uploads
+// used are returned so they can be finished once the attachments are saved.
+// On failure it writes the response and returns false.
+func (h *ComplaintHandler) receiveAttachments(c *gin.Context) ([]models.Attachment, []models.Upload, bool) {
+	form := c.Request.MultipartForm
+	if form == nil {
+		return nil, nil, true
+	}
+	var files []services.File
+	for _, fh := range append(form.File["attachments"], form.File["evidence"]...) {
+		files = append(files, services.FormFile(fh))
+	}
+	captions := form.Value["captions"]
+
+	var uploads []models.Upload
+	for _, id := range form.Value["upload_ids"] {
+		upload, err := h.Uploads.Finished(id, c.GetInt64("userID"))
+		if err != nil {
+			switch {
+			case errors.Is(err, repository.ErrNotFound), errors.Is(err, services.ErrUploadExpired):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown upload", "details": id})
+			case errors.Is(err, services.ErrUploadIncomplete):
+				c.JSON(http.StatusConflict, gin.H{"error": "Upload is not complete", "details": id})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upload", "details": err.Error()})
+			}
+			return nil, nil, false
+		}
+		uploads = append(uploads, upload)
+	}
+	for _, upload := range uploads {
+		files = append(files, h.Uploads.File(upload))
+	}
+
+	if err := h.Uploader.CheckFiles(files); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Too many or too large files", "details": err.Error()})
+		return nil, nil, false
+	}
+
+	attachments := make([]models.Attachment, 0, len(files))
+	for i, file := range files {
+		attachment, err := h.Uploader.UploadFile(file)
+		if err != nil {
+			fmt.Printf("Failed to upload file: %v\n", err)
+			h.Uploader.Discard(attachments)
+			switch {
+			case errors.Is(err, services.ErrFileTooLarge):
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large", "details": err.Error()})
+			case errors.Is(err, services.ErrInfected):
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "File failed the malware scan", "details": err.Error()})
+			case errors.Is(err, services.ErrFileRejected):
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "File type not accepted", "details": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file", "details": err.Error()})
+			}
+			return nil, nil, false
+		}
+		if i < len(captions) {
+			attachment.Caption = captions[i]
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, uploads, true
+}
+
+// finishUploads deletes resumable uploads whose files have been attached.
+func (h *ComplaintHandler) finishUploads(uploads []models.Upload) {
+	for _, upload := range uploads {
+		if err := h.Uploads.Terminate(upload); err != nil {
+			fmt.Printf("Failed to delete upload %s: %v\n", upload.ID, err)
+		}
+	}
+}
+
+// viewableComplaint loads a complaint if the caller filed it, or if their
+// role lets them view it within their jurisdiction.
+func (h *ComplaintHandler) viewableComplaint(c *gin.Context, id int64) (models.Complaint, error) {
+	complaint, err := h.Complaints.Get(id, repository.ComplaintFilter{UserID: c.GetInt64("userID")})
+	if !errors.Is(err, repository.ErrNotFound) || !hasPermission(c, services.PermComplaintViewAll) {
+		return complaint, err
+	}
+	return h.Complaints.Get(id, visibleComplaints(c))
+}
+
+// visibleComplaints is the filter every staff listing starts from: private
+// complaints only for roles allowed to see them, and only within the
+// caller's jurisdiction.
+func visibleComplaints(c *gin.Context) repository.ComplaintFilter {
+	filter := repository.ComplaintFilter{ScopeUserID: complaintScope(c)}
+	if !hasPermission(c, services.PermComplaintViewPrivate) {
+		filter.PublicOnly = true
+	} else {
+		auditSensitiveRead(c, "complaint.view_private")
+	}
+	return filter
+}
+
 func (h *ComplaintHandler) Create(c *gin.Context) {
 
 	userID_i, _ := c.Get("userID")
 	userID := userID_i.(int64)
 	var complaint models.CreateComplaintRequest
-	var registeredComplaint models.Complaint
 
 	// Handle multipart form data
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form", "details": err.Error()})
+	h.limitRequestBody(c)
+	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // 10 MB in memory, the rest on disk
+		formError(c, err)
 		return
 	}
 
-	// Get file if it exists
-	evidenceURL := ""
-	file, err := c.FormFile("evidence")
-	if err == nil && file != nil {
-		// If a file was provided, upload it using our uploader service
-		url, uploadErr := h.Uploader.UploadFile(file)
-		if uploadErr != nil {
-			fmt.Printf("Failed to upload file: %v\n", uploadErr)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file", "details": uploadErr.Error()})
-			return
-		}
-		evidenceURL = url
-	}
-
-	// Log form data for debugging
-	fmt.Printf("Received form data: title=%s, description=%s, category=%s, lat=%s, lon=%s, is_public=%s\n",
-		c.PostForm("title"),
-		c.PostForm("description"),
-		c.PostForm("category"),
-		c.PostForm("latitude"),
-		c.PostForm("longitude"),
-		c.PostForm("is_public"))
-
 	// Bind other form fields
 	complaint.Title = c.PostForm("title")
 	complaint.Description = c.PostForm("description")
@@ -109,65 +267,62 @@ func (h *ComplaintHandler) Create(c *gin.Context) {
 		return
 	}
 
+	// Upload files only once the rest of the form is known to be valid.
+	attachments, uploads, ok := h.receiveAttachments(c)
+	if !ok {
+		return
+	}
+
 	// Set default status for new complaints
-	status := "pending"
-
-	query := `INSERT INTO complaints (
-		user_id, title, description, catergory_id, evidence, location, is_public, status
-	) VALUES (
-		$1, $2, $3, $4, $5, ST_MakePoint($6, $7)::geography, $8, $9
-	) RETURNING 
-		id, user_id, title, description, catergory_id, status, 
-		created_at, updated_at, evidence,
-		ST_AsText(location) as location,
-		ST_X(location::geometry) as longitude,
-		ST_Y(location::geometry) as latitude,
-		is_public`
-
-	fmt.Printf("Executing query with values: userID=%d, title=%s, desc=%s, category=%d, evidence=%s, lon=%f, lat=%f, isPublic=%v\n",
-		userID, complaint.Title, complaint.Description, complaint.Category, evidenceURL, complaint.Longitude, complaint.Latitude, complaint.IsPublic)
-
-	err = h.DB.QueryRowx(query,
-		userID,
-		complaint.Title,
-		complaint.Description,
-		complaint.Category,
-		evidenceURL,
-		complaint.Longitude,
-		complaint.Latitude,
-		complaint.IsPublic,
-		status,
-	).StructScan(&registeredComplaint)
+	status := models.StatusPending
 
+	// The confirmation is queued with the complaint, so it is sent even if
+	// the mail server is down right now.
+	user, err := h.Users.GetByID(userID)
 	if err != nil {
-		fmt.Printf("Database error: %v\n", err)
+		h.Uploader.Discard(attachments)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating complaint", "details": err.Error()})
 		return
 	}
+	confirmation := services.ComplaintNotifications(user, services.NotifyComplaintCreated, services.EmailData{Title: complaint.Title})
+	hooks, err := h.Notifier.Webhooks.Messages(services.EventComplaintCreated, models.Complaint{
+		Title:       complaint.Title,
+		Description: complaint.Description,
+		Category:    complaint.Category,
+		Status:      status,
+		Latitude:    complaint.Latitude,
+		Longitude:   complaint.Longitude,
+		IsPublic:    complaint.IsPublic,
+	}, "", "")
+	if err != nil {
+		h.Uploader.Discard(attachments)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating complaint", "details": err.Error()})
+		return
+	}
+	confirmation = append(confirmation, hooks...)
 
-	go func() {
-		fmt.Println("Starting email sending process...") // Add logging
-		var userEmail string
-		err := h.DB.Get(&userEmail, "SELECT email FROM users WHERE id = $1", userID)
-		if err != nil {
-			fmt.Printf("Failed to get user email for userID %d: %v\n", userID, err)
-			return
-		}
+	registeredComplaint, err := h.Complaints.Create(userID, complaint, status, attachments, confirmation)
+	if err != nil {
+		h.Uploader.Discard(attachments)
+		fmt.Printf("Database error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating complaint", "details": err.Error()})
+		return
+	}
+	h.finishUploads(uploads)
+	// Only IDs: what citizens report and where stays out of the logs.
+	fmt.Printf("Created complaint %d for user %d with %d attachments\n", registeredComplaint.ID, userID, len(attachments))
 
-		fmt.Printf("Sending email to: %s\n", userEmail) // Add logging
-		err = h.Mailer.SendComplaintConfirmation(userEmail, complaint.Title, registeredComplaint.ID)
-		if err != nil {
-			fmt.Printf("Failed to send confirmation email: %v\n", err)
-		} else {
-			fmt.Printf("Email sent successfully to %s\n", userEmail) // Add logging
-		}
-	}()
+	h.Notifier.Wake()
+	h.Events.Publish(services.ComplaintEvent{Type: services.EventComplaintCreated, ComplaintID: registeredComplaint.ID, OwnerID: userID, At: registeredComplaint.CreatedAt})
 
+	h.signAttachments(registeredComplaint.Attachments)
+	if len(registeredComplaint.Attachments) > 0 {
+		registeredComplaint.EvidenceURL = registeredComplaint.Attachments[0].URL
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "complainted added succefully", "data": registeredComplaint})
 }
 
 func (h *ComplaintHandler) GetMyComplaints(c *gin.Context) {
-	var complaints []models.Complaint
 	user_id_i, exists := c.Get("userID")
 	if !exists {
 		fmt.Printf("Error: userID not found in context\n")
@@ -182,25 +337,15 @@ func (h *ComplaintHandler) GetMyComplaints(c *gin.Context) {
 		return
 	}
 
-	query := `SELECT
-        id, user_id, title, description,
-        COALESCE(catergory_id, 0) as catergory_id, status, created_at, updated_at, evidence,
-        ST_AsText(location) as location,
-        COALESCE(ST_X(location::geometry), 0) as longitude,
-        COALESCE(ST_Y(location::geometry), 0) as latitude,
-        is_public
-    	FROM
-        complaints
-    	WHERE user_id=$1 ORDER BY created_at DESC`
-
 	fmt.Printf("Fetching complaints for user ID: %d\n", user_id)
-	err := h.DB.Select(&complaints, query, user_id)
+	complaints, err := h.Complaints.List(repository.ComplaintFilter{UserID: user_id})
 	if err != nil {
 		fmt.Printf("Error fetching user complaints: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch complaints", "details": err.Error()})
 		return
 	}
 
+	h.loadAttachments(complaints)
 	fmt.Printf("Found %d complaints for user %d\n", len(complaints), user_id)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "the complaints are",
@@ -209,35 +354,23 @@ func (h *ComplaintHandler) GetMyComplaints(c *gin.Context) {
 }
 
 func (h *ComplaintHandler) GetAllComplaints(c *gin.Context) {
-	var complaints []models.Complaint
-	query := ` SELECT 
-            id, user_id, title, description, COALESCE(catergory_id, 0) as catergory_id, status,
-            created_at, updated_at, evidence,
-            ST_AsText(location) as location,is_public
-        FROM complaints
-        ORDER BY created_at DESC`
-	err := h.DB.Select(&complaints, query)
+	complaints, err := h.Complaints.List(visibleComplaints(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.loadAttachments(complaints)
 	c.JSON(http.StatusOK, gin.H{"message": "complaints retrived sucessfully", "complaints": complaints})
 
 }
 
 func (h *ComplaintHandler) GetComplaintsBy(c *gin.Context) {
-	var complaints []models.Complaint
-	query := ` SELECT 
-            id, user_id, title, description, COALESCE(catergory_id, 0) as catergory_id, status,
-            created_at, updated_at, evidence,
-            ST_AsText(location) as location,is_public
-        FROM complaints
-        ORDER BY created_at DESC`
-	err := h.DB.Select(&complaints, query)
+	complaints, err := h.Complaints.List(repository.ComplaintFilter{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.loadAttachments(complaints)
 	c.JSON(http.StatusOK, gin.H{"message": "complaints retrived sucessfully", "complaints": complaints})
 
 }
@@ -249,93 +382,165 @@ func (h *ComplaintHandler) AddUpdate(c *gin.Context) {
 	user_id_i, _ := c.Get("userID")
 	user_id := user_id_i.(int64)
 
-	err := c.ShouldBindJSON(&req)
+	complaintID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+		return
+	}
+
+	// Updates are JSON, or a multipart form when files come with them.
+	if c.ContentType() == "multipart/form-data" {
+		h.limitRequestBody(c)
+		if err := c.Request.ParseMultipartForm(10 << 20); err != nil {
+			formError(c, err)
+			return
+		}
+	}
+	err = c.ShouldBind(&req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "error parsing rhe comment ", "error": err.Error()})
 		return
 	}
-	var exists bool
-	err = h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM complaints WHERE id = $1)", id).Scan(&exists)
-	if err != nil || !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+	visibility := req.Visibility
+	if visibility == "" {
+		visibility = models.VisibilityPublic
+	}
+	if visibility == models.VisibilityInternal && req.Status != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal notes cannot change the status"})
+		return
+	}
+	attachments, uploads, ok := h.receiveAttachments(c)
+	if !ok {
 		return
 	}
 
-	var update models.ComplaintUpdate
-	query := `INSERT INTO complaint_updates(complaint_id,user_id,comment)
-			VALUES($1,$2,$3) RETURNING *`
-	err = h.DB.QueryRowx(query, id, user_id, req.Comment).StructScan(&update)
+	// An update without a status leaves the complaint's status as it is.
+	status := req.Status
+
+	// Officials may only update complaints within their own jurisdiction.
+	filter := repository.ComplaintFilter{ScopeUserID: complaintScope(c)}
+	var complaint models.Complaint
+	var notifications []models.OutboxMessage
+	if visibility == models.VisibilityInternal {
+		// Notes are for staff only: neither the citizen nor the webhooks
+		// hear about them.
+		complaint, err = h.Complaints.Get(complaintID, filter)
+	} else {
+		complaint, notifications, err = h.updateNotifications(complaintID, user_id, filter, status, req.Comment)
+	}
 	if err != nil {
+		h.Uploader.Discard(attachments)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "error updating thecomplant db ", "error": err.Error()})
 		return
 	}
-	query = `UPDATE complaints SET status=$1 WHERE id=$2`
-	_, err = h.DB.Exec(query, "In_Progress", id)
+	if visibility == models.VisibilityPublic {
+		hooks, err := h.webhookMessages(complaint, status, req.Comment)
+		if err != nil {
+			h.Uploader.Discard(attachments)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "error updating thecomplant db ", "error": err.Error()})
+			return
+		}
+		notifications = append(notifications, hooks...)
+	}
+	update, previousStatus, err := h.Complaints.AddUpdate(complaintID, user_id, req.Comment, visibility, status, filter, attachments, notifications)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "error updating the status  db ", "error": err.Error()})
+		h.Uploader.Discard(attachments)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "error updating thecomplant db ", "error": err.Error()})
 		return
 	}
+	h.finishUploads(uploads)
+	if len(notifications) > 0 {
+		h.Notifier.Wake()
+	}
+	h.Events.Publish(services.ComplaintEvent{Type: services.EventComplaintUpdated, ComplaintID: complaintID, OwnerID: complaint.UserID, At: update.CreatedAt,
+		StaffOnly: visibility == models.VisibilityInternal})
+	auditChange(c, "complaint.update", "complaint", id,
+		gin.H{"status": previousStatus},
+		gin.H{"status": *update.Status, "update_id": update.ID, "visibility": visibility, "comment": update.Comment, "attachments": len(update.Attachments)})
+	h.signAttachments(update.Attachments)
 	c.JSON(http.StatusOK, gin.H{"message": "status updated",
 		"details": update})
 
 }
 
-func (h *ComplaintHandler) GetByFilter(c *gin.Context) {
-	districtname := c.Query("district")
-	category := c.Query("category")
-	status := c.Query("status")
-	userID := c.Query("userid")
-	var temp []models.Complaint
-	var conditions []string
-	var args []interface{}
-	count := 1
-
-	baseQuery := `SELECT
-        c.id, c.user_id, c.title, c.description, COALESCE(c.catergory_id, 0) as catergory_id,
-        COALESCE(c.status, 'pending') as status, 
-        c.created_at, c.updated_at, c.evidence,
-        ST_AsText(c.location) as location,
-        COALESCE(ST_X(c.location::geometry), 0) as longitude,
-        COALESCE(ST_Y(c.location::geometry), 0) as latitude,
-        c.is_public
-    FROM complaints c`
-
-	// Only join with admin_boundaries if district filter is present
-	if districtname != "" {
-		baseQuery += " JOIN admin_boundaries b ON ST_Intersects(b.geom, c.location::geometry)"
-		conditions = append(conditions, fmt.Sprintf("b.name_2 = $%d", count))
-		args = append(args, districtname)
-		count++
+// updateNotifications loads the complaint an update is posted to and tells
+// its owner about the update, unless they posted it themselves.
+func (h *ComplaintHandler) updateNotifications(complaintID, authorID int64, filter repository.ComplaintFilter, status, comment string) (models.Complaint, []models.OutboxMessage, error) {
+	complaint, err := h.Complaints.Get(complaintID, filter)
+	if err != nil || complaint.UserID == authorID {
+		return complaint, nil, err
 	}
-
-	if status != "" {
-		conditions = append(conditions, fmt.Sprintf("c.status = $%d", count))
-		args = append(args, status)
-		count++
+	owner, err := h.Users.GetByID(complaint.UserID)
+	if err != nil {
+		return complaint, nil, err
 	}
-
-	if userID != "" {
-		conditions = append(conditions, fmt.Sprintf("c.user_id = $%d", count))
-		args = append(args, userID)
-		count++
+	author, err := h.Users.GetByID(authorID)
+	if err != nil {
+		return complaint, nil, err
 	}
+	return complaint, services.ComplaintUpdateNotifications(owner, complaint, author.Name, status, comment), nil
+}
 
-	if category != "" {
-		conditions = append(conditions, fmt.Sprintf("c.catergory_id = $%d", count))
-		args = append(args, category)
-		count++
+// webhookMessages tells the webhooks that want to know about an update to
+// complaint setting it to status, and about the status change if it is one.
+// An empty status leaves the complaint's status as it is.
+func (h *ComplaintHandler) webhookMessages(complaint models.Complaint, status, comment string) ([]models.OutboxMessage, error) {
+	previousStatus := complaint.Status
+	if status == "" {
+		status = previousStatus
 	}
-
-	// Add WHERE clause if there are conditions
-	if len(conditions) > 0 {
-		baseQuery += " WHERE " + strings.Join(conditions, " AND ")
+	complaint.Status = status
+	messages, err := h.Notifier.Webhooks.Messages(services.EventComplaintUpdated, complaint, previousStatus, comment)
+	if err != nil || status == previousStatus {
+		return messages, err
 	}
+	changed, err := h.Notifier.Webhooks.Messages(services.EventComplaintStatusChanged, complaint, previousStatus, comment)
+	return append(messages, changed...), err
+}
 
-	// Add ORDER BY
-	baseQuery += " ORDER BY c.created_at DESC"
+func (h *ComplaintHandler) GetByFilter(c *gin.Context) {
+	filter := visibleComplaints(c)
+	filter.District = c.Query("district")
+	filter.Status = c.Query("status")
+	if userID := c.Query("userid"); userID != "" {
+		id, err := strconv.ParseInt(userID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userid value"})
+			return
+		}
+		filter.UserID = id
+	}
+	if category := c.Query("category"); category != "" {
+		id, err := strconv.Atoi(category)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category value"})
+			return
+		}
+		filter.CategoryID = id
+	}
+	// assigned_to=me lists the caller's own assignments.
+	switch assignee := c.Query("assigned_to"); assignee {
+	case "":
+	case "me":
+		filter.AssignedTo = c.GetInt64("userID")
+	default:
+		id, err := strconv.ParseInt(assignee, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assigned_to value"})
+			return
+		}
+		filter.AssignedTo = id
+	}
 
-	// Execute query with all arguments
-	err := h.DB.Select(&temp, baseQuery, args...)
+	temp, err := h.Complaints.List(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to fetch complaints",
@@ -343,9 +548,159 @@ func (h *ComplaintHandler) GetByFilter(c *gin.Context) {
 		})
 		return
 	}
+	h.loadAttachments(temp)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Complaints retrieved successfully",
 		"data":    temp,
 	})
 }
+
+// complaintParam loads the complaint named by the :id parameter if the
+// caller may view it. On failure it writes the response and returns false.
+func (h *ComplaintHandler) complaintParam(c *gin.Context) (models.Complaint, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+		return models.Complaint{}, false
+	}
+	complaint, err := h.viewableComplaint(c, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+			return complaint, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch complaint", "details": err.Error()})
+		return complaint, false
+	}
+	return complaint, true
+}
+
+// attachmentParam loads the attachment named by :attachmentId if it belongs
+// to the complaint named by :id and the caller may view that complaint.
+func (h *ComplaintHandler) attachmentParam(c *gin.Context) (models.Complaint, models.Attachment, bool) {
+	complaint, ok := h.complaintParam(c)
+	if !ok {
+		return complaint, models.Attachment{}, false
+	}
+	id, err := strconv.ParseInt(c.Param("attachmentId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return complaint, models.Attachment{}, false
+	}
+	attachment, err := h.Attachments.Get(id)
+	if err == nil && attachment.ComplaintID != complaint.ID {
+		err = repository.ErrNotFound
+	}
+	if err == nil && attachment.UpdateID != nil {
+		var hidden map[int64]bool
+		hidden, err = h.hiddenUpdates(c, complaint.ID)
+		if err == nil && hidden[*attachment.UpdateID] {
+			err = repository.ErrNotFound
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return complaint, attachment, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachment", "details": err.Error()})
+		return complaint, attachment, false
+	}
+	return complaint, attachment, true
+}
+
+// GetAttachments lists every file of a complaint, including those posted
+// with its updates, with fresh signed URLs. Files posted with internal
+// notes are only listed for staff.
+func (h *ComplaintHandler) GetAttachments(c *gin.Context) {
+	complaint, ok := h.complaintParam(c)
+	if !ok {
+		return
+	}
+	attachments, err := h.Attachments.ListForComplaints([]int64{complaint.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachments", "details": err.Error()})
+		return
+	}
+	hidden, err := h.hiddenUpdates(c, complaint.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachments", "details": err.Error()})
+		return
+	}
+	attachments = withoutHidden(attachments, hidden)
+	h.signAttachments(attachments)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"message": "Attachments retrieved successfully", "data": attachments})
+}
+
+// GetAttachment redirects to a fresh signed URL for one file, for clients
+// whose earlier link has expired.
+func (h *ComplaintHandler) GetAttachment(c *gin.Context) {
+	_, attachment, ok := h.attachmentParam(c)
+	if !ok {
+		return
+	}
+	url, err := h.Uploader.SignedURL(attachment.ObjectKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign attachment URL", "details": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, url)
+}
+
+// GetEvidence redirects to the first file filed with a complaint. It is kept
+// for older clients; newer ones use the attachment endpoints.
+func (h *ComplaintHandler) GetEvidence(c *gin.Context) {
+	complaint, ok := h.complaintParam(c)
+	if !ok {
+		return
+	}
+	complaints := []models.Complaint{complaint}
+	h.loadAttachments(complaints)
+	if complaints[0].EvidenceURL == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This complaint has no evidence"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, complaints[0].EvidenceURL)
+}
+
+// DeleteAttachment removes a file. Whoever uploaded it may remove it, and so
+// may staff allowed to update complaints within their jurisdiction.
+func (h *ComplaintHandler) DeleteAttachment(c *gin.Context) {
+	complaint, attachment, ok := h.attachmentParam(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt64("userID")
+	ownFile := attachment.UploadedBy != nil && *attachment.UploadedBy == userID
+	if !ownFile {
+		allowed, err := h.worksOn(c, complaint.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch complaint", "details": err.Error()})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You may not delete this attachment"})
+			return
+		}
+	}
+
+	if err := h.Attachments.Delete(attachment.ID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attachment", "details": err.Error()})
+		return
+	}
+	// The row is gone, so a leftover object is only wasted space.
+	h.Uploader.Discard([]models.Attachment{attachment})
+
+	auditChange(c, "attachment.delete", "complaint", strconv.FormatInt(complaint.ID, 10),
+		gin.H{"attachment_id": attachment.ID, "filename": attachment.Filename, "checksum_sha256": attachment.Checksum},
+		nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted"})
+}
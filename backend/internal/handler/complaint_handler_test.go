@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateWithoutStatusLeavesItUnchanged(t *testing.T) {
+	env := newTestEnv(t)
+	complaint := env.fileComplaint(t, 1)
+	if _, _, err := env.Complaints.AddUpdate(complaint.ID, 1, "Fixed the streetlight", models.VisibilityPublic, models.StatusResolved, repository.ComplaintFilter{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	hook := models.Webhook{URL: "https://partner.example.org/hook", IsActive: true,
+		Events: []string{services.EventComplaintUpdated, services.EventComplaintStatusChanged}}
+	if err := env.Webhooks.Create(&hook); err != nil {
+		t.Fatal(err)
+	}
+	r := env.complaintRoutes(t, 1, services.NewMemoryEventBus())
+
+	message := models.AddUpdateComment{Comment: "The new lamp has a five year warranty"}
+	if w := serve(r, "POST", "/complaints/1/updates", message); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	if got, err := env.Complaints.Get(complaint.ID, repository.ComplaintFilter{}); err != nil || got.Status != models.StatusResolved {
+		t.Fatalf("complaint after the update: %+v, %v; want it still resolved", got, err)
+	}
+	inbox := env.inbox(t, complaint.UserID)
+	if len(inbox) != 1 || inbox[0].Kind != services.NotifyComplaintUpdated {
+		t.Errorf("citizen's inbox %+v, want one complaint update", inbox)
+	}
+	messages, err := env.Outbox.List(repository.OutboxFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if m.Kind == services.EventComplaintStatusChanged {
+			t.Errorf("webhook told the status changed: %+v", m)
+		}
+	}
+}
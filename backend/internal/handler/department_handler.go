@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DepartmentHandler struct {
+	Repo  repository.DepartmentRepository
+	Users repository.UserRepository
+}
+
+func NewDepartmentHandler(repo repository.DepartmentRepository, users repository.UserRepository) *DepartmentHandler {
+	return &DepartmentHandler{
+		Repo:  repo,
+		Users: users,
+	}
+}
+
+func (h *DepartmentHandler) GetDepartments(c *gin.Context) {
+	departments, err := h.Repo.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch departments", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"departments": departments})
+}
+
+// saveDepartmentFailed answers a department create or update the repository
+// refused.
+func saveDepartmentFailed(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+	case errors.Is(err, repository.ErrUnknownReference):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to set department categories", "details": "one or more categories do not exist"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "details": err.Error()})
+	}
+}
+
+func (h *DepartmentHandler) CreateDepartment(c *gin.Context) {
+	var req models.DepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	department := models.Department{Name: req.Name, Description: req.Description, CategoryIDs: unique(req.CategoryIDs)}
+	if err := h.Repo.Create(&department); err != nil {
+		saveDepartmentFailed(c, err, "Failed to create department")
+		return
+	}
+
+	auditChange(c, "department.create", "department", strconv.FormatInt(department.ID, 10), nil, department)
+	c.JSON(http.StatusCreated, gin.H{"message": "department created", "department": department})
+}
+
+func (h *DepartmentHandler) UpdateDepartment(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return
+	}
+	var req models.DepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	department := models.Department{ID: id, Name: req.Name, Description: req.Description, CategoryIDs: unique(req.CategoryIDs)}
+	if err := h.Repo.Update(&department); err != nil {
+		saveDepartmentFailed(c, err, "Failed to update department")
+		return
+	}
+
+	auditChange(c, "department.update", "department", strconv.FormatInt(department.ID, 10), nil, department)
+	c.JSON(http.StatusOK, gin.H{"message": "department updated", "department": department})
+}
+
+func (h *DepartmentHandler) DeleteDepartment(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return
+	}
+
+	if err := h.Repo.Delete(id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete department", "details": err.Error()})
+		return
+	}
+	auditChange(c, "department.delete", "department", c.Param("id"), nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "department deleted", "id": c.Param("id")})
+}
+
+// scopeUser loads the user named by the :id parameter.
+func (h *DepartmentHandler) scopeUser(c *gin.Context) (models.User, bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return models.User{}, false
+	}
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return user, false
+	}
+	return user, true
+}
+
+// GetOfficialScope returns the departments and districts an official works in.
+func (h *DepartmentHandler) GetOfficialScope(c *gin.Context) {
+	user, ok := h.scopeUser(c)
+	if !ok {
+		return
+	}
+
+	scope, err := h.Repo.Scope(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scope", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"scope": scope})
+}
+
+// SetOfficialScope replaces an official's departments and districts.
+func (h *DepartmentHandler) SetOfficialScope(c *gin.Context) {
+	var req models.OfficialScopeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := h.scopeUser(c)
+	if !ok {
+		return
+	}
+
+	before, err := h.Repo.Scope(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scope", "details": err.Error()})
+		return
+	}
+
+	scope := models.OfficialScope{UserID: user.ID, DepartmentIDs: unique(req.DepartmentIDs), Districts: unique(req.Districts)}
+	if err := h.Repo.SetScope(scope); err != nil {
+		if errors.Is(err, repository.ErrUnknownReference) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "One or more departments or districts do not exist"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scope", "details": err.Error()})
+		return
+	}
+
+	scope, err = h.Repo.Scope(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scope", "details": err.Error()})
+		return
+	}
+	auditChange(c, "user.scope_change", "user", strconv.FormatInt(user.ID, 10), before, scope)
+	c.JSON(http.StatusOK, gin.H{"message": "scope updated", "scope": scope})
+}
+
+// unique returns values without repeats, in order.
+func unique[T comparable](values []T) []T {
+	seen := make(map[T]bool, len(values))
+	out := make([]T, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/services"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func (env *testEnv) departmentRoutes(t *testing.T) http.Handler {
+	r := env.router(t, 1)
+	h, manage := NewDepartmentHandler(env.Departments, env.Users), env.Auth.RequirePermission(services.PermDepartmentManage)
+	r.GET("/departments", manage, h.GetDepartments)
+	r.POST("/departments", manage, h.CreateDepartment)
+	r.PUT("/departments/:id", manage, h.UpdateDepartment)
+	r.DELETE("/departments/:id", manage, h.DeleteDepartment)
+	r.GET("/users/:id/scope", manage, h.GetOfficialScope)
+	r.PUT("/users/:id/scope", manage, h.SetOfficialScope)
+	return r
+}
+
+func TestDepartments(t *testing.T) {
+	env := newTestEnv(t)
+	env.Departments.CategoryIDs = []int{1, 2}
+	r := env.departmentRoutes(t)
+
+	if w := serve(r, "POST", "/departments", models.DepartmentRequest{Name: "Roads", CategoryIDs: []int{1, 9}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("department with an unknown category: %d, want 400", w.Code)
+	}
+	if w := serve(r, "POST", "/departments", models.DepartmentRequest{Name: "Roads", CategoryIDs: []int{1, 1}}); w.Code != http.StatusCreated {
+		t.Fatalf("create department: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "PUT", "/departments/1", models.DepartmentRequest{Name: "Roads and bridges", CategoryIDs: []int{1, 2}}); w.Code != http.StatusOK {
+		t.Fatalf("update department: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "PUT", "/departments/7", models.DepartmentRequest{Name: "Parks"}); w.Code != http.StatusNotFound {
+		t.Fatalf("update missing department: %d, want 404", w.Code)
+	}
+
+	w := serve(r, "GET", "/departments", nil)
+	var body struct {
+		Departments []models.Department `json:"departments"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if len(body.Departments) != 1 || body.Departments[0].Name != "Roads and bridges" || len(body.Departments[0].CategoryIDs) != 2 {
+		t.Fatalf("unexpected departments %+v", body.Departments)
+	}
+
+	if w := serve(r, "DELETE", "/departments/1", nil); w.Code != http.StatusOK {
+		t.Fatalf("delete department: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "DELETE", "/departments/1", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("delete department twice: %d, want 404", w.Code)
+	}
+}
+
+func TestOfficialScope(t *testing.T) {
+	env := newTestEnv(t)
+	env.Departments.Districts = []string{"North", "South"}
+	r := env.departmentRoutes(t)
+	serve(r, "POST", "/departments", models.DepartmentRequest{Name: "Roads"})
+
+	if w := serve(r, "PUT", "/users/2/scope", models.OfficialScopeRequest{DepartmentIDs: []int64{1}, Districts: []string{"East"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("scope with an unknown district: %d, want 400", w.Code)
+	}
+	if w := serve(r, "PUT", "/users/2/scope", models.OfficialScopeRequest{DepartmentIDs: []int64{5}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("scope with an unknown department: %d, want 400", w.Code)
+	}
+	if w := serve(r, "PUT", "/users/99/scope", models.OfficialScopeRequest{}); w.Code != http.StatusNotFound {
+		t.Fatalf("scope of an unknown user: %d, want 404", w.Code)
+	}
+
+	w := serve(r, "PUT", "/users/2/scope", models.OfficialScopeRequest{DepartmentIDs: []int64{1, 1}, Districts: []string{"South", "North", "South"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("set scope: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "GET", "/users/2/scope", nil)
+	var body struct {
+		Scope models.OfficialScope `json:"scope"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if len(body.Scope.DepartmentIDs) != 1 || len(body.Scope.Districts) != 2 || body.Scope.Districts[0] != "North" {
+		t.Fatalf("unexpected scope %+v", body.Scope)
+	}
+}
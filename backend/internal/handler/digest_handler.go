@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DigestHandler lets staff choose when they get their digest email.
+type DigestHandler struct {
+	Digests *services.StaffDigests
+}
+
+func NewDigestHandler(digests *services.StaffDigests) *DigestHandler {
+	return &DigestHandler{Digests: digests}
+}
+
+// GetDigestSchedule returns the caller's digest schedule, switched off
+// until they choose one.
+func (h *DigestHandler) GetDigestSchedule(c *gin.Context) {
+	schedule, err := h.Digests.Schedules.Get(c.GetInt64("userID"))
+	if errors.Is(err, repository.ErrNotFound) {
+		schedule = models.DigestSchedule{Frequency: models.DigestOff, Hour: 8, Weekday: 1, Timezone: "UTC"}
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch digest schedule", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": schedule})
+}
+
+// PutDigestSchedule sets when the caller gets their digest, in the hour
+// and timezone they give.
+func (h *DigestHandler) PutDigestSchedule(c *gin.Context) {
+	var req models.DigestScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	userID := c.GetInt64("userID")
+	before, err := h.Digests.Schedules.Get(userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch digest schedule", "details": err.Error()})
+		return
+	}
+
+	schedule, err := h.Digests.SetSchedule(userID, req)
+	if errors.Is(err, services.ErrInvalidTimezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save digest schedule", "details": err.Error()})
+		return
+	}
+	var previous interface{}
+	if before.UserID != 0 {
+		previous = before
+	}
+	auditChange(c, "user.digest_schedule", "user", strconv.FormatInt(userID, 10), previous, schedule)
+	c.JSON(http.StatusOK, gin.H{"message": "Digest schedule updated", "data": schedule})
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailTemplateHandler lets admins reword the emails the system sends, per
+// event and language, and preview them before saving.
+type EmailTemplateHandler struct {
+	Templates *services.EmailTemplates
+	Mailer    *services.Mailer
+}
+
+func NewEmailTemplateHandler(templates *services.EmailTemplates, mailer *services.Mailer) *EmailTemplateHandler {
+	return &EmailTemplateHandler{Templates: templates, Mailer: mailer}
+}
+
+// templateParams reads and checks the :event and :language path parameters.
+func templateParams(c *gin.Context) (string, string, bool) {
+	event, language := c.Param("event"), c.Param("language")
+	if !services.IsEmailEvent(event) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown email event"})
+		return "", "", false
+	}
+	if !services.ValidLanguage(language) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language", "details": "expected a code such as en or hi"})
+		return "", "", false
+	}
+	return event, language, true
+}
+
+// ListEmailTemplates lists every event with the languages it has a
+// built-in template or an override in.
+func (h *EmailTemplateHandler) ListEmailTemplates(c *gin.Context) {
+	overrides, err := h.Templates.Overrides.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email templates", "details": err.Error()})
+		return
+	}
+	overridden := map[[2]string]bool{}
+	for _, t := range overrides {
+		overridden[[2]string{t.Event, t.Language}] = true
+	}
+
+	type languageInfo struct {
+		Language   string `json:"language"`
+		Builtin    bool   `json:"builtin"`
+		Overridden bool   `json:"overridden"`
+	}
+	events := []gin.H{}
+	for _, e := range services.EmailEvents {
+		languages := []languageInfo{}
+		for _, lang := range h.Templates.BuiltinLanguages() {
+			if _, ok := h.Templates.Builtin(e.Event, lang); ok {
+				languages = append(languages, languageInfo{Language: lang, Builtin: true, Overridden: overridden[[2]string{e.Event, lang}]})
+			}
+		}
+		for _, t := range overrides {
+			if _, ok := h.Templates.Builtin(t.Event, t.Language); t.Event == e.Event && !ok {
+				languages = append(languages, languageInfo{Language: t.Language, Overridden: true})
+			}
+		}
+		sort.Slice(languages, func(i, j int) bool { return languages[i].Language < languages[j].Language })
+		events = append(events, gin.H{"event": e.Event, "description": e.Description, "languages": languages})
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "default_language": models.DefaultLanguage})
+}
+
+// GetEmailTemplate returns the template used for an event in a language and
+// where it comes from: "override", "builtin", or "fallback" when the
+// language has neither and the default language's template is used.
+func (h *EmailTemplateHandler) GetEmailTemplate(c *gin.Context) {
+	event, language, ok := templateParams(c)
+	if !ok {
+		return
+	}
+	tmpl, err := h.Templates.Lookup(event, language)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email template", "details": err.Error()})
+		return
+	}
+	source := "fallback"
+	if tmpl.Language == language {
+		source = "builtin"
+		if tmpl.UpdatedAt != nil {
+			source = "override"
+		}
+	}
+	resp := gin.H{"template": tmpl, "source": source}
+	if builtin, ok := h.Templates.Builtin(event, language); ok {
+		resp["builtin"] = builtin
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// PutEmailTemplate saves an override. It is rejected unless it renders with
+// sample data, so a typo cannot stop emails from going out.
+func (h *EmailTemplateHandler) PutEmailTemplate(c *gin.Context) {
+	event, language, ok := templateParams(c)
+	if !ok {
+		return
+	}
+	var req models.EmailTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tmpl := models.EmailTemplate{Event: event, Language: language, Subject: req.Subject, TextBody: req.TextBody, HTMLBody: req.HTMLBody}
+	if _, err := services.RenderTemplate(tmpl, services.SampleEmailData(h.Mailer.AppURL)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template", "details": err.Error()})
+		return
+	}
+
+	before, err := h.Templates.Overrides.Get(event, language)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email template", "details": err.Error()})
+		return
+	}
+	userID := c.GetInt64("userID")
+	tmpl.UpdatedBy = &userID
+	if err := h.Templates.Overrides.Save(tmpl); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save email template", "details": err.Error()})
+		return
+	}
+	saved, err := h.Templates.Overrides.Get(event, language)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email template", "details": err.Error()})
+		return
+	}
+
+	var auditBefore interface{}
+	if before.Event != "" {
+		auditBefore = before
+	}
+	auditChange(c, "email_template.update", "email_template", event+"/"+language, auditBefore, saved)
+	c.JSON(http.StatusOK, gin.H{"message": "email template saved", "template": saved})
+}
+
+// DeleteEmailTemplate removes an override, going back to the built-in
+// template.
+func (h *EmailTemplateHandler) DeleteEmailTemplate(c *gin.Context) {
+	event, language, ok := templateParams(c)
+	if !ok {
+		return
+	}
+	before, err := h.Templates.Overrides.Get(event, language)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email template has no override"})
+		return
+	}
+	if err == nil {
+		err = h.Templates.Overrides.Delete(event, language)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete email template", "details": err.Error()})
+		return
+	}
+	auditChange(c, "email_template.delete", "email_template", event+"/"+language, before, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "email template override deleted"})
+}
+
+// PreviewEmailTemplate renders an event's email in a language with sample
+// data. With a body, the unsaved template in it is rendered instead.
+func (h *EmailTemplateHandler) PreviewEmailTemplate(c *gin.Context) {
+	event, language, ok := templateParams(c)
+	if !ok {
+		return
+	}
+	var req models.EmailTemplateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tmpl := models.EmailTemplate{Event: event, Language: language, Subject: req.Subject, TextBody: req.TextBody, HTMLBody: req.HTMLBody}
+	if req.Subject == "" && req.TextBody == "" && req.HTMLBody == "" {
+		var err error
+		tmpl, err = h.Templates.Lookup(event, language)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email template", "details": err.Error()})
+			return
+		}
+	}
+	email, err := services.RenderTemplate(tmpl, services.SampleEmailData(h.Mailer.AppURL))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template", "details": err.Error()})
+		return
+	}
+	raw, err := h.Mailer.Encode("citizen@example.com", email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode email", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"email": email, "language": tmpl.Language, "mime": string(raw)})
+}
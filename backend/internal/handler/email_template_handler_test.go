@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/services"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (env *testEnv) emailTemplateRoutes(t *testing.T) http.Handler {
+	r := env.router(t, 1)
+	h, manage := NewEmailTemplateHandler(env.Notifier.Mailer.Templates, env.Notifier.Mailer), env.Auth.RequirePermission(services.PermTemplateManage)
+	r.GET("/email-templates/:event/:language", manage, h.GetEmailTemplate)
+	r.PUT("/email-templates/:event/:language", manage, h.PutEmailTemplate)
+	r.DELETE("/email-templates/:event/:language", manage, h.DeleteEmailTemplate)
+	r.POST("/email-templates/:event/:language/preview", manage, h.PreviewEmailTemplate)
+	return r
+}
+
+// templateSource returns where the template GET returns comes from.
+func templateSource(t *testing.T, r http.Handler, path string) string {
+	t.Helper()
+	w := serve(r, "GET", path, nil)
+	var resp struct {
+		Source string `json:"source"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+	}
+	return resp.Source
+}
+
+func TestEmailTemplateOverride(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.emailTemplateRoutes(t)
+	const path = "/email-templates/complaint.updated/hi"
+
+	if source := templateSource(t, r, path); source != "builtin" {
+		t.Fatalf("source %q, want builtin", source)
+	}
+	if source := templateSource(t, r, "/email-templates/staff.invite/hi"); source != "fallback" {
+		t.Fatalf("staff invite in Hindi from %q, want fallback", source)
+	}
+	for name, tc := range map[string]struct {
+		path string
+		want int
+	}{
+		"unknown event":    {"/email-templates/complaint.deleted/hi", http.StatusNotFound},
+		"invalid language": {"/email-templates/complaint.updated/hindi", http.StatusBadRequest},
+	} {
+		if w := serve(r, "GET", tc.path, nil); w.Code != tc.want {
+			t.Errorf("%s: %d, want %d", name, w.Code, tc.want)
+		}
+	}
+
+	bad := models.EmailTemplateRequest{Subject: "{{.Nickname}}", TextBody: "text"}
+	if w := serve(r, "PUT", path, bad); w.Code != http.StatusBadRequest {
+		t.Fatalf("template that does not render: %d, want 400", w.Code)
+	}
+	override := models.EmailTemplateRequest{Subject: "शिकायत #{{.ComplaintID}}", TextBody: "{{.Comment}}"}
+	if w := serve(r, "PUT", path, override); w.Code != http.StatusOK {
+		t.Fatalf("save override: %d %s", w.Code, w.Body)
+	}
+	if source := templateSource(t, r, path); source != "override" {
+		t.Fatalf("source %q, want override", source)
+	}
+	if w := serve(r, "DELETE", path, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete override: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "DELETE", path, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("delete missing override: %d, want 404", w.Code)
+	}
+}
+
+func TestPreviewEmailTemplate(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.emailTemplateRoutes(t)
+
+	draft := models.EmailTemplateRequest{Subject: "Draft about {{.Title}}", TextBody: "{{.Comment}}", HTMLBody: "<p>{{.Comment}}</p>"}
+	w := serve(r, "POST", "/email-templates/complaint.updated/en/preview", draft)
+	var preview struct {
+		Email services.RenderedEmail `json:"email"`
+		MIME  string                 `json:"mime"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("preview draft: %d %s", w.Code, w.Body)
+	}
+	sample := services.SampleEmailData("")
+	if preview.Email.Subject != "Draft about "+sample.Title || !strings.Contains(preview.MIME, "multipart/alternative") {
+		t.Fatalf("preview %+v", preview)
+	}
+	// The draft is not saved.
+	if tmpl, _ := env.Notifier.Mailer.Templates.Lookup(services.NotifyComplaintUpdated, "en"); tmpl.UpdatedAt != nil {
+		t.Fatal("previewing saved the draft")
+	}
+
+	if w := serve(r, "POST", "/email-templates/complaint.updated/hi/preview", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"language":"hi"`) {
+		t.Fatalf("preview saved template: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/email-templates/complaint.updated/en/preview", models.EmailTemplateRequest{Subject: "{{.Nickname}}", TextBody: "text"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("preview of a broken draft: %d, want 400", w.Code)
+	}
+}
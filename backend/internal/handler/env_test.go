@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bytes"
+	"complain/internal/config"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"complain/internal/storage"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testEnv is the API on memory repositories, with an admin (user 1), an
+// official (user 2) and a citizen (user 3). Notifications from every
+// repository go to one outbox, and texts to the fake SMS sender.
+type testEnv struct {
+	Users         *repository.MemoryUserRepository
+	Complaints    *repository.MemoryComplaintRepository
+	Roles         *repository.MemoryRoleRepository
+	Departments   *repository.MemoryDepartmentRepository
+	Webhooks      *repository.MemoryWebhookRepository
+	Outbox        *repository.MemoryOutboxRepository
+	Notifications *repository.MemoryNotificationRepository
+	SMS           *services.FakeSMSSender
+	Notifier      *services.Notifier
+	Auth          *AuthService
+	UserHandler   *UserHandler
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var adminPermissions []string
+	for _, p := range services.AllPermissions {
+		adminPermissions = append(adminPermissions, p.Name)
+	}
+	roles := []models.Role{
+		{Name: "admin", IsSystem: true, RequiresMFA: true, Permissions: adminPermissions},
+		{Name: "official", IsSystem: true, Permissions: []string{services.PermComplaintViewAll, services.PermComplaintUpdate}},
+		{Name: "user", IsSystem: true, Permissions: []string{services.PermComplaintCreate, services.PermComplaintViewOwn, services.PermComplaintReply}},
+	}
+	env := &testEnv{
+		Users:         repository.NewMemoryUserRepository(),
+		Complaints:    repository.NewMemoryComplaintRepository(),
+		Roles:         repository.NewMemoryRoleRepository(roles...),
+		Departments:   repository.NewMemoryDepartmentRepository(),
+		Webhooks:      repository.NewMemoryWebhookRepository(),
+		Outbox:        repository.NewMemoryOutboxRepository(),
+		Notifications: repository.NewMemoryNotificationRepository(),
+		SMS:           services.NewFakeSMSSender(),
+	}
+	env.Users.Outbox, env.Complaints.Outbox = env.Outbox, env.Outbox
+	for _, role := range roles {
+		env.Users.RolePermissions[role.Name] = role.Permissions
+	}
+	for _, u := range []models.User{
+		{Name: "Admin", Email: "admin@example.com", Role: "admin"},
+		{Name: "Official", Email: "official@example.com", Role: "official"},
+		{Name: "Citizen", Email: "citizen@example.com", Role: "user"},
+	} {
+		if err := env.Users.Create(&u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.Auth = NewAuthService("test-secret", env.Users, services.NewRoleStore(env.Roles))
+	templates, err := services.NewEmailTemplates(repository.NewMemoryEmailTemplateRepository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := services.NewMailer(config.SMTPConfig{From: "complaints@example.org"}, "https://complaints.example.org", templates, nil)
+	env.Notifier = services.NewNotifier(env.Outbox, env.Notifications, mailer, env.SMS, services.NewWebhooks(env.Webhooks),
+		config.NotifyConfig{Workers: 1, MaxAttempts: 3, RetryBase: time.Minute, RetryMax: time.Hour, PollInterval: time.Second})
+	limiter := services.NewLoginLimiter(repository.NewMemoryLoginFailureRepository(), services.DefaultAccountPolicy, services.DefaultIPPolicy)
+	env.UserHandler = NewUserHandler(env.Users, env.Complaints, env.Auth, env.Notifier, limiter)
+	return env
+}
+
+// router serves requests as the given user, who has passed 2FA.
+func (env *testEnv) router(t *testing.T, userID int64) *gin.Engine {
+	t.Helper()
+	user, err := env.Users.GetByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userID", user.ID)
+		c.Set("userRole", user.Role)
+		c.Set("mfaVerified", true)
+	})
+	return r
+}
+
+func serve(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func (env *testEnv) complaintRoutes(t *testing.T, userID int64, events services.EventBus) http.Handler {
+	r := env.router(t, userID)
+	store := storage.NewMemoryStore(storage.NewURLSigner("https://api.example.org/files", "signing-secret"))
+	uploader := services.NewUploader(store, 15*time.Minute, config.AttachmentConfig{}, nil, services.NewImagePipeline(config.ImageConfig{}))
+	h := NewComplaintHandler(env.Complaints, repository.NewMemoryAttachmentRepository(env.Complaints), env.Users, env.Notifier, uploader, nil,
+		events, services.NewRoleStore(env.Roles))
+	update := env.Auth.RequirePermission(services.PermComplaintUpdate)
+	r.POST("/complaints/:id/assign", update, env.Auth.RequirePermission(services.PermComplaintAssign), h.Assign)
+	r.POST("/complaints/:id/escalate", update, h.Escalate)
+	r.POST("/complaints/:id/updates", update, h.AddUpdate)
+	r.POST("/complaints/:id/replies", env.Auth.RequirePermission(services.PermComplaintReply), h.Reply)
+	r.GET("/complaints/:id/thread", env.Auth.RequirePermission(), h.GetThread)
+	return r
+}
+
+// fileComplaint files a public complaint in category as the citizen.
+func (env *testEnv) fileComplaint(t *testing.T, category int) models.Complaint {
+	t.Helper()
+	complaint, err := env.Complaints.Create(3, models.CreateComplaintRequest{Title: "Broken streetlight", Category: category, IsPublic: true}, models.StatusPending, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return complaint
+}
+
+// inbox returns the in-app notifications queued for userID.
+func (env *testEnv) inbox(t *testing.T, userID int64) []models.OutboxMessage {
+	t.Helper()
+	messages, err := env.Outbox.List(repository.OutboxFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inbox []models.OutboxMessage
+	for _, m := range messages {
+		if m.Channel == models.ChannelInApp && m.Recipient == strconv.FormatInt(userID, 10) {
+			inbox = append(inbox, m)
+		}
+	}
+	return inbox
+}
+
+// deliverInApp puts the in-app notifications waiting in the outbox into
+// their recipients' inboxes, as a worker would.
+func (env *testEnv) deliverInApp(t *testing.T) {
+	t.Helper()
+	messages, err := env.Outbox.List(repository.OutboxFilter{Status: models.OutboxPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Oldest first, as the workers take them.
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		if m.Channel != models.ChannelInApp {
+			continue
+		}
+		if err := env.Notifier.Deliver(m); err != nil {
+			t.Fatalf("delivering notification %d: %v", m.ID, err)
+		}
+		if err := env.Outbox.MarkSent(m.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
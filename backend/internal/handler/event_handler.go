@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// EventHandler streams complaint events to dashboards with Server-Sent
+// Events, so they can show new complaints and updates without reloading.
+type EventHandler struct {
+	Events     services.EventBus
+	Complaints repository.ComplaintRepository
+	Auth       *AuthService
+	// Heartbeat is how often an idle stream sends a comment and checks that
+	// the caller's session is still valid.
+	Heartbeat time.Duration
+}
+
+func NewEventHandler(events services.EventBus, complaints repository.ComplaintRepository, auth *AuthService, heartbeat time.Duration) *EventHandler {
+	return &EventHandler{Events: events, Complaints: complaints, Auth: auth, Heartbeat: heartbeat}
+}
+
+// eventComplaint loads the complaint an event is about if the caller may
+// see it: their own complaints, and for staff those in their jurisdiction.
+// staff is nil for callers who may not view other people's complaints, who
+// are never sent staff-only events.
+func (h *EventHandler) eventComplaint(userID int64, staff *repository.ComplaintFilter, event services.ComplaintEvent) (models.Complaint, bool) {
+	filter := repository.ComplaintFilter{UserID: userID}
+	if event.OwnerID != userID || event.StaffOnly {
+		if staff == nil {
+			return models.Complaint{}, false
+		}
+		filter = *staff
+	}
+	complaint, err := h.Complaints.Get(event.ComplaintID, filter)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			fmt.Printf("Failed to load complaint %d for event stream: %v\n", event.ComplaintID, err)
+		}
+		return complaint, false
+	}
+	return complaint, true
+}
+
+// Stream sends complaint.created, complaint.updated and complaint.assigned
+// events for the complaints the caller can see, each with the complaint's
+// current state. A "resync" event means events were missed and the client
+// should reload. The stream ends with an "expired" event once the caller's
+// token expires or is revoked.
+func (h *EventHandler) Stream(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	var staff *repository.ComplaintFilter
+	if hasPermission(c, services.PermComplaintViewAll) {
+		filter := visibleComplaints(c)
+		staff = &filter
+	}
+	heartbeat := time.NewTicker(h.Heartbeat)
+	defer heartbeat.Stop()
+
+	subscription := h.Events.Subscribe()
+	defer subscription.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Render(-1, sse.Event{Event: "ready", Data: gin.H{"heartbeat": h.Heartbeat.Seconds()}})
+	c.Writer.Flush()
+
+	var sent int64
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			if !h.Auth.StillAuthenticated(c) {
+				c.Render(-1, sse.Event{Event: "expired", Data: gin.H{"error": "Session is no longer valid, please log in again"}})
+				return false
+			}
+			// A comment line, which EventSource ignores.
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-subscription.Events:
+			if subscription.Lagged() || event.Type == services.EventResync {
+				c.Render(-1, sse.Event{Event: services.EventResync, Data: gin.H{"reason": "events were missed"}})
+			}
+			if event.Type == services.EventResync {
+				return true
+			}
+			complaint, ok := h.eventComplaint(userID, staff, event)
+			if !ok {
+				return true
+			}
+			sent++
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatInt(sent, 10),
+				Event: event.Type,
+				Data: gin.H{
+					"type":      event.Type,
+					"at":        event.At,
+					"complaint": complaint,
+				},
+			})
+		}
+		return true
+	})
+}
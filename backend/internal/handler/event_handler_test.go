@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"bufio"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is an event read from a stream.
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// openStream connects userID to the event stream and returns its events.
+// The channel is closed when the stream ends.
+func (env *testEnv) openStream(t *testing.T, userID int64, events services.EventBus) <-chan sseEvent {
+	t.Helper()
+	r := env.router(t, userID)
+	r.GET("/events", env.Auth.RequirePermission(), NewEventHandler(events, env.Complaints, env.Auth, 20*time.Millisecond).Stream)
+	server := httptest.NewServer(r)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("stream: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	received := make(chan sseEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(received)
+		var event sseEvent
+		lines := bufio.NewScanner(resp.Body)
+		for lines.Scan() {
+			name, value, _ := strings.Cut(lines.Text(), ":")
+			switch name {
+			case "event":
+				event.Event = value
+			case "data":
+				event.Data = value
+			case "":
+				// A blank line ends an event; comments have no name.
+				if value == "" && event.Event != "" {
+					received <- event
+					event = sseEvent{}
+				}
+			}
+		}
+	}()
+	return received
+}
+
+// nextEvent waits for the stream's next event. ok is false if the stream
+// ended.
+func nextEvent(t *testing.T, stream <-chan sseEvent) (sseEvent, bool) {
+	t.Helper()
+	select {
+	case event, ok := <-stream:
+		return event, ok
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event within 2s")
+	}
+	return sseEvent{}, false
+}
+
+// eventAbout returns the complaint an event's data describes.
+func eventAbout(t *testing.T, event sseEvent) int64 {
+	t.Helper()
+	var data struct {
+		Complaint models.Complaint `json:"complaint"`
+	}
+	if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+		t.Fatalf("%s data %q: %v", event.Event, event.Data, err)
+	}
+	return data.Complaint.ID
+}
+
+func TestEventStream(t *testing.T) {
+	env := newTestEnv(t)
+	own := env.fileComplaint(t, 1)
+	other, err := env.Complaints.Create(2, models.CreateComplaintRequest{Title: "Fallen tree", Category: 1, IsPublic: true}, models.StatusPending, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := services.NewMemoryEventBus()
+	citizen, admin := env.openStream(t, 3, events), env.openStream(t, 1, events)
+	for _, stream := range []<-chan sseEvent{citizen, admin} {
+		if event, _ := nextEvent(t, stream); event.Event != "ready" {
+			t.Fatalf("first event %+v, want ready", event)
+		}
+	}
+
+	now := time.Now()
+	events.Publish(services.ComplaintEvent{Type: services.EventComplaintCreated, ComplaintID: other.ID, OwnerID: other.UserID, At: now})
+	events.Publish(services.ComplaintEvent{Type: services.EventComplaintUpdated, ComplaintID: own.ID, OwnerID: own.UserID, At: now, StaffOnly: true})
+	events.Publish(services.ComplaintEvent{Type: services.EventComplaintAssigned, ComplaintID: own.ID, OwnerID: own.UserID, At: now})
+
+	// The citizen only hears about their own complaint, and not of notes.
+	if event, _ := nextEvent(t, citizen); event.Event != services.EventComplaintAssigned || eventAbout(t, event) != own.ID {
+		t.Fatalf("citizen's event %+v, want their complaint's assignment", event)
+	}
+	for _, want := range []struct {
+		event string
+		id    int64
+	}{{services.EventComplaintCreated, other.ID}, {services.EventComplaintUpdated, own.ID}, {services.EventComplaintAssigned, own.ID}} {
+		if event, _ := nextEvent(t, admin); event.Event != want.event || eventAbout(t, event) != want.id {
+			t.Fatalf("admin's event %+v, want %s of complaint %d", event, want.event, want.id)
+		}
+	}
+
+	events.Publish(services.ComplaintEvent{Type: services.EventResync, At: now})
+	if event, _ := nextEvent(t, citizen); event.Event != services.EventResync {
+		t.Fatalf("event %+v, want resync", event)
+	}
+
+	// The stream ends at the next heartbeat once the session is revoked.
+	if err := env.Users.SetActive(3, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if event, _ := nextEvent(t, citizen); event.Event != "expired" {
+		t.Fatalf("event %+v, want expired", event)
+	}
+	if event, ok := nextEvent(t, citizen); ok {
+		t.Fatalf("event %+v after the stream expired", event)
+	}
+}
+
+func TestInternalNoteEventsAreStaffOnly(t *testing.T) {
+	env := newTestEnv(t)
+	complaint := env.fileComplaint(t, 1)
+	events := services.NewMemoryEventBus()
+	subscription := events.Subscribe()
+	defer subscription.Close()
+	r := env.complaintRoutes(t, 1, events)
+
+	note := models.AddUpdateComment{Comment: "Waiting on the roads budget", Visibility: models.VisibilityInternal}
+	if w := serve(r, "POST", "/complaints/1/updates", note); w.Code != http.StatusOK {
+		t.Fatalf("internal note: %d %s", w.Code, w.Body)
+	}
+	var event services.ComplaintEvent
+	select {
+	case event = <-subscription.Events:
+	default:
+		t.Fatal("no event published for the note")
+	}
+	if event.Type != services.EventComplaintUpdated || !event.StaffOnly {
+		t.Fatalf("event %+v, want a staff-only complaint.updated", event)
+	}
+
+	h := NewEventHandler(events, env.Complaints, env.Auth, 0)
+	if _, ok := h.eventComplaint(complaint.UserID, nil, event); ok {
+		t.Error("the citizen was sent the internal note's event")
+	}
+	if _, ok := h.eventComplaint(1, &repository.ComplaintFilter{}, event); !ok {
+		t.Error("staff were not sent the internal note's event")
+	}
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"complain/internal/storage"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileHandler serves objects from stores that have no web server of their
+// own (the local and memory backends), through URLs signed by Signer.
+type FileHandler struct {
+	Store  storage.ObjectStore
+	Signer *storage.URLSigner
+}
+
+func NewFileHandler(store storage.ObjectStore, signer *storage.URLSigner) *FileHandler {
+	return &FileHandler{Store: store, Signer: signer}
+}
+
+// ServeFile streams the object named by the *key path parameter if the URL
+// carries a valid, unexpired signature.
+func (h *FileHandler) ServeFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !h.Signer.Verify(key, c.Request.URL.Query(), time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This link is invalid or has expired"})
+		return
+	}
+	body, info, err := h.Store.Get(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file", "details": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, body, map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
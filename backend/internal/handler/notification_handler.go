@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"complain/internal/repository"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationHandler serves the caller's in-app notification inbox.
+type NotificationHandler struct {
+	Notifications repository.NotificationRepository
+}
+
+func NewNotificationHandler(notifications repository.NotificationRepository) *NotificationHandler {
+	return &NotificationHandler{Notifications: notifications}
+}
+
+// GetNotifications lists the caller's notifications, newest first, with the
+// number still unread. ?unread=true lists only those.
+func (h *NotificationHandler) GetNotifications(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	filter := repository.NotificationFilter{UnreadOnly: c.Query("unread") == "true"}
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	filter.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if filter.Limit <= 0 || filter.Limit > 200 {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	notifications, err := h.Notifications.List(userID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications", "details": err.Error()})
+		return
+	}
+	unread, err := h.Notifications.CountUnread(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread, "limit": filter.Limit, "offset": filter.Offset})
+}
+
+// GetUnreadCount is the number on the bell; it is cheap enough to poll.
+func (h *NotificationHandler) GetUnreadCount(c *gin.Context) {
+	unread, err := h.Notifications.CountUnread(c.GetInt64("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+	notification, err := h.Notifications.MarkRead(c.GetInt64("userID"), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notification": notification})
+}
+
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	marked, err := h.Notifications.MarkAllRead(c.GetInt64("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "notifications marked read", "marked": marked})
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (env *testEnv) notificationRoutes(t *testing.T, userID int64) http.Handler {
+	r := env.router(t, userID)
+	h, signedIn := NewNotificationHandler(env.Notifications), env.Auth.RequirePermission()
+	r.GET("/notifications", signedIn, h.GetNotifications)
+	r.GET("/notifications/unread-count", signedIn, h.GetUnreadCount)
+	r.POST("/notifications/:id/read", signedIn, h.MarkRead)
+	r.POST("/notifications/read-all", signedIn, h.MarkAllRead)
+	r.GET("/me/notification-preferences", signedIn, env.UserHandler.GetNotificationPreferences)
+	r.PUT("/me/notification-preferences", signedIn, env.UserHandler.UpdateNotificationPreferences)
+	return r
+}
+
+// notificationList is the body of GET /notifications.
+type notificationList struct {
+	Notifications []models.Notification `json:"notifications"`
+	Unread        int                   `json:"unread"`
+}
+
+func listNotifications(t *testing.T, r http.Handler, path string) notificationList {
+	t.Helper()
+	w := serve(r, "GET", path, nil)
+	var list notificationList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+	}
+	return list
+}
+
+func TestNotificationInbox(t *testing.T) {
+	env := newTestEnv(t)
+	complaint := env.fileComplaint(t, 1)
+	staff := env.complaintRoutes(t, 1, services.NewMemoryEventBus())
+	for _, status := range []string{models.StatusInProgress, models.StatusResolved} {
+		update := models.AddUpdateComment{Comment: "Crew booked", Status: status}
+		if w := serve(staff, "POST", "/complaints/1/updates", update); w.Code != http.StatusOK {
+			t.Fatalf("update: %d %s", w.Code, w.Body)
+		}
+	}
+	env.deliverInApp(t)
+	// A message delivered again, after its worker's lease ran out, is not
+	// added twice.
+	sent, _ := env.Outbox.List(repository.OutboxFilter{Status: models.OutboxSent})
+	for _, m := range sent {
+		if m.Channel == models.ChannelInApp {
+			if err := env.Notifier.Deliver(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	citizen, official := env.notificationRoutes(t, 3), env.notificationRoutes(t, 2)
+
+	list := listNotifications(t, citizen, "/notifications")
+	if list.Unread != 2 || len(list.Notifications) != 2 {
+		t.Fatalf("citizen's inbox %+v, want two unread", list)
+	}
+	// Newest first, summed up like the email subject.
+	resolved := list.Notifications[0]
+	if resolved.Kind != services.NotifyComplaintResolved || *resolved.ComplaintID != complaint.ID || !strings.Contains(resolved.Summary, "#1") {
+		t.Fatalf("newest notification %+v", resolved)
+	}
+	if list := listNotifications(t, official, "/notifications"); len(list.Notifications) != 0 {
+		t.Fatalf("official's inbox %+v, want it empty", list)
+	}
+
+	if w := serve(official, "POST", "/notifications/1/read", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("marking someone else's notification read: %d, want 404", w.Code)
+	}
+	if w := serve(citizen, "POST", "/notifications/1/read", nil); w.Code != http.StatusOK {
+		t.Fatalf("mark read: %d %s", w.Code, w.Body)
+	}
+	if w := serve(citizen, "GET", "/notifications/unread-count", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"unread":1`) {
+		t.Fatalf("unread count: %d %s", w.Code, w.Body)
+	}
+	if list := listNotifications(t, citizen, "/notifications?unread=true"); len(list.Notifications) != 1 || list.Notifications[0].ID != resolved.ID {
+		t.Fatalf("unread notifications %+v", list.Notifications)
+	}
+	if w := serve(citizen, "POST", "/notifications/read-all", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"marked":1`) {
+		t.Fatalf("mark all read: %d %s", w.Code, w.Body)
+	}
+	if list := listNotifications(t, citizen, "/notifications"); list.Unread != 0 || len(list.Notifications) != 2 {
+		t.Fatalf("inbox after reading everything %+v", list)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	env := newTestEnv(t)
+	env.fileComplaint(t, 1)
+	citizen := env.notificationRoutes(t, 3)
+	staff := env.complaintRoutes(t, 1, services.NewMemoryEventBus())
+
+	if w := serve(citizen, "GET", "/me/notification-preferences", nil); !strings.Contains(w.Body.String(), `"channel":"email","frequency":"immediate"`) {
+		t.Fatalf("default preferences: %d %s", w.Code, w.Body)
+	}
+	for name, prefs := range map[string]models.NotificationPreferences{
+		"unknown channel":     {Channel: "pigeon", Frequency: models.NotifyImmediately},
+		"unknown frequency":   {Channel: models.NotifyByEmail, Frequency: "weekly"},
+		"SMS without a phone": {Channel: models.NotifyBySMS, Frequency: models.NotifyImmediately},
+	} {
+		if w := serve(citizen, "PUT", "/me/notification-preferences", prefs); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", name, w.Code)
+		}
+	}
+
+	// Each update is told about the way the citizen last asked for.
+	for _, tc := range []struct {
+		prefs    models.NotificationPreferences
+		channels []string
+		held     bool
+	}{
+		{models.NotificationPreferences{Channel: models.NotifyInApp, Frequency: models.NotifyImmediately}, []string{models.ChannelInApp}, false},
+		{models.NotificationPreferences{Channel: models.NotifyByEmail, Frequency: models.NotifyDaily}, []string{models.ChannelInApp, models.ChannelEmail}, true},
+		{models.NotificationPreferences{Channel: models.NotifyNone, Frequency: models.NotifyImmediately}, nil, false},
+	} {
+		if w := serve(citizen, "PUT", "/me/notification-preferences", tc.prefs); w.Code != http.StatusOK {
+			t.Fatalf("set %+v: %d %s", tc.prefs, w.Code, w.Body)
+		}
+		before, _ := env.Outbox.List(repository.OutboxFilter{})
+		if w := serve(staff, "POST", "/complaints/1/updates", models.AddUpdateComment{Comment: "Crew booked"}); w.Code != http.StatusOK {
+			t.Fatalf("update: %d %s", w.Code, w.Body)
+		}
+		after, _ := env.Outbox.List(repository.OutboxFilter{})
+		// Newest first.
+		queued := after[:len(after)-len(before)]
+		var channels []string
+		for i := len(queued) - 1; i >= 0; i-- {
+			channels = append(channels, queued[i].Channel)
+			if queued[i].Channel == models.ChannelEmail && (queued[i].Status == models.OutboxHeld) != tc.held {
+				t.Errorf("%+v: email %s, want held %v", tc.prefs, queued[i].Status, tc.held)
+			}
+		}
+		if strings.Join(channels, ",") != strings.Join(tc.channels, ",") {
+			t.Errorf("%+v: queued on %v, want %v", tc.prefs, channels, tc.channels)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OutboxHandler lets admins see what notifications were sent, which are
+// waiting to be retried and which were given up on, and retry those.
+type OutboxHandler struct {
+	Notifier *services.Notifier
+}
+
+func NewOutboxHandler(notifier *services.Notifier) *OutboxHandler {
+	return &OutboxHandler{Notifier: notifier}
+}
+
+// redactPayload hides the one-time tokens of messages not yet sent; admins
+// must not be able to use someone else's invite or reset link.
+func redactPayload(m *models.OutboxMessage) {
+	var payload map[string]interface{}
+	if json.Unmarshal(m.Payload, &payload) != nil {
+		return
+	}
+	if _, ok := payload["token"]; ok {
+		payload["token"] = "[redacted]"
+		m.Payload, _ = json.Marshal(payload)
+	}
+}
+
+// outboxParam loads the message named by the :id path parameter, writing
+// the response and returning false if there is none.
+func (h *OutboxHandler) outboxParam(c *gin.Context) (models.OutboxMessage, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return models.OutboxMessage{}, false
+	}
+	message, err := h.Notifier.Outbox.Get(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return message, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification", "details": err.Error()})
+		return message, false
+	}
+	return message, true
+}
+
+// GetOutbox lists notifications, newest first, optionally filtered by
+// status (pending, sending, sent, dead, held or digested), kind and
+// complaint_id.
+func (h *OutboxHandler) GetOutbox(c *gin.Context) {
+	filter := repository.OutboxFilter{
+		Status: c.Query("status"),
+		Kind:   c.Query("kind"),
+	}
+	switch filter.Status {
+	case "", models.OutboxPending, models.OutboxSending, models.OutboxSent, models.OutboxDead, models.OutboxHeld, models.OutboxDigested:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, expected pending, sending, sent, dead, held or digested"})
+		return
+	}
+	if v := c.Query("complaint_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid complaint_id"})
+			return
+		}
+		filter.ComplaintID = id
+	}
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "100"))
+	filter.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	messages, err := h.Notifier.Outbox.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications", "details": err.Error()})
+		return
+	}
+	for i := range messages {
+		redactPayload(&messages[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": messages, "limit": filter.Limit, "offset": filter.Offset})
+}
+
+func (h *OutboxHandler) GetOutboxMessage(c *gin.Context) {
+	message, ok := h.outboxParam(c)
+	if !ok {
+		return
+	}
+	redactPayload(&message)
+	c.JSON(http.StatusOK, gin.H{"notification": message})
+}
+
+// RetryOutboxMessage queues a dead or pending notification for delivery
+// now, with a fresh set of attempts.
+func (h *OutboxHandler) RetryOutboxMessage(c *gin.Context) {
+	before, ok := h.outboxParam(c)
+	if !ok {
+		return
+	}
+	message, err := h.Notifier.Outbox.Retry(before.ID)
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending or dead notifications can be retried", "status": before.Status})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry notification", "details": err.Error()})
+		return
+	}
+	h.Notifier.Wake()
+
+	auditChange(c, "notification.retry", "notification", strconv.FormatInt(message.ID, 10),
+		gin.H{"status": before.Status, "attempts": before.Attempts, "last_error": before.LastError},
+		gin.H{"status": message.Status, "attempts": message.Attempts})
+	redactPayload(&message)
+	c.JSON(http.StatusOK, gin.H{"message": "notification queued for delivery", "notification": message})
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (env *testEnv) outboxRoutes(t *testing.T) http.Handler {
+	r := env.router(t, 1)
+	h, manage := NewOutboxHandler(env.Notifier), env.Auth.RequirePermission(services.PermNotificationManage)
+	r.GET("/notifications", manage, h.GetOutbox)
+	r.GET("/notifications/:id", manage, h.GetOutboxMessage)
+	r.POST("/notifications/:id/retry", manage, h.RetryOutboxMessage)
+	return r
+}
+
+func TestRetryDeadNotification(t *testing.T) {
+	env := newTestEnv(t)
+	citizen, _ := env.Users.GetByID(3)
+	if err := env.Outbox.Enqueue([]models.OutboxMessage{
+		services.PasswordResetEmail(citizen, "reset-token"),
+		services.AccountLockedEmail(citizen, time.Now().Add(time.Hour)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The reset link is sent, the lockout email given up on.
+	claimed, err := env.Outbox.Claim(2, time.Minute)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("claimed %+v, %v", claimed, err)
+	}
+	env.Outbox.MarkSent(1)
+	env.Outbox.MarkFailed(2, "connection refused", nil)
+	r := env.outboxRoutes(t)
+
+	w := serve(r, "GET", "/notifications?status=dead", nil)
+	var listed struct {
+		Notifications []models.OutboxMessage `json:"notifications"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("list dead: %d %s", w.Code, w.Body)
+	}
+	if len(listed.Notifications) != 1 || listed.Notifications[0].ID != 2 || listed.Notifications[0].LastError != "connection refused" {
+		t.Fatalf("dead notifications %+v", listed.Notifications)
+	}
+	if w := serve(r, "GET", "/notifications?status=lost", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown status: %d, want 400", w.Code)
+	}
+
+	if w := serve(r, "POST", "/notifications/1/retry", nil); w.Code != http.StatusConflict {
+		t.Errorf("retry of a sent notification: %d, want 409", w.Code)
+	}
+	if w := serve(r, "POST", "/notifications/7/retry", nil); w.Code != http.StatusNotFound {
+		t.Errorf("retry of a missing notification: %d, want 404", w.Code)
+	}
+	if w := serve(r, "POST", "/notifications/2/retry", nil); w.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s", w.Code, w.Body)
+	}
+	if m, _ := env.Outbox.Get(2); m.Status != models.OutboxPending || m.Attempts != 0 || m.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("retried notification %s with %d attempts, due %v", m.Status, m.Attempts, m.NextAttemptAt)
+	}
+	if due, _ := env.Outbox.List(repository.OutboxFilter{Status: models.OutboxPending}); len(due) != 1 {
+		t.Fatalf("pending notifications %+v, want the retried one", due)
+	}
+}
+
+func TestOutboxHidesTokens(t *testing.T) {
+	env := newTestEnv(t)
+	citizen, _ := env.Users.GetByID(3)
+	if err := env.Outbox.Enqueue([]models.OutboxMessage{services.PasswordResetEmail(citizen, "reset-token")}); err != nil {
+		t.Fatal(err)
+	}
+	r := env.outboxRoutes(t)
+	for _, path := range []string{"/notifications", "/notifications/1"} {
+		w := serve(r, "GET", path, nil)
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "reset-token") {
+			t.Errorf("%s: %d %s, want the token redacted", path, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PhoneHandler lets users add a phone number for text notifications and
+// prove it is theirs with a texted code.
+type PhoneHandler struct {
+	Verifier *services.PhoneVerifier
+}
+
+func NewPhoneHandler(verifier *services.PhoneVerifier) *PhoneHandler {
+	return &PhoneHandler{Verifier: verifier}
+}
+
+// GetPhone returns the caller's number, whether it is verified, and the
+// number awaiting verification, if any.
+func (h *PhoneHandler) GetPhone(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	user, err := h.Verifier.Users.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	response := gin.H{"phone": user.Phone, "verified": user.PhoneVerified, "sms_available": h.Verifier.SMS != nil}
+	if pending, err := h.Verifier.Verifications.Get(userID); err == nil {
+		response["pending"] = pending.Phone
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// StartPhoneVerification texts a code to the given number, or to the one
+// already on the account.
+func (h *PhoneHandler) StartPhoneVerification(c *gin.Context) {
+	var req models.PhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := h.Verifier.Users.GetByID(c.GetInt64("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	phone := req.Phone
+	if phone == "" && user.Phone != nil {
+		phone = *user.Phone
+	}
+
+	err = h.Verifier.Start(user, phone)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "verification code sent", "phone": phone})
+	case errors.Is(err, services.ErrSMSUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Text messages are not available"})
+	case errors.Is(err, services.ErrInvalidPhone):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCodeRecentlySent):
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send verification code", "details": err.Error()})
+	}
+}
+
+// ConfirmPhoneVerification makes the number the code was texted to the
+// caller's verified number.
+func (h *PhoneHandler) ConfirmPhoneVerification(c *gin.Context) {
+	var req models.PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.GetInt64("userID")
+	user, err := h.Verifier.Users.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+
+	phone, err := h.Verifier.Confirm(userID, req.Code)
+	if errors.Is(err, services.ErrInvalidCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify phone", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "user.phone_verify", "user", strconv.FormatInt(userID, 10),
+		gin.H{"phone": user.Phone, "verified": user.PhoneVerified}, gin.H{"phone": phone, "verified": true})
+	c.JSON(http.StatusOK, gin.H{"message": "phone verified", "phone": phone})
+}
+
+// DeletePhone removes the caller's number. If they were notified by SMS,
+// they are notified by email again.
+func (h *PhoneHandler) DeletePhone(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	user, err := h.Verifier.Users.GetByID(userID)
+	if err == nil {
+		err = h.Verifier.Users.SetPhone(userID, "", false)
+	}
+	if err == nil {
+		err = h.Verifier.Verifications.Delete(userID)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove phone", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "user.phone_remove", "user", strconv.FormatInt(userID, 10),
+		gin.H{"phone": user.Phone, "verified": user.PhoneVerified}, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "phone removed"})
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func (env *testEnv) phoneRoutes(t *testing.T, userID int64) http.Handler {
+	r := env.router(t, userID)
+	h := NewPhoneHandler(services.NewPhoneVerifier(repository.NewMemoryPhoneVerificationRepository(), env.Users, env.SMS))
+	signedIn := env.Auth.RequirePermission()
+	r.GET("/me/phone", signedIn, h.GetPhone)
+	r.POST("/me/phone", signedIn, h.StartPhoneVerification)
+	r.POST("/me/phone/verify", signedIn, h.ConfirmPhoneVerification)
+	r.DELETE("/me/phone", signedIn, h.DeletePhone)
+	r.PUT("/me/notification-preferences", signedIn, env.UserHandler.UpdateNotificationPreferences)
+	return r
+}
+
+func TestStatusUpdatesByText(t *testing.T) {
+	env := newTestEnv(t)
+	complaint := env.fileComplaint(t, 1)
+	r := env.phoneRoutes(t, 3)
+	const phone = "+919812345678"
+	sms := models.NotificationPreferences{Channel: models.NotifyBySMS, Frequency: models.NotifyImmediately}
+
+	if w := serve(r, "POST", "/me/phone", models.PhoneRequest{Phone: "98123 45678"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("local number: %d, want 400", w.Code)
+	}
+	if w := serve(r, "POST", "/me/phone", models.PhoneRequest{Phone: phone}); w.Code != http.StatusOK {
+		t.Fatalf("start verification: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/me/phone", models.PhoneRequest{Phone: phone}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second code at once: %d, want 429", w.Code)
+	}
+	if w := serve(r, "PUT", "/me/notification-preferences", sms); w.Code != http.StatusBadRequest {
+		t.Fatalf("texts to an unverified number: %d, want 400", w.Code)
+	}
+	if w := serve(r, "GET", "/me/phone", nil); !strings.Contains(w.Body.String(), `"pending":"`+phone+`"`) {
+		t.Fatalf("phone while verifying: %d %s", w.Code, w.Body)
+	}
+
+	sent := env.SMS.Sent()
+	code := regexp.MustCompile(`\b[0-9]{6}\b`).FindString(sent[len(sent)-1].Body)
+	if w := serve(r, "POST", "/me/phone/verify", models.PhoneCodeRequest{Code: code}); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "PUT", "/me/notification-preferences", models.NotificationPreferences{Channel: models.NotifyBySMS, Frequency: models.NotifyDaily}); w.Code != http.StatusBadRequest {
+		t.Fatalf("texts in a daily digest: %d, want 400", w.Code)
+	}
+	if w := serve(r, "PUT", "/me/notification-preferences", sms); w.Code != http.StatusOK {
+		t.Fatalf("choose texts: %d %s", w.Code, w.Body)
+	}
+
+	staff := env.complaintRoutes(t, 1, services.NewMemoryEventBus())
+	if w := serve(staff, "POST", "/complaints/1/updates", models.AddUpdateComment{Comment: "Crew booked", Status: models.StatusInProgress}); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	texts, _ := env.Outbox.List(repository.OutboxFilter{Status: models.OutboxPending})
+	var delivered int
+	for _, m := range texts {
+		switch m.Channel {
+		case models.ChannelEmail:
+			t.Errorf("email %+v queued for a citizen who chose texts", m)
+		case models.ChannelSMS:
+			if err := env.Notifier.Deliver(m); err != nil {
+				t.Fatal(err)
+			}
+			delivered++
+		}
+	}
+	sent = env.SMS.Sent()
+	if last := sent[len(sent)-1]; delivered != 1 || last.To != phone || !strings.HasSuffix(last.Body, "/complaints/1") {
+		t.Fatalf("delivered %d texts, last %+v", delivered, last)
+	}
+
+	// Without a number, the citizen is told by email again.
+	if w := serve(r, "DELETE", "/me/phone", nil); w.Code != http.StatusOK {
+		t.Fatalf("remove phone: %d %s", w.Code, w.Body)
+	}
+	if user, _ := env.Users.GetByID(complaint.UserID); user.Phone != nil || user.NotifyChannel != models.NotifyByEmail {
+		t.Fatalf("user after removing the phone: %v, notified by %s", user.Phone, user.NotifyChannel)
+	}
+}
+
+func TestPhoneWithoutSMSProvider(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.router(t, 3)
+	h := NewPhoneHandler(services.NewPhoneVerifier(repository.NewMemoryPhoneVerificationRepository(), env.Users, nil))
+	r.POST("/me/phone", env.Auth.RequirePermission(), h.StartPhoneVerification)
+	if w := serve(r, "POST", "/me/phone", models.PhoneRequest{Phone: "+919812345678"}); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("start verification without a provider: %d, want 503", w.Code)
+	}
+}
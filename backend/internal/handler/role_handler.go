@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,49}$`)
+
+type RoleHandler struct {
+	Repo  repository.RoleRepository
+	Users repository.UserRepository
+	// Roles is the cache to invalidate when roles change.
+	Roles *services.RoleStore
+}
+
+func NewRoleHandler(repo repository.RoleRepository, users repository.UserRepository, roles *services.RoleStore) *RoleHandler {
+	return &RoleHandler{
+		Repo:  repo,
+		Users: users,
+		Roles: roles,
+	}
+}
+
+// validatePermissions checks that every permission exists and that the caller
+// holds it themselves, so nobody can create a role more powerful than their own.
+func validatePermissions(c *gin.Context, permissions []string) error {
+	for _, perm := range permissions {
+		if !services.IsKnownPermission(perm) {
+			return fmt.Errorf("unknown permission %q", perm)
+		}
+		if !hasPermission(c, perm) {
+			return fmt.Errorf("you cannot grant permission %q", perm)
+		}
+	}
+	return nil
+}
+
+func (h *RoleHandler) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"permissions": services.AllPermissions})
+}
+
+func (h *RoleHandler) GetRoles(c *gin.Context) {
+	roles, err := h.Repo.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var req models.CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role name must be 2-50 lowercase letters, digits or underscores"})
+		return
+	}
+	if err := validatePermissions(c, req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := models.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		RequiresMFA: req.RequiresMFA,
+		Permissions: req.Permissions,
+	}
+	if err := h.Repo.Create(role); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role", "details": err.Error()})
+		return
+	}
+	h.Roles.Invalidate()
+
+	auditChange(c, "role.create", "role", req.Name, nil, req)
+	c.JSON(http.StatusCreated, gin.H{"message": "role created", "role": role})
+}
+
+// loadCustomRole fetches a role and rejects system roles, which are read-only.
+func (h *RoleHandler) loadCustomRole(c *gin.Context, name string) (models.Role, bool) {
+	role, err := h.Repo.Get(name)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return role, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role", "details": err.Error()})
+		return role, false
+	}
+	if role.IsSystem {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System roles cannot be modified"})
+		return role, false
+	}
+	return role, true
+}
+
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	name := c.Param("name")
+	var req models.UpdateRoleDefinitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, ok := h.loadCustomRole(c, name)
+	if !ok {
+		return
+	}
+	if err := validatePermissions(c, req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := models.Role{
+		Name:        name,
+		Description: req.Description,
+		RequiresMFA: req.RequiresMFA,
+		Permissions: req.Permissions,
+	}
+	if err := h.Repo.Update(role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role", "details": err.Error()})
+		return
+	}
+	h.Roles.Invalidate()
+
+	auditChange(c, "role.update", "role", name, before, req)
+	c.JSON(http.StatusOK, gin.H{"message": "role updated", "role": role})
+}
+
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := h.loadCustomRole(c, name); !ok {
+		return
+	}
+
+	holders, err := h.Users.List(repository.UserFilter{Role: name})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error", "details": err.Error()})
+		return
+	}
+	if len(holders) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is still assigned to users", "users": len(holders)})
+		return
+	}
+
+	if err := h.Repo.Delete(name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role", "details": err.Error()})
+		return
+	}
+	h.Roles.Invalidate()
+
+	auditChange(c, "role.delete", "role", name, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "role deleted", "role": name})
+}
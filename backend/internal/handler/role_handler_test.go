@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/services"
+	"net/http"
+	"testing"
+)
+
+func (env *testEnv) roleRoutes(t *testing.T, userID int64) http.Handler {
+	r := env.router(t, userID)
+	h, manage := NewRoleHandler(env.Roles, env.Users, env.Auth.Roles), env.Auth.RequirePermission(services.PermRoleManage)
+	r.GET("/roles", manage, h.GetRoles)
+	r.POST("/roles", manage, h.CreateRole)
+	r.PUT("/roles/:name", manage, h.UpdateRole)
+	r.DELETE("/roles/:name", manage, h.DeleteRole)
+	return r
+}
+
+func TestCustomRoleLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.roleRoutes(t, 1)
+
+	create := models.CreateRoleRequest{Name: "inspector", Permissions: []string{services.PermComplaintViewAll}}
+	if w := serve(r, "POST", "/roles", create); w.Code != http.StatusCreated {
+		t.Fatalf("create role: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/roles", create); w.Code != http.StatusConflict {
+		t.Fatalf("duplicate role: %d, want 409", w.Code)
+	}
+
+	update := models.UpdateRoleDefinitionRequest{RequiresMFA: true, Permissions: []string{services.PermComplaintViewAll, services.PermComplaintUpdate}}
+	if w := serve(r, "PUT", "/roles/inspector", update); w.Code != http.StatusOK {
+		t.Fatalf("update role: %d %s", w.Code, w.Body)
+	}
+	// The role store sees the change at once.
+	if perms, _ := env.Auth.Roles.Permissions("inspector"); !perms[services.PermComplaintUpdate] || !env.Auth.MFARequired("inspector") {
+		t.Fatalf("role store not refreshed: %v", perms)
+	}
+
+	if err := env.Users.UpdateRole(3, "inspector", ""); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, "DELETE", "/roles/inspector", nil); w.Code != http.StatusConflict {
+		t.Fatalf("deleting a role in use: %d, want 409", w.Code)
+	}
+	env.Users.UpdateRole(3, "user", "")
+	if w := serve(r, "DELETE", "/roles/inspector", nil); w.Code != http.StatusOK {
+		t.Fatalf("delete role: %d %s", w.Code, w.Body)
+	}
+	if exists, _ := env.Auth.Roles.Exists("inspector"); exists {
+		t.Fatal("deleted role still exists")
+	}
+}
+
+func TestSystemRolesAreReadOnly(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.roleRoutes(t, 1)
+
+	update := models.UpdateRoleDefinitionRequest{Permissions: []string{services.PermComplaintCreate}}
+	if w := serve(r, "PUT", "/roles/user", update); w.Code != http.StatusBadRequest {
+		t.Fatalf("updating a system role: %d, want 400", w.Code)
+	}
+	if w := serve(r, "DELETE", "/roles/admin", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("deleting a system role: %d, want 400", w.Code)
+	}
+	if w := serve(r, "PUT", "/roles/missing", update); w.Code != http.StatusNotFound {
+		t.Fatalf("updating a missing role: %d, want 404", w.Code)
+	}
+}
+
+func TestRolesCannotGrantMoreThanTheCaller(t *testing.T) {
+	env := newTestEnv(t)
+	env.Roles.Create(models.Role{Name: "role_admin", Permissions: []string{services.PermRoleManage, services.PermComplaintViewAll}})
+	env.Users.UpdateRole(2, "role_admin", "")
+	r := env.roleRoutes(t, 2)
+
+	create := models.CreateRoleRequest{Name: "too_strong", Permissions: []string{services.PermUserManage}}
+	if w := serve(r, "POST", "/roles", create); w.Code != http.StatusBadRequest {
+		t.Fatalf("granting a permission the caller lacks: %d, want 400", w.Code)
+	}
+	create.Permissions = []string{services.PermComplaintViewAll}
+	if w := serve(r, "POST", "/roles", create); w.Code != http.StatusCreated {
+		t.Fatalf("granting the caller's own permission: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"complain/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// complaintScope returns the user whose jurisdiction limits the caller's
+// access to complaints, for repository.ComplaintFilter.ScopeUserID. Callers
+// with complaint.scope_all are not limited and get 0.
+func complaintScope(c *gin.Context) int64 {
+	if hasPermission(c, services.PermComplaintScopeAll) {
+		return 0
+	}
+	return c.GetInt64("userID")
+}
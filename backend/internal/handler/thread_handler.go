@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// worksOn reports whether the caller is staff allowed to update the
+// complaint within their jurisdiction, and so may read its internal notes.
+func (h *ComplaintHandler) worksOn(c *gin.Context, complaintID int64) (bool, error) {
+	if !hasPermission(c, services.PermComplaintUpdate) {
+		return false, nil
+	}
+	_, err := h.Complaints.Get(complaintID, repository.ComplaintFilter{ScopeUserID: complaintScope(c)})
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// hiddenUpdates returns the IDs of the internal notes on a complaint the
+// caller may not see, so the files posted with them can be left out.
+func (h *ComplaintHandler) hiddenUpdates(c *gin.Context, complaintID int64) (map[int64]bool, error) {
+	staff, err := h.worksOn(c, complaintID)
+	if err != nil || staff {
+		return nil, err
+	}
+	updates, err := h.Complaints.ListUpdates(complaintID, true)
+	if err != nil {
+		return nil, err
+	}
+	hidden := map[int64]bool{}
+	for _, u := range updates {
+		if u.Visibility == models.VisibilityInternal {
+			hidden[u.ID] = true
+		}
+	}
+	return hidden, nil
+}
+
+// withoutHidden drops the attachments posted with hidden updates.
+func withoutHidden(attachments []models.Attachment, hidden map[int64]bool) []models.Attachment {
+	visible := attachments[:0]
+	for _, a := range attachments {
+		if a.UpdateID == nil || !hidden[*a.UpdateID] {
+			visible = append(visible, a)
+		}
+	}
+	return visible
+}
+
+// Reply posts a citizen's answer on their own complaint as a public
+// message, leaving its status as it is, and tells the staff following it.
+func (h *ComplaintHandler) Reply(c *gin.Context) {
+	id := c.Param("id")
+	complaintID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+		return
+	}
+	userID := c.GetInt64("userID")
+
+	if c.ContentType() == "multipart/form-data" {
+		h.limitRequestBody(c)
+		if err := c.Request.ParseMultipartForm(10 << 20); err != nil {
+			formError(c, err)
+			return
+		}
+	}
+	var req models.ReplyRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	filter := repository.ComplaintFilter{UserID: userID}
+	complaint, err := h.Complaints.Get(complaintID, filter)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch complaint", "details": err.Error()})
+		return
+	}
+	author, err := h.Users.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	notifications, err := services.ReplyNotifications(h.Complaints, h.Users, complaint, author.Name, req.Comment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post reply", "details": err.Error()})
+		return
+	}
+	hooks, err := h.webhookMessages(complaint, complaint.Status, req.Comment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post reply", "details": err.Error()})
+		return
+	}
+	notifications = append(notifications, hooks...)
+
+	attachments, uploads, ok := h.receiveAttachments(c)
+	if !ok {
+		return
+	}
+	update, _, err := h.Complaints.AddUpdate(complaintID, userID, req.Comment, models.VisibilityPublic, "", filter, attachments, notifications)
+	if err != nil {
+		h.Uploader.Discard(attachments)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Complaint not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post reply", "details": err.Error()})
+		return
+	}
+	h.finishUploads(uploads)
+	if len(notifications) > 0 {
+		h.Notifier.Wake()
+	}
+	h.Events.Publish(services.ComplaintEvent{Type: services.EventComplaintUpdated, ComplaintID: complaintID, OwnerID: userID, At: update.CreatedAt})
+	auditChange(c, "complaint.reply", "complaint", id, nil,
+		gin.H{"update_id": update.ID, "comment": update.Comment, "attachments": len(update.Attachments)})
+	h.signAttachments(update.Attachments)
+	c.JSON(http.StatusCreated, gin.H{"message": "Reply posted", "data": update})
+}
+
+// GetThread returns a complaint's timeline, oldest first: its filing, the
+// messages on it with their files, and its status changes. Internal notes
+// are only included for staff who may update the complaint.
+func (h *ComplaintHandler) GetThread(c *gin.Context) {
+	complaint, ok := h.complaintParam(c)
+	if !ok {
+		return
+	}
+	staff, err := h.worksOn(c, complaint.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread", "details": err.Error()})
+		return
+	}
+	updates, err := h.Complaints.ListUpdates(complaint.ID, staff)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread", "details": err.Error()})
+		return
+	}
+	attachments, err := h.Attachments.ListForComplaints([]int64{complaint.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachments", "details": err.Error()})
+		return
+	}
+
+	// Files of updates not listed belong to notes the caller may not see.
+	listed := map[int64]bool{}
+	for _, u := range updates {
+		listed[u.ID] = true
+	}
+	byUpdate := map[int64][]models.Attachment{}
+	var filed []models.Attachment
+	for _, a := range attachments {
+		switch {
+		case a.UpdateID == nil:
+			filed = append(filed, a)
+		case listed[*a.UpdateID]:
+			byUpdate[*a.UpdateID] = append(byUpdate[*a.UpdateID], a)
+		}
+	}
+	h.signAttachments(filed)
+	for _, files := range byUpdate {
+		h.signAttachments(files)
+	}
+
+	authors := map[int64]*models.ThreadAuthor{}
+	author := func(userID int64) *models.ThreadAuthor {
+		if a, ok := authors[userID]; ok {
+			return a
+		}
+		a := &models.ThreadAuthor{ID: userID, IsOwner: userID == complaint.UserID}
+		if user, err := h.Users.GetByID(userID); err == nil {
+			a.Name = user.Name
+		} else {
+			fmt.Printf("Failed to load author %d of complaint %d's thread: %v\n", userID, complaint.ID, err)
+		}
+		authors[userID] = a
+		return a
+	}
+
+	thread := []models.ThreadEntry{{
+		Type:        models.ThreadFiled,
+		At:          complaint.CreatedAt,
+		Author:      author(complaint.UserID),
+		Comment:     complaint.Description,
+		Attachments: filed,
+	}}
+	for _, u := range updates {
+		updateID := u.ID
+		entry := models.ThreadEntry{
+			Type:        models.ThreadMessage,
+			At:          u.CreatedAt,
+			Author:      author(u.UserID),
+			UpdateID:    &updateID,
+			Comment:     u.Comment,
+			Attachments: byUpdate[u.ID],
+		}
+		if u.Visibility == models.VisibilityInternal {
+			entry.Type = models.ThreadNote
+		}
+		thread = append(thread, entry)
+		if u.ChangedStatus() {
+			thread = append(thread, models.ThreadEntry{
+				Type:       models.ThreadStatusChange,
+				At:         u.CreatedAt,
+				Author:     entry.Author,
+				UpdateID:   &updateID,
+				FromStatus: *u.PreviousStatus,
+				ToStatus:   *u.Status,
+			})
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"message": "Thread retrieved successfully", "data": thread})
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// readThread returns the thread of complaint 1 as r's user sees it.
+func readThread(t *testing.T, r http.Handler) []models.ThreadEntry {
+	t.Helper()
+	w := serve(r, "GET", "/complaints/1/thread", nil)
+	var body struct {
+		Data []models.ThreadEntry `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET thread: %d %s", w.Code, w.Body)
+	}
+	return body.Data
+}
+
+func threadTypes(thread []models.ThreadEntry) string {
+	var types []string
+	for _, e := range thread {
+		types = append(types, e.Type)
+	}
+	return strings.Join(types, ",")
+}
+
+func TestThreadHidesInternalNotesFromCitizens(t *testing.T) {
+	env := newTestEnv(t)
+	env.fileComplaint(t, 1)
+	events := services.NewMemoryEventBus()
+	admin, citizen := env.complaintRoutes(t, 1, events), env.complaintRoutes(t, 3, events)
+
+	update := models.AddUpdateComment{Comment: "Crew booked for Monday", Status: models.StatusInProgress}
+	if w := serve(admin, "POST", "/complaints/1/updates", update); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	note := []models.Attachment{{ObjectKey: "complaints/1/site-survey.pdf", Filename: "site-survey.pdf", ContentType: "application/pdf"}}
+	if _, _, err := env.Complaints.AddUpdate(1, 1, "Contractor owes us a visit", models.VisibilityInternal, "", repository.ComplaintFilter{}, note, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(env.inbox(t, 1))
+	if w := serve(citizen, "POST", "/complaints/1/replies", models.ReplyRequest{Comment: "Thanks, it is still dark"}); w.Code != http.StatusCreated {
+		t.Fatalf("reply: %d %s", w.Code, w.Body)
+	}
+	// The staff who updated the complaint are told of the reply.
+	if inbox := env.inbox(t, 1); len(inbox) != before+1 || inbox[0].Kind != services.NotifyComplaintReplied {
+		t.Fatalf("admin's inbox after the reply %+v", inbox)
+	}
+
+	thread := readThread(t, citizen)
+	if got, want := threadTypes(thread), "filed,message,status_change,message"; got != want {
+		t.Fatalf("citizen's thread %s, want %s", got, want)
+	}
+	for _, e := range thread {
+		if len(e.Attachments) != 0 || strings.Contains(e.Comment, "Contractor") {
+			t.Errorf("citizen sees %+v", e)
+		}
+	}
+	if reply := thread[3]; reply.Author == nil || !reply.Author.IsOwner || reply.Comment != "Thanks, it is still dark" {
+		t.Errorf("citizen's reply %+v", reply)
+	}
+
+	// Staff see the note and its file, signed.
+	thread = readThread(t, admin)
+	if got, want := threadTypes(thread), "filed,message,status_change,note,message"; got != want {
+		t.Fatalf("staff's thread %s, want %s", got, want)
+	}
+	if files := thread[3].Attachments; len(files) != 1 || !strings.HasPrefix(files[0].URL, "https://api.example.org/files/") {
+		t.Errorf("note's files %+v", files)
+	}
+}
+
+func TestThreadOfSomeoneElsesComplaint(t *testing.T) {
+	env := newTestEnv(t)
+	env.fileComplaint(t, 1)
+	neighbour := models.User{Name: "Neighbour", Email: "neighbour@example.com", Role: "user"}
+	if err := env.Users.Create(&neighbour); err != nil {
+		t.Fatal(err)
+	}
+	r := env.complaintRoutes(t, neighbour.ID, services.NewMemoryEventBus())
+
+	if w := serve(r, "GET", "/complaints/1/thread", nil); w.Code != http.StatusNotFound {
+		t.Errorf("someone else's thread: %d, want 404", w.Code)
+	}
+	if w := serve(r, "POST", "/complaints/1/replies", models.ReplyRequest{Comment: "Same on my street"}); w.Code != http.StatusNotFound {
+		t.Errorf("reply on someone else's complaint: %d, want 404", w.Code)
+	}
+	if updates, _ := env.Complaints.ListUpdates(1, true); len(updates) != 0 {
+		t.Errorf("updates %+v after a refused reply", updates)
+	}
+}
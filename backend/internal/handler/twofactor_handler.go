@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// totpIssuer is the name shown next to the account in authenticator apps.
+const totpIssuer = "Complaint Portal"
+
+// recoveryCodeCount is how many recovery codes are issued at a time.
+const recoveryCodeCount = 10
+
+// useTOTPCode validates a code and records its time step so the same code
+// cannot be replayed within its validity window.
+func (h *UserHandler) useTOTPCode(user models.User, code string) bool {
+	if !user.TOTPSecret.Valid {
+		return false
+	}
+	step, ok := services.ValidateTOTP(user.TOTPSecret.String, code, time.Now())
+	if !ok {
+		return false
+	}
+	used, err := h.Users.UseTOTPStep(user.ID, step)
+	return err == nil && used
+}
+
+// useRecoveryCode marks a matching unused recovery code as used.
+func (h *UserHandler) useRecoveryCode(userID int64, code string) bool {
+	used, err := h.Users.UseRecoveryCode(userID, services.HashRecoveryCode(code))
+	return err == nil && used
+}
+
+// newRecoveryCodes generates a set of recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := services.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = services.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// VerifyTwoFactorLogin is the second step of a login for accounts with 2FA.
+func (h *UserHandler) VerifyTwoFactorLogin(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either code or recovery_code is required"})
+		return
+	}
+
+	userID, nonce, err := h.Auth.VerifyMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login session"})
+		return
+	}
+
+	user, err := h.Users.GetTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login session"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	// Codes are short, so the second step shares the account's attempt budget.
+	attempt, ok := h.allowLogin(c, user.Email)
+	if !ok {
+		return
+	}
+
+	if req.Code != "" {
+		ok = h.useTOTPCode(user, req.Code)
+	} else {
+		ok = h.useRecoveryCode(user.ID, req.RecoveryCode)
+	}
+	if !ok {
+		h.recordLoginFailure(attempt, &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		return
+	}
+	// Each token completes one login; a replayed one is turned away even
+	// with a good code.
+	used, err := h.Users.UseMFALogin(user.ID, services.HashOpaqueToken(nonce))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !used {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login session"})
+		return
+	}
+	h.recordLoginSuccess(attempt, user.Email)
+
+	// The account may have been deactivated between the two steps.
+	if !checkAccountUsable(c, user) {
+		return
+	}
+	h.completeLogin(c, user, true)
+}
+
+// SetupTwoFactor generates a new secret for the current user and returns the
+// provisioning URI to render as a QR code. 2FA is not active until EnableTwoFactor.
+func (h *UserHandler) SetupTwoFactor(c *gin.Context) {
+	userID := c.GetInt64("userID")
+
+	user, err := h.Users.GetTwoFactor(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := services.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	if err := h.Users.StartTOTP(userID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Scan the QR code with your authenticator app and confirm with a code",
+		"secret":           secret,
+		"provisioning_uri": services.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// EnableTwoFactor confirms enrollment with a first code, turns 2FA on and
+// returns the recovery codes together with a token that counts as 2FA-verified.
+func (h *UserHandler) EnableTwoFactor(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetTwoFactor(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if !user.TOTPSecret.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment with /2fa/setup first"})
+		return
+	}
+	// Guesses at the code come out of the account's login attempt budget.
+	attempt, ok := h.allowLogin(c, user.Email)
+	if !ok {
+		return
+	}
+	if !h.useTOTPCode(user, req.Code) {
+		h.recordLoginFailure(attempt, &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		return
+	}
+	h.recordLoginSuccess(attempt, user.Email)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes", "details": err.Error()})
+		return
+	}
+	if err := h.Users.EnableTOTP(userID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication", "details": err.Error()})
+		return
+	}
+
+	tokenString, err := h.Auth.GenerateToken(user.ID, user.Role, user.TokenVersion, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+		"token":          tokenString,
+	})
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a current
+// code. Roles that require 2FA cannot turn it off. Tokens issued with the
+// second factor are revoked; the caller gets a new one without it.
+func (h *UserHandler) DisableTwoFactor(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	var req models.TwoFactorDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetTwoFactor(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if h.Auth.MFARequired(user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for this role"})
+		return
+	}
+	// Guesses at the password and code come out of the account's login
+	// attempt budget.
+	attempt, ok := h.allowLogin(c, user.Email)
+	if !ok {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil || !h.useTOTPCode(user, req.Code) {
+		h.recordLoginFailure(attempt, &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or authentication code"})
+		return
+	}
+	h.recordLoginSuccess(attempt, user.Email)
+
+	tokenVersion, err := h.Users.DisableTOTP(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication", "details": err.Error()})
+		return
+	}
+
+	tokenString, err := h.Auth.GenerateToken(user.ID, user.Role, tokenVersion, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication disabled",
+		"token":   tokenString,
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes; requires a current code.
+func (h *UserHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetInt64("userID")
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.Users.GetTwoFactor(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	// Guesses at the code come out of the account's login attempt budget.
+	attempt, ok := h.allowLogin(c, user.Email)
+	if !ok {
+		return
+	}
+	if !h.useTOTPCode(user, req.Code) {
+		h.recordLoginFailure(attempt, &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		return
+	}
+	h.recordLoginSuccess(attempt, user.Email)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes", "details": err.Error()})
+		return
+	}
+	if err := h.Users.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tusVersion is the version of the tus resumable upload protocol spoken by
+// UploadHandler. See https://tus.io/protocols/resumable-upload.
+const tusVersion = "1.0.0"
+
+// UploadHandler implements the tus protocol with its creation, termination
+// and expiration extensions, so clients can upload large files in pieces
+// and resume after a dropped connection. Finished uploads are attached by
+// passing their IDs as "upload_ids" when filing a complaint or an update.
+type UploadHandler struct {
+	Uploads *services.ResumableUploads
+}
+
+func NewUploadHandler(uploads *services.ResumableUploads) *UploadHandler {
+	return &UploadHandler{Uploads: uploads}
+}
+
+// tusHeaders sets the headers every tus response carries.
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// checkTusVersion rejects requests for a protocol version we do not speak.
+func checkTusVersion(c *gin.Context) bool {
+	tusHeaders(c)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version", "details": "Tus-Resumable must be " + tusVersion})
+		return false
+	}
+	return true
+}
+
+// uploadExpires formats an upload's expiry the way tus expects.
+func uploadExpires(upload models.Upload) string {
+	return upload.ExpiresAt.UTC().Format(http.TimeFormat)
+}
+
+// Options describes what the server supports. It needs no authentication.
+func (h *UploadHandler) Options(c *gin.Context) {
+	tusHeaders(c)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,creation-with-upload,termination,expiration")
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.Uploads.MaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// parseUploadMetadata reads the Upload-Metadata header: comma-separated
+// keys, each followed by a space and a base64 value, or by nothing.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q is not base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// Create starts an upload of the length given in Upload-Length. The
+// filename and type may be given in Upload-Metadata as "filename" and
+// "filetype". The request body, if any, is the start of the file.
+func (h *UploadHandler) Create(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length must be a positive number of bytes"})
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata", "details": err.Error()})
+		return
+	}
+
+	upload, err := h.Uploads.Start(c.GetInt64("userID"), length, metadata["filename"], metadata["filetype"])
+	if err != nil {
+		if errors.Is(err, services.ErrUploadTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload too large", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload", "details": err.Error()})
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID)
+	// creation-with-upload: the body is the first chunk.
+	if c.ContentType() == "application/offset+octet-stream" && c.Request.ContentLength != 0 {
+		upload, err = h.Uploads.Append(upload.ID, upload.UserID, 0, c.Request.Body)
+		if err != nil {
+			uploadError(c, err)
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	c.Header("Upload-Expires", uploadExpires(upload))
+	c.JSON(http.StatusCreated, gin.H{"message": "upload created", "data": upload})
+}
+
+// uploadError writes the response for an error from ResumableUploads.
+func uploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+	case errors.Is(err, services.ErrUploadExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Upload has expired"})
+	case errors.Is(err, services.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload", "details": err.Error()})
+	case errors.Is(err, services.ErrUploadTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload too large", "details": err.Error()})
+	default:
+		fmt.Printf("Failed to store upload: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store upload", "details": err.Error()})
+	}
+}
+
+// Head reports how much of an upload has been received, so a client can
+// resume from there.
+func (h *UploadHandler) Head(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	upload, err := h.Uploads.Get(c.Param("id"), c.GetInt64("userID"))
+	if err != nil {
+		// HEAD responses have no body; the status says it all.
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, services.ErrUploadExpired):
+			c.Status(http.StatusGone)
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", uploadExpires(upload))
+	c.Status(http.StatusOK)
+}
+
+// Patch appends the request body to an upload at Upload-Offset.
+func (h *UploadHandler) Patch(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset must be a number of bytes"})
+		return
+	}
+
+	upload, err := h.Uploads.Append(c.Param("id"), c.GetInt64("userID"), offset, c.Request.Body)
+	if upload.ID != "" {
+		// Whatever was received is kept, even if the request failed.
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("Upload-Expires", uploadExpires(upload))
+	}
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Delete abandons an upload.
+func (h *UploadHandler) Delete(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	upload, err := h.Uploads.Get(c.Param("id"), c.GetInt64("userID"))
+	if err != nil && !errors.Is(err, services.ErrUploadExpired) {
+		uploadError(c, err)
+		return
+	}
+	if err := h.Uploads.Terminate(upload); err != nil {
+		uploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
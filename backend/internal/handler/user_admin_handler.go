@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordTokenTTL is how long invite and reset links stay valid.
+const passwordTokenTTL = 72 * time.Hour
+
+// recentActivityLimit is how many recent complaints and updates the
+// activity summary lists.
+const recentActivityLimit = 10
+
+// canManageRole reports whether the caller holds every permission of role,
+// i.e. whether they could have granted it themselves.
+func (h *UserHandler) canManageRole(c *gin.Context, role string) (bool, error) {
+	perms, err := h.Auth.Roles.Permissions(role)
+	if err != nil {
+		return false, err
+	}
+	for perm := range perms {
+		if !hasPermission(c, perm) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// lastAdminConflict answers a change the repository refused with
+// ErrLastAdmin, or any other error it returned.
+func lastAdminConflict(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrLastAdmin) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the last administrator"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message, "details": err.Error()})
+}
+
+// targetUser loads the user named by the :id parameter and checks the caller
+// may manage them. Callers may not use these endpoints on themselves.
+func (h *UserHandler) targetUser(c *gin.Context) (models.User, bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return models.User{}, false
+	}
+	if userID == c.GetInt64("userID") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot perform this action on your own account"})
+		return models.User{}, false
+	}
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return user, false
+	}
+
+	allowed, err := h.canManageRole(c, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions", "details": err.Error()})
+		return user, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot manage users with role " + user.Role})
+		return user, false
+	}
+	return user, true
+}
+
+// newPasswordToken makes an invite or reset token, returning the token for
+// the link and what is stored of it.
+func newPasswordToken(purpose string) (string, models.PasswordToken, error) {
+	token, hash, err := services.NewOpaqueToken()
+	return token, models.PasswordToken{Hash: hash, Purpose: purpose, ExpiresAt: time.Now().Add(passwordTokenTTL)}, err
+}
+
+// CreateStaffUser creates an account for a staff member and emails them an
+// invite link to choose their password.
+func (h *UserHandler) CreateStaffUser(c *gin.Context) {
+	var req models.CreateStaffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roleExists, err := h.Auth.Roles.Exists(req.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role", "details": err.Error()})
+		return
+	}
+	if !roleExists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	allowed, err := h.canManageRole(c, req.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions", "details": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot create users with role " + req.Role})
+		return
+	}
+
+	// Nobody knows this password; the user sets a real one from the invite.
+	placeholder, _, err := services.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate password"})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(placeholder), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	token, invite, err := newPasswordToken(models.PasswordTokenInvite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite", "details": err.Error()})
+		return
+	}
+	user := models.User{Name: req.Name, Email: req.Email, PasswordHash: string(hashedPassword), Role: req.Role}
+	messages := []models.OutboxMessage{services.StaffInviteEmail(req.Email, req.Name, req.Role, token)}
+	if err := h.Users.CreateInvited(&user, invite, messages); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user with this email already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user", "details": err.Error()})
+		return
+	}
+	h.Notifier.Wake()
+
+	auditChange(c, "user.create_staff", "user", strconv.FormatInt(user.ID, 10), nil,
+		gin.H{"name": req.Name, "email": req.Email, "role": req.Role})
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Staff account created and invite sent",
+		"user_id": user.ID,
+	})
+}
+
+// SetPassword redeems an invite or password reset token.
+func (h *UserHandler) SetPassword(c *gin.Context) {
+	var req models.SetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	email, err := h.Users.RedeemPasswordToken(services.HashOpaqueToken(req.Token), string(hashedPassword))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This link is invalid or has expired"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password", "details": err.Error()})
+		return
+	}
+	if err := h.Limiter.Unlock(email); err != nil {
+		fmt.Printf("Failed to clear login failures of %s: %v\n", email, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password set, you can now log in"})
+}
+
+// DeactivateUser blocks a user from logging in and revokes their tokens.
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Users.SetActive(user.ID, false, services.PermUserManage); err != nil {
+		lastAdminConflict(c, err, "Failed to deactivate user")
+		return
+	}
+
+	auditChange(c, "user.deactivate", "user", strconv.FormatInt(user.ID, 10), gin.H{"is_active": user.IsActive}, gin.H{"is_active": false})
+	c.JSON(http.StatusOK, gin.H{"message": "user deactivated", "userid": user.ID})
+}
+
+// ReactivateUser allows a deactivated user to log in again.
+func (h *UserHandler) ReactivateUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Users.SetActive(user.ID, true, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reactivate user", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "user.reactivate", "user", strconv.FormatInt(user.ID, 10), gin.H{"is_active": user.IsActive}, gin.H{"is_active": true})
+	c.JSON(http.StatusOK, gin.H{"message": "user reactivated", "userid": user.ID})
+}
+
+// DemoteUser turns a staff member back into a citizen and removes their
+// departments and districts.
+func (h *UserHandler) DemoteUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	if user.Role == "user" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User is already a citizen"})
+		return
+	}
+
+	if err := h.Users.Demote(user.ID, "user", services.PermUserManage); err != nil {
+		lastAdminConflict(c, err, "Failed to demote user")
+		return
+	}
+
+	auditChange(c, "user.demote", "user", strconv.FormatInt(user.ID, 10), gin.H{"role": user.Role}, gin.H{"role": "user"})
+	c.JSON(http.StatusOK, gin.H{"message": "user demoted", "userid": user.ID, "role": "user"})
+}
+
+// ForcePasswordReset logs the user out everywhere and requires them to choose
+// a new password from an emailed link before they can log in again.
+func (h *UserHandler) ForcePasswordReset(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	token, reset, err := newPasswordToken(models.PasswordTokenReset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset link", "details": err.Error()})
+		return
+	}
+	messages := []models.OutboxMessage{services.PasswordResetEmail(user, token)}
+	if err := h.Users.RequirePasswordReset(user.ID, reset, messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user", "details": err.Error()})
+		return
+	}
+	h.Notifier.Wake()
+
+	auditChange(c, "user.force_password_reset", "user", strconv.FormatInt(user.ID, 10), nil, gin.H{"password_reset_required": true})
+	c.JSON(http.StatusOK, gin.H{"message": "password reset required and email sent", "userid": user.ID})
+}
+
+// GetUserActivity returns a summary of a user's recent activity.
+func (h *UserHandler) GetUserActivity(c *gin.Context) {
+	auditSensitiveRead(c, "user.view_activity")
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+
+	activity, err := h.Complaints.Activity(user.ID, recentActivityLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activity", "details": err.Error()})
+		return
+	}
+	activity.IsActive = user.IsActive
+	activity.LastLoginAt = user.LastLoginAt
+
+	c.JSON(http.StatusOK, gin.H{"activity": activity})
+}
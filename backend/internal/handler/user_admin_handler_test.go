@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (env *testEnv) adminRoutes(t *testing.T) *gin.Engine {
+	r := env.router(t, 1)
+	h, manage := env.UserHandler, env.Auth.RequirePermission(services.PermUserManage)
+	r.POST("/users", manage, h.CreateStaffUser)
+	r.POST("/users/:id/deactivate", manage, h.DeactivateUser)
+	r.POST("/users/:id/reactivate", manage, h.ReactivateUser)
+	r.POST("/users/:id/demote", manage, h.DemoteUser)
+	r.POST("/users/:id/force-password-reset", manage, h.ForcePasswordReset)
+	r.GET("/users/:id/activity", env.Auth.RequirePermission(services.PermUserView), h.GetUserActivity)
+	r.POST("/set-password", h.SetPassword)
+	return r
+}
+
+func TestDeactivateRevokesTokensAndReactivates(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.adminRoutes(t)
+
+	if w := serve(r, "POST", "/users/2/deactivate", nil); w.Code != http.StatusOK {
+		t.Fatalf("deactivate: %d %s", w.Code, w.Body)
+	}
+	if env.Auth.sessionValid(2, 0) {
+		t.Fatal("token of a deactivated user still valid")
+	}
+	if w := serve(r, "POST", "/users/2/reactivate", nil); w.Code != http.StatusOK {
+		t.Fatalf("reactivate: %d %s", w.Code, w.Body)
+	}
+	if user, _ := env.Users.GetByID(2); !user.IsActive || user.TokenVersion != 1 {
+		t.Fatalf("reactivated user: active %v, token version %d", user.IsActive, user.TokenVersion)
+	}
+}
+
+func TestAdminsCannotRemoveEachOther(t *testing.T) {
+	env := newTestEnv(t)
+	second := models.User{Name: "Second", Email: "second@example.com", Role: "admin"}
+	if err := env.Users.Create(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(env.adminRoutes(t), "POST", "/users/4/deactivate", nil); w.Code != http.StatusOK {
+		t.Fatalf("deactivating one of two admins: %d %s", w.Code, w.Body)
+	}
+	// The second admin's request was let in before they were deactivated.
+	r := env.router(t, 4)
+	r.POST("/users/:id/deactivate", env.Auth.RequirePermission(services.PermUserManage), env.UserHandler.DeactivateUser)
+	r.POST("/users/:id/demote", env.Auth.RequirePermission(services.PermUserManage), env.UserHandler.DemoteUser)
+	for _, path := range []string{"/users/1/deactivate", "/users/1/demote"} {
+		if w := serve(r, "POST", path, nil); w.Code != http.StatusConflict {
+			t.Fatalf("%s of the last admin: %d, want 409", path, w.Code)
+		}
+	}
+	if user, _ := env.Users.GetByID(1); !user.IsActive || user.Role != "admin" {
+		t.Fatalf("last admin changed: active %v, role %q", user.IsActive, user.Role)
+	}
+}
+
+func TestDemoteUser(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.adminRoutes(t)
+
+	if w := serve(r, "POST", "/users/2/demote", nil); w.Code != http.StatusOK {
+		t.Fatalf("demoting an official: %d %s", w.Code, w.Body)
+	}
+	if user, _ := env.Users.GetByID(2); user.Role != "user" || user.TokenVersion != 1 {
+		t.Fatalf("demoted official: role %q, token version %d", user.Role, user.TokenVersion)
+	}
+	if w := serve(r, "POST", "/users/3/demote", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("demoting a citizen: %d, want 400", w.Code)
+	}
+	if w := serve(r, "POST", "/users/1/demote", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("demoting yourself: %d, want 403", w.Code)
+	}
+}
+
+func TestCreateStaffUserSendsInviteThatSetsPassword(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.adminRoutes(t)
+
+	w := serve(r, "POST", "/users", models.CreateStaffRequest{Name: "New", Email: "new@example.com", Role: "official"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create staff: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/users", models.CreateStaffRequest{Name: "New", Email: "new@example.com", Role: "official"}); w.Code != http.StatusConflict {
+		t.Fatalf("duplicate staff email: %d, want 409", w.Code)
+	}
+	if w := serve(r, "POST", "/users", models.CreateStaffRequest{Name: "X", Email: "x@example.com", Role: "nobody"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown role: %d, want 400", w.Code)
+	}
+
+	invites, _ := env.Outbox.List(repository.OutboxFilter{Kind: services.NotifyStaffInvite})
+	if len(invites) != 1 {
+		t.Fatalf("got %d invites, want 1", len(invites))
+	}
+	var data services.EmailData
+	if err := json.Unmarshal(invites[0].Payload, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	set := models.SetPasswordRequest{Token: data.Token, Password: "a-long-password"}
+	if w := serve(r, "POST", "/set-password", set); w.Code != http.StatusOK {
+		t.Fatalf("set password: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/set-password", set); w.Code != http.StatusBadRequest {
+		t.Fatalf("reusing the invite: %d, want 400", w.Code)
+	}
+}
+
+func TestForcePasswordResetRevokesEarlierLinks(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.adminRoutes(t)
+
+	for i := 0; i < 2; i++ {
+		if w := serve(r, "POST", "/users/3/force-password-reset", nil); w.Code != http.StatusOK {
+			t.Fatalf("force reset: %d %s", w.Code, w.Body)
+		}
+	}
+	user, _ := env.Users.GetByID(3)
+	if !user.PasswordResetRequired || user.TokenVersion != 2 {
+		t.Fatalf("reset user: required %v, token version %d", user.PasswordResetRequired, user.TokenVersion)
+	}
+
+	resets, _ := env.Outbox.List(repository.OutboxFilter{Kind: services.NotifyPasswordReset})
+	if len(resets) != 2 {
+		t.Fatalf("got %d reset emails, want 2", len(resets))
+	}
+	// Newest first: only the second link still works.
+	for i, want := range []int{http.StatusBadRequest, http.StatusOK} {
+		var data services.EmailData
+		if err := json.Unmarshal(resets[len(resets)-1-i].Payload, &data); err != nil {
+			t.Fatal(err)
+		}
+		w := serve(r, "POST", "/set-password", models.SetPasswordRequest{Token: data.Token, Password: "a-long-password"})
+		if w.Code != want {
+			t.Fatalf("reset link %d: %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if user, _ := env.Users.GetByID(3); user.PasswordResetRequired {
+		t.Fatal("password reset still required after a new password was set")
+	}
+}
+
+func TestUserActivity(t *testing.T) {
+	env := newTestEnv(t)
+	complaint, err := env.Complaints.Create(3, models.CreateComplaintRequest{Title: "Pothole"}, "pending", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Complaints.AddUpdate(complaint.ID, 2, "On it", models.VisibilityPublic, "in_progress", repository.ComplaintFilter{}, nil, nil)
+
+	w := serve(env.adminRoutes(t), "GET", "/users/3/activity", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("activity: %d %s", w.Code, w.Body)
+	}
+	var body struct {
+		Activity models.UserActivity `json:"activity"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Activity.ComplaintsFiled != 1 || len(body.Activity.RecentComplaints) != 1 || body.Activity.UpdatesPosted != 0 || !body.Activity.IsActive {
+		t.Fatalf("unexpected activity %+v", body.Activity)
+	}
+	if w := serve(env.adminRoutes(t), "GET", "/users/99/activity", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("activity of unknown user: %d, want 404", w.Code)
+	}
+}
+
+// totpNow is the code an authenticator app shows for secret (RFC 6238).
+func totpNow(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.router(t, 3)
+	h := env.UserHandler
+	r.POST("/2fa/setup", h.SetupTwoFactor)
+	r.POST("/2fa/enable", h.EnableTwoFactor)
+	r.POST("/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+
+	w := serve(r, "POST", "/2fa/setup", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("setup: %d %s", w.Code, w.Body)
+	}
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &setup)
+
+	if w := serve(r, "POST", "/2fa/enable", models.TwoFactorCodeRequest{Code: "000000"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("enable with a wrong code: %d, want 401", w.Code)
+	}
+	code := totpNow(t, setup.Secret)
+	w = serve(r, "POST", "/2fa/enable", models.TwoFactorCodeRequest{Code: code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("enable: %d %s", w.Code, w.Body)
+	}
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &enabled)
+	if len(enabled.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(enabled.RecoveryCodes), recoveryCodeCount)
+	}
+
+	// The code that enabled 2FA cannot be replayed.
+	if w := serve(r, "POST", "/2fa/recovery-codes", models.TwoFactorCodeRequest{Code: code}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed code: %d, want 401", w.Code)
+	}
+	if !h.useRecoveryCode(3, enabled.RecoveryCodes[0]) {
+		t.Fatal("recovery code not accepted")
+	}
+	if h.useRecoveryCode(3, enabled.RecoveryCodes[0]) {
+		t.Fatal("recovery code accepted twice")
+	}
+}
+
+func TestDisableTwoFactorRefusedForRolesThatRequireIt(t *testing.T) {
+	env := newTestEnv(t)
+	env.Users.StartTOTP(1, "JBSWY3DPEHPK3PXP")
+	env.Users.EnableTOTP(1, nil)
+
+	r := env.router(t, 1)
+	r.POST("/2fa/disable", env.UserHandler.DisableTwoFactor)
+	w := serve(r, "POST", "/2fa/disable", models.TwoFactorDisableRequest{Password: "x", Code: "000000"})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("disabling 2FA as admin: %d, want 403", w.Code)
+	}
+}
+
+func TestTwoFactorCodeGuessesAreLimited(t *testing.T) {
+	env := newTestEnv(t)
+	env.Users.StartTOTP(3, "JBSWY3DPEHPK3PXP")
+	env.Users.EnableTOTP(3, nil)
+
+	r := env.router(t, 3)
+	r.POST("/2fa/recovery-codes", env.UserHandler.RegenerateRecoveryCodes)
+	// Past the free attempts, each wrong code makes the next one wait.
+	wrong := services.DefaultAccountPolicy.FreeAttempts + 1
+	for i := 0; i < wrong; i++ {
+		if w := serve(r, "POST", "/2fa/recovery-codes", models.TwoFactorCodeRequest{Code: "000000"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: %d, want 401", i+1, w.Code)
+		}
+	}
+	w := serve(r, "POST", "/2fa/recovery-codes", models.TwoFactorCodeRequest{Code: totpNow(t, "JBSWY3DPEHPK3PXP")})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("code after %d wrong ones: %d, want 429", wrong, w.Code)
+	}
+}
+
+func TestMFATokenCompletesOneLogin(t *testing.T) {
+	env := newTestEnv(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	citizen := models.User{Name: "Second", Email: "second@example.com", Role: "user", PasswordHash: string(hash)}
+	if err := env.Users.Create(&citizen); err != nil {
+		t.Fatal(err)
+	}
+	env.Users.StartTOTP(citizen.ID, "JBSWY3DPEHPK3PXP")
+	codes := []string{"first-code", "second-code"}
+	env.Users.EnableTOTP(citizen.ID, []string{services.HashRecoveryCode(codes[0]), services.HashRecoveryCode(codes[1])})
+
+	r := gin.New()
+	r.POST("/login", env.UserHandler.Login)
+	r.POST("/login/2fa", env.UserHandler.VerifyTwoFactorLogin)
+	w := serve(r, "POST", "/login", models.LoginRequest{Email: citizen.Email, Password: "correct horse"})
+	var login struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if json.Unmarshal(w.Body.Bytes(), &login); login.MFAToken == "" {
+		t.Fatalf("login: %d %s, want an MFA token", w.Code, w.Body)
+	}
+
+	if w := serve(r, "POST", "/login/2fa", models.TwoFactorLoginRequest{MFAToken: login.MFAToken, RecoveryCode: codes[0]}); w.Code != http.StatusOK {
+		t.Fatalf("second factor: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/login/2fa", models.TwoFactorLoginRequest{MFAToken: login.MFAToken, RecoveryCode: codes[1]}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed MFA token: %d %s, want 401", w.Code, w.Body)
+	}
+}
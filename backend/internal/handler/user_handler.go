@@ -2,25 +2,82 @@ package handler
 
 import (
 	"complain/internal/models" // Make s	query := `SELECT id, name, email, role, password_hash FROM users WHERE email=$1`re your module name is correct
-	"database/sql"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyPasswordHash is compared against when the email is unknown so a
+// failed login takes the same time whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
 // The UserHandler now needs the AuthService to generate tokens.
 type UserHandler struct {
-	DB   *sqlx.DB
-	Auth *AuthService // Dependency for Auth Service
+	Users repository.UserRepository
+	// Complaints supplies the activity summaries of users.
+	Complaints repository.ComplaintRepository
+	Auth       *AuthService // Dependency for Auth Service
+	// Notifier queues the emails sent about accounts.
+	Notifier *services.Notifier
+	Limiter  *services.LoginLimiter
 }
 
 // NewUserHandler is updated to accept and store both dependencies.
-func NewUserHandler(db *sqlx.DB, auth *AuthService) *UserHandler {
+func NewUserHandler(users repository.UserRepository, complaints repository.ComplaintRepository, auth *AuthService, notifier *services.Notifier, limiter *services.LoginLimiter) *UserHandler {
 	return &UserHandler{
-		DB:   db,
-		Auth: auth,
+		Users:      users,
+		Complaints: complaints,
+		Auth:       auth,
+		Notifier:   notifier,
+		Limiter:    limiter,
+	}
+}
+
+// tooManyAttempts rejects a throttled login with a Retry-After hint.
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts, please try again later"})
+}
+
+// allowLogin reserves a login attempt, or answers the request if it is
+// throttled or the counters cannot be read.
+func (h *UserHandler) allowLogin(c *gin.Context, email string) (services.LoginAttempt, bool) {
+	attempt, wait, err := h.Limiter.Allow(email, c.ClientIP(), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return attempt, false
+	}
+	if wait > 0 {
+		tooManyAttempts(c, wait)
+		return attempt, false
+	}
+	return attempt, true
+}
+
+// recordLoginSuccess clears the failures the attempt counted.
+func (h *UserHandler) recordLoginSuccess(attempt services.LoginAttempt, email string) {
+	if err := h.Limiter.RecordSuccess(attempt); err != nil {
+		fmt.Printf("Failed to clear login failures of %s: %v\n", email, err)
+	}
+}
+
+// recordLoginFailure emails the owner if the failed attempt just locked an
+// existing account. user is nil for unknown emails.
+func (h *UserHandler) recordLoginFailure(attempt services.LoginAttempt, user *models.User) {
+	lockedUntil, locked := h.Limiter.RecordFailure(attempt)
+	if !locked || user == nil {
+		return
+	}
+	if err := h.Notifier.Enqueue(services.AccountLockedEmail(*user, lockedUntil)); err != nil {
+		fmt.Printf("Failed to queue account locked email to %s: %v\n", user.Email, err)
 	}
 }
 
@@ -31,15 +88,29 @@ func (h *UserHandler) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if r.Language != "" && !services.ValidLanguage(r.Language) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language", "details": "expected a code such as en or hi"})
+		return
+	}
+	if r.Phone != "" && !services.ValidPhone(r.Phone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone", "details": services.ErrInvalidPhone.Error()})
+		return
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(r.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
-	query := `INSERT INTO users (name,email,password_hash) VALUES ($1,$2,$3) RETURNING id`
-	var newUserID int64 // Use int64 for DB IDs
-	err = h.DB.QueryRow(query, r.Name, r.Email, string(hashedPassword)).Scan(&newUserID)
-
+	user := models.User{Name: r.Name, Email: r.Email, PasswordHash: string(hashedPassword), Role: "user", Language: r.Language}
+	// The number is only texted once the user has verified it.
+	if r.Phone != "" {
+		user.Phone = &r.Phone
+	}
+	err = h.Users.Create(&user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user", "details": err.Error()})
 		// This return was missing before
@@ -48,7 +119,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "User created successfully",
-		"user_id": newUserID,
+		"user_id": user.ID,
 	})
 }
 
@@ -60,16 +131,22 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User // Use the full user model to get all data
-	query := "SELECT id, role, password_hash FROM users WHERE email=$1"
+	// 0. Refuse throttled or locked out attempts before touching the account.
+	attempt, ok := h.allowLogin(c, req.Email)
+	if !ok {
+		return
+	}
 
 	// --- LOGIC IS NOW IN THE CORRECT ORDER ---
 
 	// 1. Find user in database.
-	err := h.DB.Get(&user, query, req.Email)
+	user, err := h.Users.GetByEmail(req.Email)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// User not found - send a generic, secure error.
+		if errors.Is(err, repository.ErrNotFound) {
+			// User not found - burn the same bcrypt time and count the failure
+			// exactly like a wrong password, then send a generic, secure error.
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+			h.recordLoginFailure(attempt, nil)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 			return
 		}
@@ -82,21 +159,82 @@ func (h *UserHandler) Login(c *gin.Context) {
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
 		// Password does not match - send the SAME generic error for security.
+		h.recordLoginFailure(attempt, &user)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
+	if !checkAccountUsable(c, user) {
+		return
+	}
+
+	// 3. Accounts with 2FA get a short-lived token that must be exchanged,
+	// together with a code, at /login/2fa. Their failure count is only
+	// cleared once the second factor succeeds.
+	if user.TOTPEnabled {
+		if err := h.Limiter.Release(attempt); err != nil {
+			fmt.Printf("Failed to release login attempt of %s: %v\n", req.Email, err)
+		}
+		nonce, nonceHash, err := services.NewOpaqueToken()
+		if err == nil {
+			err = h.Users.StartMFALogin(user.ID, nonceHash)
+		}
+		var mfaToken string
+		if err == nil {
+			mfaToken, err = h.Auth.GenerateMFAToken(user.ID, nonce)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Two-factor authentication required",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return
+	}
+
+	h.recordLoginSuccess(attempt, req.Email)
+
+	// 4. If password is correct, generate a token and send it back.
+	h.completeLogin(c, user, false)
+}
 
-	// 3. If password is correct, generate a token using the AuthService.
-	tokenString, err := h.Auth.GenerateToken(user.ID, user.Role)
+// checkAccountUsable rejects logins for deactivated accounts and accounts
+// that must reset their password first. It is only called once the password
+// has been verified, so it does not reveal anything about unknown emails.
+func checkAccountUsable(c *gin.Context, user models.User) bool {
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
+		return false
+	}
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                   "You must reset your password before logging in; check your email",
+			"password_reset_required": true,
+		})
+		return false
+	}
+	return true
+}
+
+// completeLogin issues an access token, records the login time and sends the
+// response shared by both login steps.
+func (h *UserHandler) completeLogin(c *gin.Context, user models.User, mfa bool) {
+	tokenString, err := h.Auth.GenerateToken(user.ID, user.Role, user.TokenVersion, mfa)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	// 4. Send success response with the token.
+	if err := h.Users.RecordLogin(user.ID); err != nil {
+		fmt.Printf("Failed to record login for user %d: %v\n", user.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully logged in",
-		"token":   tokenString,
+		"message":                 "Successfully logged in",
+		"token":                   tokenString,
+		"mfa_enrollment_required": h.Auth.MFARequired(user.Role) && !user.TOTPEnabled,
 		"user": gin.H{
 			"id":    user.ID,
 			"name":  user.Name,
@@ -107,10 +245,8 @@ func (h *UserHandler) Login(c *gin.Context) {
 }
 
 func (h *UserHandler) GetAllUsers(c *gin.Context) {
-	var users []models.User
-	query := `SELECT id, name, email, role FROM users WHERE role != 'admin'` // Exclude admin users for security
-
-	err := h.DB.Select(&users, query)
+	auditSensitiveRead(c, "user.list")
+	users, err := h.Users.List(repository.UserFilter{ExcludeRole: "admin"}) // Exclude admin users for security
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to fetch users",
@@ -134,28 +270,69 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error reading new role from body"})
 		return
 	}
-	if newrole.Role == "admin" || newrole.Role == "user" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "role can be official"})
+	if userID == strconv.FormatInt(c.GetInt64("userID"), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own role"})
 		return
 	}
-	query := `UPDATE users SET role=$1 WHERE id=$2`
 
-	result, err := h.DB.Exec(query, newrole.Role, userID)
+	targetID, err := strconv.ParseInt(userID, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to update role", "error": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
 		return
 	}
+	target, err := h.Users.GetByID(targetID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	currentRole := target.Role
 
-	rowsAffected, err := result.RowsAffected()
+	roleExists, err := h.Auth.Roles.Exists(newrole.Role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check affected rows", "details": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role", "details": err.Error()})
+		return
+	}
+	if !roleExists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
 		return
 	}
-	if rowsAffected == 0 {
+
+	// Only roles the caller could have granted themselves may be given or taken away.
+	for _, role := range []string{currentRole, newrole.Role} {
+		allowed, err := h.canManageRole(c, role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions", "details": err.Error()})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot manage users with role " + role})
+			return
+		}
+	}
+
+	// A role without user.manage must not take it from the last administrator.
+	guard := ""
+	if newPerms, err := h.Auth.Roles.Permissions(newrole.Role); err != nil || !newPerms[services.PermUserManage] {
+		guard = services.PermUserManage
+	}
+	err = h.Users.UpdateRole(targetID, newrole.Role, guard)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
 		return
+	case errors.Is(err, repository.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the last administrator"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to update role", "error": err.Error()})
+		return
 	}
 
+	auditChange(c, "user.role_change", "user", userID, gin.H{"role": currentRole}, gin.H{"role": newrole.Role})
 	c.JSON(http.StatusOK, gin.H{"message": "user role updated succesfuuly",
 		"userid": userID,
 		"role":   newrole.Role,
@@ -163,11 +340,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 
 }
 func (h *UserHandler) GetAllOfficials(c *gin.Context) {
+	auditSensitiveRead(c, "official.list")
 
-	var officials []models.User
-	query := `SELECT id, name, email, role, created_at FROM users WHERE role = 'official'`
-
-	err := h.DB.Select(&officials, query)
+	officials, err := h.Users.List(repository.UserFilter{Role: "official"})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to fetch officials",
@@ -181,3 +356,79 @@ func (h *UserHandler) GetAllOfficials(c *gin.Context) {
 	})
 
 }
+
+// UnlockUser clears a login lockout for the given user.
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
+		return
+	}
+	user, err := h.Users.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found with the specified ID"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+
+	if err := h.Limiter.Unlock(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user", "details": err.Error()})
+		return
+	}
+	auditChange(c, "user.unlock", "user", userID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked", "userid": userID})
+}
+
+// GetNotificationPreferences returns how the caller hears about their
+// complaints.
+func (h *UserHandler) GetNotificationPreferences(c *gin.Context) {
+	user, err := h.Users.GetByID(c.GetInt64("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preferences": models.NotificationPreferences{Channel: user.NotifyChannel, Frequency: user.NotifyFrequency}})
+}
+
+// UpdateNotificationPreferences sets how the caller hears about their
+// complaints: by email, by text, in the app or not at all, at once or in a
+// daily digest. Texts need a verified phone number and are never held for
+// a digest.
+func (h *UserHandler) UpdateNotificationPreferences(c *gin.Context) {
+	var req models.NotificationPreferences
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.GetInt64("userID")
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences", "details": err.Error()})
+		return
+	}
+	if req.Channel == models.NotifyBySMS {
+		switch {
+		case h.Notifier.SMS == nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Text messages are not available"})
+			return
+		case user.SMSPhone() == "":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verify a phone number before choosing text messages"})
+			return
+		case req.Frequency != models.NotifyImmediately:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Daily digests are only sent by email"})
+			return
+		}
+	}
+	if err := h.Users.UpdateNotificationPreferences(userID, req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences", "details": err.Error()})
+		return
+	}
+	auditChange(c, "user.notification_preferences", "user", strconv.FormatInt(userID, 10),
+		models.NotificationPreferences{Channel: user.NotifyChannel, Frequency: user.NotifyFrequency}, req)
+	c.JSON(http.StatusOK, gin.H{"message": "notification preferences updated", "preferences": req})
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookHandler lets admins subscribe partner systems to complaint events
+// and see how deliveries to them went.
+type WebhookHandler struct {
+	Webhooks *services.Webhooks
+}
+
+func NewWebhookHandler(webhooks *services.Webhooks) *WebhookHandler {
+	return &WebhookHandler{Webhooks: webhooks}
+}
+
+// checkWebhookRequest rejects URLs that are not http(s), URLs naming hosts
+// on the internal network, and unknown events. Host names that resolve to
+// such hosts are refused when connecting instead.
+func checkWebhookRequest(req models.WebhookRequest) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	ip, err := netip.ParseAddr(host)
+	if (err == nil && !services.IsPublicAddress(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("url must point to a public address")
+	}
+	for _, event := range req.Events {
+		if !services.IsWebhookEvent(event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// applyWebhookRequest copies req onto webhook.
+func applyWebhookRequest(webhook *models.Webhook, req models.WebhookRequest) {
+	webhook.URL = req.URL
+	webhook.Description = req.Description
+	webhook.Events = req.Events
+	webhook.CategoryIDs = req.CategoryIDs
+	webhook.Districts = req.Districts
+	webhook.IncludePrivate = req.IncludePrivate
+	webhook.IsActive = req.IsActive == nil || *req.IsActive
+}
+
+// webhookParam loads the webhook named by the :id path parameter.
+func (h *WebhookHandler) webhookParam(c *gin.Context) (models.Webhook, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return models.Webhook{}, false
+	}
+	webhook, err := h.Webhooks.Repo.Get(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return webhook, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook", "details": err.Error()})
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// GetWebhooks lists every webhook and the events they can subscribe to.
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	webhooks, err := h.Webhooks.Repo.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks, "events": services.WebhookEvents})
+}
+
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.webhookParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook})
+}
+
+// CreateWebhook adds a webhook with a new secret. The secret is only ever
+// returned here and by RotateWebhookSecret.
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkWebhookRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, err := services.NewWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret", "details": err.Error()})
+		return
+	}
+
+	createdBy := c.GetInt64("userID")
+	webhook := models.Webhook{Secret: secret, CreatedBy: &createdBy}
+	applyWebhookRequest(&webhook, req)
+	if err := h.Webhooks.Repo.Create(&webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "webhook.create", "webhook", strconv.FormatInt(webhook.ID, 10), nil, webhook)
+	c.JSON(http.StatusCreated, gin.H{"message": "webhook created", "webhook": webhook, "secret": secret})
+}
+
+// UpdateWebhook replaces a webhook's URL, events and filters. The secret
+// is kept.
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.webhookParam(c)
+	if !ok {
+		return
+	}
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkWebhookRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := webhook
+	applyWebhookRequest(&webhook, req)
+	if err := h.Webhooks.Repo.Update(&webhook); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "webhook.update", "webhook", strconv.FormatInt(webhook.ID, 10), before, webhook)
+	c.JSON(http.StatusOK, gin.H{"message": "webhook updated", "webhook": webhook})
+}
+
+// RotateWebhookSecret replaces a webhook's secret. Deliveries not yet made
+// are signed with the new one.
+func (h *WebhookHandler) RotateWebhookSecret(c *gin.Context) {
+	webhook, ok := h.webhookParam(c)
+	if !ok {
+		return
+	}
+	secret, err := services.NewWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret", "details": err.Error()})
+		return
+	}
+	webhook.Secret = secret
+	if err := h.Webhooks.Repo.Update(&webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "webhook.rotate_secret", "webhook", strconv.FormatInt(webhook.ID, 10), nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "secret rotated", "secret": secret})
+}
+
+// DeleteWebhook removes a webhook. Deliveries still queued for it are
+// given up on.
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.webhookParam(c)
+	if !ok {
+		return
+	}
+	if err := h.Webhooks.Repo.Delete(webhook.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook", "details": err.Error()})
+		return
+	}
+
+	auditChange(c, "webhook.delete", "webhook", strconv.FormatInt(webhook.ID, 10), webhook, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// GetWebhookDeliveries returns the log of attempts to deliver to a webhook,
+// newest first.
+func (h *WebhookHandler) GetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := h.webhookParam(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	deliveries, err := h.Webhooks.Repo.ListDeliveries(webhook.ID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "limit": limit, "offset": offset})
+}
+
+// PingWebhook sends a webhook.ping event at once so admins can check the
+// receiving end, and reports how it went. An unreachable or failing
+// receiver is not an error of this request.
+func (h *WebhookHandler) PingWebhook(c *gin.Context) {
+	webhook, ok := h.webhookParam(c)
+	if !ok {
+		return
+	}
+	delivery, err := h.Webhooks.Ping(webhook)
+	c.JSON(http.StatusOK, gin.H{"ok": err == nil, "delivery": delivery})
+}
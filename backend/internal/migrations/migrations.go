@@ -0,0 +1,342 @@
+// Package migrations holds the versioned database schema, embedded into the
+// binary, and applies it.
+//
+// Each version is a pair of files in sql/ named NNNN_name.up.sql and
+// NNNN_name.down.sql. Applied versions are recorded in schema_migrations; each
+// migration runs in its own transaction together with that bookkeeping.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey serializes concurrent migrators (pg_advisory_lock).
+const lockKey = 7303
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a known migration and when it was applied, if it was.
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// ErrSchemaOutdated is returned by Check when migrations are pending.
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+// All returns the embedded migrations ordered by version.
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionPart, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.%s.sql", name, direction)
+		}
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, versionPart)
+		}
+
+		body, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+type Migrator struct {
+	DB         *sqlx.DB
+	Migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Latest is the highest version this binary knows about.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func ensureTable(ctx context.Context, q sqlx.ExecerContext) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	return err
+}
+
+type appliedRow struct {
+	Version   int64     `db:"version"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func applied(ctx context.Context, q sqlx.QueryerContext) (map[int64]time.Time, error) {
+	var rows []appliedRow
+	err := sqlx.SelectContext(ctx, q, &rows, `SELECT version, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int64]time.Time, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r.AppliedAt
+	}
+	return out, nil
+}
+
+// Current returns the highest applied version, or 0 for an empty database.
+func (m *Migrator) Current() (int64, error) {
+	// A database that has never been migrated has no table yet.
+	var exists bool
+	if err := m.DB.Get(&exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+	var version int64
+	err := m.DB.Get(&version, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	return version, err
+}
+
+// Status lists every known migration and every applied one.
+func (m *Migrator) Status() ([]Status, error) {
+	ctx := context.Background()
+	if err := ensureTable(ctx, m.DB); err != nil {
+		return nil, err
+	}
+	done, err := applied(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		s := Status{Version: migration.Version, Name: migration.Name}
+		if at, ok := done[migration.Version]; ok {
+			at := at
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	// Versions applied by a newer binary are still worth listing.
+	for version, at := range done {
+		if _, ok := m.find(version); !ok {
+			at := at
+			statuses = append(statuses, Status{Version: version, Name: "(unknown)", AppliedAt: &at})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Check reports whether the database is at exactly the latest version.
+func (m *Migrator) Check() error {
+	current, err := m.Current()
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	latest := m.Latest()
+	switch {
+	case current < latest:
+		return fmt.Errorf("%w: at version %d, need %d", ErrSchemaOutdated, current, latest)
+	case current > latest:
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, latest)
+	}
+	return nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() ([]Status, error) {
+	return m.To(m.Latest())
+}
+
+// Down reverts the most recently applied steps migrations.
+func (m *Migrator) Down(steps int) ([]Status, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1")
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var appliedVersions []int64
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			appliedVersions = append(appliedVersions, s.Version)
+		}
+	}
+	if len(appliedVersions) == 0 {
+		return nil, nil
+	}
+	target := int64(0)
+	if steps < len(appliedVersions) {
+		target = appliedVersions[len(appliedVersions)-steps-1]
+	}
+	return m.To(target)
+}
+
+// To migrates up or down until exactly the migrations up to and including
+// target are applied. It returns the migrations it ran, in order.
+func (m *Migrator) To(target int64) ([]Status, error) {
+	if target != 0 {
+		if _, ok := m.find(target); !ok {
+			return nil, fmt.Errorf("unknown migration version %d", target)
+		}
+	}
+
+	ctx := context.Background()
+	conn, err := m.DB.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return nil, fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	done, err := applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Status
+
+	// Revert newer migrations first, newest to oldest.
+	var revert []int64
+	for version := range done {
+		if version > target {
+			revert = append(revert, version)
+		}
+	}
+	sort.Slice(revert, func(i, j int) bool { return revert[i] > revert[j] })
+	for _, version := range revert {
+		migration, ok := m.find(version)
+		if !ok {
+			return ran, fmt.Errorf("cannot revert version %d: this binary does not know it", version)
+		}
+		err := run(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version=$1`, migration.Version)
+		if err != nil {
+			return ran, fmt.Errorf("reverting %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, Status{Version: migration.Version, Name: migration.Name})
+	}
+
+	for _, migration := range m.Migrations {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+		err := run(ctx, conn, migration.Up,
+			`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		if err != nil {
+			return ran, fmt.Errorf("applying %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		now := time.Now()
+		ran = append(ran, Status{Version: migration.Version, Name: migration.Name, AppliedAt: &now})
+	}
+	return ran, nil
+}
+
+// run executes a migration script and its bookkeeping statement atomically.
+// The script is sent without arguments so PostgreSQL accepts several
+// statements at once.
+func run(ctx context.Context, conn *sqlx.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestAll(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2*len(migrations) {
+		t.Errorf("%d files for %d migrations", len(entries), len(migrations))
+	}
+	for i, m := range migrations {
+		// Versions count up from 1 without gaps, so a missing file
+		// cannot go unnoticed.
+		if m.Version != int64(i+1) {
+			t.Fatalf("migration %d is version %d", i+1, m.Version)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("%04d_%s has an empty script", m.Version, m.Name)
+		}
+		for _, direction := range []string{"up", "down"} {
+			name := fmt.Sprintf("sql/%04d_%s.%s.sql", m.Version, m.Name, direction)
+			if _, err := fs.Stat(files, name); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+// testDB connects to TEST_DATABASE_URL. The database is wiped: it must be
+// one kept for the tests.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sqlx.Connect("pgx", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// tables lists the tables in the public schema other than
+// schema_migrations.
+func tables(t *testing.T, db *sqlx.DB) []string {
+	t.Helper()
+	var names []string
+	err := db.Select(&names, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema='public' AND table_type='BASE TABLE'
+			AND table_name NOT IN ('schema_migrations', 'spatial_ref_sys')
+		ORDER BY table_name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestUpDownUp(t *testing.T) {
+	db := testDB(t)
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.To(0); err != nil {
+		t.Fatalf("clearing the database: %v", err)
+	}
+
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	schema := tables(t, db)
+
+	// Each down must undo its up, so reverting one at a time leaves
+	// nothing behind.
+	for version := m.Latest(); version > 0; version-- {
+		if _, err := m.Down(1); err != nil {
+			t.Fatalf("down from %d: %v", version, err)
+		}
+		if current, err := m.Current(); err != nil || current != version-1 {
+			t.Fatalf("after reverting %d at version %d, %v", version, current, err)
+		}
+	}
+	if left := tables(t, db); len(left) > 0 {
+		t.Fatalf("tables left after reverting everything: %v", left)
+	}
+
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+	if again := tables(t, db); strings.Join(again, ",") != strings.Join(schema, ",") {
+		t.Errorf("tables after up, down and up: %v; want %v", again, schema)
+	}
+}
@@ -0,0 +1,6 @@
+-- The postgis extension is left installed; other database objects may depend on it.
+DROP TABLE IF EXISTS admin_boundaries;
+DROP TABLE IF EXISTS complaint_updates;
+DROP TABLE IF EXISTS complaints;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS category;
@@ -0,0 +1,62 @@
+-- Base schema the application was built against. Every statement is
+-- idempotent so databases created before migrations existed can adopt it.
+--
+-- complaints.catergory_id keeps its historical spelling; the code and
+-- existing data depend on it.
+
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE IF NOT EXISTS category (
+    id            SERIAL PRIMARY KEY,
+    category_name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGSERIAL PRIMARY KEY,
+    name          TEXT NOT NULL,
+    email         TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role          TEXT NOT NULL DEFAULT 'user',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS complaints (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id),
+    title        TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    catergory_id INTEGER REFERENCES category(id),
+    status       TEXT NOT NULL DEFAULT 'pending',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    evidence     TEXT NOT NULL DEFAULT '',
+    location     GEOGRAPHY(Point, 4326),
+    is_public    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_complaints_user ON complaints(user_id);
+CREATE INDEX IF NOT EXISTS idx_complaints_category ON complaints(catergory_id);
+CREATE INDEX IF NOT EXISTS idx_complaints_location ON complaints USING GIST(location);
+
+-- AddUpdate scans "RETURNING *" into models.ComplaintUpdate, so columns
+-- added here must also be added to the model.
+CREATE TABLE IF NOT EXISTS complaint_updates (
+    id           BIGSERIAL PRIMARY KEY,
+    complaint_id BIGINT NOT NULL REFERENCES complaints(id) ON DELETE CASCADE,
+    user_id      BIGINT NOT NULL REFERENCES users(id),
+    comment      TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_complaint_updates_complaint ON complaint_updates(complaint_id);
+
+-- District polygons, loaded from GADM level 2 data. name_2 is the district.
+CREATE TABLE IF NOT EXISTS admin_boundaries (
+    gid    SERIAL PRIMARY KEY,
+    name_1 TEXT,
+    name_2 TEXT,
+    geom   GEOMETRY(MultiPolygon, 4326)
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_boundaries_geom ON admin_boundaries USING GIST(geom);
+CREATE INDEX IF NOT EXISTS idx_admin_boundaries_name_2 ON admin_boundaries(name_2);
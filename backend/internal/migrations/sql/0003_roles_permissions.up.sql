@@ -0,0 +1,59 @@
+-- Roles and permissions. users.role refers to roles.name.
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    name         TEXT PRIMARY KEY,
+    description  TEXT NOT NULL DEFAULT '',
+    is_system    BOOLEAN NOT NULL DEFAULT FALSE,
+    requires_mfa BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_name  TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role_name, permission)
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('complaint.create',       'File new complaints'),
+    ('complaint.view_own',     'View complaints you filed'),
+    ('complaint.view_all',     'View and filter all complaints'),
+    ('complaint.view_private', 'See complaints that are not marked public'),
+    ('complaint.update',       'Post updates and change complaint status'),
+    ('complaint.assign',       'Assign complaints to officials'),
+    ('user.view',              'List users and officials'),
+    ('user.manage',            'Change user roles and unlock accounts'),
+    ('role.manage',            'Define roles and their permissions')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description, is_system, requires_mfa) VALUES
+    ('admin',    'Full administrative access',         TRUE, TRUE),
+    ('official', 'Handles and updates complaints',     TRUE, TRUE),
+    ('user',     'Citizen filing their own complaints', TRUE, FALSE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission)
+SELECT 'admin', name FROM permissions
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('official', 'complaint.view_all'),
+    ('official', 'complaint.view_private'),
+    ('official', 'complaint.update'),
+    ('user',     'complaint.create'),
+    ('user',     'complaint.view_own')
+ON CONFLICT DO NOTHING;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_fkey') THEN
+        ALTER TABLE users
+            ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name);
+    END IF;
+END;
+$$;
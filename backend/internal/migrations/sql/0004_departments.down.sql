@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS official_districts;
+DROP TABLE IF EXISTS official_departments;
+DROP TABLE IF EXISTS department_categories;
+DROP TABLE IF EXISTS departments;
+
+DELETE FROM permissions WHERE name IN ('complaint.scope_all', 'department.manage');
@@ -0,0 +1,38 @@
+-- Departments and officials' jurisdictions. An official sees complaints whose
+-- category belongs to one of their departments and, if they have districts,
+-- which lie inside one of those admin_boundaries.
+
+CREATE TABLE IF NOT EXISTS departments (
+    id          BIGSERIAL PRIMARY KEY,
+    name        TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS department_categories (
+    department_id BIGINT NOT NULL REFERENCES departments(id) ON DELETE CASCADE,
+    category_id   INTEGER NOT NULL REFERENCES category(id) ON DELETE CASCADE,
+    PRIMARY KEY (department_id, category_id)
+);
+
+CREATE TABLE IF NOT EXISTS official_departments (
+    user_id       BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    department_id BIGINT NOT NULL REFERENCES departments(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, department_id)
+);
+
+CREATE TABLE IF NOT EXISTS official_districts (
+    user_id       BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    district_name TEXT NOT NULL,
+    PRIMARY KEY (user_id, district_name)
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('complaint.scope_all', 'Work on complaints outside your own departments and districts'),
+    ('department.manage',   'Manage departments and officials'' jurisdictions')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'complaint.scope_all'),
+    ('admin', 'department.manage')
+ON CONFLICT DO NOTHING;
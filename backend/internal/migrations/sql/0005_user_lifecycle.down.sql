@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS password_tokens;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS is_active,
+    DROP COLUMN IF EXISTS password_reset_required,
+    DROP COLUMN IF EXISTS token_version,
+    DROP COLUMN IF EXISTS last_login_at;
@@ -0,0 +1,2 @@
+-- The public URLs cannot be rebuilt here: they depended on the deployment's
+-- storage endpoint. Keys are left as they are.
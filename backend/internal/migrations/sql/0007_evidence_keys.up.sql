@@ -0,0 +1,10 @@
+-- complaints.evidence now holds the object key instead of a public URL;
+-- URLs are signed per request. Earlier uploads were stored at the bucket
+-- root, so their key is the last path segment of the old URL.
+--
+-- Objects uploaded before this change were given the public-read ACL; make
+-- the bucket private and reset those ACLs to stop serving them publicly.
+
+UPDATE complaints
+SET evidence = regexp_replace(evidence, '^.*/', '')
+WHERE evidence LIKE 'http://%' OR evidence LIKE 'https://%';
@@ -0,0 +1,14 @@
+ALTER TABLE complaints ADD COLUMN IF NOT EXISTS evidence TEXT NOT NULL DEFAULT '';
+
+-- Keep each complaint's oldest own attachment as its evidence.
+UPDATE complaints c
+SET evidence = a.object_key
+FROM (
+    SELECT DISTINCT ON (complaint_id) complaint_id, object_key
+    FROM attachments
+    WHERE update_id IS NULL
+    ORDER BY complaint_id, id
+) a
+WHERE a.complaint_id = c.id;
+
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,29 @@
+-- Files attached to complaints and to their updates. update_id is NULL for
+-- files attached to the complaint itself.
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id              BIGSERIAL PRIMARY KEY,
+    complaint_id    BIGINT NOT NULL REFERENCES complaints(id) ON DELETE CASCADE,
+    update_id       BIGINT REFERENCES complaint_updates(id) ON DELETE CASCADE,
+    object_key      TEXT NOT NULL UNIQUE,
+    filename        TEXT NOT NULL,
+    content_type    TEXT NOT NULL,
+    size_bytes      BIGINT NOT NULL,
+    checksum_sha256 TEXT NOT NULL,
+    caption         TEXT NOT NULL DEFAULT '',
+    uploaded_by     BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_complaint ON attachments(complaint_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_update ON attachments(update_id);
+
+-- The single evidence file becomes the complaint's first attachment. Its
+-- size and checksum were never recorded.
+INSERT INTO attachments (complaint_id, object_key, filename, content_type, size_bytes, checksum_sha256, uploaded_by, created_at)
+SELECT id, evidence, regexp_replace(evidence, '^.*/', ''), 'application/octet-stream', 0, '', user_id, created_at
+FROM complaints
+WHERE evidence <> ''
+ON CONFLICT (object_key) DO NOTHING;
+
+ALTER TABLE complaints DROP COLUMN IF EXISTS evidence;
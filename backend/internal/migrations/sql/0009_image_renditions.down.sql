@@ -0,0 +1,7 @@
+ALTER TABLE attachments
+    DROP COLUMN IF EXISTS thumbnail_key,
+    DROP COLUMN IF EXISTS web_key,
+    DROP COLUMN IF EXISTS taken_at,
+    DROP COLUMN IF EXISTS photo_latitude,
+    DROP COLUMN IF EXISTS photo_longitude,
+    DROP COLUMN IF EXISTS photo_distance_m;
@@ -0,0 +1,11 @@
+-- Resized copies of photos and what their EXIF data said before it was
+-- stripped. photo_distance_m is how far from the complaint the photo was
+-- taken, when it recorded a position.
+
+ALTER TABLE attachments
+    ADD COLUMN IF NOT EXISTS thumbnail_key    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS web_key          TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS taken_at         TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS photo_latitude   DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS photo_longitude  DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS photo_distance_m DOUBLE PRECISION;
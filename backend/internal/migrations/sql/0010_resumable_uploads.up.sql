@@ -0,0 +1,30 @@
+-- Resumable (tus) uploads. The file is assembled in the object store under
+-- object_key through a multipart upload; bytes that do not yet fill a part
+-- wait in a pending object. A finished upload is referenced by id when a
+-- complaint or update is created, and removed once attached. Uploads past
+-- expires_at are garbage-collected.
+
+CREATE TABLE IF NOT EXISTS uploads (
+    id            TEXT PRIMARY KEY,
+    user_id       BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename      TEXT NOT NULL DEFAULT '',
+    content_type  TEXT NOT NULL DEFAULT '',
+    length        BIGINT NOT NULL CHECK (length > 0),
+    upload_offset BIGINT NOT NULL DEFAULT 0 CHECK (upload_offset <= length),
+    object_key    TEXT NOT NULL,
+    multipart_id  TEXT NOT NULL,
+    pending_size  BIGINT NOT NULL DEFAULT 0,
+    completed_at  TIMESTAMPTZ,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads(expires_at);
+
+CREATE TABLE IF NOT EXISTS upload_parts (
+    upload_id   TEXT NOT NULL REFERENCES uploads(id) ON DELETE CASCADE,
+    part_number INT NOT NULL,
+    etag        TEXT NOT NULL,
+    size_bytes  BIGINT NOT NULL,
+    PRIMARY KEY (upload_id, part_number)
+);
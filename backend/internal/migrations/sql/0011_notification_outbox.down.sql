@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS outbox;
+
+DELETE FROM permissions WHERE name = 'notification.manage';
@@ -0,0 +1,35 @@
+-- Outgoing notifications. A row is written in the same transaction as the
+-- change it reports, so a crash or a mail server outage cannot lose it;
+-- workers then deliver it, retrying with exponential backoff, and give up
+-- after the configured number of attempts by marking it dead. Rows being
+-- delivered are leased until locked_until so another worker can pick them up
+-- if one dies.
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id              BIGSERIAL PRIMARY KEY,
+    kind            TEXT NOT NULL,
+    channel         TEXT NOT NULL DEFAULT 'email',
+    recipient       TEXT NOT NULL,
+    complaint_id    BIGINT REFERENCES complaints(id) ON DELETE SET NULL,
+    payload         JSONB NOT NULL DEFAULT '{}',
+    status          TEXT NOT NULL DEFAULT 'pending'
+                    CHECK (status IN ('pending', 'sending', 'sent', 'dead')),
+    attempts        INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ,
+    last_error      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at         TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox(next_attempt_at)
+    WHERE status IN ('pending', 'sending');
+CREATE INDEX IF NOT EXISTS idx_outbox_status ON outbox(status, created_at);
+
+INSERT INTO permissions (name, description) VALUES
+    ('notification.manage', 'Inspect and retry outgoing notifications')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'notification.manage')
+ON CONFLICT DO NOTHING;
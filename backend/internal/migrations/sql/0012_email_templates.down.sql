@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS email_templates;
+ALTER TABLE outbox DROP COLUMN IF EXISTS language;
+ALTER TABLE users DROP COLUMN IF EXISTS language;
+
+DELETE FROM permissions WHERE name = 'template.manage';
@@ -0,0 +1,25 @@
+-- Email templates. The built-in templates ship with the code; a row here
+-- overrides one event in one language. Users and queued emails carry the
+-- language the email is rendered in.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT 'en';
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT 'en';
+
+CREATE TABLE IF NOT EXISTS email_templates (
+    event      TEXT NOT NULL,
+    language   TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    text_body  TEXT NOT NULL,
+    html_body  TEXT NOT NULL,
+    updated_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (event, language)
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('template.manage', 'Edit and preview email templates')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'template.manage')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_outbox_held;
+
+-- Held notifications would never be sent; digested ones were.
+UPDATE outbox SET status = 'dead', last_error = 'digests removed' WHERE status = 'held';
+UPDATE outbox SET status = 'sent' WHERE status = 'digested';
+ALTER TABLE outbox DROP CONSTRAINT IF EXISTS outbox_status_check;
+ALTER TABLE outbox ADD CONSTRAINT outbox_status_check
+    CHECK (status IN ('pending', 'sending', 'sent', 'dead'));
+
+ALTER TABLE users DROP COLUMN IF EXISTS notify_frequency;
+ALTER TABLE users DROP COLUMN IF EXISTS notify_channel;
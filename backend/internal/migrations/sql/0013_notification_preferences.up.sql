@@ -0,0 +1,15 @@
+-- How users want to hear about their complaints: by email, only in the app,
+-- or not at all, and at once or in a daily digest. Notifications waiting
+-- for a digest stay in the outbox as 'held' until the digest goes out, then
+-- are marked 'digested'.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_channel TEXT NOT NULL DEFAULT 'email'
+    CHECK (notify_channel IN ('email', 'in_app', 'none'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_frequency TEXT NOT NULL DEFAULT 'immediate'
+    CHECK (notify_frequency IN ('immediate', 'daily'));
+
+ALTER TABLE outbox DROP CONSTRAINT IF EXISTS outbox_status_check;
+ALTER TABLE outbox ADD CONSTRAINT outbox_status_check
+    CHECK (status IN ('pending', 'sending', 'sent', 'dead', 'held', 'digested'));
+
+CREATE INDEX IF NOT EXISTS idx_outbox_held ON outbox(recipient, id) WHERE status = 'held';
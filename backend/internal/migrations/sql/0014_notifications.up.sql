@@ -0,0 +1,18 @@
+-- The in-app notification inbox. Rows are written by the outbox workers
+-- from messages on the 'in_app' channel; outbox_id keeps a message that is
+-- delivered twice from showing up twice.
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind         TEXT NOT NULL,
+    complaint_id BIGINT REFERENCES complaints(id) ON DELETE CASCADE,
+    summary      TEXT NOT NULL,
+    data         JSONB NOT NULL DEFAULT '{}',
+    outbox_id    BIGINT UNIQUE,
+    read_at      TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL;
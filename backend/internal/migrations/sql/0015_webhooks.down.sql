@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_districts;
+DROP TABLE IF EXISTS webhook_categories;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS webhooks;
+
+DELETE FROM permissions WHERE name = 'webhook.manage';
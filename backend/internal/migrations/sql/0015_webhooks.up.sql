@@ -0,0 +1,56 @@
+-- Outbound webhooks. Partner systems subscribe to complaint events,
+-- optionally only for some categories or districts; deliveries go through
+-- the outbox on the 'webhook' channel and every attempt is logged.
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id          BIGSERIAL PRIMARY KEY,
+    url         TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    secret      TEXT NOT NULL,
+    is_active   BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by  BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_events (
+    webhook_id BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event      TEXT NOT NULL,
+    PRIMARY KEY (webhook_id, event)
+);
+
+-- No rows means every category, or every district.
+CREATE TABLE IF NOT EXISTS webhook_categories (
+    webhook_id  BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    category_id INT NOT NULL,
+    PRIMARY KEY (webhook_id, category_id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_districts (
+    webhook_id    BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    district_name TEXT NOT NULL,
+    PRIMARY KEY (webhook_id, district_name)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    webhook_id      BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    outbox_id       BIGINT REFERENCES outbox(id) ON DELETE SET NULL,
+    event           TEXT NOT NULL,
+    attempt         INT NOT NULL,
+    response_status INT,
+    response_body   TEXT NOT NULL DEFAULT '',
+    error           TEXT NOT NULL DEFAULT '',
+    duration_ms     INT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id DESC);
+
+INSERT INTO permissions (name, description) VALUES
+    ('webhook.manage', 'Manage outbound webhooks')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'webhook.manage')
+ON CONFLICT DO NOTHING;
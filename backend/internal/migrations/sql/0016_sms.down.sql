@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS phone_verifications;
+
+UPDATE users SET notify_channel = 'email' WHERE notify_channel = 'sms';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_notify_channel_check;
+ALTER TABLE users ADD CONSTRAINT users_notify_channel_check
+    CHECK (notify_channel IN ('email', 'in_app', 'none'));
+
+ALTER TABLE users DROP COLUMN IF EXISTS phone_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
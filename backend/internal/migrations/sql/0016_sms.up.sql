@@ -0,0 +1,19 @@
+-- Text messages: an optional phone number per user, verified by a one-time
+-- code before anything is sent to it, and 'sms' as a notification channel.
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS phone TEXT,
+    ADD COLUMN IF NOT EXISTS phone_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_notify_channel_check;
+ALTER TABLE users ADD CONSTRAINT users_notify_channel_check
+    CHECK (notify_channel IN ('email', 'in_app', 'sms', 'none'));
+
+CREATE TABLE IF NOT EXISTS phone_verifications (
+    user_id    BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    phone      TEXT NOT NULL,
+    code_hash  TEXT NOT NULL,
+    attempts   INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
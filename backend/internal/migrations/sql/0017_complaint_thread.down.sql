@@ -0,0 +1,8 @@
+DELETE FROM permissions WHERE name = 'complaint.reply';
+
+DROP INDEX IF EXISTS idx_complaint_updates_user;
+-- Without the column internal notes would turn into public messages.
+DELETE FROM complaint_updates WHERE visibility = 'internal';
+ALTER TABLE complaint_updates DROP COLUMN IF EXISTS previous_status;
+ALTER TABLE complaint_updates DROP COLUMN IF EXISTS status;
+ALTER TABLE complaint_updates DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,18 @@
+-- Updates are public messages, seen by the citizen, or internal notes only
+-- staff see. status and previous_status record the complaint's status after
+-- and before each update; they are NULL on updates posted before this.
+ALTER TABLE complaint_updates ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public'
+    CHECK (visibility IN ('public', 'internal'));
+ALTER TABLE complaint_updates ADD COLUMN IF NOT EXISTS status TEXT;
+ALTER TABLE complaint_updates ADD COLUMN IF NOT EXISTS previous_status TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_complaint_updates_user ON complaint_updates(complaint_id, user_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('complaint.reply', 'Reply on complaints you filed')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'complaint.reply'),
+    ('user',  'complaint.reply')
+ON CONFLICT DO NOTHING;
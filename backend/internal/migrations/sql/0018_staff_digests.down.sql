@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_complaint_updates_created;
+DROP TABLE IF EXISTS digest_schedules;
@@ -0,0 +1,20 @@
+-- When staff receive their summary email. hour and weekday (0 is Sunday,
+-- used by weekly digests) are in timezone, an IANA name. next_due_at is
+-- worked out by the API from the rest and moved on once the digest has been
+-- queued, which is also what stops two API processes sending it twice.
+CREATE TABLE IF NOT EXISTS digest_schedules (
+    user_id      BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    frequency    TEXT NOT NULL CHECK (frequency IN ('off', 'daily', 'weekly')),
+    hour         INT NOT NULL CHECK (hour BETWEEN 0 AND 23),
+    weekday      INT NOT NULL DEFAULT 1 CHECK (weekday BETWEEN 0 AND 6),
+    timezone     TEXT NOT NULL DEFAULT 'UTC',
+    next_due_at  TIMESTAMPTZ,
+    last_sent_at TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_digest_schedules_due ON digest_schedules(next_due_at)
+    WHERE frequency <> 'off';
+
+-- Finds reopened complaints without scanning every update.
+CREATE INDEX IF NOT EXISTS idx_complaint_updates_created ON complaint_updates(created_at);
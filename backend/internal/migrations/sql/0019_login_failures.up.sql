@@ -0,0 +1,11 @@
+-- Failed login counters per account and per client address, shared by every
+-- API process and kept across restarts.
+
+CREATE TABLE IF NOT EXISTS login_failures (
+    key           TEXT PRIMARY KEY,
+    failures      INT NOT NULL DEFAULT 0,
+    last_failure  TIMESTAMPTZ NOT NULL DEFAULT to_timestamp(0),
+    blocked_until TIMESTAMPTZ NOT NULL DEFAULT to_timestamp(0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure ON login_failures (last_failure);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_complaints_assigned_to;
+ALTER TABLE complaints
+    DROP COLUMN IF EXISTS escalation_level,
+    DROP COLUMN IF EXISTS assigned_to;
@@ -0,0 +1,7 @@
+-- The official working on each complaint, and how often it was escalated.
+
+ALTER TABLE complaints
+    ADD COLUMN IF NOT EXISTS assigned_to      BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS escalation_level INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_complaints_assigned_to ON complaints (assigned_to) WHERE assigned_to IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Webhooks only hear about complaints not marked public if they opt in.
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS include_private BOOLEAN NOT NULL DEFAULT FALSE;
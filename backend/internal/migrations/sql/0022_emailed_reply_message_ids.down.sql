@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_complaint_updates_message_id;
+ALTER TABLE complaint_updates DROP COLUMN IF EXISTS message_id;
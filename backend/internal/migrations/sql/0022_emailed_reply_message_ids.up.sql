@@ -0,0 +1,6 @@
+-- Replies emailed in keep their Message-ID, so a message delivered twice or
+-- processed by two API instances becomes one comment.
+
+ALTER TABLE complaint_updates ADD COLUMN IF NOT EXISTS message_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_complaint_updates_message_id ON complaint_updates(message_id) WHERE message_id IS NOT NULL;
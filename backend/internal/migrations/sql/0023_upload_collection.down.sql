@@ -0,0 +1 @@
+ALTER TABLE uploads DROP COLUMN IF EXISTS collecting_until;
@@ -0,0 +1,5 @@
+-- Every API instance collects expired uploads. An instance leases the ones
+-- it collects until collecting_until, so no other instance collects them at
+-- the same time and, if collecting fails, they are tried again later.
+
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS collecting_until TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+-- The token handed out between the password and the second factor carries a
+-- nonce; only the hash of the latest one is kept, and it is cleared when the
+-- login completes, so each token works once.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_login_hash TEXT;
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// ComplaintSummary is a short view of a complaint used in listings.
+type ComplaintSummary struct {
+	ID        int64     `db:"id" json:"id"`
+	Title     string    `db:"title" json:"title"`
+	Status    string    `db:"status" json:"status"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// UserActivity summarises what a user has done, for the admin user view.
+type UserActivity struct {
+	UserID           int64              `json:"user_id"`
+	IsActive         bool               `json:"is_active"`
+	LastLoginAt      *time.Time         `json:"last_login_at"`
+	ComplaintsFiled  int                `json:"complaints_filed"`
+	UpdatesPosted    int                `json:"updates_posted"`
+	RecentComplaints []ComplaintSummary `json:"recent_complaints"`
+	RecentUpdates    []ComplaintUpdate  `json:"recent_updates"`
+}
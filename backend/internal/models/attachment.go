@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Attachment is a file attached to a complaint, or to one of its updates
+// when UpdateID is set.
+type Attachment struct {
+	ID          int64  `db:"id" json:"id"`
+	ComplaintID int64  `db:"complaint_id" json:"complaint_id"`
+	UpdateID    *int64 `db:"update_id" json:"update_id"`
+	// ObjectKey locates the file in the object store; clients get URL.
+	ObjectKey   string    `db:"object_key" json:"-"`
+	Filename    string    `db:"filename" json:"filename"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Size        int64     `db:"size_bytes" json:"size"`
+	Checksum    string    `db:"checksum_sha256" json:"checksum_sha256"`
+	Caption     string    `db:"caption" json:"caption"`
+	UploadedBy  *int64    `db:"uploaded_by" json:"uploaded_by"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	// Photos get resized renditions, and keep what their EXIF data said
+	// about when and where they were taken. The EXIF data itself is
+	// stripped before storing.
+	ThumbnailKey   string     `db:"thumbnail_key" json:"-"`
+	WebKey         string     `db:"web_key" json:"-"`
+	TakenAt        *time.Time `db:"taken_at" json:"taken_at"`
+	PhotoLatitude  *float64   `db:"photo_latitude" json:"photo_latitude"`
+	PhotoLongitude *float64   `db:"photo_longitude" json:"photo_longitude"`
+	// PhotoDistance is how far from the complaint's location, in meters,
+	// the photo was taken.
+	PhotoDistance *float64 `db:"photo_distance_m" json:"photo_distance_m"`
+
+	// URL, ThumbnailURL and WebURL are short-lived signed URLs made for the
+	// current request.
+	URL          string `db:"-" json:"url,omitempty"`
+	ThumbnailURL string `db:"-" json:"thumbnail_url,omitempty"`
+	WebURL       string `db:"-" json:"web_url,omitempty"`
+	// LocationMismatch flags photos taken further from the complaint than
+	// the configured tolerance.
+	LocationMismatch bool `db:"-" json:"location_mismatch"`
+}
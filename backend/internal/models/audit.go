@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry is one row of the append-only audit log. Hash covers every other
+// field plus PrevHash, chaining each entry to the one before it.
+type AuditEntry struct {
+	ID         int64           `db:"id" json:"id"`
+	OccurredAt time.Time       `db:"occurred_at" json:"occurred_at"`
+	ActorID    *int64          `db:"actor_id" json:"actor_id"`
+	ActorRole  string          `db:"actor_role" json:"actor_role"`
+	Action     string          `db:"action" json:"action"`
+	TargetType string          `db:"target_type" json:"target_type"`
+	TargetID   string          `db:"target_id" json:"target_id"`
+	Before     json.RawMessage `db:"before_state" json:"before"`
+	After      json.RawMessage `db:"after_state" json:"after"`
+	Status     int             `db:"status" json:"status"`
+	IP         string          `db:"ip" json:"ip"`
+	RequestID  string          `db:"request_id" json:"request_id"`
+	PrevHash   string          `db:"prev_hash" json:"prev_hash"`
+	Hash       string          `db:"hash" json:"hash"`
+}
+
+// AuditFilter narrows audit log queries. Zero values mean "any".
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
@@ -13,11 +13,32 @@ type Complaint struct {
 	Status      string    `db:"status" json:"status"`
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
-	Evidence    string    `db:"evidence" json:"evidence"`
-	Location    *string   `db:"location" json:"location"` // PostGIS geography type
-	Latitude    float64   `db:"latitude" json:"latitude"`
-	Longitude   float64   `db:"longitude" json:"longitude"`
-	IsPublic    bool      `json:"ispublic" db:"is_public"`
+	// Attachments are the files attached to the complaint itself, with
+	// signed URLs made for the current request.
+	Attachments []Attachment `db:"-" json:"attachments"`
+	// EvidenceURL is the first attachment's URL, kept for older clients.
+	EvidenceURL string  `db:"-" json:"evidence"`
+	Location    *string `db:"location" json:"location"` // PostGIS geography type
+	Latitude    float64 `db:"latitude" json:"latitude"`
+	Longitude   float64 `db:"longitude" json:"longitude"`
+	IsPublic    bool    `json:"ispublic" db:"is_public"`
+	// AssignedTo is the official working on the complaint, if any.
+	AssignedTo *int64 `db:"assigned_to" json:"assigned_to"`
+	// EscalationLevel counts how often the complaint has been escalated.
+	EscalationLevel int `db:"escalation_level" json:"escalation_level"`
+}
+
+// Complaint statuses.
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "In_Progress"
+	StatusResolved   = "Resolved"
+	StatusRejected   = "Rejected"
+)
+
+// IsClosed reports whether status is one a complaint is finished in.
+func IsClosed(status string) bool {
+	return status == StatusResolved || status == StatusRejected
 }
 
 // Category represents a complaint category in the database
@@ -31,8 +52,19 @@ type CreateComplaintRequest struct {
 	Title       string  `json:"title" binding:"required,min=5"`
 	Description string  `json:"description" binding:"required,min=10"`
 	Category    int     `json:"category" binding:"required"`
-	Evidence    string  `json:"evidence"`
 	Latitude    float64 `json:"latitude" binding:"required,latitude"`
 	Longitude   float64 `json:"longitude" binding:"required,longitude"`
 	IsPublic    bool    `json:"is_public" `
 }
+
+// AssignComplaintRequest names the official to work on a complaint.
+type AssignComplaintRequest struct {
+	AssigneeID int64 `json:"assignee_id" binding:"required"`
+}
+
+// EscalateComplaintRequest hands a complaint to another official, usually a
+// more senior one, saying why.
+type EscalateComplaintRequest struct {
+	AssigneeID int64  `json:"assignee_id" binding:"required"`
+	Reason     string `json:"reason" binding:"required,min=10"`
+}
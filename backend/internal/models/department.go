@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Department groups the complaint categories a team of officials handles.
+type Department struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	CategoryIDs []int     `db:"-" json:"category_ids"`
+}
+
+// DepartmentRequest creates or replaces a department.
+type DepartmentRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	CategoryIDs []int  `json:"category_ids"`
+}
+
+// OfficialScope is the jurisdiction of one official. An empty Districts list
+// means the official is not restricted geographically.
+type OfficialScope struct {
+	UserID        int64    `json:"user_id"`
+	DepartmentIDs []int64  `json:"department_ids"`
+	Districts     []string `json:"districts"`
+}
+
+// OfficialScopeRequest replaces an official's departments and districts.
+type OfficialScopeRequest struct {
+	DepartmentIDs []int64  `json:"department_ids"`
+	Districts     []string `json:"districts"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Digest frequencies.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestSchedule is when a staff member gets their summary email: at Hour
+// every day, or on Weekday (0 is Sunday) for weekly digests, in Timezone.
+type DigestSchedule struct {
+	UserID    int64  `db:"user_id" json:"-"`
+	Frequency string `db:"frequency" json:"frequency"`
+	Hour      int    `db:"hour" json:"hour"`
+	Weekday   int    `db:"weekday" json:"weekday"`
+	Timezone  string `db:"timezone" json:"timezone"`
+	// NextDueAt is when the next digest goes out; nil while switched off,
+	// or after a digest could not be sent for good, until saved again.
+	NextDueAt  *time.Time `db:"next_due_at" json:"next_due_at"`
+	LastSentAt *time.Time `db:"last_sent_at" json:"last_sent_at"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"-"`
+}
+
+// DigestScheduleRequest sets the caller's digest schedule.
+type DigestScheduleRequest struct {
+	Frequency string `json:"frequency" binding:"required,oneof=off daily weekly"`
+	Hour      *int   `json:"hour" binding:"required,min=0,max=23"`
+	// Weekday is only needed for weekly digests.
+	Weekday  int    `json:"weekday" binding:"min=0,max=6"`
+	Timezone string `json:"timezone" binding:"required"`
+}
+
+// ComplaintTotals counts the complaints of one category in one district.
+// District is empty for complaints outside every district.
+type ComplaintTotals struct {
+	District   string `db:"district" json:"district"`
+	CategoryID int    `db:"category_id" json:"category_id"`
+	Category   string `db:"category" json:"category"`
+	// Filed counts the complaints filed in the period asked about.
+	Filed   int `db:"filed" json:"filed"`
+	Open    int `db:"open" json:"open"`
+	Overdue int `db:"overdue" json:"overdue"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// DefaultLanguage is the language emails are written in when there is no
+// template in the recipient's.
+const DefaultLanguage = "en"
+
+// EmailTemplate is the email sent for one event in one language. Subject and
+// TextBody are Go text templates, HTMLBody an html/template.
+type EmailTemplate struct {
+	Event     string     `db:"event" json:"event"`
+	Language  string     `db:"language" json:"language"`
+	Subject   string     `db:"subject" json:"subject"`
+	TextBody  string     `db:"text_body" json:"text_body"`
+	HTMLBody  string     `db:"html_body" json:"html_body"`
+	UpdatedBy *int64     `db:"updated_by" json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+// EmailTemplateRequest is the body of a template override or preview.
+type EmailTemplateRequest struct {
+	Subject  string `json:"subject"`
+	TextBody string `json:"text_body"`
+	HTMLBody string `json:"html_body"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginFailures counts the failed logins for one account or client address.
+// Key is "account:<email>" or "ip:<address>".
+type LoginFailures struct {
+	Key          string    `db:"key"`
+	Failures     int       `db:"failures"`
+	LastFailure  time.Time `db:"last_failure"`
+	BlockedUntil time.Time `db:"blocked_until"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Notification is an item in a user's in-app inbox. Summary is a one-line
+// description in the user's language; Data holds the details, such as the
+// complaint's title and new status, for the frontend to show.
+type Notification struct {
+	ID          int64           `db:"id" json:"id"`
+	UserID      int64           `db:"user_id" json:"-"`
+	Kind        string          `db:"kind" json:"kind"`
+	ComplaintID *int64          `db:"complaint_id" json:"complaint_id,omitempty"`
+	Summary     string          `db:"summary" json:"summary"`
+	Data        json.RawMessage `db:"data" json:"data"`
+	OutboxID    *int64          `db:"outbox_id" json:"-"`
+	ReadAt      *time.Time      `db:"read_at" json:"read_at"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Outbox message statuses.
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+	// OutboxHeld messages wait for the recipient's daily digest, after which
+	// they are OutboxDigested.
+	OutboxHeld     = "held"
+	OutboxDigested = "digested"
+)
+
+// Notification channels. Messages for the in-app inbox have the user's ID
+// as their recipient, webhook messages the webhook's, and text messages a
+// phone number.
+const (
+	ChannelEmail   = "email"
+	ChannelInApp   = "in_app"
+	ChannelWebhook = "webhook"
+	ChannelSMS     = "sms"
+)
+
+// OutboxMessage is a notification waiting to be delivered, or the record of
+// one that was delivered or given up on. Payload holds what the message for
+// Kind needs; ComplaintID is set for messages about a complaint.
+type OutboxMessage struct {
+	ID            int64           `db:"id" json:"id"`
+	Kind          string          `db:"kind" json:"kind"`
+	Channel       string          `db:"channel" json:"channel"`
+	Recipient     string          `db:"recipient" json:"recipient"`
+	Language      string          `db:"language" json:"language"`
+	ComplaintID   *int64          `db:"complaint_id" json:"complaint_id,omitempty"`
+	Payload       json.RawMessage `db:"payload" json:"payload"`
+	Status        string          `db:"status" json:"status"`
+	Attempts      int             `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   *time.Time      `db:"locked_until" json:"-"`
+	LastError     string          `db:"last_error" json:"last_error"`
+	CreatedAt     time.Time       `db:"created_at" json:"created_at"`
+	SentAt        *time.Time      `db:"sent_at" json:"sent_at"`
+}
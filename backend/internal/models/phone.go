@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// PhoneVerification is a one-time code texted to a number the user wants
+// to receive messages on. Each user has at most one pending.
+type PhoneVerification struct {
+	UserID    int64     `db:"user_id"`
+	Phone     string    `db:"phone"`
+	CodeHash  string    `db:"code_hash"`
+	Attempts  int       `db:"attempts"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// PhoneRequest starts verifying a phone number. Phone defaults to the
+// number already on the account.
+type PhoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+// PhoneCodeRequest confirms a phone number with the code texted to it.
+type PhoneCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
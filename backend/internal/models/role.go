@@ -0,0 +1,27 @@
+package models
+
+// Role is a named set of permissions that can be assigned to users.
+// System roles (admin, official, user) are seeded and cannot be changed.
+type Role struct {
+	Name        string   `db:"name" json:"name"`
+	Description string   `db:"description" json:"description"`
+	IsSystem    bool     `db:"is_system" json:"is_system"`
+	RequiresMFA bool     `db:"requires_mfa" json:"requires_mfa"`
+	Permissions []string `db:"-" json:"permissions"`
+}
+
+// CreateRoleRequest defines a new custom role.
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	RequiresMFA bool     `json:"requires_mfa"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// UpdateRoleDefinitionRequest replaces the description, 2FA policy and
+// permissions of a custom role.
+type UpdateRoleDefinitionRequest struct {
+	Description string   `json:"description"`
+	RequiresMFA bool     `json:"requires_mfa"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Thread entry types.
+const (
+	// ThreadFiled is the complaint being filed, with its description.
+	ThreadFiled = "filed"
+	// ThreadMessage is a public update or citizen reply.
+	ThreadMessage = "message"
+	// ThreadNote is an internal staff note.
+	ThreadNote = "note"
+	// ThreadStatusChange is an update changing the complaint's status.
+	ThreadStatusChange = "status_change"
+)
+
+// ThreadAuthor is who wrote a thread entry.
+type ThreadAuthor struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// IsOwner is true for the citizen who filed the complaint.
+	IsOwner bool `json:"is_owner"`
+}
+
+// ThreadEntry is one event in a complaint's timeline.
+type ThreadEntry struct {
+	Type     string        `json:"type"`
+	At       time.Time     `json:"at"`
+	Author   *ThreadAuthor `json:"author,omitempty"`
+	UpdateID *int64        `json:"update_id,omitempty"`
+	// Comment is the message, note or complaint description.
+	Comment string `json:"comment,omitempty"`
+	// FromStatus and ToStatus are set on status changes.
+	FromStatus string `json:"from_status,omitempty"`
+	ToStatus   string `json:"to_status,omitempty"`
+	// Attachments are the files filed with the complaint or posted with a
+	// message or note.
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
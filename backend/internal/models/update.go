@@ -2,15 +2,45 @@ package models
 
 import "time"
 
+// Update visibilities.
+const (
+	// VisibilityPublic updates are messages the citizen sees.
+	VisibilityPublic = "public"
+	// VisibilityInternal updates are notes only staff see.
+	VisibilityInternal = "internal"
+)
+
 type ComplaintUpdate struct {
 	ID          int64     `db:"id" json:"id"`
 	ComplaintID int64     `db:"complaint_id" json:"complaint_id"`
 	UserID      int64     `db:"user_id" json:"user_id"`
 	Comment     string    `db:"comment" json:"comment"`
+	Visibility  string    `db:"visibility" json:"visibility"`
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	// Status and PreviousStatus are the complaint's status after and before
+	// the update. They are nil on updates posted before they were recorded.
+	Status         *string `db:"status" json:"status,omitempty"`
+	PreviousStatus *string `db:"previous_status" json:"previous_status,omitempty"`
+	// Attachments are the files posted with the update.
+	Attachments []Attachment `db:"-" json:"attachments,omitempty"`
+}
+
+// ChangedStatus reports whether the update changed the complaint's status.
+func (u ComplaintUpdate) ChangedStatus() bool {
+	return u.Status != nil && u.PreviousStatus != nil && *u.Status != *u.PreviousStatus
 }
 
 // AddUpdateRequest is the structure for the request body.
 type AddUpdateComment struct {
-	Comment string `json:"comment" binding:"required,min=10"`
-}
\ No newline at end of file
+	Comment string `json:"comment" form:"comment" binding:"required,min=10"`
+	// Status is the complaint's new status, unchanged if not given.
+	// Internal notes leave the status as it is.
+	Status string `json:"status" form:"status" binding:"omitempty,oneof=In_Progress Resolved Rejected"`
+	// Visibility is public if not given.
+	Visibility string `json:"visibility" form:"visibility" binding:"omitempty,oneof=public internal"`
+}
+
+// ReplyRequest is a citizen's reply on their own complaint.
+type ReplyRequest struct {
+	Comment string `json:"comment" form:"comment" binding:"required,max=10000"`
+}
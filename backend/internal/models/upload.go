@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload, in progress or finished and waiting to be
+// attached to a complaint.
+type Upload struct {
+	ID          string `db:"id" json:"id"`
+	UserID      int64  `db:"user_id" json:"user_id"`
+	Filename    string `db:"filename" json:"filename"`
+	ContentType string `db:"content_type" json:"content_type"`
+	Length      int64  `db:"length" json:"length"`
+	Offset      int64  `db:"upload_offset" json:"offset"`
+	// ObjectKey is where the file is assembled, through the object store's
+	// multipart upload MultipartID.
+	ObjectKey   string `db:"object_key" json:"-"`
+	MultipartID string `db:"multipart_id" json:"-"`
+	// PendingSize counts received bytes not yet stored as a part.
+	PendingSize int64      `db:"pending_size" json:"-"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at"`
+	ExpiresAt   time.Time  `db:"expires_at" json:"expires_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	// Parts are the parts stored so far, in order.
+	Parts []UploadPart `db:"-" json:"-"`
+}
+
+// UploadPart is one stored part of an upload.
+type UploadPart struct {
+	Number int    `db:"part_number"`
+	ETag   string `db:"etag"`
+	Size   int64  `db:"size_bytes"`
+}
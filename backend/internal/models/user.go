@@ -1,27 +1,129 @@
 package models
-import(
+
+import (
+	"database/sql"
 	"time"
 )
 
-type User struct{
-	ID int64 `db:"id"`
-	Name string `db:"name"`
-	Email string `db:"email"`
-	PasswordHash string `db:"password_hash"`
-	Role string `db:"role"`
-	CreatedAt time.Time `db:"created_at"`
+type User struct {
+	ID           int64     `db:"id"`
+	Name         string    `db:"name"`
+	Email        string    `db:"email"`
+	PasswordHash string    `db:"password_hash"`
+	Role         string    `db:"role"`
+	CreatedAt    time.Time `db:"created_at"`
+	// TOTPSecret is set once the user starts enrolling; TOTPEnabled only
+	// after they proved they can generate codes with it.
+	TOTPSecret       sql.NullString `db:"totp_secret" json:"-"`
+	TOTPEnabled      bool           `db:"totp_enabled" json:"-"`
+	TOTPLastUsedStep sql.NullInt64  `db:"totp_last_used_step" json:"-"`
+	// IsActive is false for deactivated accounts, which cannot log in.
+	IsActive              bool       `db:"is_active"`
+	PasswordResetRequired bool       `db:"password_reset_required"`
+	LastLoginAt           *time.Time `db:"last_login_at"`
+	// TokenVersion is embedded in issued tokens; bumping it revokes them all.
+	TokenVersion int `db:"token_version" json:"-"`
+	// Language is the language emails to the user are written in.
+	Language string `db:"language"`
+	// NotifyChannel and NotifyFrequency say how the user hears about their
+	// complaints.
+	NotifyChannel   string `db:"notify_channel"`
+	NotifyFrequency string `db:"notify_frequency"`
+	// Phone is an E.164 number such as +919812345678. Text messages are
+	// only sent to it once PhoneVerified.
+	Phone         *string `db:"phone"`
+	PhoneVerified bool    `db:"phone_verified"`
+}
+
+// SMSPhone is the number text messages may be sent to, or "" if the user
+// has no verified number.
+func (u User) SMSPhone() string {
+	if u.Phone == nil || !u.PhoneVerified {
+		return ""
+	}
+	return *u.Phone
+}
+
+// Ways a user can choose to hear about their complaints.
+const (
+	NotifyByEmail = "email"
+	NotifyInApp   = "in_app"
+	NotifyBySMS   = "sms"
+	NotifyNone    = "none"
+
+	NotifyImmediately = "immediate"
+	NotifyDaily       = "daily"
+)
+
+// NotificationPreferences is how a user wants to hear about updates to
+// their complaints. Security emails, such as password resets, are always
+// sent at once, and by email. Text messages are never held for a digest.
+type NotificationPreferences struct {
+	Channel   string `json:"channel" binding:"required,oneof=email in_app sms none"`
+	Frequency string `json:"frequency" binding:"required,oneof=immediate daily"`
 }
 
 type RegisterRequest struct {
-    Name     string `json:"name" binding:"required"`
-    Email    string `json:"email" binding:"required,email"` // Essential for login/uniqueness
-    Password string `json:"password"binding:"required,min=8"`
+	Name     string `json:"name" binding:"required"`
+	Email    string `json:"email" binding:"required,email"` // Essential for login/uniqueness
+	Password string `json:"password" binding:"required,min=8"`
+	// Language is optional and defaults to English.
+	Language string `json:"language"`
+	// Phone is optional and must be verified before texts are sent to it.
+	Phone string `json:"phone"`
 }
-type LoginRequest struct{
-	 Email    string `json:"email" binding:"required,email"` // Essential for login/uniqueness
-    Password string `json:"password"binding:"required,min=8"`
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required,email"` // Essential for login/uniqueness
+	Password string `json:"password" binding:"required,min=8"`
 }
 
 type UpdateRoleRequest struct {
 	Role string `json:"role" binding:"required"`
 }
+
+// TwoFactorLoginRequest completes a login for an account with 2FA enabled.
+// Either Code (from the authenticator app) or RecoveryCode must be set.
+type TwoFactorLoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TwoFactorCodeRequest carries a single TOTP code, used to confirm enrollment.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorDisableRequest requires both the password and a current code.
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// CreateStaffRequest is used by admins to create an account for a staff
+// member, who then sets their own password from the invite email.
+type CreateStaffRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// SetPasswordRequest redeems an invite or password reset token.
+type SetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// PasswordToken is an invite or password reset link. Only the hash of the
+// token in the link is stored.
+type PasswordToken struct {
+	Hash      string
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+// Purposes of a PasswordToken.
+const (
+	PasswordTokenInvite = "invite"
+	PasswordTokenReset  = "reset"
+)
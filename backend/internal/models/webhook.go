@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Webhook is a partner system's subscription to complaint events. Empty
+// CategoryIDs or Districts do not filter.
+type Webhook struct {
+	ID          int64  `db:"id" json:"id"`
+	URL         string `db:"url" json:"url"`
+	Description string `db:"description" json:"description"`
+	// Secret signs the payloads. It is only shown when created or rotated.
+	Secret   string `db:"secret" json:"-"`
+	IsActive bool   `db:"is_active" json:"is_active"`
+	// IncludePrivate lets the webhook hear about complaints not marked
+	// public.
+	IncludePrivate bool      `db:"include_private" json:"include_private"`
+	CreatedBy      *int64    `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+	Events         []string  `db:"-" json:"events"`
+	CategoryIDs    []int     `db:"-" json:"category_ids"`
+	Districts      []string  `db:"-" json:"districts"`
+}
+
+// Matches reports whether the webhook wants event about a complaint in
+// categoryID and district.
+func (w Webhook) Matches(event string, categoryID int, district string) bool {
+	if !w.IsActive {
+		return false
+	}
+	subscribed := false
+	for _, e := range w.Events {
+		subscribed = subscribed || e == event
+	}
+	if !subscribed {
+		return false
+	}
+	if len(w.CategoryIDs) > 0 {
+		found := false
+		for _, id := range w.CategoryIDs {
+			found = found || id == categoryID
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(w.Districts) > 0 {
+		found := false
+		for _, d := range w.Districts {
+			found = found || d == district
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookRequest creates or replaces a webhook.
+type WebhookRequest struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required,min=1"`
+	CategoryIDs []int    `json:"category_ids"`
+	Districts   []string `json:"districts"`
+	// IncludePrivate sends complaints not marked public too.
+	IncludePrivate bool `json:"include_private"`
+	// IsActive defaults to true.
+	IsActive *bool `json:"is_active"`
+}
+
+// WebhookDelivery is the log of one attempt to deliver to a webhook.
+// ResponseStatus is nil if no response was received.
+type WebhookDelivery struct {
+	ID             int64     `db:"id" json:"id"`
+	WebhookID      int64     `db:"webhook_id" json:"webhook_id"`
+	OutboxID       *int64    `db:"outbox_id" json:"outbox_id,omitempty"`
+	Event          string    `db:"event" json:"event"`
+	Attempt        int       `db:"attempt" json:"attempt"`
+	ResponseStatus *int      `db:"response_status" json:"response_status"`
+	ResponseBody   string    `db:"response_body" json:"response_body"`
+	Error          string    `db:"error" json:"error"`
+	DurationMS     int       `db:"duration_ms" json:"duration_ms"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,55 @@
+package repository
+
+import "complain/internal/models"
+
+// MemoryAttachmentRepository reads the attachments kept by a
+// MemoryComplaintRepository.
+type MemoryAttachmentRepository struct {
+	complaints *MemoryComplaintRepository
+}
+
+func NewMemoryAttachmentRepository(complaints *MemoryComplaintRepository) *MemoryAttachmentRepository {
+	return &MemoryAttachmentRepository{complaints: complaints}
+}
+
+func (r *MemoryAttachmentRepository) Get(id int64) (models.Attachment, error) {
+	r.complaints.mutex.Lock()
+	defer r.complaints.mutex.Unlock()
+
+	for _, a := range r.complaints.attachments {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return models.Attachment{}, ErrNotFound
+}
+
+func (r *MemoryAttachmentRepository) ListForComplaints(complaintIDs []int64) ([]models.Attachment, error) {
+	r.complaints.mutex.Lock()
+	defer r.complaints.mutex.Unlock()
+
+	wanted := make(map[int64]bool, len(complaintIDs))
+	for _, id := range complaintIDs {
+		wanted[id] = true
+	}
+	attachments := []models.Attachment{}
+	for _, a := range r.complaints.attachments {
+		if wanted[a.ComplaintID] {
+			attachments = append(attachments, a)
+		}
+	}
+	return attachments, nil
+}
+
+func (r *MemoryAttachmentRepository) Delete(id int64) error {
+	r.complaints.mutex.Lock()
+	defer r.complaints.mutex.Unlock()
+
+	for i, a := range r.complaints.attachments {
+		if a.ID == id {
+			r.complaints.attachments = append(r.complaints.attachments[:i], r.complaints.attachments[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+)
+
+// MemoryCategoryRepository keeps categories in memory.
+type MemoryCategoryRepository struct {
+	categories []models.Category
+	mutex      sync.Mutex
+}
+
+// NewMemoryCategoryRepository returns a repository holding categories.
+func NewMemoryCategoryRepository(categories ...models.Category) *MemoryCategoryRepository {
+	return &MemoryCategoryRepository{categories: append([]models.Category(nil), categories...)}
+}
+
+func (r *MemoryCategoryRepository) List() ([]models.Category, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	categories := append([]models.Category{}, r.categories...)
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
@@ -0,0 +1,408 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Jurisdiction is what official_departments and official_districts say an
+// official covers, flattened to the categories of their departments.
+type Jurisdiction struct {
+	CategoryIDs []int
+	Districts   []string
+}
+
+// MemoryComplaintRepository keeps complaints in memory.
+type MemoryComplaintRepository struct {
+	// Jurisdictions stands in for the department and district tables when
+	// filtering by ComplaintFilter.ScopeUserID.
+	Jurisdictions map[int64]Jurisdiction
+	// DistrictOf stands in for admin_boundaries and names the district a
+	// point lies in. If nil, no complaint lies in any district.
+	DistrictOf func(latitude, longitude float64) string
+	// Outbox receives the notifications passed to Create, AddUpdate and
+	// Assign.
+	Outbox *MemoryOutboxRepository
+
+	complaints  []models.Complaint
+	updates     []models.ComplaintUpdate
+	attachments []models.Attachment
+	// messageIDs are the Message-IDs of the emailed replies added.
+	messageIDs map[string]bool
+	mutex      sync.Mutex
+}
+
+func NewMemoryComplaintRepository() *MemoryComplaintRepository {
+	return &MemoryComplaintRepository{Jurisdictions: map[int64]Jurisdiction{}, Outbox: NewMemoryOutboxRepository()}
+}
+
+// Updates returns every stored complaint update, oldest first.
+func (r *MemoryComplaintRepository) Updates() []models.ComplaintUpdate {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]models.ComplaintUpdate(nil), r.updates...)
+}
+
+func (r *MemoryComplaintRepository) district(c models.Complaint) string {
+	if r.DistrictOf == nil {
+		return ""
+	}
+	return r.DistrictOf(c.Latitude, c.Longitude)
+}
+
+// reopened reports whether an update moved the complaint out of resolved or
+// rejected after since; the caller holds the mutex.
+func (r *MemoryComplaintRepository) reopened(complaintID int64, since time.Time) bool {
+	for _, u := range r.updates {
+		if u.ComplaintID == complaintID && !u.CreatedAt.Before(since) &&
+			u.PreviousStatus != nil && models.IsClosed(*u.PreviousStatus) && u.Status != nil && !models.IsClosed(*u.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryComplaintRepository) matches(c models.Complaint, filter ComplaintFilter) bool {
+	switch {
+	case filter.UserID != 0 && c.UserID != filter.UserID,
+		filter.Status != "" && c.Status != filter.Status,
+		filter.CategoryID != 0 && c.Category != filter.CategoryID,
+		filter.PublicOnly && !c.IsPublic,
+		filter.AssignedTo != 0 && (c.AssignedTo == nil || *c.AssignedTo != filter.AssignedTo),
+		filter.District != "" && r.district(c) != filter.District,
+		filter.Open && models.IsClosed(c.Status),
+		!filter.FiledAfter.IsZero() && c.CreatedAt.Before(filter.FiledAfter),
+		!filter.FiledBefore.IsZero() && !c.CreatedAt.Before(filter.FiledBefore),
+		!filter.ReopenedAfter.IsZero() && !r.reopened(c.ID, filter.ReopenedAfter):
+		return false
+	}
+	if filter.ScopeUserID != 0 {
+		j := r.Jurisdictions[filter.ScopeUserID]
+		if !containsInt(j.CategoryIDs, c.Category) {
+			return false
+		}
+		if len(j.Districts) > 0 && !containsString(j.Districts, r.district(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *MemoryComplaintRepository) Create(userID int64, req models.CreateComplaintRequest, status string, attachments []models.Attachment, notifications []models.OutboxMessage) (models.Complaint, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	location := fmt.Sprintf("POINT(%g %g)", req.Longitude, req.Latitude)
+	complaint := models.Complaint{
+		ID:          int64(len(r.complaints) + 1),
+		UserID:      userID,
+		Title:       req.Title,
+		Description: req.Description,
+		Category:    req.Category,
+		Status:      status,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Location:    &location,
+		Latitude:    req.Latitude,
+		Longitude:   req.Longitude,
+		IsPublic:    req.IsPublic,
+	}
+	r.complaints = append(r.complaints, complaint)
+	complaint.Attachments = r.addAttachments(complaint, nil, userID, attachments)
+	for i := range notifications {
+		notifications[i].ComplaintID = &complaint.ID
+	}
+	return complaint, r.Outbox.Enqueue(notifications)
+}
+
+func (r *MemoryComplaintRepository) Get(id int64, filter ComplaintFilter) (models.Complaint, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, c := range r.complaints {
+		if c.ID == id && r.matches(c, filter) {
+			return c, nil
+		}
+	}
+	return models.Complaint{}, ErrNotFound
+}
+
+func (r *MemoryComplaintRepository) List(filter ComplaintFilter) ([]models.Complaint, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	complaints := []models.Complaint{}
+	for _, c := range r.complaints {
+		if r.matches(c, filter) {
+			complaints = append(complaints, c)
+		}
+	}
+	sort.SliceStable(complaints, func(i, j int) bool {
+		if !complaints[i].CreatedAt.Equal(complaints[j].CreatedAt) {
+			return complaints[i].CreatedAt.After(complaints[j].CreatedAt)
+		}
+		return complaints[i].ID > complaints[j].ID
+	})
+	return complaints, nil
+}
+
+func (r *MemoryComplaintRepository) AddUpdate(complaintID, userID int64, comment, visibility, status string, filter ComplaintFilter, attachments []models.Attachment, notifications []models.OutboxMessage) (models.ComplaintUpdate, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, c := range r.complaints {
+		if c.ID != complaintID || !r.matches(c, filter) {
+			continue
+		}
+		previousStatus := c.Status
+		if status == "" {
+			status = previousStatus
+		}
+		update := models.ComplaintUpdate{
+			ID:             int64(len(r.updates) + 1),
+			ComplaintID:    complaintID,
+			UserID:         userID,
+			Comment:        comment,
+			Visibility:     visibility,
+			CreatedAt:      time.Now(),
+			Status:         &status,
+			PreviousStatus: &previousStatus,
+		}
+		r.updates = append(r.updates, update)
+		update.Attachments = r.addAttachments(c, &update.ID, userID, attachments)
+		r.complaints[i].Status = status
+		r.complaints[i].UpdatedAt = update.CreatedAt
+		for i := range notifications {
+			notifications[i].ComplaintID = &complaintID
+		}
+		return update, c.Status, r.Outbox.Enqueue(notifications)
+	}
+	return models.ComplaintUpdate{}, "", ErrNotFound
+}
+
+func (r *MemoryComplaintRepository) AddEmailedReply(complaintID, userID int64, comment, messageID string, filter ComplaintFilter, notifications []models.OutboxMessage) (models.ComplaintUpdate, error) {
+	r.mutex.Lock()
+	seen := r.messageIDs[messageID]
+	r.mutex.Unlock()
+	if seen {
+		return models.ComplaintUpdate{}, ErrDuplicate
+	}
+	update, _, err := r.AddUpdate(complaintID, userID, comment, models.VisibilityPublic, "", filter, nil, notifications)
+	if err != nil {
+		return update, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.messageIDs == nil {
+		r.messageIDs = map[string]bool{}
+	}
+	r.messageIDs[messageID] = true
+	return update, nil
+}
+
+// Assign does not check that assigneeID exists.
+func (r *MemoryComplaintRepository) Assign(complaintID, assigneeID int64, escalate bool, filter ComplaintFilter, notifications []models.OutboxMessage) (models.Complaint, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, c := range r.complaints {
+		if c.ID != complaintID || !r.matches(c, filter) {
+			continue
+		}
+		c.AssignedTo = &assigneeID
+		if escalate {
+			c.EscalationLevel++
+		}
+		c.UpdatedAt = time.Now()
+		r.complaints[i] = c
+		for i := range notifications {
+			notifications[i].ComplaintID = &complaintID
+		}
+		return c, r.Outbox.Enqueue(notifications)
+	}
+	return models.Complaint{}, ErrNotFound
+}
+
+func (r *MemoryComplaintRepository) ListUpdates(complaintID int64, includeInternal bool) ([]models.ComplaintUpdate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	updates := []models.ComplaintUpdate{}
+	for _, u := range r.updates {
+		if u.ComplaintID == complaintID && (includeInternal || u.Visibility != models.VisibilityInternal) {
+			updates = append(updates, u)
+		}
+	}
+	return updates, nil
+}
+
+// Totals leaves Category empty; there is no category table to name it from.
+func (r *MemoryComplaintRepository) Totals(filter ComplaintFilter, since, overdueBefore time.Time) ([]models.ComplaintTotals, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	type key struct {
+		district string
+		category int
+	}
+	byKey := map[key]*models.ComplaintTotals{}
+	totals := []models.ComplaintTotals{}
+	for _, c := range r.complaints {
+		filed, open := !c.CreatedAt.Before(since), !models.IsClosed(c.Status)
+		if !r.matches(c, filter) || (!filed && !open) {
+			continue
+		}
+		k := key{r.district(c), c.Category}
+		if byKey[k] == nil {
+			byKey[k] = &models.ComplaintTotals{District: k.district, CategoryID: k.category}
+		}
+		t := byKey[k]
+		if filed {
+			t.Filed++
+		}
+		if open {
+			t.Open++
+			if c.CreatedAt.Before(overdueBefore) {
+				t.Overdue++
+			}
+		}
+	}
+	for _, t := range byKey {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		a, b := totals[i], totals[j]
+		if a.Overdue != b.Overdue {
+			return a.Overdue > b.Overdue
+		}
+		if a.Open != b.Open {
+			return a.Open > b.Open
+		}
+		if a.District != b.District {
+			return a.District < b.District
+		}
+		return a.CategoryID < b.CategoryID
+	})
+	return totals, nil
+}
+
+// Staff takes everyone to be active; Jurisdictions stands in for the
+// officials' departments and districts.
+func (r *MemoryComplaintRepository) Staff(complaintID int64) ([]int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var complaint models.Complaint
+	for _, c := range r.complaints {
+		if c.ID == complaintID {
+			complaint = c
+		}
+	}
+	seen := map[int64]bool{}
+	ids := []int64{}
+	if a := complaint.AssignedTo; a != nil && *a != complaint.UserID {
+		seen[*a] = true
+		ids = append(ids, *a)
+	}
+	for _, u := range r.updates {
+		if u.ComplaintID == complaintID && u.UserID != complaint.UserID && !seen[u.UserID] {
+			seen[u.UserID] = true
+			ids = append(ids, u.UserID)
+		}
+	}
+	if len(ids) == 0 && complaint.ID != 0 {
+		for id, j := range r.Jurisdictions {
+			if id == complaint.UserID || !containsInt(j.CategoryIDs, complaint.Category) {
+				continue
+			}
+			if len(j.Districts) == 0 || containsString(j.Districts, r.district(complaint)) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// addAttachments stores attachments; the caller holds the mutex.
+func (r *MemoryComplaintRepository) addAttachments(complaint models.Complaint, updateID *int64, uploadedBy int64, attachments []models.Attachment) []models.Attachment {
+	stored := []models.Attachment{}
+	for _, a := range attachments {
+		a.ID = int64(len(r.attachments) + 1)
+		a.ComplaintID = complaint.ID
+		if a.PhotoLatitude != nil && a.PhotoLongitude != nil {
+			distance := haversine(complaint.Latitude, complaint.Longitude, *a.PhotoLatitude, *a.PhotoLongitude)
+			a.PhotoDistance = &distance
+		}
+		a.UpdateID = updateID
+		a.UploadedBy = &uploadedBy
+		a.CreatedAt = time.Now()
+		r.attachments = append(r.attachments, a)
+		stored = append(stored, a)
+	}
+	return stored
+}
+
+// haversine is the great-circle distance in meters between two points,
+// standing in for ST_Distance.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371008.8
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryComplaintRepository) Activity(userID int64, limit int) (models.UserActivity, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	activity := models.UserActivity{
+		UserID:           userID,
+		RecentComplaints: []models.ComplaintSummary{},
+		RecentUpdates:    []models.ComplaintUpdate{},
+	}
+	// Both lists are stored oldest first.
+	for i := len(r.complaints) - 1; i >= 0; i-- {
+		if c := r.complaints[i]; c.UserID == userID {
+			activity.ComplaintsFiled++
+			if len(activity.RecentComplaints) < limit {
+				activity.RecentComplaints = append(activity.RecentComplaints, models.ComplaintSummary{ID: c.ID, Title: c.Title, Status: c.Status, CreatedAt: c.CreatedAt})
+			}
+		}
+	}
+	for i := len(r.updates) - 1; i >= 0; i-- {
+		if u := r.updates[i]; u.UserID == userID {
+			activity.UpdatesPosted++
+			if len(activity.RecentUpdates) < limit {
+				activity.RecentUpdates = append(activity.RecentUpdates, u)
+			}
+		}
+	}
+	return activity, nil
+}
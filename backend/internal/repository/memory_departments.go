@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDepartmentRepository keeps departments and scopes in memory.
+type MemoryDepartmentRepository struct {
+	// CategoryIDs and Districts stand in for the category and
+	// admin_boundaries tables that departments and scopes refer to.
+	CategoryIDs []int
+	Districts   []string
+
+	departments []models.Department
+	scopes      map[int64]models.OfficialScope
+	mutex       sync.Mutex
+}
+
+func NewMemoryDepartmentRepository() *MemoryDepartmentRepository {
+	return &MemoryDepartmentRepository{scopes: map[int64]models.OfficialScope{}}
+}
+
+func (r *MemoryDepartmentRepository) List() ([]models.Department, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	departments := []models.Department{}
+	for _, d := range r.departments {
+		d.CategoryIDs = append([]int{}, d.CategoryIDs...)
+		departments = append(departments, d)
+	}
+	sort.Slice(departments, func(i, j int) bool { return departments[i].Name < departments[j].Name })
+	return departments, nil
+}
+
+// checkCategories returns ErrUnknownReference for a category that does not
+// exist; the caller holds the mutex.
+func (r *MemoryDepartmentRepository) checkCategories(categoryIDs []int) error {
+	for _, id := range categoryIDs {
+		if !containsInt(r.CategoryIDs, id) {
+			return ErrUnknownReference
+		}
+	}
+	return nil
+}
+
+func (r *MemoryDepartmentRepository) Create(department *models.Department) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.checkCategories(department.CategoryIDs); err != nil {
+		return err
+	}
+	department.ID = int64(len(r.departments) + 1)
+	department.CreatedAt = time.Now()
+	stored := *department
+	stored.CategoryIDs = append([]int{}, department.CategoryIDs...)
+	r.departments = append(r.departments, stored)
+	return nil
+}
+
+func (r *MemoryDepartmentRepository) Update(department *models.Department) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, d := range r.departments {
+		if d.ID != department.ID {
+			continue
+		}
+		if err := r.checkCategories(department.CategoryIDs); err != nil {
+			return err
+		}
+		department.CreatedAt = d.CreatedAt
+		stored := *department
+		stored.CategoryIDs = append([]int{}, department.CategoryIDs...)
+		r.departments[i] = stored
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryDepartmentRepository) Delete(id int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, d := range r.departments {
+		if d.ID != id {
+			continue
+		}
+		r.departments = append(r.departments[:i], r.departments[i+1:]...)
+		for userID, scope := range r.scopes {
+			kept := []int64{}
+			for _, departmentID := range scope.DepartmentIDs {
+				if departmentID != id {
+					kept = append(kept, departmentID)
+				}
+			}
+			scope.DepartmentIDs = kept
+			r.scopes[userID] = scope
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryDepartmentRepository) Scope(userID int64) (models.OfficialScope, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	scope := models.OfficialScope{UserID: userID, DepartmentIDs: []int64{}, Districts: []string{}}
+	stored := r.scopes[userID]
+	scope.DepartmentIDs = append(scope.DepartmentIDs, stored.DepartmentIDs...)
+	scope.Districts = append(scope.Districts, stored.Districts...)
+	sort.Slice(scope.DepartmentIDs, func(i, j int) bool { return scope.DepartmentIDs[i] < scope.DepartmentIDs[j] })
+	sort.Strings(scope.Districts)
+	return scope, nil
+}
+
+func (r *MemoryDepartmentRepository) SetScope(scope models.OfficialScope) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, district := range scope.Districts {
+		if !containsString(r.Districts, district) {
+			return ErrUnknownReference
+		}
+	}
+	departmentIDs := []int64{}
+	for _, id := range scope.DepartmentIDs {
+		known := false
+		for _, d := range r.departments {
+			known = known || d.ID == id
+		}
+		if !known {
+			return ErrUnknownReference
+		}
+		departmentIDs = append(departmentIDs, id)
+	}
+	r.scopes[scope.UserID] = models.OfficialScope{
+		UserID:        scope.UserID,
+		DepartmentIDs: departmentIDs,
+		Districts:     append([]string{}, scope.Districts...),
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDigestScheduleRepository keeps digest schedules in memory.
+type MemoryDigestScheduleRepository struct {
+	// Outbox receives the messages passed to Advance.
+	Outbox *MemoryOutboxRepository
+
+	schedules map[int64]models.DigestSchedule
+	mutex     sync.Mutex
+}
+
+func NewMemoryDigestScheduleRepository() *MemoryDigestScheduleRepository {
+	return &MemoryDigestScheduleRepository{Outbox: NewMemoryOutboxRepository(), schedules: map[int64]models.DigestSchedule{}}
+}
+
+func (r *MemoryDigestScheduleRepository) Get(userID int64) (models.DigestSchedule, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	schedule, ok := r.schedules[userID]
+	if !ok {
+		return schedule, ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (r *MemoryDigestScheduleRepository) Save(schedule models.DigestSchedule) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	schedule.LastSentAt = r.schedules[schedule.UserID].LastSentAt
+	schedule.UpdatedAt = time.Now()
+	r.schedules[schedule.UserID] = schedule
+	return nil
+}
+
+func (r *MemoryDigestScheduleRepository) Due(now time.Time, limit int) ([]models.DigestSchedule, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	schedules := []models.DigestSchedule{}
+	for _, s := range r.schedules {
+		if s.Frequency != models.DigestOff && s.NextDueAt != nil && !s.NextDueAt.After(now) {
+			schedules = append(schedules, s)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].NextDueAt.Before(*schedules[j].NextDueAt) })
+	if len(schedules) > limit {
+		schedules = schedules[:limit]
+	}
+	return schedules, nil
+}
+
+func (r *MemoryDigestScheduleRepository) Advance(userID int64, due, next time.Time, messages []models.OutboxMessage) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	schedule, ok := r.schedules[userID]
+	if !ok || schedule.Frequency == models.DigestOff || schedule.NextDueAt == nil || !schedule.NextDueAt.Equal(due) {
+		return ErrConflict
+	}
+	schedule.NextDueAt = &next
+	if len(messages) > 0 {
+		now := time.Now()
+		schedule.LastSentAt = &now
+	}
+	r.schedules[userID] = schedule
+	return r.Outbox.Enqueue(messages)
+}
+
+func (r *MemoryDigestScheduleRepository) Disable(userID int64, due time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	schedule, ok := r.schedules[userID]
+	if !ok || schedule.NextDueAt == nil || !schedule.NextDueAt.Equal(due) {
+		return ErrConflict
+	}
+	schedule.NextDueAt = nil
+	r.schedules[userID] = schedule
+	return nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryEmailTemplateRepository keeps template overrides in memory.
+type MemoryEmailTemplateRepository struct {
+	templates map[[2]string]models.EmailTemplate
+	mutex     sync.Mutex
+}
+
+func NewMemoryEmailTemplateRepository() *MemoryEmailTemplateRepository {
+	return &MemoryEmailTemplateRepository{templates: map[[2]string]models.EmailTemplate{}}
+}
+
+func (r *MemoryEmailTemplateRepository) List() ([]models.EmailTemplate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	templates := []models.EmailTemplate{}
+	for _, t := range r.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Event != templates[j].Event {
+			return templates[i].Event < templates[j].Event
+		}
+		return templates[i].Language < templates[j].Language
+	})
+	return templates, nil
+}
+
+func (r *MemoryEmailTemplateRepository) Get(event, language string) (models.EmailTemplate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t, ok := r.templates[[2]string{event, language}]
+	if !ok {
+		return models.EmailTemplate{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (r *MemoryEmailTemplateRepository) Save(template models.EmailTemplate) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	template.UpdatedAt = &now
+	r.templates[[2]string{template.Event, template.Language}] = template
+	return nil
+}
+
+func (r *MemoryEmailTemplateRepository) Delete(event, language string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := [2]string{event, language}
+	if _, ok := r.templates[key]; !ok {
+		return ErrNotFound
+	}
+	delete(r.templates, key)
+	return nil
+}
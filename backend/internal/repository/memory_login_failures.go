@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryLoginFailureRepository keeps failed login counters in memory.
+type MemoryLoginFailureRepository struct {
+	counters map[string]models.LoginFailures
+	mutex    sync.Mutex
+}
+
+func NewMemoryLoginFailureRepository() *MemoryLoginFailureRepository {
+	return &MemoryLoginFailureRepository{counters: map[string]models.LoginFailures{}}
+}
+
+func (r *MemoryLoginFailureRepository) Update(keys []string, change func(counters []*models.LoginFailures) error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	counters := make([]*models.LoginFailures, 0, len(keys))
+	for _, key := range keys {
+		c, ok := r.counters[key]
+		if !ok {
+			c = models.LoginFailures{Key: key}
+		}
+		counters = append(counters, &c)
+	}
+	if err := change(counters); err != nil {
+		return err
+	}
+	for _, c := range counters {
+		r.counters[c.Key] = *c
+	}
+	return nil
+}
+
+func (r *MemoryLoginFailureRepository) Delete(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.counters, key)
+	return nil
+}
+
+func (r *MemoryLoginFailureRepository) Prune(before time.Time) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	pruned := 0
+	for key, c := range r.counters {
+		if c.LastFailure.Before(before) && c.BlockedUntil.Before(before) {
+			delete(r.counters, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
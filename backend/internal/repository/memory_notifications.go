@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// MemoryNotificationRepository keeps the in-app inbox in memory.
+type MemoryNotificationRepository struct {
+	notifications []models.Notification
+	mutex         sync.Mutex
+}
+
+func NewMemoryNotificationRepository() *MemoryNotificationRepository {
+	return &MemoryNotificationRepository{}
+}
+
+func (r *MemoryNotificationRepository) Create(notification *models.Notification) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if notification.OutboxID != nil {
+		for _, n := range r.notifications {
+			if n.OutboxID != nil && *n.OutboxID == *notification.OutboxID {
+				return nil
+			}
+		}
+	}
+	notification.ID = int64(len(r.notifications) + 1)
+	notification.CreatedAt = time.Now()
+	if len(notification.Data) == 0 {
+		notification.Data = json.RawMessage("{}")
+	}
+	r.notifications = append(r.notifications, *notification)
+	return nil
+}
+
+func (r *MemoryNotificationRepository) List(userID int64, filter NotificationFilter) ([]models.Notification, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	notifications := []models.Notification{}
+	for i := len(r.notifications) - 1; i >= 0; i-- {
+		n := r.notifications[i]
+		if n.UserID != userID || (filter.UnreadOnly && n.ReadAt != nil) {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	if filter.Limit > 0 {
+		if filter.Offset >= len(notifications) {
+			return []models.Notification{}, nil
+		}
+		notifications = notifications[filter.Offset:]
+		if len(notifications) > filter.Limit {
+			notifications = notifications[:filter.Limit]
+		}
+	}
+	return notifications, nil
+}
+
+func (r *MemoryNotificationRepository) CountUnread(userID int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := 0
+	for _, n := range r.notifications {
+		if n.UserID == userID && n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryNotificationRepository) MarkRead(userID, id int64) (models.Notification, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i := range r.notifications {
+		n := &r.notifications[i]
+		if n.ID == id && n.UserID == userID {
+			if n.ReadAt == nil {
+				now := time.Now()
+				n.ReadAt = &now
+			}
+			return *n, nil
+		}
+	}
+	return models.Notification{}, ErrNotFound
+}
+
+func (r *MemoryNotificationRepository) MarkAllRead(userID int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	count := 0
+	for i := range r.notifications {
+		n := &r.notifications[i]
+		if n.UserID == userID && n.ReadAt == nil {
+			n.ReadAt = &now
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryOutboxRepository keeps the outbox in memory.
+type MemoryOutboxRepository struct {
+	messages []models.OutboxMessage
+	mutex    sync.Mutex
+}
+
+func NewMemoryOutboxRepository() *MemoryOutboxRepository {
+	return &MemoryOutboxRepository{}
+}
+
+func (r *MemoryOutboxRepository) Enqueue(messages []models.OutboxMessage) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for _, m := range messages {
+		m.ID = int64(len(r.messages) + 1)
+		if m.Channel == "" {
+			m.Channel = models.ChannelEmail
+		}
+		if m.Language == "" {
+			m.Language = models.DefaultLanguage
+		}
+		if len(m.Payload) == 0 {
+			m.Payload = json.RawMessage("{}")
+		}
+		if m.Status != models.OutboxHeld {
+			m.Status = models.OutboxPending
+		}
+		m.Attempts = 0
+		m.NextAttemptAt = now
+		m.LockedUntil = nil
+		m.LastError = ""
+		m.CreatedAt = now
+		m.SentAt = nil
+		r.messages = append(r.messages, m)
+	}
+	return nil
+}
+
+func (r *MemoryOutboxRepository) ReleaseDigests(build func(held []models.OutboxMessage) models.OutboxMessage) (int, error) {
+	r.mutex.Lock()
+	var recipients []string
+	held := map[string][]models.OutboxMessage{}
+	now := time.Now()
+	for i := range r.messages {
+		m := &r.messages[i]
+		if m.Status != models.OutboxHeld {
+			continue
+		}
+		if _, ok := held[m.Recipient]; !ok {
+			recipients = append(recipients, m.Recipient)
+		}
+		held[m.Recipient] = append(held[m.Recipient], *m)
+		m.Status = models.OutboxDigested
+		m.SentAt = &now
+	}
+	r.mutex.Unlock()
+
+	sort.Strings(recipients)
+	for _, recipient := range recipients {
+		if err := r.Enqueue([]models.OutboxMessage{build(held[recipient])}); err != nil {
+			return 0, err
+		}
+	}
+	return len(recipients), nil
+}
+
+func (r *MemoryOutboxRepository) Claim(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	var due []int
+	for i, m := range r.messages {
+		if (m.Status == models.OutboxPending && !m.NextAttemptAt.After(now)) ||
+			(m.Status == models.OutboxSending && m.LockedUntil != nil && m.LockedUntil.Before(now)) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(a, b int) bool {
+		return r.messages[due[a]].NextAttemptAt.Before(r.messages[due[b]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := []models.OutboxMessage{}
+	lockedUntil := now.Add(lease)
+	for _, i := range due {
+		m := &r.messages[i]
+		m.Status = models.OutboxSending
+		m.Attempts++
+		m.LockedUntil = &lockedUntil
+		claimed = append(claimed, *m)
+	}
+	return claimed, nil
+}
+
+func (r *MemoryOutboxRepository) find(id int64) *models.OutboxMessage {
+	for i := range r.messages {
+		if r.messages[i].ID == id {
+			return &r.messages[i]
+		}
+	}
+	return nil
+}
+
+func (r *MemoryOutboxRepository) MarkSent(id int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := r.find(id)
+	if m == nil {
+		return nil
+	}
+	now := time.Now()
+	m.Status = models.OutboxSent
+	m.SentAt = &now
+	m.LockedUntil = nil
+	m.LastError = ""
+	var payload map[string]interface{}
+	if json.Unmarshal(m.Payload, &payload) == nil {
+		delete(payload, "token")
+		m.Payload, _ = json.Marshal(payload)
+	}
+	return nil
+}
+
+func (r *MemoryOutboxRepository) MarkFailed(id int64, lastError string, retryAt *time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := r.find(id)
+	if m == nil {
+		return nil
+	}
+	m.LockedUntil = nil
+	m.LastError = lastError
+	if retryAt == nil {
+		m.Status = models.OutboxDead
+	} else {
+		m.Status = models.OutboxPending
+		m.NextAttemptAt = *retryAt
+	}
+	return nil
+}
+
+func (r *MemoryOutboxRepository) Get(id int64) (models.OutboxMessage, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := r.find(id)
+	if m == nil {
+		return models.OutboxMessage{}, ErrNotFound
+	}
+	return *m, nil
+}
+
+func (r *MemoryOutboxRepository) List(filter OutboxFilter) ([]models.OutboxMessage, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	messages := []models.OutboxMessage{}
+	for i := len(r.messages) - 1; i >= 0; i-- {
+		m := r.messages[i]
+		switch {
+		case filter.Status != "" && m.Status != filter.Status,
+			filter.Kind != "" && m.Kind != filter.Kind,
+			filter.ComplaintID != 0 && (m.ComplaintID == nil || *m.ComplaintID != filter.ComplaintID):
+			continue
+		}
+		messages = append(messages, m)
+	}
+	if filter.Limit > 0 {
+		if filter.Offset >= len(messages) {
+			return []models.OutboxMessage{}, nil
+		}
+		messages = messages[filter.Offset:]
+		if len(messages) > filter.Limit {
+			messages = messages[:filter.Limit]
+		}
+	}
+	return messages, nil
+}
+
+func (r *MemoryOutboxRepository) Retry(id int64) (models.OutboxMessage, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := r.find(id)
+	if m == nil {
+		return models.OutboxMessage{}, ErrNotFound
+	}
+	if m.Status != models.OutboxPending && m.Status != models.OutboxDead {
+		return *m, ErrConflict
+	}
+	m.Status = models.OutboxPending
+	m.Attempts = 0
+	m.NextAttemptAt = time.Now()
+	m.LockedUntil = nil
+	return *m, nil
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sync"
+	"time"
+)
+
+// MemoryPhoneVerificationRepository keeps pending phone verifications in
+// memory.
+type MemoryPhoneVerificationRepository struct {
+	verifications map[int64]models.PhoneVerification
+	mutex         sync.Mutex
+}
+
+func NewMemoryPhoneVerificationRepository() *MemoryPhoneVerificationRepository {
+	return &MemoryPhoneVerificationRepository{verifications: map[int64]models.PhoneVerification{}}
+}
+
+func (r *MemoryPhoneVerificationRepository) Save(verification *models.PhoneVerification) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	verification.Attempts = 0
+	verification.CreatedAt = time.Now()
+	r.verifications[verification.UserID] = *verification
+	return nil
+}
+
+func (r *MemoryPhoneVerificationRepository) Get(userID int64) (models.PhoneVerification, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	verification, ok := r.verifications[userID]
+	if !ok {
+		return verification, ErrNotFound
+	}
+	return verification, nil
+}
+
+func (r *MemoryPhoneVerificationRepository) RecordAttempt(userID int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	verification, ok := r.verifications[userID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	verification.Attempts++
+	r.verifications[userID] = verification
+	return verification.Attempts, nil
+}
+
+func (r *MemoryPhoneVerificationRepository) Delete(userID int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.verifications, userID)
+	return nil
+}
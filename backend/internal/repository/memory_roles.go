@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+)
+
+// MemoryRoleRepository keeps roles in memory.
+type MemoryRoleRepository struct {
+	roles map[string]models.Role
+	mutex sync.Mutex
+}
+
+// NewMemoryRoleRepository returns a repository holding roles.
+func NewMemoryRoleRepository(roles ...models.Role) *MemoryRoleRepository {
+	r := &MemoryRoleRepository{roles: map[string]models.Role{}}
+	for _, role := range roles {
+		r.roles[role.Name] = copyRole(role)
+	}
+	return r
+}
+
+// copyRole copies role with its permissions in order, so callers cannot
+// change the stored ones.
+func copyRole(role models.Role) models.Role {
+	role.Permissions = append([]string{}, role.Permissions...)
+	sort.Strings(role.Permissions)
+	return role
+}
+
+func (r *MemoryRoleRepository) List() ([]models.Role, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	roles := []models.Role{}
+	for _, role := range r.roles {
+		roles = append(roles, copyRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (r *MemoryRoleRepository) Get(name string) (models.Role, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	role, ok := r.roles[name]
+	if !ok {
+		return models.Role{}, ErrNotFound
+	}
+	return copyRole(role), nil
+}
+
+func (r *MemoryRoleRepository) Create(role models.Role) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.roles[role.Name]; ok {
+		return ErrDuplicate
+	}
+	role.IsSystem = false
+	r.roles[role.Name] = copyRole(role)
+	return nil
+}
+
+func (r *MemoryRoleRepository) Update(role models.Role) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.roles[role.Name]
+	if !ok {
+		return ErrNotFound
+	}
+	role.IsSystem = stored.IsSystem
+	r.roles[role.Name] = copyRole(role)
+	return nil
+}
+
+func (r *MemoryRoleRepository) Delete(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.roles[name]; !ok {
+		return ErrNotFound
+	}
+	delete(r.roles, name)
+	return nil
+}
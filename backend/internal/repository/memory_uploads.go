@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryUploadRepository keeps resumable uploads in memory.
+type MemoryUploadRepository struct {
+	uploads map[string]models.Upload
+	// collecting holds when the lease of each upload being collected ends.
+	collecting map[string]time.Time
+	mutex      sync.Mutex
+}
+
+func NewMemoryUploadRepository() *MemoryUploadRepository {
+	return &MemoryUploadRepository{uploads: map[string]models.Upload{}, collecting: map[string]time.Time{}}
+}
+
+func (r *MemoryUploadRepository) Create(upload models.Upload) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.uploads[upload.ID]; ok {
+		return ErrDuplicate
+	}
+	upload.Offset, upload.PendingSize, upload.CompletedAt, upload.Parts = 0, 0, nil, nil
+	upload.CreatedAt = time.Now()
+	r.uploads[upload.ID] = upload
+	return nil
+}
+
+func (r *MemoryUploadRepository) Get(id string) (models.Upload, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	upload, ok := r.uploads[id]
+	if !ok {
+		return models.Upload{}, ErrNotFound
+	}
+	upload.Parts = append([]models.UploadPart(nil), upload.Parts...)
+	return upload, nil
+}
+
+func (r *MemoryUploadRepository) Advance(upload models.Upload, fromOffset int64, parts []models.UploadPart) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.uploads[upload.ID]
+	if _, collecting := r.collecting[upload.ID]; !ok || collecting || stored.Offset != fromOffset {
+		return ErrConflict
+	}
+	stored.Offset = upload.Offset
+	stored.PendingSize = upload.PendingSize
+	stored.ExpiresAt = upload.ExpiresAt
+	stored.CompletedAt = upload.CompletedAt
+	for _, part := range parts {
+		if part.Number <= len(stored.Parts) {
+			stored.Parts[part.Number-1] = part
+		} else {
+			stored.Parts = append(stored.Parts, part)
+		}
+	}
+	r.uploads[upload.ID] = stored
+	return nil
+}
+
+func (r *MemoryUploadRepository) Delete(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.uploads, id)
+	delete(r.collecting, id)
+	return nil
+}
+
+func (r *MemoryUploadRepository) ClaimExpired(t time.Time, limit int, lease time.Duration) ([]models.Upload, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	uploads := []models.Upload{}
+	for _, upload := range r.uploads {
+		if until, ok := r.collecting[upload.ID]; upload.ExpiresAt.Before(t) && (!ok || until.Before(t)) {
+			uploads = append(uploads, upload)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].ExpiresAt.Before(uploads[j].ExpiresAt) })
+	if len(uploads) > limit {
+		uploads = uploads[:limit]
+	}
+	for _, upload := range uploads {
+		r.collecting[upload.ID] = t.Add(lease)
+	}
+	return uploads, nil
+}
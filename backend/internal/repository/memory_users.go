@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserRepository keeps users in memory.
+type MemoryUserRepository struct {
+	// RolePermissions stands in for role_permissions when UpdateRole guards
+	// against removing the last administrator.
+	RolePermissions map[string][]string
+	// Outbox receives the messages passed to CreateInvited and
+	// RequirePasswordReset.
+	Outbox *MemoryOutboxRepository
+
+	users map[int64]models.User
+	// tokens are the password tokens by hash, recoveryCodes whether each of
+	// a user's recovery codes, by hash, was used, and mfaLogins the nonce
+	// hash of each user's pending 2FA login.
+	tokens        map[string]memoryPasswordToken
+	recoveryCodes map[int64]map[string]bool
+	mfaLogins     map[int64]string
+	mutex         sync.Mutex
+}
+
+type memoryPasswordToken struct {
+	models.PasswordToken
+	userID int64
+	used   bool
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		RolePermissions: map[string][]string{},
+		Outbox:          NewMemoryOutboxRepository(),
+		users:           map[int64]models.User{},
+		tokens:          map[string]memoryPasswordToken{},
+		recoveryCodes:   map[int64]map[string]bool{},
+		mfaLogins:       map[int64]string{},
+	}
+}
+
+func (r *MemoryUserRepository) Create(user *models.User) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return ErrDuplicate
+		}
+	}
+	user.ID = int64(len(r.users) + 1)
+	user.CreatedAt = time.Now()
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	if user.Language == "" {
+		user.Language = models.DefaultLanguage
+	}
+	// New rows get the column defaults.
+	user.IsActive = true
+	user.NotifyChannel = models.NotifyByEmail
+	user.NotifyFrequency = models.NotifyImmediately
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) GetByID(id int64) (models.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	user.TOTPSecret.Valid, user.TOTPSecret.String = false, ""
+	return user, nil
+}
+
+func (r *MemoryUserRepository) GetByEmail(email string) (models.User, error) {
+	r.mutex.Lock()
+	var id int64
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			id = u.ID
+		}
+	}
+	r.mutex.Unlock()
+	return r.GetByID(id)
+}
+
+func (r *MemoryUserRepository) List(filter UserFilter) ([]models.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	users := []models.User{}
+	for _, u := range r.users {
+		if (filter.Role != "" && u.Role != filter.Role) || (filter.ExcludeRole != "" && u.Role == filter.ExcludeRole) {
+			continue
+		}
+		users = append(users, models.User{
+			ID:                    u.ID,
+			Name:                  u.Name,
+			Email:                 u.Email,
+			Role:                  u.Role,
+			IsActive:              u.IsActive,
+			PasswordResetRequired: u.PasswordResetRequired,
+			CreatedAt:             u.CreatedAt,
+			LastLoginAt:           u.LastLoginAt,
+			Language:              u.Language,
+			NotifyChannel:         u.NotifyChannel,
+			NotifyFrequency:       u.NotifyFrequency,
+			Phone:                 u.Phone,
+			PhoneVerified:         u.PhoneVerified,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (r *MemoryUserRepository) RecordLogin(id int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if user, ok := r.users[id]; ok {
+		now := time.Now()
+		user.LastLoginAt = &now
+		r.users[id] = user
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) UpdateNotificationPreferences(id int64, prefs models.NotificationPreferences) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.NotifyChannel, user.NotifyFrequency = prefs.Channel, prefs.Frequency
+	r.users[id] = user
+	return nil
+}
+
+func (r *MemoryUserRepository) SetPhone(id int64, phone string, verified bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Phone, user.PhoneVerified = nil, verified
+	if phone != "" {
+		user.Phone = &phone
+	}
+	if user.NotifyChannel == models.NotifyBySMS && !verified {
+		user.NotifyChannel = models.NotifyByEmail
+	}
+	r.users[id] = user
+	return nil
+}
+
+// change applies change to user id and, if guardPermission is set, undoes it
+// with ErrLastAdmin when no active user holds guardPermission afterwards. The
+// caller holds the mutex.
+func (r *MemoryUserRepository) change(id int64, guardPermission string, change func(user *models.User)) error {
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	previous := user
+	change(&user)
+	r.users[id] = user
+
+	if guardPermission != "" {
+		holders := 0
+		for _, u := range r.users {
+			if u.IsActive && containsString(r.RolePermissions[u.Role], guardPermission) {
+				holders++
+			}
+		}
+		if holders == 0 {
+			r.users[id] = previous
+			return ErrLastAdmin
+		}
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) UpdateRole(id int64, role, guardPermission string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.change(id, guardPermission, func(user *models.User) {
+		user.Role = role
+		user.TokenVersion++
+	})
+}
+
+// Demote is UpdateRole; there are no departments or districts to remove.
+func (r *MemoryUserRepository) Demote(id int64, role, guardPermission string) error {
+	return r.UpdateRole(id, role, guardPermission)
+}
+
+func (r *MemoryUserRepository) SetActive(id int64, active bool, guardPermission string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if active {
+		guardPermission = ""
+	}
+	return r.change(id, guardPermission, func(user *models.User) {
+		user.IsActive = active
+		if !active {
+			user.TokenVersion++
+		}
+	})
+}
+
+// storeToken stores token for userID, using up their older ones; the caller
+// holds the mutex.
+func (r *MemoryUserRepository) storeToken(userID int64, token models.PasswordToken) {
+	for hash, t := range r.tokens {
+		if t.userID == userID {
+			t.used = true
+			r.tokens[hash] = t
+		}
+	}
+	r.tokens[token.Hash] = memoryPasswordToken{PasswordToken: token, userID: userID}
+}
+
+func (r *MemoryUserRepository) CreateInvited(user *models.User, token models.PasswordToken, messages []models.OutboxMessage) error {
+	if err := r.Create(user); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.storeToken(user.ID, token)
+	r.mutex.Unlock()
+	return r.Outbox.Enqueue(messages)
+}
+
+func (r *MemoryUserRepository) RequirePasswordReset(id int64, token models.PasswordToken, messages []models.OutboxMessage) error {
+	r.mutex.Lock()
+	err := r.change(id, "", func(user *models.User) {
+		user.PasswordResetRequired = true
+		user.TokenVersion++
+	})
+	if err == nil {
+		r.storeToken(id, token)
+	}
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.Outbox.Enqueue(messages)
+}
+
+func (r *MemoryUserRepository) RedeemPasswordToken(tokenHash, passwordHash string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.used || !time.Now().Before(token.ExpiresAt) {
+		return "", ErrNotFound
+	}
+	token.used = true
+	r.tokens[tokenHash] = token
+	user := r.users[token.userID]
+	user.PasswordHash = passwordHash
+	user.PasswordResetRequired = false
+	user.TokenVersion++
+	r.users[user.ID] = user
+	return user.Email, nil
+}
+
+func (r *MemoryUserRepository) GetTwoFactor(id int64) (models.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *MemoryUserRepository) StartTOTP(id int64, secret string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.change(id, "", func(user *models.User) {
+		user.TOTPSecret = sql.NullString{String: secret, Valid: true}
+		user.TOTPLastUsedStep = sql.NullInt64{}
+	})
+}
+
+func (r *MemoryUserRepository) UseTOTPStep(id, step int64) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || (user.TOTPLastUsedStep.Valid && user.TOTPLastUsedStep.Int64 >= step) {
+		return false, nil
+	}
+	user.TOTPLastUsedStep = sql.NullInt64{Int64: step, Valid: true}
+	r.users[id] = user
+	return true, nil
+}
+
+func (r *MemoryUserRepository) StartMFALogin(id int64, nonceHash string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	r.mfaLogins[id] = nonceHash
+	return nil
+}
+
+func (r *MemoryUserRepository) UseMFALogin(id int64, nonceHash string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if hash, ok := r.mfaLogins[id]; !ok || hash != nonceHash {
+		return false, nil
+	}
+	delete(r.mfaLogins, id)
+	return true, nil
+}
+
+func (r *MemoryUserRepository) EnableTOTP(id int64, codeHashes []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.change(id, "", func(user *models.User) { user.TOTPEnabled = true })
+	if err == nil {
+		r.replaceRecoveryCodes(id, codeHashes)
+	}
+	return err
+}
+
+func (r *MemoryUserRepository) DisableTOTP(id int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.change(id, "", func(user *models.User) {
+		user.TOTPEnabled = false
+		user.TOTPSecret = sql.NullString{}
+		user.TOTPLastUsedStep = sql.NullInt64{}
+		user.TokenVersion++
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.replaceRecoveryCodes(id, nil)
+	return r.users[id].TokenVersion, nil
+}
+
+// replaceRecoveryCodes stores a user's new recovery codes; the caller holds
+// the mutex.
+func (r *MemoryUserRepository) replaceRecoveryCodes(id int64, codeHashes []string) {
+	codes := map[string]bool{}
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	r.recoveryCodes[id] = codes
+}
+
+func (r *MemoryUserRepository) ReplaceRecoveryCodes(id int64, codeHashes []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	r.replaceRecoveryCodes(id, codeHashes)
+	return nil
+}
+
+func (r *MemoryUserRepository) UseRecoveryCode(id int64, codeHash string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	used, ok := r.recoveryCodes[id][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recoveryCodes[id][codeHash] = true
+	return true, nil
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sync"
+	"time"
+)
+
+// MemoryWebhookRepository keeps webhooks and their delivery log in memory.
+type MemoryWebhookRepository struct {
+	// DistrictOf stands in for admin_boundaries and names the district a
+	// point lies in. If nil, no point lies in any district.
+	DistrictOf func(latitude, longitude float64) string
+
+	webhooks       []models.Webhook
+	deliveries     []models.WebhookDelivery
+	nextID         int64
+	nextDeliveryID int64
+	mutex          sync.Mutex
+}
+
+func NewMemoryWebhookRepository() *MemoryWebhookRepository {
+	return &MemoryWebhookRepository{}
+}
+
+// copyWebhook keeps callers from sharing the stored filter slices.
+func copyWebhook(w models.Webhook) models.Webhook {
+	w.Events = append([]string{}, w.Events...)
+	w.CategoryIDs = append([]int{}, w.CategoryIDs...)
+	w.Districts = append([]string{}, w.Districts...)
+	return w
+}
+
+func (r *MemoryWebhookRepository) Create(webhook *models.Webhook) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nextID++
+	webhook.ID = r.nextID
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	r.webhooks = append(r.webhooks, copyWebhook(*webhook))
+	return nil
+}
+
+func (r *MemoryWebhookRepository) Get(id int64) (models.Webhook, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, w := range r.webhooks {
+		if w.ID == id {
+			return copyWebhook(w), nil
+		}
+	}
+	return models.Webhook{}, ErrNotFound
+}
+
+func (r *MemoryWebhookRepository) List() ([]models.Webhook, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	webhooks := []models.Webhook{}
+	for _, w := range r.webhooks {
+		webhooks = append(webhooks, copyWebhook(w))
+	}
+	return webhooks, nil
+}
+
+func (r *MemoryWebhookRepository) Update(webhook *models.Webhook) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, w := range r.webhooks {
+		if w.ID == webhook.ID {
+			webhook.CreatedBy, webhook.CreatedAt = w.CreatedBy, w.CreatedAt
+			webhook.UpdatedAt = time.Now()
+			r.webhooks[i] = copyWebhook(*webhook)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryWebhookRepository) Delete(id int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, w := range r.webhooks {
+		if w.ID == id {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+			deliveries := r.deliveries[:0]
+			for _, d := range r.deliveries {
+				if d.WebhookID != id {
+					deliveries = append(deliveries, d)
+				}
+			}
+			r.deliveries = deliveries
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryWebhookRepository) Matching(event string, categoryID int, latitude, longitude float64) ([]models.Webhook, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	district := ""
+	if r.DistrictOf != nil {
+		district = r.DistrictOf(latitude, longitude)
+	}
+	matching := []models.Webhook{}
+	for _, w := range r.webhooks {
+		if w.Matches(event, categoryID, district) {
+			matching = append(matching, copyWebhook(w))
+		}
+	}
+	return matching, nil
+}
+
+func (r *MemoryWebhookRepository) RecordDelivery(delivery *models.WebhookDelivery) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nextDeliveryID++
+	delivery.ID = r.nextDeliveryID
+	delivery.CreatedAt = time.Now()
+	r.deliveries = append(r.deliveries, *delivery)
+	return nil
+}
+
+func (r *MemoryWebhookRepository) ListDeliveries(webhookID int64, limit, offset int) ([]models.WebhookDelivery, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		if r.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	if offset >= len(deliveries) {
+		return []models.WebhookDelivery{}, nil
+	}
+	deliveries = deliveries[offset:]
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
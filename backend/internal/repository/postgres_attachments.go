@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const attachmentColumns = `id, complaint_id, update_id, object_key, filename, content_type,
+	size_bytes, checksum_sha256, caption, uploaded_by, created_at,
+	thumbnail_key, web_key, taken_at, photo_latitude, photo_longitude, photo_distance_m`
+
+type PostgresAttachmentRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresAttachmentRepository(db *sqlx.DB) *PostgresAttachmentRepository {
+	return &PostgresAttachmentRepository{DB: db}
+}
+
+// insertAttachments stores attachments for a complaint, or for one of its
+// updates if updateID is set, and returns them as stored. The distance of
+// each photo from the complaint is worked out on the way in.
+func insertAttachments(tx *sqlx.Tx, complaintID int64, updateID *int64, uploadedBy int64, attachments []models.Attachment) ([]models.Attachment, error) {
+	stored := []models.Attachment{}
+	for _, a := range attachments {
+		var row models.Attachment
+		err := tx.QueryRowx(`INSERT INTO attachments
+			(complaint_id, update_id, object_key, filename, content_type, size_bytes, checksum_sha256, caption, uploaded_by,
+			 thumbnail_key, web_key, taken_at, photo_latitude, photo_longitude, photo_distance_m)
+			SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13::float8, $14::float8,
+				ST_Distance(c.location, ST_MakePoint($14::float8, $13::float8)::geography)
+			FROM complaints c WHERE c.id = $1
+			RETURNING `+attachmentColumns,
+			complaintID, updateID, a.ObjectKey, a.Filename, a.ContentType, a.Size, a.Checksum, a.Caption, uploadedBy,
+			a.ThumbnailKey, a.WebKey, a.TakenAt, a.PhotoLatitude, a.PhotoLongitude,
+		).StructScan(&row)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, row)
+	}
+	return stored, nil
+}
+
+func (r *PostgresAttachmentRepository) Get(id int64) (models.Attachment, error) {
+	var attachment models.Attachment
+	err := r.DB.Get(&attachment, `SELECT `+attachmentColumns+` FROM attachments WHERE id=$1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return attachment, ErrNotFound
+	}
+	return attachment, err
+}
+
+func (r *PostgresAttachmentRepository) ListForComplaints(complaintIDs []int64) ([]models.Attachment, error) {
+	attachments := []models.Attachment{}
+	if len(complaintIDs) == 0 {
+		return attachments, nil
+	}
+	err := r.DB.Select(&attachments, `SELECT `+attachmentColumns+` FROM attachments
+		WHERE complaint_id = ANY($1) ORDER BY id`, complaintIDs)
+	return attachments, err
+}
+
+func (r *PostgresAttachmentRepository) Delete(id int64) error {
+	result, err := r.DB.Exec(`DELETE FROM attachments WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
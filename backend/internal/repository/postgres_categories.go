@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"complain/internal/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresCategoryRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresCategoryRepository(db *sqlx.DB) *PostgresCategoryRepository {
+	return &PostgresCategoryRepository{DB: db}
+}
+
+func (r *PostgresCategoryRepository) List() ([]models.Category, error) {
+	categories := []models.Category{}
+	err := r.DB.Select(&categories, `SELECT id, category_name FROM category ORDER BY category_name`)
+	return categories, err
+}
@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// complaintColumns selects a models.Complaint from complaints aliased as c.
+const complaintColumns = `c.id, c.user_id, c.title, c.description,
+	COALESCE(c.catergory_id, 0) AS catergory_id,
+	COALESCE(c.status, 'pending') AS status,
+	c.created_at, c.updated_at,
+	ST_AsText(c.location) AS location,
+	COALESCE(ST_X(c.location::geometry), 0) AS longitude,
+	COALESCE(ST_Y(c.location::geometry), 0) AS latitude,
+	c.is_public, c.assigned_to, c.escalation_level`
+
+type PostgresComplaintRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresComplaintRepository(db *sqlx.DB) *PostgresComplaintRepository {
+	return &PostgresComplaintRepository{DB: db}
+}
+
+// complaintConditions turns filter into SQL conditions on alias c, numbering
+// placeholders from $first.
+func complaintConditions(filter ComplaintFilter, first int) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	next := func(v interface{}) int {
+		args = append(args, v)
+		return first + len(args) - 1
+	}
+
+	if filter.District != "" {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (SELECT 1 FROM admin_boundaries b
+			WHERE b.name_2 = $%d AND ST_Intersects(b.geom, c.location::geometry))`, next(filter.District)))
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, fmt.Sprintf("c.status = $%d", next(filter.Status)))
+	}
+	if filter.UserID != 0 {
+		conditions = append(conditions, fmt.Sprintf("c.user_id = $%d", next(filter.UserID)))
+	}
+	if filter.CategoryID != 0 {
+		conditions = append(conditions, fmt.Sprintf("c.catergory_id = $%d", next(filter.CategoryID)))
+	}
+	if filter.AssignedTo != 0 {
+		conditions = append(conditions, fmt.Sprintf("c.assigned_to = $%d", next(filter.AssignedTo)))
+	}
+	if filter.PublicOnly {
+		conditions = append(conditions, "c.is_public = TRUE")
+	}
+	// An official sees complaints whose category belongs to one of their
+	// departments. If they are also assigned districts, the complaint must lie
+	// inside one of those admin_boundaries as well.
+	if filter.ScopeUserID != 0 {
+		conditions = append(conditions, fmt.Sprintf(`c.catergory_id IN (
+				SELECT dc.category_id FROM department_categories dc
+				JOIN official_departments od ON od.department_id = dc.department_id
+				WHERE od.user_id = $%[1]d)
+			AND (NOT EXISTS (SELECT 1 FROM official_districts WHERE user_id = $%[1]d)
+				OR EXISTS (SELECT 1 FROM official_districts odi
+					JOIN admin_boundaries b ON b.name_2 = odi.district_name
+					WHERE odi.user_id = $%[1]d AND ST_Intersects(b.geom, c.location::geometry)))`, next(filter.ScopeUserID)))
+	}
+	if filter.Open {
+		conditions = append(conditions, "COALESCE(c.status, 'pending') NOT IN ('Resolved', 'Rejected')")
+	}
+	if !filter.FiledAfter.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("c.created_at >= $%d", next(filter.FiledAfter)))
+	}
+	if !filter.FiledBefore.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("c.created_at < $%d", next(filter.FiledBefore)))
+	}
+	if !filter.ReopenedAfter.IsZero() {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (SELECT 1 FROM complaint_updates cu
+			WHERE cu.complaint_id = c.id AND cu.created_at >= $%d
+				AND cu.previous_status IN ('Resolved', 'Rejected') AND cu.status NOT IN ('Resolved', 'Rejected'))`, next(filter.ReopenedAfter)))
+	}
+	return conditions, args
+}
+
+func (r *PostgresComplaintRepository) Create(userID int64, req models.CreateComplaintRequest, status string, attachments []models.Attachment, notifications []models.OutboxMessage) (models.Complaint, error) {
+	var complaint models.Complaint
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return complaint, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO complaints AS c (
+		user_id, title, description, catergory_id, location, is_public, status
+	) VALUES (
+		$1, $2, $3, $4, ST_MakePoint($5, $6)::geography, $7, $8
+	) RETURNING ` + complaintColumns
+	err = tx.QueryRowx(query,
+		userID,
+		req.Title,
+		req.Description,
+		req.Category,
+		req.Longitude,
+		req.Latitude,
+		req.IsPublic,
+		status,
+	).StructScan(&complaint)
+	if err != nil {
+		return complaint, err
+	}
+	complaint.Attachments, err = insertAttachments(tx, complaint.ID, nil, userID, attachments)
+	if err != nil {
+		return complaint, err
+	}
+	for i := range notifications {
+		notifications[i].ComplaintID = &complaint.ID
+	}
+	if err := EnqueueOutbox(tx, notifications); err != nil {
+		return complaint, err
+	}
+	return complaint, tx.Commit()
+}
+
+func (r *PostgresComplaintRepository) Get(id int64, filter ComplaintFilter) (models.Complaint, error) {
+	var complaint models.Complaint
+	conditions, args := complaintConditions(filter, 2)
+	query := `SELECT ` + complaintColumns + ` FROM complaints c WHERE c.id = $1`
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+	err := r.DB.Get(&complaint, query, append([]interface{}{id}, args...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return complaint, ErrNotFound
+	}
+	return complaint, err
+}
+
+func (r *PostgresComplaintRepository) List(filter ComplaintFilter) ([]models.Complaint, error) {
+	complaints := []models.Complaint{}
+	conditions, args := complaintConditions(filter, 1)
+	query := `SELECT ` + complaintColumns + ` FROM complaints c`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY c.created_at DESC"
+	err := r.DB.Select(&complaints, query, args...)
+	return complaints, err
+}
+
+// updateColumns selects a models.ComplaintUpdate from complaint_updates.
+const updateColumns = `id, complaint_id, user_id, comment, visibility, status, previous_status, created_at`
+
+func (r *PostgresComplaintRepository) AddUpdate(complaintID, userID int64, comment, visibility, status string, filter ComplaintFilter, attachments []models.Attachment, notifications []models.OutboxMessage) (models.ComplaintUpdate, string, error) {
+	return r.addUpdate(complaintID, userID, comment, visibility, status, "", filter, attachments, notifications)
+}
+
+func (r *PostgresComplaintRepository) AddEmailedReply(complaintID, userID int64, comment, messageID string, filter ComplaintFilter, notifications []models.OutboxMessage) (models.ComplaintUpdate, error) {
+	update, _, err := r.addUpdate(complaintID, userID, comment, models.VisibilityPublic, "", messageID, filter, nil, notifications)
+	return update, err
+}
+
+// addUpdate is AddUpdate, also recording the Message-ID of the email the
+// update came in unless it is empty.
+func (r *PostgresComplaintRepository) addUpdate(complaintID, userID int64, comment, visibility, status, messageID string, filter ComplaintFilter, attachments []models.Attachment, notifications []models.OutboxMessage) (models.ComplaintUpdate, string, error) {
+	var update models.ComplaintUpdate
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return update, "", err
+	}
+	defer tx.Rollback()
+
+	conditions, args := complaintConditions(filter, 2)
+	query := `SELECT COALESCE(c.status, '') FROM complaints c WHERE c.id = $1`
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+	query += " FOR UPDATE OF c"
+	var previousStatus string
+	err = tx.Get(&previousStatus, query, append([]interface{}{complaintID}, args...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return update, "", ErrNotFound
+		}
+		return update, "", err
+	}
+	if status == "" {
+		status = previousStatus
+	}
+
+	err = tx.QueryRowx(`INSERT INTO complaint_updates (complaint_id, user_id, comment, visibility, status, previous_status, message_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')) RETURNING `+updateColumns,
+		complaintID, userID, comment, visibility, status, previousStatus, messageID).StructScan(&update)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return update, "", ErrDuplicate
+		}
+		return update, "", err
+	}
+	update.Attachments, err = insertAttachments(tx, complaintID, &update.ID, userID, attachments)
+	if err != nil {
+		return update, "", err
+	}
+	if _, err := tx.Exec(`UPDATE complaints SET status=$1, updated_at=NOW() WHERE id=$2`, status, complaintID); err != nil {
+		return update, "", err
+	}
+	for i := range notifications {
+		notifications[i].ComplaintID = &complaintID
+	}
+	if err := EnqueueOutbox(tx, notifications); err != nil {
+		return update, "", err
+	}
+	return update, previousStatus, tx.Commit()
+}
+
+func (r *PostgresComplaintRepository) Assign(complaintID, assigneeID int64, escalate bool, filter ComplaintFilter, notifications []models.OutboxMessage) (models.Complaint, error) {
+	var complaint models.Complaint
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return complaint, err
+	}
+	defer tx.Rollback()
+
+	conditions, args := complaintConditions(filter, 4)
+	query := `UPDATE complaints c SET assigned_to = $2,
+			escalation_level = c.escalation_level + CASE WHEN $3::boolean THEN 1 ELSE 0 END,
+			updated_at = NOW()
+		WHERE c.id = $1`
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+	query += " RETURNING " + complaintColumns
+	err = tx.Get(&complaint, query, append([]interface{}{complaintID, assigneeID, escalate}, args...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return complaint, ErrNotFound
+		}
+		if isForeignKeyViolation(err) {
+			return complaint, ErrUnknownReference
+		}
+		return complaint, err
+	}
+	for i := range notifications {
+		notifications[i].ComplaintID = &complaintID
+	}
+	if err := EnqueueOutbox(tx, notifications); err != nil {
+		return complaint, err
+	}
+	return complaint, tx.Commit()
+}
+
+func (r *PostgresComplaintRepository) ListUpdates(complaintID int64, includeInternal bool) ([]models.ComplaintUpdate, error) {
+	updates := []models.ComplaintUpdate{}
+	query := `SELECT ` + updateColumns + ` FROM complaint_updates WHERE complaint_id = $1`
+	if !includeInternal {
+		query += ` AND visibility = 'public'`
+	}
+	query += ` ORDER BY created_at, id`
+	err := r.DB.Select(&updates, query, complaintID)
+	return updates, err
+}
+
+func (r *PostgresComplaintRepository) Totals(filter ComplaintFilter, since, overdueBefore time.Time) ([]models.ComplaintTotals, error) {
+	totals := []models.ComplaintTotals{}
+	conditions, args := complaintConditions(filter, 3)
+	conditions = append(conditions, "(c.created_at >= $1 OR COALESCE(c.status, 'pending') NOT IN ('Resolved', 'Rejected'))")
+	query := `SELECT COALESCE(d.name_2, '') AS district,
+			COALESCE(c.catergory_id, 0) AS category_id,
+			COALESCE(cat.category_name, '') AS category,
+			COUNT(*) FILTER (WHERE c.created_at >= $1) AS filed,
+			COUNT(*) FILTER (WHERE COALESCE(c.status, 'pending') NOT IN ('Resolved', 'Rejected')) AS open,
+			COUNT(*) FILTER (WHERE COALESCE(c.status, 'pending') NOT IN ('Resolved', 'Rejected')
+				AND c.created_at < $2) AS overdue
+		FROM complaints c
+		LEFT JOIN category cat ON cat.id = c.catergory_id
+		LEFT JOIN LATERAL (SELECT b.name_2 FROM admin_boundaries b
+			WHERE ST_Intersects(b.geom, c.location::geometry) ORDER BY b.gid LIMIT 1) d ON TRUE
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY 1, 2, 3
+		ORDER BY overdue DESC, open DESC, district, category`
+	err := r.DB.Select(&totals, query, append([]interface{}{since, overdueBefore}, args...)...)
+	return totals, err
+}
+
+func (r *PostgresComplaintRepository) Staff(complaintID int64) ([]int64, error) {
+	ids := []int64{}
+	err := r.DB.Select(&ids, `SELECT DISTINCT u.id FROM complaints c
+		JOIN users u ON u.id = c.assigned_to
+			OR u.id IN (SELECT cu.user_id FROM complaint_updates cu WHERE cu.complaint_id = c.id)
+		WHERE c.id = $1 AND u.id <> c.user_id AND u.is_active
+		ORDER BY u.id`, complaintID)
+	if err != nil || len(ids) > 0 {
+		return ids, err
+	}
+	// The same jurisdiction test as ComplaintFilter.ScopeUserID, from the
+	// complaint's side.
+	err = r.DB.Select(&ids, `SELECT DISTINCT u.id FROM complaints c
+		JOIN department_categories dc ON dc.category_id = c.catergory_id
+		JOIN official_departments od ON od.department_id = dc.department_id
+		JOIN users u ON u.id = od.user_id
+		WHERE c.id = $1 AND u.is_active AND u.id <> c.user_id
+			AND (NOT EXISTS (SELECT 1 FROM official_districts WHERE user_id = u.id)
+				OR EXISTS (SELECT 1 FROM official_districts odi
+					JOIN admin_boundaries b ON b.name_2 = odi.district_name
+					WHERE odi.user_id = u.id AND ST_Intersects(b.geom, c.location::geometry)))
+		ORDER BY u.id`, complaintID)
+	return ids, err
+}
+
+func (r *PostgresComplaintRepository) Activity(userID int64, limit int) (models.UserActivity, error) {
+	activity := models.UserActivity{
+		UserID:           userID,
+		RecentComplaints: []models.ComplaintSummary{},
+		RecentUpdates:    []models.ComplaintUpdate{},
+	}
+	if err := r.DB.Get(&activity.ComplaintsFiled, `SELECT COUNT(*) FROM complaints WHERE user_id=$1`, userID); err != nil {
+		return activity, err
+	}
+	if err := r.DB.Get(&activity.UpdatesPosted, `SELECT COUNT(*) FROM complaint_updates WHERE user_id=$1`, userID); err != nil {
+		return activity, err
+	}
+	err := r.DB.Select(&activity.RecentComplaints, `SELECT id, title, status, created_at FROM complaints
+		WHERE user_id=$1 ORDER BY created_at DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return activity, err
+	}
+	err = r.DB.Select(&activity.RecentUpdates, `SELECT `+updateColumns+` FROM complaint_updates
+		WHERE user_id=$1 ORDER BY created_at DESC LIMIT $2`, userID, limit)
+	return activity, err
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresDepartmentRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresDepartmentRepository(db *sqlx.DB) *PostgresDepartmentRepository {
+	return &PostgresDepartmentRepository{DB: db}
+}
+
+func (r *PostgresDepartmentRepository) List() ([]models.Department, error) {
+	departments := []models.Department{}
+	err := r.DB.Select(&departments, `SELECT id, name, description, created_at FROM departments ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []struct {
+		DepartmentID int64 `db:"department_id"`
+		CategoryID   int   `db:"category_id"`
+	}
+	err = r.DB.Select(&links, `SELECT department_id, category_id FROM department_categories ORDER BY category_id`)
+	if err != nil {
+		return nil, err
+	}
+	byDepartment := make(map[int64][]int)
+	for _, l := range links {
+		byDepartment[l.DepartmentID] = append(byDepartment[l.DepartmentID], l.CategoryID)
+	}
+	for i := range departments {
+		departments[i].CategoryIDs = append([]int{}, byDepartment[departments[i].ID]...)
+	}
+	return departments, nil
+}
+
+func setDepartmentCategories(tx *sqlx.Tx, departmentID int64, categoryIDs []int) error {
+	if _, err := tx.Exec(`DELETE FROM department_categories WHERE department_id=$1`, departmentID); err != nil {
+		return err
+	}
+	for _, categoryID := range categoryIDs {
+		_, err := tx.Exec(`INSERT INTO department_categories (department_id, category_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, departmentID, categoryID)
+		if isForeignKeyViolation(err) {
+			return ErrUnknownReference
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresDepartmentRepository) Create(department *models.Department) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowx(`INSERT INTO departments (name, description) VALUES ($1, $2)
+		RETURNING id, created_at`, department.Name, department.Description).Scan(&department.ID, &department.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if err := setDepartmentCategories(tx, department.ID, department.CategoryIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresDepartmentRepository) Update(department *models.Department) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&department.CreatedAt, `UPDATE departments SET name=$1, description=$2 WHERE id=$3
+		RETURNING created_at`, department.Name, department.Description, department.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := setDepartmentCategories(tx, department.ID, department.CategoryIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresDepartmentRepository) Delete(id int64) error {
+	// department_categories and official_departments rows go with it (ON DELETE CASCADE).
+	return execOne(r.DB, `DELETE FROM departments WHERE id=$1`, id)
+}
+
+func (r *PostgresDepartmentRepository) Scope(userID int64) (models.OfficialScope, error) {
+	scope := models.OfficialScope{UserID: userID, DepartmentIDs: []int64{}, Districts: []string{}}
+	err := r.DB.Select(&scope.DepartmentIDs,
+		`SELECT department_id FROM official_departments WHERE user_id=$1 ORDER BY department_id`, userID)
+	if err != nil {
+		return scope, err
+	}
+	err = r.DB.Select(&scope.Districts,
+		`SELECT district_name FROM official_districts WHERE user_id=$1 ORDER BY district_name`, userID)
+	return scope, err
+}
+
+func (r *PostgresDepartmentRepository) SetScope(scope models.OfficialScope) error {
+	if len(scope.Districts) > 0 {
+		var known int
+		err := r.DB.Get(&known, `SELECT COUNT(DISTINCT name_2) FROM admin_boundaries WHERE name_2 = ANY($1)`, scope.Districts)
+		if err != nil {
+			return err
+		}
+		if known != len(scope.Districts) {
+			return ErrUnknownReference
+		}
+	}
+
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM official_departments WHERE user_id=$1`, scope.UserID); err != nil {
+		return err
+	}
+	for _, departmentID := range scope.DepartmentIDs {
+		_, err := tx.Exec(`INSERT INTO official_departments (user_id, department_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, scope.UserID, departmentID)
+		if isForeignKeyViolation(err) {
+			return ErrUnknownReference
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM official_districts WHERE user_id=$1`, scope.UserID); err != nil {
+		return err
+	}
+	for _, district := range scope.Districts {
+		_, err := tx.Exec(`INSERT INTO official_districts (user_id, district_name) VALUES ($1, $2)`, scope.UserID, district)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// digestScheduleColumns selects a models.DigestSchedule.
+const digestScheduleColumns = `user_id, frequency, hour, weekday, timezone, next_due_at, last_sent_at, updated_at`
+
+type PostgresDigestScheduleRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresDigestScheduleRepository(db *sqlx.DB) *PostgresDigestScheduleRepository {
+	return &PostgresDigestScheduleRepository{DB: db}
+}
+
+func (r *PostgresDigestScheduleRepository) Get(userID int64) (models.DigestSchedule, error) {
+	var schedule models.DigestSchedule
+	err := r.DB.Get(&schedule, `SELECT `+digestScheduleColumns+` FROM digest_schedules WHERE user_id=$1`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return schedule, ErrNotFound
+	}
+	return schedule, err
+}
+
+func (r *PostgresDigestScheduleRepository) Save(schedule models.DigestSchedule) error {
+	_, err := r.DB.Exec(`INSERT INTO digest_schedules (user_id, frequency, hour, weekday, timezone, next_due_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET frequency=EXCLUDED.frequency, hour=EXCLUDED.hour,
+			weekday=EXCLUDED.weekday, timezone=EXCLUDED.timezone, next_due_at=EXCLUDED.next_due_at,
+			updated_at=NOW()`,
+		schedule.UserID, schedule.Frequency, schedule.Hour, schedule.Weekday, schedule.Timezone, schedule.NextDueAt)
+	return err
+}
+
+func (r *PostgresDigestScheduleRepository) Due(now time.Time, limit int) ([]models.DigestSchedule, error) {
+	schedules := []models.DigestSchedule{}
+	err := r.DB.Select(&schedules, `SELECT `+digestScheduleColumns+` FROM digest_schedules
+		WHERE frequency <> 'off' AND next_due_at <= $1
+		ORDER BY next_due_at LIMIT $2`, now, limit)
+	return schedules, err
+}
+
+func (r *PostgresDigestScheduleRepository) Advance(userID int64, due, next time.Time, messages []models.OutboxMessage) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE digest_schedules SET next_due_at=$3,
+			last_sent_at = CASE WHEN $4 THEN NOW() ELSE last_sent_at END
+		WHERE user_id=$1 AND next_due_at=$2 AND frequency <> 'off'`,
+		userID, due, next, len(messages) > 0)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrConflict
+	}
+	if err := EnqueueOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresDigestScheduleRepository) Disable(userID int64, due time.Time) error {
+	result, err := r.DB.Exec(`UPDATE digest_schedules SET next_due_at=NULL
+		WHERE user_id=$1 AND next_due_at=$2`, userID, due)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrConflict
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const emailTemplateColumns = `event, language, subject, text_body, html_body, updated_by, updated_at`
+
+type PostgresEmailTemplateRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresEmailTemplateRepository(db *sqlx.DB) *PostgresEmailTemplateRepository {
+	return &PostgresEmailTemplateRepository{DB: db}
+}
+
+func (r *PostgresEmailTemplateRepository) List() ([]models.EmailTemplate, error) {
+	templates := []models.EmailTemplate{}
+	err := r.DB.Select(&templates, `SELECT `+emailTemplateColumns+` FROM email_templates ORDER BY event, language`)
+	return templates, err
+}
+
+func (r *PostgresEmailTemplateRepository) Get(event, language string) (models.EmailTemplate, error) {
+	var template models.EmailTemplate
+	err := r.DB.Get(&template, `SELECT `+emailTemplateColumns+` FROM email_templates WHERE event=$1 AND language=$2`, event, language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return template, ErrNotFound
+	}
+	return template, err
+}
+
+func (r *PostgresEmailTemplateRepository) Save(template models.EmailTemplate) error {
+	_, err := r.DB.Exec(`INSERT INTO email_templates (event, language, subject, text_body, html_body, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (event, language) DO UPDATE SET subject=EXCLUDED.subject, text_body=EXCLUDED.text_body,
+			html_body=EXCLUDED.html_body, updated_by=EXCLUDED.updated_by, updated_at=NOW()`,
+		template.Event, template.Language, template.Subject, template.TextBody, template.HTMLBody, template.UpdatedBy)
+	return err
+}
+
+func (r *PostgresEmailTemplateRepository) Delete(event, language string) error {
+	result, err := r.DB.Exec(`DELETE FROM email_templates WHERE event=$1 AND language=$2`, event, language)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
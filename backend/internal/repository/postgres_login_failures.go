@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresLoginFailureRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresLoginFailureRepository(db *sqlx.DB) *PostgresLoginFailureRepository {
+	return &PostgresLoginFailureRepository{DB: db}
+}
+
+func (r *PostgresLoginFailureRepository) Update(keys []string, change func(counters []*models.LoginFailures) error) error {
+	// Rows are always locked in key order so two logins cannot deadlock.
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO login_failures (key)
+		SELECT k FROM unnest($1::text[]) AS k ORDER BY k
+		ON CONFLICT (key) DO NOTHING`, keys); err != nil {
+		return err
+	}
+	var rows []models.LoginFailures
+	if err := tx.Select(&rows, `SELECT key, failures, last_failure, blocked_until FROM login_failures
+		WHERE key = ANY($1) ORDER BY key FOR UPDATE`, keys); err != nil {
+		return err
+	}
+	counters := make([]*models.LoginFailures, len(rows))
+	for i := range rows {
+		counters[i] = &rows[i]
+	}
+	if err := change(counters); err != nil {
+		return err
+	}
+	for _, c := range counters {
+		if _, err := tx.Exec(`UPDATE login_failures SET failures=$2, last_failure=$3, blocked_until=$4 WHERE key=$1`,
+			c.Key, c.Failures, c.LastFailure, c.BlockedUntil); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresLoginFailureRepository) Delete(key string) error {
+	_, err := r.DB.Exec(`DELETE FROM login_failures WHERE key=$1`, key)
+	return err
+}
+
+func (r *PostgresLoginFailureRepository) Prune(before time.Time) (int, error) {
+	result, err := r.DB.Exec(`DELETE FROM login_failures WHERE last_failure < $1 AND blocked_until < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	return int(rows), err
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const notificationColumns = `id, user_id, kind, complaint_id, summary, data, outbox_id, read_at, created_at`
+
+type PostgresNotificationRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresNotificationRepository(db *sqlx.DB) *PostgresNotificationRepository {
+	return &PostgresNotificationRepository{DB: db}
+}
+
+func (r *PostgresNotificationRepository) Create(notification *models.Notification) error {
+	data := string(notification.Data)
+	if data == "" {
+		data = "{}"
+	}
+	err := r.DB.QueryRowx(`INSERT INTO notifications (user_id, kind, complaint_id, summary, data, outbox_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (outbox_id) DO NOTHING
+		RETURNING id, created_at`,
+		notification.UserID, notification.Kind, notification.ComplaintID, notification.Summary, data, notification.OutboxID).
+		Scan(&notification.ID, &notification.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Already delivered.
+		return nil
+	}
+	return err
+}
+
+func (r *PostgresNotificationRepository) List(userID int64, filter NotificationFilter) ([]models.Notification, error) {
+	notifications := []models.Notification{}
+	query := `SELECT ` + notificationColumns + ` FROM notifications WHERE user_id=$1`
+	if filter.UnreadOnly {
+		query += " AND read_at IS NULL"
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", filter.Limit, filter.Offset)
+	}
+	err := r.DB.Select(&notifications, query, userID)
+	return notifications, err
+}
+
+func (r *PostgresNotificationRepository) CountUnread(userID int64) (int, error) {
+	var count int
+	err := r.DB.Get(&count, `SELECT COUNT(*) FROM notifications WHERE user_id=$1 AND read_at IS NULL`, userID)
+	return count, err
+}
+
+func (r *PostgresNotificationRepository) MarkRead(userID, id int64) (models.Notification, error) {
+	var notification models.Notification
+	err := r.DB.Get(&notification, `UPDATE notifications SET read_at=COALESCE(read_at, NOW())
+		WHERE id=$1 AND user_id=$2 RETURNING `+notificationColumns, id, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notification, ErrNotFound
+	}
+	return notification, err
+}
+
+func (r *PostgresNotificationRepository) MarkAllRead(userID int64) (int, error) {
+	result, err := r.DB.Exec(`UPDATE notifications SET read_at=NOW() WHERE user_id=$1 AND read_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
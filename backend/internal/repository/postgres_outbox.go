@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const outboxColumns = `id, kind, channel, recipient, language, complaint_id, payload, status, attempts,
+	next_attempt_at, locked_until, last_error, created_at, sent_at`
+
+type PostgresOutboxRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresOutboxRepository(db *sqlx.DB) *PostgresOutboxRepository {
+	return &PostgresOutboxRepository{DB: db}
+}
+
+// EnqueueOutbox adds messages to the outbox within tx, so they are only
+// sent if the change they report is committed.
+func EnqueueOutbox(tx sqlx.Execer, messages []models.OutboxMessage) error {
+	for _, m := range messages {
+		channel := m.Channel
+		if channel == "" {
+			channel = models.ChannelEmail
+		}
+		language := m.Language
+		if language == "" {
+			language = models.DefaultLanguage
+		}
+		payload := string(m.Payload)
+		if payload == "" {
+			payload = "{}"
+		}
+		status := models.OutboxPending
+		if m.Status == models.OutboxHeld {
+			status = models.OutboxHeld
+		}
+		_, err := tx.Exec(`INSERT INTO outbox (kind, channel, recipient, language, complaint_id, payload, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			m.Kind, channel, m.Recipient, language, m.ComplaintID, payload, status)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresOutboxRepository) Enqueue(messages []models.OutboxMessage) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := EnqueueOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresOutboxRepository) ReleaseDigests(build func(held []models.OutboxMessage) models.OutboxMessage) (int, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.Get(&locked, `SELECT pg_try_advisory_xact_lock($1)`, DigestLockKey); err != nil {
+		return 0, err
+	}
+	if !locked {
+		// Another process is making the digests right now.
+		return 0, nil
+	}
+	held := []models.OutboxMessage{}
+	err = tx.Select(&held, `SELECT `+outboxColumns+` FROM outbox WHERE status='held'
+		ORDER BY recipient, id FOR UPDATE`)
+	if err != nil {
+		return 0, err
+	}
+
+	digests := 0
+	for start := 0; start < len(held); {
+		end := start + 1
+		for end < len(held) && held[end].Recipient == held[start].Recipient {
+			end++
+		}
+		group := held[start:end]
+		if err := EnqueueOutbox(tx, []models.OutboxMessage{build(group)}); err != nil {
+			return 0, err
+		}
+		ids := make([]int64, len(group))
+		for i, m := range group {
+			ids[i] = m.ID
+		}
+		if _, err := tx.Exec(`UPDATE outbox SET status='digested', sent_at=NOW() WHERE id = ANY($1)`, ids); err != nil {
+			return 0, err
+		}
+		digests++
+		start = end
+	}
+	return digests, tx.Commit()
+}
+
+func (r *PostgresOutboxRepository) Claim(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	messages := []models.OutboxMessage{}
+	// SKIP LOCKED lets any number of workers, in any number of processes,
+	// claim messages without waiting on each other.
+	err := r.DB.Select(&messages, `UPDATE outbox
+		SET status='sending', attempts=attempts+1, locked_until=NOW()+$2::interval
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE (status='pending' AND next_attempt_at <= NOW())
+			   OR (status='sending' AND locked_until < NOW())
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+outboxColumns, limit, fmt.Sprintf("%d milliseconds", lease.Milliseconds()))
+	return messages, err
+}
+
+func (r *PostgresOutboxRepository) MarkSent(id int64) error {
+	_, err := r.DB.Exec(`UPDATE outbox
+		SET status='sent', sent_at=NOW(), locked_until=NULL, last_error='', payload=payload - 'token'
+		WHERE id=$1`, id)
+	return err
+}
+
+func (r *PostgresOutboxRepository) MarkFailed(id int64, lastError string, retryAt *time.Time) error {
+	var err error
+	if retryAt == nil {
+		_, err = r.DB.Exec(`UPDATE outbox SET status='dead', locked_until=NULL, last_error=$2 WHERE id=$1`, id, lastError)
+	} else {
+		_, err = r.DB.Exec(`UPDATE outbox SET status='pending', locked_until=NULL, last_error=$2, next_attempt_at=$3 WHERE id=$1`,
+			id, lastError, *retryAt)
+	}
+	return err
+}
+
+func (r *PostgresOutboxRepository) Get(id int64) (models.OutboxMessage, error) {
+	var message models.OutboxMessage
+	err := r.DB.Get(&message, `SELECT `+outboxColumns+` FROM outbox WHERE id=$1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return message, ErrNotFound
+	}
+	return message, err
+}
+
+func (r *PostgresOutboxRepository) List(filter OutboxFilter) ([]models.OutboxMessage, error) {
+	messages := []models.OutboxMessage{}
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Status != "" {
+		add("status=$%d", filter.Status)
+	}
+	if filter.Kind != "" {
+		add("kind=$%d", filter.Kind)
+	}
+	if filter.ComplaintID != 0 {
+		add("complaint_id=$%d", filter.ComplaintID)
+	}
+	query := `SELECT ` + outboxColumns + ` FROM outbox`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", filter.Limit, filter.Offset)
+	}
+	err := r.DB.Select(&messages, query, args...)
+	return messages, err
+}
+
+func (r *PostgresOutboxRepository) Retry(id int64) (models.OutboxMessage, error) {
+	var message models.OutboxMessage
+	err := r.DB.Get(&message, `UPDATE outbox
+		SET status='pending', attempts=0, next_attempt_at=NOW(), locked_until=NULL
+		WHERE id=$1 AND status IN ('pending', 'dead')
+		RETURNING `+outboxColumns, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := r.Get(id); err != nil {
+			return message, err
+		}
+		return message, ErrConflict
+	}
+	return message, err
+}
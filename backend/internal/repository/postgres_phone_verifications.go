@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresPhoneVerificationRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresPhoneVerificationRepository(db *sqlx.DB) *PostgresPhoneVerificationRepository {
+	return &PostgresPhoneVerificationRepository{DB: db}
+}
+
+func (r *PostgresPhoneVerificationRepository) Save(verification *models.PhoneVerification) error {
+	return r.DB.QueryRowx(`INSERT INTO phone_verifications (user_id, phone, code_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET phone=EXCLUDED.phone, code_hash=EXCLUDED.code_hash,
+			attempts=0, expires_at=EXCLUDED.expires_at, created_at=NOW()
+		RETURNING attempts, created_at`,
+		verification.UserID, verification.Phone, verification.CodeHash, verification.ExpiresAt).
+		Scan(&verification.Attempts, &verification.CreatedAt)
+}
+
+func (r *PostgresPhoneVerificationRepository) Get(userID int64) (models.PhoneVerification, error) {
+	var verification models.PhoneVerification
+	err := r.DB.Get(&verification, `SELECT user_id, phone, code_hash, attempts, expires_at, created_at
+		FROM phone_verifications WHERE user_id=$1`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return verification, ErrNotFound
+	}
+	return verification, err
+}
+
+func (r *PostgresPhoneVerificationRepository) RecordAttempt(userID int64) (int, error) {
+	var attempts int
+	err := r.DB.Get(&attempts, `UPDATE phone_verifications SET attempts=attempts+1
+		WHERE user_id=$1 RETURNING attempts`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return attempts, err
+}
+
+func (r *PostgresPhoneVerificationRepository) Delete(userID int64) error {
+	_, err := r.DB.Exec(`DELETE FROM phone_verifications WHERE user_id=$1`, userID)
+	return err
+}
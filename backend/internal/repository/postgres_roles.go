@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresRoleRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresRoleRepository(db *sqlx.DB) *PostgresRoleRepository {
+	return &PostgresRoleRepository{DB: db}
+}
+
+// permissionsByRole returns the permissions of the given roles, or of every
+// role if names is empty.
+func (r *PostgresRoleRepository) permissionsByRole(names ...string) (map[string][]string, error) {
+	var grants []struct {
+		Role       string `db:"role_name"`
+		Permission string `db:"permission"`
+	}
+	query := `SELECT role_name, permission FROM role_permissions`
+	var args []interface{}
+	if len(names) > 0 {
+		query += ` WHERE role_name = ANY($1)`
+		args = append(args, names)
+	}
+	if err := r.DB.Select(&grants, query+` ORDER BY permission`, args...); err != nil {
+		return nil, err
+	}
+	byRole := make(map[string][]string)
+	for _, g := range grants {
+		byRole[g.Role] = append(byRole[g.Role], g.Permission)
+	}
+	return byRole, nil
+}
+
+func (r *PostgresRoleRepository) List() ([]models.Role, error) {
+	roles := []models.Role{}
+	if err := r.DB.Select(&roles, `SELECT name, description, is_system, requires_mfa FROM roles ORDER BY name`); err != nil {
+		return nil, err
+	}
+	byRole, err := r.permissionsByRole()
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		roles[i].Permissions = append([]string{}, byRole[roles[i].Name]...)
+	}
+	return roles, nil
+}
+
+func (r *PostgresRoleRepository) Get(name string) (models.Role, error) {
+	var role models.Role
+	err := r.DB.Get(&role, `SELECT name, description, is_system, requires_mfa FROM roles WHERE name=$1`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return role, ErrNotFound
+	}
+	if err != nil {
+		return role, err
+	}
+	byRole, err := r.permissionsByRole(name)
+	role.Permissions = append([]string{}, byRole[name]...)
+	return role, err
+}
+
+func setRolePermissions(tx *sqlx.Tx, role string, permissions []string) error {
+	if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_name=$1`, role); err != nil {
+		return err
+	}
+	for _, perm := range permissions {
+		_, err := tx.Exec(`INSERT INTO role_permissions (role_name, permission) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, role, perm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRoleRepository) Create(role models.Role) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execOne(tx, `INSERT INTO roles (name, description, requires_mfa) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING`, role.Name, role.Description, role.RequiresMFA)
+	if errors.Is(err, ErrNotFound) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	if err := setRolePermissions(tx, role.Name, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRoleRepository) Update(role models.Role) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execOne(tx, `UPDATE roles SET description=$1, requires_mfa=$2 WHERE name=$3`, role.Description, role.RequiresMFA, role.Name)
+	if err != nil {
+		return err
+	}
+	if err := setRolePermissions(tx, role.Name, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRoleRepository) Delete(name string) error {
+	// role_permissions rows are removed by ON DELETE CASCADE.
+	return execOne(r.DB, `DELETE FROM roles WHERE name=$1`, name)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const uploadColumns = `id, user_id, filename, content_type, length, upload_offset, object_key,
+	multipart_id, pending_size, completed_at, expires_at, created_at`
+
+type PostgresUploadRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresUploadRepository(db *sqlx.DB) *PostgresUploadRepository {
+	return &PostgresUploadRepository{DB: db}
+}
+
+func (r *PostgresUploadRepository) Create(upload models.Upload) error {
+	_, err := r.DB.NamedExec(`INSERT INTO uploads
+		(id, user_id, filename, content_type, length, object_key, multipart_id, expires_at)
+		VALUES (:id, :user_id, :filename, :content_type, :length, :object_key, :multipart_id, :expires_at)`, upload)
+	return err
+}
+
+func (r *PostgresUploadRepository) Get(id string) (models.Upload, error) {
+	var upload models.Upload
+	err := r.DB.Get(&upload, `SELECT `+uploadColumns+` FROM uploads WHERE id=$1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return upload, ErrNotFound
+	}
+	if err != nil {
+		return upload, err
+	}
+	err = r.DB.Select(&upload.Parts, `SELECT part_number, etag, size_bytes FROM upload_parts
+		WHERE upload_id=$1 ORDER BY part_number`, id)
+	return upload, err
+}
+
+func (r *PostgresUploadRepository) Advance(upload models.Upload, fromOffset int64, parts []models.UploadPart) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE uploads
+		SET upload_offset=$3, pending_size=$4, expires_at=$5, completed_at=$6
+		WHERE id=$1 AND upload_offset=$2 AND collecting_until IS NULL`,
+		upload.ID, fromOffset, upload.Offset, upload.PendingSize, upload.ExpiresAt, upload.CompletedAt)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrConflict
+	}
+	for _, part := range parts {
+		// A part number is reused when a client resumes after a part was
+		// stored but not recorded.
+		_, err := tx.Exec(`INSERT INTO upload_parts (upload_id, part_number, etag, size_bytes)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (upload_id, part_number) DO UPDATE SET etag=EXCLUDED.etag, size_bytes=EXCLUDED.size_bytes`,
+			upload.ID, part.Number, part.ETag, part.Size)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresUploadRepository) Delete(id string) error {
+	_, err := r.DB.Exec(`DELETE FROM uploads WHERE id=$1`, id)
+	return err
+}
+
+func (r *PostgresUploadRepository) ClaimExpired(t time.Time, limit int, lease time.Duration) ([]models.Upload, error) {
+	uploads := []models.Upload{}
+	// As with the outbox, SKIP LOCKED lets every API instance collect
+	// without waiting on the others.
+	err := r.DB.Select(&uploads, `UPDATE uploads
+		SET collecting_until=$1::timestamptz+$3::interval
+		WHERE id IN (
+			SELECT id FROM uploads
+			WHERE expires_at < $1 AND (collecting_until IS NULL OR collecting_until < $1)
+			ORDER BY expires_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+uploadColumns, t, limit, fmt.Sprintf("%d milliseconds", lease.Milliseconds()))
+	return uploads, err
+}
@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+)
+
+// userLoginColumns are the columns GetByID and GetByEmail return.
+const userLoginColumns = `id, name, email, role, password_hash, totp_enabled,
+	is_active, password_reset_required, token_version, created_at, last_login_at, language,
+	notify_channel, notify_frequency, phone, phone_verified`
+
+// userListColumns leave out anything secret.
+const userListColumns = `id, name, email, role, is_active, password_reset_required, created_at, last_login_at, language,
+	notify_channel, notify_frequency, phone, phone_verified`
+
+type PostgresUserRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresUserRepository(db *sqlx.DB) *PostgresUserRepository {
+	return &PostgresUserRepository{DB: db}
+}
+
+// isUniqueViolation reports whether err is PostgreSQL's unique_violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err is PostgreSQL's
+// foreign_key_violation.
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+func (r *PostgresUserRepository) Create(user *models.User) error {
+	if user.Language == "" {
+		user.Language = models.DefaultLanguage
+	}
+	err := r.DB.QueryRowx(`INSERT INTO users (name, email, password_hash, role, language, phone) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, notify_channel, notify_frequency`, user.Name, user.Email, user.PasswordHash, user.Role, user.Language, user.Phone).
+		Scan(&user.ID, &user.CreatedAt, &user.NotifyChannel, &user.NotifyFrequency)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *PostgresUserRepository) get(column string, value interface{}) (models.User, error) {
+	var user models.User
+	err := r.DB.Get(&user, `SELECT `+userLoginColumns+` FROM users WHERE `+column+`=$1`, value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (r *PostgresUserRepository) GetByID(id int64) (models.User, error) {
+	return r.get("id", id)
+}
+
+func (r *PostgresUserRepository) GetByEmail(email string) (models.User, error) {
+	return r.get("email", email)
+}
+
+func (r *PostgresUserRepository) List(filter UserFilter) ([]models.User, error) {
+	users := []models.User{}
+	var conditions []string
+	var args []interface{}
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conditions = append(conditions, fmt.Sprintf("role = $%d", len(args)))
+	}
+	if filter.ExcludeRole != "" {
+		args = append(args, filter.ExcludeRole)
+		conditions = append(conditions, fmt.Sprintf("role != $%d", len(args)))
+	}
+	query := `SELECT ` + userListColumns + ` FROM users`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id"
+	err := r.DB.Select(&users, query, args...)
+	return users, err
+}
+
+func (r *PostgresUserRepository) RecordLogin(id int64) error {
+	_, err := r.DB.Exec(`UPDATE users SET last_login_at=NOW() WHERE id=$1`, id)
+	return err
+}
+
+func (r *PostgresUserRepository) UpdateNotificationPreferences(id int64, prefs models.NotificationPreferences) error {
+	result, err := r.DB.Exec(`UPDATE users SET notify_channel=$2, notify_frequency=$3 WHERE id=$1`, id, prefs.Channel, prefs.Frequency)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) SetPhone(id int64, phone string, verified bool) error {
+	result, err := r.DB.Exec(`UPDATE users SET phone=NULLIF($2, ''), phone_verified=$3,
+			notify_channel=CASE WHEN notify_channel='sms' AND NOT $3 THEN 'email' ELSE notify_channel END
+		WHERE id=$1`, id, phone, verified)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// transact runs change in a transaction. If guardPermission is set it
+// holds the admin change lock and refuses the change with ErrLastAdmin if
+// no active user holds guardPermission afterwards.
+func (r *PostgresUserRepository) transact(guardPermission string, change func(tx *sqlx.Tx) error) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if guardPermission != "" {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, AdminChangeLockKey); err != nil {
+			return err
+		}
+	}
+	if err := change(tx); err != nil {
+		return err
+	}
+	if guardPermission != "" {
+		var holders int
+		err := tx.Get(&holders, `SELECT COUNT(*) FROM users u
+			JOIN role_permissions rp ON rp.role_name = u.role
+			WHERE u.is_active AND rp.permission = $1`, guardPermission)
+		if err != nil {
+			return err
+		}
+		if holders == 0 {
+			return ErrLastAdmin
+		}
+	}
+	return tx.Commit()
+}
+
+// execOne runs a statement that must change exactly the row of one user.
+func execOne(tx sqlx.Execer, query string, args ...interface{}) error {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) UpdateRole(id int64, role, guardPermission string) error {
+	return r.transact(guardPermission, func(tx *sqlx.Tx) error {
+		// Bumping token_version logs the user out so the new role takes effect.
+		return execOne(tx, `UPDATE users SET role=$1, token_version=token_version+1 WHERE id=$2`, role, id)
+	})
+}
+
+func (r *PostgresUserRepository) Demote(id int64, role, guardPermission string) error {
+	return r.transact(guardPermission, func(tx *sqlx.Tx) error {
+		if err := execOne(tx, `UPDATE users SET role=$1, token_version=token_version+1 WHERE id=$2`, role, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM official_departments WHERE user_id=$1`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM official_districts WHERE user_id=$1`, id)
+		return err
+	})
+}
+
+func (r *PostgresUserRepository) SetActive(id int64, active bool, guardPermission string) error {
+	if active {
+		return execOne(r.DB, `UPDATE users SET is_active=TRUE WHERE id=$1`, id)
+	}
+	return r.transact(guardPermission, func(tx *sqlx.Tx) error {
+		return execOne(tx, `UPDATE users SET is_active=FALSE, token_version=token_version+1 WHERE id=$1`, id)
+	})
+}
+
+// storePasswordToken stores token for a user; their older unused tokens stop
+// working.
+func storePasswordToken(tx *sqlx.Tx, userID int64, token models.PasswordToken) error {
+	if _, err := tx.Exec(`UPDATE password_tokens SET used_at=NOW() WHERE user_id=$1 AND used_at IS NULL`, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO password_tokens (user_id, token_hash, purpose, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, token.Hash, token.Purpose, token.ExpiresAt)
+	return err
+}
+
+func (r *PostgresUserRepository) CreateInvited(user *models.User, token models.PasswordToken, messages []models.OutboxMessage) error {
+	if user.Language == "" {
+		user.Language = models.DefaultLanguage
+	}
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowx(`INSERT INTO users (name, email, password_hash, role, language) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, is_active, notify_channel, notify_frequency`, user.Name, user.Email, user.PasswordHash, user.Role, user.Language).
+		Scan(&user.ID, &user.CreatedAt, &user.IsActive, &user.NotifyChannel, &user.NotifyFrequency)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	if err := storePasswordToken(tx, user.ID, token); err != nil {
+		return err
+	}
+	if err := EnqueueOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresUserRepository) RequirePasswordReset(id int64, token models.PasswordToken, messages []models.OutboxMessage) error {
+	return r.transact("", func(tx *sqlx.Tx) error {
+		err := execOne(tx, `UPDATE users SET password_reset_required=TRUE, token_version=token_version+1 WHERE id=$1`, id)
+		if err != nil {
+			return err
+		}
+		if err := storePasswordToken(tx, id, token); err != nil {
+			return err
+		}
+		return EnqueueOutbox(tx, messages)
+	})
+}
+
+func (r *PostgresUserRepository) RedeemPasswordToken(tokenHash, passwordHash string) (string, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.Get(&userID, `UPDATE password_tokens SET used_at=NOW()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	var email string
+	err = tx.Get(&email, `UPDATE users SET password_hash=$1, password_reset_required=FALSE, token_version=token_version+1
+		WHERE id=$2 RETURNING email`, passwordHash, userID)
+	if err != nil {
+		return "", err
+	}
+	return email, tx.Commit()
+}
+
+func (r *PostgresUserRepository) GetTwoFactor(id int64) (models.User, error) {
+	var user models.User
+	err := r.DB.Get(&user, `SELECT `+userLoginColumns+`, totp_secret, totp_last_used_step FROM users WHERE id=$1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (r *PostgresUserRepository) StartTOTP(id int64, secret string) error {
+	return execOne(r.DB, `UPDATE users SET totp_secret=$1, totp_last_used_step=NULL WHERE id=$2`, secret, id)
+}
+
+func (r *PostgresUserRepository) UseTOTPStep(id, step int64) (bool, error) {
+	err := execOne(r.DB, `UPDATE users SET totp_last_used_step=$1
+		WHERE id=$2 AND (totp_last_used_step IS NULL OR totp_last_used_step < $1)`, step, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *PostgresUserRepository) StartMFALogin(id int64, nonceHash string) error {
+	return execOne(r.DB, `UPDATE users SET mfa_login_hash=$1 WHERE id=$2`, nonceHash, id)
+}
+
+func (r *PostgresUserRepository) UseMFALogin(id int64, nonceHash string) (bool, error) {
+	err := execOne(r.DB, `UPDATE users SET mfa_login_hash=NULL WHERE id=$1 AND mfa_login_hash=$2`, id, nonceHash)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// replaceRecoveryCodes invalidates a user's recovery codes and stores new
+// ones.
+func replaceRecoveryCodes(tx *sqlx.Tx, id int64, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id=$1`, id); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, id, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) EnableTOTP(id int64, codeHashes []string) error {
+	return r.transact("", func(tx *sqlx.Tx) error {
+		if err := execOne(tx, `UPDATE users SET totp_enabled=TRUE WHERE id=$1`, id); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, id, codeHashes)
+	})
+}
+
+func (r *PostgresUserRepository) DisableTOTP(id int64) (int, error) {
+	var tokenVersion int
+	err := r.transact("", func(tx *sqlx.Tx) error {
+		err := tx.Get(&tokenVersion, `UPDATE users SET totp_enabled=FALSE, totp_secret=NULL, totp_last_used_step=NULL,
+			token_version=token_version+1 WHERE id=$1 RETURNING token_version`, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, id, nil)
+	})
+	return tokenVersion, err
+}
+
+func (r *PostgresUserRepository) ReplaceRecoveryCodes(id int64, codeHashes []string) error {
+	return r.transact("", func(tx *sqlx.Tx) error {
+		return replaceRecoveryCodes(tx, id, codeHashes)
+	})
+}
+
+func (r *PostgresUserRepository) UseRecoveryCode(id int64, codeHash string) (bool, error) {
+	err := execOne(r.DB, `UPDATE user_recovery_codes SET used_at=NOW()
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, id, codeHash)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
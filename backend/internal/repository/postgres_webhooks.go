@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"complain/internal/models"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const webhookColumns = `id, url, description, secret, is_active, include_private, created_by, created_at, updated_at`
+
+type PostgresWebhookRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresWebhookRepository(db *sqlx.DB) *PostgresWebhookRepository {
+	return &PostgresWebhookRepository{DB: db}
+}
+
+// setWebhookFilters replaces the events, categories and districts of a
+// webhook.
+func setWebhookFilters(tx *sqlx.Tx, webhook *models.Webhook) error {
+	for _, table := range []string{"webhook_events", "webhook_categories", "webhook_districts"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE webhook_id=$1`, webhook.ID); err != nil {
+			return err
+		}
+	}
+	for _, event := range webhook.Events {
+		_, err := tx.Exec(`INSERT INTO webhook_events (webhook_id, event) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, webhook.ID, event)
+		if err != nil {
+			return err
+		}
+	}
+	for _, categoryID := range webhook.CategoryIDs {
+		_, err := tx.Exec(`INSERT INTO webhook_categories (webhook_id, category_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, webhook.ID, categoryID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, district := range webhook.Districts {
+		_, err := tx.Exec(`INSERT INTO webhook_districts (webhook_id, district_name) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, webhook.ID, district)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFilters fills in the events, categories and districts of webhooks.
+func (r *PostgresWebhookRepository) loadFilters(webhooks []models.Webhook) error {
+	byID := make(map[int64]*models.Webhook, len(webhooks))
+	ids := make([]int64, len(webhooks))
+	for i := range webhooks {
+		w := &webhooks[i]
+		w.Events, w.CategoryIDs, w.Districts = []string{}, []int{}, []string{}
+		byID[w.ID] = w
+		ids[i] = w.ID
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var events []struct {
+		WebhookID int64  `db:"webhook_id"`
+		Event     string `db:"event"`
+	}
+	if err := r.DB.Select(&events, `SELECT webhook_id, event FROM webhook_events
+		WHERE webhook_id = ANY($1) ORDER BY event`, ids); err != nil {
+		return err
+	}
+	for _, e := range events {
+		byID[e.WebhookID].Events = append(byID[e.WebhookID].Events, e.Event)
+	}
+
+	var categories []struct {
+		WebhookID  int64 `db:"webhook_id"`
+		CategoryID int   `db:"category_id"`
+	}
+	if err := r.DB.Select(&categories, `SELECT webhook_id, category_id FROM webhook_categories
+		WHERE webhook_id = ANY($1) ORDER BY category_id`, ids); err != nil {
+		return err
+	}
+	for _, c := range categories {
+		byID[c.WebhookID].CategoryIDs = append(byID[c.WebhookID].CategoryIDs, c.CategoryID)
+	}
+
+	var districts []struct {
+		WebhookID int64  `db:"webhook_id"`
+		District  string `db:"district_name"`
+	}
+	if err := r.DB.Select(&districts, `SELECT webhook_id, district_name FROM webhook_districts
+		WHERE webhook_id = ANY($1) ORDER BY district_name`, ids); err != nil {
+		return err
+	}
+	for _, d := range districts {
+		byID[d.WebhookID].Districts = append(byID[d.WebhookID].Districts, d.District)
+	}
+	return nil
+}
+
+func (r *PostgresWebhookRepository) Create(webhook *models.Webhook) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowx(`INSERT INTO webhooks (url, description, secret, is_active, include_private, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`,
+		webhook.URL, webhook.Description, webhook.Secret, webhook.IsActive, webhook.IncludePrivate, webhook.CreatedBy).
+		Scan(&webhook.ID, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := setWebhookFilters(tx, webhook); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresWebhookRepository) Get(id int64) (models.Webhook, error) {
+	var webhook models.Webhook
+	err := r.DB.Get(&webhook, `SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhook, ErrNotFound
+	}
+	if err != nil {
+		return webhook, err
+	}
+	webhooks := []models.Webhook{webhook}
+	err = r.loadFilters(webhooks)
+	return webhooks[0], err
+}
+
+func (r *PostgresWebhookRepository) List() ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+	if err := r.DB.Select(&webhooks, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`); err != nil {
+		return nil, err
+	}
+	return webhooks, r.loadFilters(webhooks)
+}
+
+func (r *PostgresWebhookRepository) Update(webhook *models.Webhook) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowx(`UPDATE webhooks SET url=$1, description=$2, secret=$3, is_active=$4, include_private=$5, updated_at=NOW()
+		WHERE id=$6 RETURNING created_by, created_at, updated_at`,
+		webhook.URL, webhook.Description, webhook.Secret, webhook.IsActive, webhook.IncludePrivate, webhook.ID).
+		Scan(&webhook.CreatedBy, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := setWebhookFilters(tx, webhook); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresWebhookRepository) Delete(id int64) error {
+	// Filters and deliveries go with it (ON DELETE CASCADE).
+	result, err := r.DB.Exec(`DELETE FROM webhooks WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresWebhookRepository) Matching(event string, categoryID int, latitude, longitude float64) ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+	err := r.DB.Select(&webhooks, `SELECT `+webhookColumns+` FROM webhooks w
+		WHERE w.is_active AND EXISTS (SELECT 1 FROM webhook_events e WHERE e.webhook_id = w.id AND e.event = $1)
+		ORDER BY w.id`, event)
+	if err != nil || len(webhooks) == 0 {
+		return webhooks, err
+	}
+	if err := r.loadFilters(webhooks); err != nil {
+		return nil, err
+	}
+
+	// Only look the district up if some webhook filters by it.
+	district := ""
+	for _, w := range webhooks {
+		if len(w.Districts) > 0 {
+			err := r.DB.Get(&district, `SELECT name_2 FROM admin_boundaries
+				WHERE ST_Intersects(geom, ST_SetSRID(ST_MakePoint($1, $2), 4326)) LIMIT 1`, longitude, latitude)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	matching := []models.Webhook{}
+	for _, w := range webhooks {
+		if w.Matches(event, categoryID, district) {
+			matching = append(matching, w)
+		}
+	}
+	return matching, nil
+}
+
+func (r *PostgresWebhookRepository) RecordDelivery(delivery *models.WebhookDelivery) error {
+	return r.DB.QueryRowx(`INSERT INTO webhook_deliveries
+		(webhook_id, outbox_id, event, attempt, response_status, response_body, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		delivery.WebhookID, delivery.OutboxID, delivery.Event, delivery.Attempt, delivery.ResponseStatus,
+		delivery.ResponseBody, delivery.Error, delivery.DurationMS).
+		Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+func (r *PostgresWebhookRepository) ListDeliveries(webhookID int64, limit, offset int) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	err := r.DB.Select(&deliveries, `SELECT id, webhook_id, outbox_id, event, attempt, response_status,
+			response_body, error, duration_ms, created_at
+		FROM webhook_deliveries WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2 OFFSET $3`, webhookID, limit, offset)
+	return deliveries, err
+}
@@ -0,0 +1,414 @@
+// Package repository keeps the SQL for complaints, users and categories out
+// of the handlers. Every repository has a Postgres implementation used by the
+// API and an in-memory one for exercising handler logic without a database.
+package repository
+
+import (
+	"complain/internal/models"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist or lies
+	// outside the caller's scope.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when a unique value, such as an email, is taken.
+	ErrDuplicate = errors.New("already exists")
+	// ErrLastAdmin is returned when a change would leave no active user
+	// holding the guarded permission.
+	ErrLastAdmin = errors.New("cannot remove the last administrator")
+	// ErrConflict is returned when a row changed since it was read.
+	ErrConflict = errors.New("changed concurrently")
+	// ErrUnknownReference is returned when a row refers to another that
+	// does not exist, such as a department to a missing category.
+	ErrUnknownReference = errors.New("refers to a missing row")
+)
+
+// AdminChangeLockKey is the advisory lock taken by every change that could
+// leave the system without an administrator, so two admins cannot remove
+// each other at the same time.
+const AdminChangeLockKey = 7301
+
+// DigestLockKey is the advisory lock held while daily digests are made, so
+// each process's digest job does not split a recipient's notifications.
+const DigestLockKey = 7304
+
+// ComplaintFilter narrows complaint listings. Zero values do not filter.
+type ComplaintFilter struct {
+	UserID     int64
+	Status     string
+	CategoryID int
+	// District is an admin_boundaries name_2 the complaint must lie in.
+	District string
+	// PublicOnly hides complaints that are not marked public.
+	PublicOnly bool
+	// AssignedTo keeps complaints assigned to that official.
+	AssignedTo int64
+	// ScopeUserID limits results to that official's jurisdiction: categories
+	// of their departments and, if they have any, their districts.
+	ScopeUserID int64
+	// Open leaves out resolved and rejected complaints.
+	Open bool
+	// FiledAfter and FiledBefore bound when the complaint was filed.
+	FiledAfter  time.Time
+	FiledBefore time.Time
+	// ReopenedAfter keeps complaints an update moved out of resolved or
+	// rejected since then.
+	ReopenedAfter time.Time
+}
+
+// UserFilter narrows user listings. Zero values do not filter.
+type UserFilter struct {
+	Role        string
+	ExcludeRole string
+}
+
+// OutboxFilter narrows outbox listings. Zero values do not filter.
+type OutboxFilter struct {
+	Status      string
+	Kind        string
+	ComplaintID int64
+	Limit       int
+	Offset      int
+}
+
+type ComplaintRepository interface {
+	// Create stores a new complaint filed by userID together with its
+	// attachments and returns it as stored. The notifications about it are
+	// added to the outbox in the same transaction, with their ComplaintID set.
+	Create(userID int64, req models.CreateComplaintRequest, status string, attachments []models.Attachment, notifications []models.OutboxMessage) (models.Complaint, error)
+	// Get returns one complaint, or ErrNotFound if it does not match filter.
+	Get(id int64, filter ComplaintFilter) (models.Complaint, error)
+	// List returns matching complaints, newest first.
+	List(filter ComplaintFilter) ([]models.Complaint, error)
+	// AddUpdate records a comment of the given visibility with its
+	// attachments and sets the complaint's status, unless status is empty,
+	// in one step, queueing the notifications about it with their
+	// ComplaintID set. It returns the update and the status before the
+	// change, or ErrNotFound if the complaint does not match filter.
+	AddUpdate(complaintID, userID int64, comment, visibility, status string, filter ComplaintFilter, attachments []models.Attachment, notifications []models.OutboxMessage) (models.ComplaintUpdate, string, error)
+	// AddEmailedReply records a reply the owner emailed as a public comment
+	// by userID, like AddUpdate leaving the status as it is. messageID is
+	// the email's Message-ID; ErrDuplicate is returned if a reply with the
+	// same one was added before.
+	AddEmailedReply(complaintID, userID int64, comment, messageID string, filter ComplaintFilter, notifications []models.OutboxMessage) (models.ComplaintUpdate, error)
+	// Assign makes assigneeID the official working on a complaint, raising
+	// its escalation level if escalate, and queues the notifications about
+	// it with their ComplaintID set. It returns the complaint as changed,
+	// ErrNotFound if it does not match filter, or ErrUnknownReference if
+	// there is no such user.
+	Assign(complaintID, assigneeID int64, escalate bool, filter ComplaintFilter, notifications []models.OutboxMessage) (models.Complaint, error)
+	// ListUpdates returns a complaint's updates, oldest first, without
+	// their attachments. Internal notes are left out unless includeInternal.
+	ListUpdates(complaintID int64, includeInternal bool) ([]models.ComplaintUpdate, error)
+	// Totals counts matching complaints by district and category: those
+	// filed since since, those open, and those open and filed before
+	// overdueBefore. Groups with nothing to count are left out.
+	Totals(filter ComplaintFilter, since, overdueBefore time.Time) ([]models.ComplaintTotals, error)
+	// Staff returns the IDs of the active staff following a complaint: those
+	// other than its owner who are assigned to it or have posted on it or,
+	// while nobody is or has, the officials whose jurisdiction covers it.
+	Staff(complaintID int64) ([]int64, error)
+	// Activity counts the complaints userID filed and the updates they
+	// posted, and lists the latest limit of each, newest first. The account
+	// fields of the result are left to the caller.
+	Activity(userID int64, limit int) (models.UserActivity, error)
+}
+
+// DigestScheduleRepository keeps when staff get their digest emails.
+type DigestScheduleRepository interface {
+	// Get returns a user's schedule, or ErrNotFound if they never set one.
+	Get(userID int64) (models.DigestSchedule, error)
+	// Save creates or replaces a user's schedule.
+	Save(schedule models.DigestSchedule) error
+	// Due returns up to limit schedules whose next digest is due at now,
+	// most overdue first.
+	Due(now time.Time, limit int) ([]models.DigestSchedule, error)
+	// Advance moves a user's schedule from due to next and queues messages,
+	// recording the time they were sent if there are any. It returns
+	// ErrConflict if the schedule is no longer due at due, because another
+	// process got there first or the user changed it.
+	Advance(userID int64, due, next time.Time, messages []models.OutboxMessage) error
+	// Disable stops a schedule due at due from falling due again until the
+	// user saves it anew. It returns ErrConflict if the schedule is no
+	// longer due at due.
+	Disable(userID int64, due time.Time) error
+}
+
+// UploadRepository keeps track of resumable uploads.
+type UploadRepository interface {
+	Create(upload models.Upload) error
+	// Get returns an upload with its parts.
+	Get(id string) (models.Upload, error)
+	// Advance saves the offset, pending size, expiry and completion of
+	// upload and adds parts, provided its offset is still fromOffset and it
+	// is not being collected. Otherwise it returns ErrConflict.
+	Advance(upload models.Upload, fromOffset int64, parts []models.UploadPart) error
+	Delete(id string) error
+	// ClaimExpired leases up to limit uploads that expired before t, and
+	// are not leased already, to be collected until lease has passed.
+	ClaimExpired(t time.Time, limit int, lease time.Duration) ([]models.Upload, error)
+}
+
+// OutboxRepository queues notifications for delivery and tracks their
+// attempts.
+type OutboxRepository interface {
+	// Enqueue adds messages to the outbox, due at once, or held for the
+	// recipient's digest if their Status is models.OutboxHeld.
+	Enqueue(messages []models.OutboxMessage) error
+	// ReleaseDigests gathers each recipient's held messages, oldest first,
+	// and enqueues the digest build makes of them in their place, marking
+	// them digested. It returns the number of digests enqueued.
+	ReleaseDigests(build func(held []models.OutboxMessage) models.OutboxMessage) (int, error)
+	// Claim leases up to limit due messages until lease has passed and counts
+	// an attempt on each. Messages whose lease ran out without a result are
+	// due again.
+	Claim(limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	// MarkSent records a delivered message. One-time tokens in its payload
+	// are not kept.
+	MarkSent(id int64) error
+	// MarkFailed records a failed attempt. The message is due again at
+	// retryAt, or dead if retryAt is nil.
+	MarkFailed(id int64, lastError string, retryAt *time.Time) error
+	Get(id int64) (models.OutboxMessage, error)
+	// List returns matching messages, newest first.
+	List(filter OutboxFilter) ([]models.OutboxMessage, error)
+	// Retry makes a dead or pending message due now with its attempts
+	// reset. It returns ErrConflict for messages being or already delivered.
+	Retry(id int64) (models.OutboxMessage, error)
+}
+
+// NotificationFilter narrows a user's notification listing.
+type NotificationFilter struct {
+	UnreadOnly bool
+	Limit      int
+	Offset     int
+}
+
+// NotificationRepository is the in-app inbox.
+type NotificationRepository interface {
+	// Create adds a notification and sets its ID and CreatedAt. A second
+	// notification from the same outbox message is ignored.
+	Create(notification *models.Notification) error
+	// List returns a user's notifications, newest first.
+	List(userID int64, filter NotificationFilter) ([]models.Notification, error)
+	CountUnread(userID int64) (int, error)
+	// MarkRead marks one of the user's notifications read. It returns
+	// ErrNotFound if the user has no such notification.
+	MarkRead(userID, id int64) (models.Notification, error)
+	// MarkAllRead marks all of the user's notifications read and returns how
+	// many were unread.
+	MarkAllRead(userID int64) (int, error)
+}
+
+// WebhookRepository stores webhook subscriptions and the log of their
+// deliveries.
+type WebhookRepository interface {
+	// Create stores webhook with its filters and sets its ID and timestamps.
+	Create(webhook *models.Webhook) error
+	Get(id int64) (models.Webhook, error)
+	// List returns every webhook, oldest first.
+	List() ([]models.Webhook, error)
+	// Update replaces a webhook's settings, filters and secret.
+	Update(webhook *models.Webhook) error
+	// Delete removes a webhook and its delivery log.
+	Delete(id int64) error
+	// Matching returns the active webhooks that want event about a complaint
+	// in categoryID at the given point.
+	Matching(event string, categoryID int, latitude, longitude float64) ([]models.Webhook, error)
+	// RecordDelivery logs an attempt and sets its ID and CreatedAt.
+	RecordDelivery(delivery *models.WebhookDelivery) error
+	// ListDeliveries returns a webhook's delivery log, newest first.
+	ListDeliveries(webhookID int64, limit, offset int) ([]models.WebhookDelivery, error)
+}
+
+// EmailTemplateRepository stores the templates admins have overridden.
+type EmailTemplateRepository interface {
+	// List returns every override, by event and language.
+	List() ([]models.EmailTemplate, error)
+	Get(event, language string) (models.EmailTemplate, error)
+	// Save creates or replaces the override for the template's event and
+	// language.
+	Save(template models.EmailTemplate) error
+	// Delete removes an override, restoring the built-in template.
+	Delete(event, language string) error
+}
+
+// AttachmentRepository reads and removes attachments. They are created with
+// the complaint or update they belong to.
+type AttachmentRepository interface {
+	Get(id int64) (models.Attachment, error)
+	// ListForComplaints returns the attachments of the complaints and of
+	// their updates, oldest first.
+	ListForComplaints(complaintIDs []int64) ([]models.Attachment, error)
+	Delete(id int64) error
+}
+
+type UserRepository interface {
+	// Create stores user and sets its ID and CreatedAt.
+	Create(user *models.User) error
+	// GetByID and GetByEmail return everything needed to log a user in,
+	// except the TOTP secret.
+	GetByID(id int64) (models.User, error)
+	GetByEmail(email string) (models.User, error)
+	// List returns users without credentials, oldest first.
+	List(filter UserFilter) ([]models.User, error)
+	// RecordLogin stores the time of a successful login.
+	RecordLogin(id int64) error
+	// UpdateNotificationPreferences sets how the user hears about their
+	// complaints.
+	UpdateNotificationPreferences(id int64, prefs models.NotificationPreferences) error
+	// SetPhone sets the user's phone number, "" for none. A user who chose
+	// SMS notifications goes back to email if the number is not verified.
+	SetPhone(id int64, phone string, verified bool) error
+	// UpdateRole changes a user's role and revokes their tokens. If
+	// guardPermission is set and no active user would hold it afterwards, the
+	// change is refused with ErrLastAdmin.
+	UpdateRole(id int64, role, guardPermission string) error
+	// Demote is UpdateRole that also removes the user's departments and
+	// districts.
+	Demote(id int64, role, guardPermission string) error
+	// SetActive activates or deactivates a user. Deactivating revokes their
+	// tokens and is guarded like UpdateRole.
+	SetActive(id int64, active bool, guardPermission string) error
+
+	// CreateInvited stores user with an invite token and queues messages in
+	// one step, setting its ID and CreatedAt.
+	CreateInvited(user *models.User, token models.PasswordToken, messages []models.OutboxMessage) error
+	// RequirePasswordReset revokes a user's tokens, makes them choose a new
+	// password before they can log in, stores the reset token and queues
+	// messages in one step.
+	RequirePasswordReset(id int64, token models.PasswordToken, messages []models.OutboxMessage) error
+	// RedeemPasswordToken sets the password of the user an unused, unexpired
+	// token was issued to and revokes their tokens. Older tokens of a user
+	// stop working when a new one is stored. It returns the user's email, or
+	// ErrNotFound if the token is not valid.
+	RedeemPasswordToken(tokenHash, passwordHash string) (string, error)
+
+	// GetTwoFactor is GetByID with the TOTP secret and last used step.
+	GetTwoFactor(id int64) (models.User, error)
+	// StartTOTP stores a new secret for a user enrolling in 2FA.
+	StartTOTP(id int64, secret string) error
+	// UseTOTPStep records that a code of step was used. It returns false if
+	// that step or a later one was used before, so codes cannot be replayed.
+	UseTOTPStep(id, step int64) (bool, error)
+	// StartMFALogin stores the nonce hash of the token a user exchanges for
+	// a session with their second factor, replacing any earlier one.
+	StartMFALogin(id int64, nonceHash string) error
+	// UseMFALogin forgets the user's nonce hash if it is nonceHash. It
+	// returns false if it is not, so each token can complete one login.
+	UseMFALogin(id int64, nonceHash string) (bool, error)
+	// EnableTOTP turns 2FA on and replaces the recovery codes in one step.
+	EnableTOTP(id int64, codeHashes []string) error
+	// DisableTOTP turns 2FA off, forgets the secret and recovery codes and
+	// revokes the user's tokens. It returns the new token version.
+	DisableTOTP(id int64) (int, error)
+	// ReplaceRecoveryCodes invalidates a user's recovery codes and stores
+	// new ones.
+	ReplaceRecoveryCodes(id int64, codeHashes []string) error
+	// UseRecoveryCode marks the unused recovery code with codeHash used. It
+	// returns false if there is none.
+	UseRecoveryCode(id int64, codeHash string) (bool, error)
+}
+
+// RoleRepository stores the roles and the permissions they grant.
+type RoleRepository interface {
+	// List returns every role with its permissions, by name.
+	List() ([]models.Role, error)
+	// Get returns a role with its permissions.
+	Get(name string) (models.Role, error)
+	// Create stores a new role, or returns ErrDuplicate if the name is taken.
+	Create(role models.Role) error
+	// Update replaces the description, 2FA policy and permissions of a role.
+	Update(role models.Role) error
+	// Delete removes a role and its permissions.
+	Delete(name string) error
+}
+
+// DepartmentRepository stores departments and the jurisdiction of each
+// official.
+type DepartmentRepository interface {
+	// List returns every department with its categories, by name.
+	List() ([]models.Department, error)
+	// Create stores department with its categories and sets its ID and
+	// CreatedAt. It returns ErrUnknownReference for a category that does
+	// not exist.
+	Create(department *models.Department) error
+	// Update replaces a department's name, description and categories, and
+	// sets its CreatedAt.
+	Update(department *models.Department) error
+	// Delete removes a department; officials lose it from their scope.
+	Delete(id int64) error
+	// Scope returns the departments and districts of an official.
+	Scope(userID int64) (models.OfficialScope, error)
+	// SetScope replaces an official's departments and districts, which must
+	// not repeat. It returns ErrUnknownReference for a department or
+	// district that does not exist.
+	SetScope(scope models.OfficialScope) error
+}
+
+// PhoneVerificationRepository keeps the codes texted to verify phone
+// numbers, one pending per user.
+type PhoneVerificationRepository interface {
+	// Save replaces the user's pending verification and sets CreatedAt.
+	Save(verification *models.PhoneVerification) error
+	Get(userID int64) (models.PhoneVerification, error)
+	// RecordAttempt counts a guess at the code and returns how many there
+	// have been.
+	RecordAttempt(userID int64) (int, error)
+	Delete(userID int64) error
+}
+
+// LoginFailureRepository keeps the failed login counters of accounts and
+// client addresses, shared by every API process.
+type LoginFailureRepository interface {
+	// Update loads the counters for keys, zero for unknown keys, lets change
+	// modify them and saves them. Concurrent Updates of the same keys run one
+	// after another. Nothing is saved if change returns an error.
+	Update(keys []string, change func(counters []*models.LoginFailures) error) error
+	// Delete forgets a key's failures and any lockout.
+	Delete(key string) error
+	// Prune deletes the counters that neither failed nor were blocked since
+	// before, and returns how many there were.
+	Prune(before time.Time) (int, error)
+}
+
+type CategoryRepository interface {
+	// List returns all categories ordered by name.
+	List() ([]models.Category, error)
+}
+
+// Compile-time checks that both implementations satisfy the interfaces.
+var (
+	_ ComplaintRepository  = (*PostgresComplaintRepository)(nil)
+	_ ComplaintRepository  = (*MemoryComplaintRepository)(nil)
+	_ UserRepository       = (*PostgresUserRepository)(nil)
+	_ UserRepository       = (*MemoryUserRepository)(nil)
+	_ CategoryRepository   = (*PostgresCategoryRepository)(nil)
+	_ CategoryRepository   = (*MemoryCategoryRepository)(nil)
+	_ UploadRepository     = (*PostgresUploadRepository)(nil)
+	_ UploadRepository     = (*MemoryUploadRepository)(nil)
+	_ AttachmentRepository = (*PostgresAttachmentRepository)(nil)
+	_ AttachmentRepository = (*MemoryAttachmentRepository)(nil)
+	_ OutboxRepository     = (*PostgresOutboxRepository)(nil)
+	_ OutboxRepository     = (*MemoryOutboxRepository)(nil)
+
+	_ EmailTemplateRepository = (*PostgresEmailTemplateRepository)(nil)
+	_ EmailTemplateRepository = (*MemoryEmailTemplateRepository)(nil)
+	_ NotificationRepository  = (*PostgresNotificationRepository)(nil)
+	_ NotificationRepository  = (*MemoryNotificationRepository)(nil)
+	_ WebhookRepository       = (*PostgresWebhookRepository)(nil)
+	_ WebhookRepository       = (*MemoryWebhookRepository)(nil)
+
+	_ PhoneVerificationRepository = (*PostgresPhoneVerificationRepository)(nil)
+	_ PhoneVerificationRepository = (*MemoryPhoneVerificationRepository)(nil)
+	_ DigestScheduleRepository    = (*PostgresDigestScheduleRepository)(nil)
+	_ DigestScheduleRepository    = (*MemoryDigestScheduleRepository)(nil)
+	_ LoginFailureRepository      = (*PostgresLoginFailureRepository)(nil)
+	_ LoginFailureRepository      = (*MemoryLoginFailureRepository)(nil)
+	_ RoleRepository              = (*PostgresRoleRepository)(nil)
+	_ RoleRepository              = (*MemoryRoleRepository)(nil)
+	_ DepartmentRepository        = (*PostgresDepartmentRepository)(nil)
+	_ DepartmentRepository        = (*MemoryDepartmentRepository)(nil)
+)
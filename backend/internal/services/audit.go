@@ -0,0 +1,208 @@
+package services
+
+import (
+	"complain/internal/models"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// auditChainLockKey serialises writers so every entry links to its
+// predecessor. It must differ from the keys in the repository package.
+const auditChainLockKey = 7302
+
+// AuditLog writes and reads the hash-chained audit log. The table itself
+// rejects UPDATE, DELETE and TRUNCATE; the chain makes any tampering done
+// around that (e.g. by a superuser) detectable with Verify.
+type AuditLog struct {
+	DB *sqlx.DB
+}
+
+func NewAuditLog(db *sqlx.DB) *AuditLog {
+	return &AuditLog{DB: db}
+}
+
+// hashAuditEntry computes the chain hash of an entry from all of its fields
+// except ID and Hash.
+func hashAuditEntry(e *models.AuditEntry) string {
+	var actor string
+	if e.ActorID != nil {
+		actor = fmt.Sprint(*e.ActorID)
+	}
+	fields := []string{
+		e.PrevHash,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		actor,
+		e.ActorRole,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		string(e.Before),
+		string(e.After),
+		fmt.Sprint(e.Status),
+		e.IP,
+		e.RequestID,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MarshalAuditState encodes a before/after snapshot, using JSON null for nil.
+func MarshalAuditState(v interface{}) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+// Record appends an entry to the log, filling in its time and hashes.
+func (l *AuditLog) Record(e *models.AuditEntry) error {
+	// Postgres keeps microseconds; truncate so the stored value hashes the same.
+	e.OccurredAt = time.Now().UTC().Truncate(time.Microsecond)
+	if e.Before == nil {
+		e.Before = json.RawMessage("null")
+	}
+	if e.After == nil {
+		e.After = json.RawMessage("null")
+	}
+
+	tx, err := l.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, auditChainLockKey); err != nil {
+		return err
+	}
+	err = tx.Get(&e.PrevHash, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	e.Hash = hashAuditEntry(e)
+
+	query := `INSERT INTO audit_log (
+		occurred_at, actor_id, actor_role, action, target_type, target_id,
+		before_state, after_state, status, ip, request_id, prev_hash, hash
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`
+	err = tx.QueryRow(query,
+		e.OccurredAt, e.ActorID, e.ActorRole, e.Action, e.TargetType, e.TargetID,
+		string(e.Before), string(e.After), e.Status, e.IP, e.RequestID, e.PrevHash, e.Hash,
+	).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditEntryColumns selects a models.AuditEntry from audit_log.
+const auditEntryColumns = `id, occurred_at, actor_id, actor_role, action, target_type, target_id,
+	before_state, after_state, status, ip, request_id, prev_hash, hash`
+
+// auditQuery builds the query for entries matching the filter, newest first.
+func auditQuery(f models.AuditFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	count := 1
+
+	add := func(condition string, value interface{}) {
+		conditions = append(conditions, fmt.Sprintf(condition, count))
+		args = append(args, value)
+		count++
+	}
+	if f.ActorID != "" {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.From != nil {
+		add("occurred_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("occurred_at < $%d", *f.To)
+	}
+
+	query := `SELECT ` + auditEntryColumns + ` FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	return query, args
+}
+
+// Query returns entries matching the filter, newest first.
+func (l *AuditLog) Query(f models.AuditFilter) ([]models.AuditEntry, error) {
+	query, args := auditQuery(f)
+	entries := []models.AuditEntry{}
+	err := l.DB.Select(&entries, query, args...)
+	return entries, err
+}
+
+// Each passes the entries matching the filter, newest first, to fn one at a
+// time as they are read, so exports of any size need little memory. It
+// stops at the first error fn returns.
+func (l *AuditLog) Each(f models.AuditFilter, fn func(e models.AuditEntry) error) error {
+	query, args := auditQuery(f)
+	rows, err := l.DB.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.StructScan(&e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Verify walks the whole chain in order and returns the ID of the first entry
+// whose hash or link does not match, or 0 if the chain is intact.
+func (l *AuditLog) Verify() (int64, int, error) {
+	rows, err := l.DB.Queryx(`SELECT ` + auditEntryColumns + ` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	prev := ""
+	checked := 0
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.StructScan(&e); err != nil {
+			return 0, checked, err
+		}
+		if e.PrevHash != prev || hashAuditEntry(&e) != e.Hash {
+			return e.ID, checked, nil
+		}
+		prev = e.Hash
+		checked++
+	}
+	return 0, checked, rows.Err()
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"complain/internal/config"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"testing"
+	"time"
+)
+
+const testAppURL = "https://complaints.example.org"
+
+// testEnv is the notification services on memory repositories that share
+// one outbox, with the fake SMS sender.
+type testEnv struct {
+	Outbox     *repository.MemoryOutboxRepository
+	Users      *repository.MemoryUserRepository
+	Complaints *repository.MemoryComplaintRepository
+	Webhooks   *repository.MemoryWebhookRepository
+	Inbox      *repository.MemoryNotificationRepository
+	SMS        *FakeSMSSender
+	Notifier   *Notifier
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := &testEnv{
+		Outbox:     repository.NewMemoryOutboxRepository(),
+		Users:      repository.NewMemoryUserRepository(),
+		Complaints: repository.NewMemoryComplaintRepository(),
+		Webhooks:   repository.NewMemoryWebhookRepository(),
+		Inbox:      repository.NewMemoryNotificationRepository(),
+		SMS:        NewFakeSMSSender(),
+	}
+	env.Users.Outbox, env.Complaints.Outbox = env.Outbox, env.Outbox
+	templates, err := NewEmailTemplates(repository.NewMemoryEmailTemplateRepository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := NewMailer(config.SMTPConfig{From: "complaints@example.org"}, testAppURL, templates, nil)
+	env.Notifier = NewNotifier(env.Outbox, env.Inbox, mailer, env.SMS, NewWebhooks(env.Webhooks), config.NotifyConfig{
+		Workers: 1, MaxAttempts: 3, RetryBase: time.Minute, RetryMax: time.Hour, PollInterval: time.Second,
+	})
+	return env
+}
+
+// user creates u and returns it with its ID.
+func (env *testEnv) user(t *testing.T, u models.User) models.User {
+	t.Helper()
+	if err := env.Users.Create(&u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// deliverDue delivers the messages in the outbox that are due, as a worker
+// would, and returns how many there were.
+func (env *testEnv) deliverDue(t *testing.T) int {
+	t.Helper()
+	delivered := 0
+	for {
+		messages, err := env.Outbox.Claim(1, deliveryLease)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) == 0 {
+			return delivered
+		}
+		env.Notifier.process(messages[0])
+		delivered++
+	}
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+// Complaint event types.
+const (
+	EventComplaintCreated  = "complaint.created"
+	EventComplaintUpdated  = "complaint.updated"
+	EventComplaintAssigned = "complaint.assigned"
+	// EventResync tells subscribers events may have been missed, so they
+	// should reload instead of relying on the events they got.
+	EventResync = "resync"
+)
+
+// ComplaintEvent says something happened to a complaint. It carries no
+// details: whoever receives it loads the complaint, which also checks that
+// the recipient may see it.
+type ComplaintEvent struct {
+	Type        string `json:"type"`
+	ComplaintID int64  `json:"complaint_id"`
+	// OwnerID is the user who filed the complaint.
+	OwnerID int64     `json:"owner_id"`
+	At      time.Time `json:"at"`
+	// StaffOnly marks events about something only staff may see, such as
+	// an internal note; the owner is not told of them.
+	StaffOnly bool `json:"staff_only,omitempty"`
+}
+
+// EventBus carries complaint events from the handlers that cause them to
+// the real-time streams. Delivery is best effort: a subscriber that falls
+// behind misses events and is told so.
+type EventBus interface {
+	Publish(event ComplaintEvent)
+	Subscribe() *Subscription
+}
+
+// subscriptionBuffer is how many events a subscriber may fall behind by
+// before it starts missing them.
+const subscriptionBuffer = 64
+
+// Subscription receives the events published after it was made.
+type Subscription struct {
+	Events chan ComplaintEvent
+	lagged atomic.Bool
+	cancel func()
+}
+
+// Lagged reports whether events were dropped because the subscriber fell
+// behind since the last call.
+func (s *Subscription) Lagged() bool {
+	return s.lagged.Swap(false)
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// MemoryEventBus delivers events within one process.
+type MemoryEventBus struct {
+	subscribers map[*Subscription]struct{}
+	mutex       sync.Mutex
+}
+
+func NewMemoryEventBus() *MemoryEventBus {
+	return &MemoryEventBus{subscribers: map[*Subscription]struct{}{}}
+}
+
+func (b *MemoryEventBus) Publish(event ComplaintEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		select {
+		case s.Events <- event:
+		default:
+			s.lagged.Store(true)
+		}
+	}
+}
+
+func (b *MemoryEventBus) Subscribe() *Subscription {
+	s := &Subscription{Events: make(chan ComplaintEvent, subscriptionBuffer)}
+	s.cancel = func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, s)
+	}
+	b.mutex.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mutex.Unlock()
+	return s
+}
+
+// eventChannel is the PostgreSQL notification channel events go through.
+const eventChannel = "complaint_events"
+
+// PostgresEventBus shares events between API processes with PostgreSQL's
+// LISTEN/NOTIFY. Each process listens on its own connection and hands what
+// it hears to its local subscribers, including the events it published.
+type PostgresEventBus struct {
+	DB *sqlx.DB
+	// DatabaseURL is used to open the listening connection, which must not
+	// be shared with DB's pool.
+	DatabaseURL string
+
+	local *MemoryEventBus
+	// listened is set once the first connection is listening; only the
+	// Listen goroutine uses it.
+	listened bool
+}
+
+// NewPostgresEventBus creates a bus on db; call Listen to receive events.
+func NewPostgresEventBus(db *sqlx.DB, databaseURL string) *PostgresEventBus {
+	return &PostgresEventBus{DB: db, DatabaseURL: databaseURL, local: NewMemoryEventBus()}
+}
+
+func (b *PostgresEventBus) Publish(event ComplaintEvent) {
+	payload, _ := json.Marshal(event)
+	if _, err := b.DB.Exec(`SELECT pg_notify($1, $2)`, eventChannel, string(payload)); err != nil {
+		fmt.Printf("Failed to publish %s event for complaint %d: %v\n", event.Type, event.ComplaintID, err)
+	}
+}
+
+func (b *PostgresEventBus) Subscribe() *Subscription {
+	return b.local.Subscribe()
+}
+
+// Listen receives events from every process and never returns, so run it
+// in its own goroutine. Lost connections are reopened; events published
+// while it was down are missed, so local subscribers get an EventResync
+// once it listens again.
+func (b *PostgresEventBus) Listen() {
+	delay := time.Second
+	for {
+		listened, err := b.listen()
+		if listened {
+			delay = time.Second
+		}
+		fmt.Printf("Complaint event listener stopped, reconnecting in %s: %v\n", delay, err)
+		time.Sleep(delay)
+		if delay < 30*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// listen receives events until the connection fails, reporting whether it
+// got as far as listening.
+func (b *PostgresEventBus) listen() (bool, error) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, b.DatabaseURL)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, "LISTEN "+eventChannel); err != nil {
+		return false, err
+	}
+	if b.listened {
+		b.local.Publish(ComplaintEvent{Type: EventResync, At: time.Now()})
+	}
+	b.listened = true
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		var event ComplaintEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			fmt.Printf("Ignoring malformed complaint event %q: %v\n", notification.Payload, err)
+			continue
+		}
+		b.local.Publish(event)
+	}
+}
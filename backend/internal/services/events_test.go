@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryEventBus(t *testing.T) {
+	bus := NewMemoryEventBus()
+	first, second := bus.Subscribe(), bus.Subscribe()
+	defer first.Close()
+
+	event := ComplaintEvent{Type: EventComplaintCreated, ComplaintID: 1, OwnerID: 3, At: time.Now()}
+	bus.Publish(event)
+	for i, s := range []*Subscription{first, second} {
+		select {
+		case got := <-s.Events:
+			if got != event {
+				t.Errorf("subscriber %d got %+v", i+1, got)
+			}
+		default:
+			t.Errorf("subscriber %d got nothing", i+1)
+		}
+	}
+
+	second.Close()
+	bus.Publish(event)
+	select {
+	case got := <-second.Events:
+		t.Fatalf("closed subscription got %+v", got)
+	default:
+	}
+	<-first.Events
+}
+
+func TestSubscriberThatFallsBehindIsTold(t *testing.T) {
+	bus := NewMemoryEventBus()
+	s := bus.Subscribe()
+	defer s.Close()
+
+	for i := 0; i < subscriptionBuffer; i++ {
+		bus.Publish(ComplaintEvent{Type: EventComplaintUpdated, ComplaintID: int64(i)})
+	}
+	if s.Lagged() {
+		t.Fatal("lagged before missing anything")
+	}
+	// Publishing does not wait for a full subscriber.
+	bus.Publish(ComplaintEvent{Type: EventComplaintUpdated, ComplaintID: -1})
+	if !s.Lagged() {
+		t.Fatal("not lagged after an event was dropped")
+	}
+	if s.Lagged() {
+		t.Error("Lagged() stays set after it was read")
+	}
+	if got := <-s.Events; got.ComplaintID != 0 {
+		t.Errorf("first event kept is about complaint %d, want 0", got.ComplaintID)
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// exifInfo is what we keep from a photo's EXIF data.
+type exifInfo struct {
+	// Orientation is the EXIF orientation, 1 to 8; 1 is upright.
+	Orientation int
+	TakenAt     *time.Time
+	Latitude    *float64
+	Longitude   *float64
+}
+
+// TIFF tags read from EXIF data.
+const (
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// TIFF field types and their sizes in bytes.
+var tiffTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+var errBadExif = errors.New("malformed EXIF data")
+
+// exifPrefix starts EXIF data in JPEG APP1 segments, and in some WebP files.
+var exifPrefix = []byte("Exif\x00\x00")
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifd reads the directory at offset into a map of tag to entry.
+func (r tiffReader) ifd(offset uint32) (map[uint16]tiffEntry, error) {
+	if int64(offset)+2 > int64(len(r.data)) {
+		return nil, errBadExif
+	}
+	n := int(r.order.Uint16(r.data[offset:]))
+	entries := make(map[uint16]tiffEntry, n)
+	for i := 0; i < n; i++ {
+		at := int64(offset) + 2 + int64(i)*12
+		if at+12 > int64(len(r.data)) {
+			return nil, errBadExif
+		}
+		e := r.data[at : at+12]
+		typ := r.order.Uint16(e[2:])
+		count := r.order.Uint32(e[4:])
+		size, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		length := int64(size) * int64(count)
+		value := e[8:12]
+		if length > 4 {
+			start := int64(r.order.Uint32(e[8:]))
+			if start+length > int64(len(r.data)) {
+				continue
+			}
+			value = r.data[start : start+length]
+		} else {
+			value = value[:length]
+		}
+		entries[r.order.Uint16(e)] = tiffEntry{typ: typ, count: count, value: value}
+	}
+	return entries, nil
+}
+
+func (r tiffReader) uint(e tiffEntry) (uint32, bool) {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(r.order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return r.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+func (r tiffReader) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(e.value), "\x00 ")
+}
+
+// degrees reads a GPS coordinate stored as degrees, minutes and seconds.
+func (r tiffReader) degrees(e tiffEntry) (float64, bool) {
+	if e.typ != 5 || e.count != 3 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		num := r.order.Uint32(e.value[i*8:])
+		den := r.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+// parseExif reads EXIF data, which is a TIFF file with an optional
+// "Exif\0\0" prefix. Fields that are missing or malformed are left unset.
+func parseExif(data []byte) (exifInfo, error) {
+	info := exifInfo{Orientation: 1}
+	data = bytes.TrimPrefix(data, exifPrefix)
+	if len(data) < 8 {
+		return info, errBadExif
+	}
+	r := tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return info, errBadExif
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return info, errBadExif
+	}
+
+	ifd0, err := r.ifd(r.order.Uint32(data[4:]))
+	if err != nil {
+		return info, err
+	}
+	if o, ok := r.uint(ifd0[tagOrientation]); ok && o >= 1 && o <= 8 {
+		info.Orientation = int(o)
+	}
+
+	taken := r.ascii(ifd0[tagDateTime])
+	offset := ""
+	if at, ok := r.uint(ifd0[tagExifIFD]); ok {
+		if exif, err := r.ifd(at); err == nil {
+			if original := r.ascii(exif[tagDateTimeOriginal]); original != "" {
+				taken = original
+				offset = r.ascii(exif[tagOffsetTimeOriginal])
+			}
+		}
+	}
+	if t, ok := parseExifTime(taken, offset); ok {
+		info.TakenAt = &t
+	}
+
+	if at, ok := r.uint(ifd0[tagGPSIFD]); ok {
+		if gps, err := r.ifd(at); err == nil {
+			lat, latOK := r.degrees(gps[tagGPSLatitude])
+			lon, lonOK := r.degrees(gps[tagGPSLongitude])
+			if latOK && lonOK && lat <= 90 && lon <= 180 {
+				if r.ascii(gps[tagGPSLatitudeRef]) == "S" {
+					lat = -lat
+				}
+				if r.ascii(gps[tagGPSLongitudeRef]) == "W" {
+					lon = -lon
+				}
+				info.Latitude, info.Longitude = &lat, &lon
+			}
+		}
+	}
+	return info, nil
+}
+
+// parseExifTime reads an EXIF timestamp such as "2024:05:01 14:03:22" with
+// an optional offset such as "+05:30". Cameras that record no offset are
+// taken to be on UTC.
+func parseExifTime(value, offset string) (time.Time, bool) {
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t.UTC(), true
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// orientationExif builds the smallest EXIF data that records only an
+// orientation, so stripped photos still display upright.
+func orientationExif(orientation int) []byte {
+	tiff := make([]byte, 26)
+	copy(tiff, "MM\x00\x2A")
+	binary.BigEndian.PutUint32(tiff[4:], 8) // IFD0 follows the header
+	binary.BigEndian.PutUint16(tiff[8:], 1) // one entry
+	binary.BigEndian.PutUint16(tiff[10:], tagOrientation)
+	binary.BigEndian.PutUint16(tiff[12:], 3) // SHORT
+	binary.BigEndian.PutUint32(tiff[14:], 1)
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	// The next IFD offset at tiff[22:26] stays zero.
+	return append(append([]byte{}, exifPrefix...), tiff...)
+}
+
+// validCoordinate rejects the 0,0 some cameras write without a fix.
+func validCoordinate(lat, lon *float64) bool {
+	return lat != nil && lon != nil && !(math.Abs(*lat) < 1e-9 && math.Abs(*lon) < 1e-9)
+}
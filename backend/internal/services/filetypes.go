@@ -0,0 +1,483 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of accepted files. Each kind has its own size limit.
+const (
+	KindImage    = "image"
+	KindDocument = "document"
+	KindVideo    = "video"
+)
+
+// FileType is a file format evidence may be uploaded in.
+type FileType struct {
+	ContentType string
+	Kind        string
+	// Extensions are the filename extensions the format is known by; the
+	// first one is used when a file has none.
+	Extensions []string
+}
+
+// Errors for files that fail validation. They all wrap ErrFileRejected.
+var (
+	ErrFileRejected = errors.New("file rejected")
+	ErrFileTooLarge = fmt.Errorf("%w: too large", ErrFileRejected)
+	ErrInfected     = fmt.Errorf("%w: malware found", ErrFileRejected)
+)
+
+var (
+	typeJPEG = FileType{"image/jpeg", KindImage, []string{".jpg", ".jpeg"}}
+	typePNG  = FileType{"image/png", KindImage, []string{".png"}}
+	typeGIF  = FileType{"image/gif", KindImage, []string{".gif"}}
+	typeWebP = FileType{"image/webp", KindImage, []string{".webp"}}
+	typePDF  = FileType{"application/pdf", KindDocument, []string{".pdf"}}
+	typeMP4  = FileType{"video/mp4", KindVideo, []string{".mp4", ".m4v"}}
+	typeMOV  = FileType{"video/quicktime", KindVideo, []string{".mov"}}
+	typeWebM = FileType{"video/webm", KindVideo, []string{".webm"}}
+)
+
+// mp4Brands are the ISO base media major brands accepted as MP4 video.
+// Other brands in the same container, such as HEIC and AVIF images, are not.
+var mp4Brands = map[string]bool{
+	"isom": true, "iso2": true, "iso4": true, "iso5": true, "iso6": true,
+	"mp41": true, "mp42": true, "avc1": true, "M4V ": true, "dash": true, "MSNV": true,
+}
+
+// sniffLength is how much of a file sniff looks at.
+const sniffLength = 512
+
+// sniff identifies a file's format from its first bytes.
+func sniff(header []byte) (FileType, bool) {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xFF\xD8\xFF")):
+		return typeJPEG, true
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1A\n")):
+		return typePNG, true
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return typeGIF, true
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return typeWebP, true
+	case bytes.HasPrefix(header, []byte("%PDF-")):
+		return typePDF, true
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		brand := string(header[8:12])
+		if brand == "qt  " {
+			return typeMOV, true
+		}
+		if mp4Brands[brand] {
+			return typeMP4, true
+		}
+	case bytes.HasPrefix(header, []byte("\x1A\x45\xDF\xA3")) && bytes.Contains(header, []byte("webm")):
+		return typeWebM, true
+	}
+	return FileType{}, false
+}
+
+// activeMarkers betray content a browser or another reader would act on.
+// A file of an accepted type that has one where a reader looks is treated
+// as a polyglot.
+var activeMarkers = [][]byte{
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<!doctype"),
+	[]byte("<?php"),
+	[]byte("<svg"),
+	[]byte("<iframe"),
+	[]byte("javascript:"),
+}
+
+// pdfMarker is how a PDF starts; readers accept it anywhere in the first
+// polyglotHead bytes.
+var pdfMarker = []byte("%pdf-")
+
+// polyglotHead is how much of the start of a file is searched for markers:
+// browsers sniff the first 512 bytes, PDF readers the first 1024.
+const polyglotHead = 1024
+
+// pdfEnd ends a PDF; readers ignore what follows the last one.
+var pdfEnd = []byte("%%EOF")
+
+// zipTrailer starts the zip end of central directory record, which zip
+// readers look for at the end of a file whatever precedes it.
+var zipTrailer = []byte("PK\x05\x06")
+
+// zipTrailerSize is the size of the record without its comment, and
+// maxZipComment the longest comment that may follow it.
+const (
+	zipTrailerSize = 22
+	maxZipComment  = 1<<16 - 1
+)
+
+// limitFor is the size limit of a kind of file.
+func (u *Uploader) limitFor(kind string) int64 {
+	switch kind {
+	case KindImage:
+		return u.Limits.MaxImageSize
+	case KindDocument:
+		return u.Limits.MaxDocumentSize
+	case KindVideo:
+		return u.Limits.MaxVideoSize
+	}
+	return 0
+}
+
+// Validate identifies a file by its content and checks it against the
+// allow-list, its size limit, its extension and for being a polyglot.
+// It does not scan for malware.
+func (u *Uploader) Validate(file File) (FileType, error) {
+	src, err := file.Open()
+	if err != nil {
+		return FileType{}, err
+	}
+	defer src.Close()
+
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return FileType{}, err
+	}
+	fileType, ok := sniff(header[:n])
+	if !ok {
+		return FileType{}, fmt.Errorf("%w: %s is not a JPEG, PNG, GIF, WebP, PDF, MP4, MOV or WebM file", ErrFileRejected, file.Filename)
+	}
+
+	if ext := strings.ToLower(filepath.Ext(file.Filename)); ext != "" && !containsExt(fileType.Extensions, ext) {
+		return FileType{}, fmt.Errorf("%w: %s has the extension %s but contains %s", ErrFileRejected, file.Filename, ext, fileType.ContentType)
+	}
+	if limit := u.limitFor(fileType.Kind); file.Size > limit {
+		return FileType{}, fmt.Errorf("%w: %s is larger than the %d byte limit for %s files", ErrFileTooLarge, file.Filename, limit, fileType.Kind)
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return FileType{}, err
+	}
+	if reason, err := polyglotReason(src, file.Size, fileType); err != nil {
+		return FileType{}, err
+	} else if reason != "" {
+		return FileType{}, fmt.Errorf("%w: %s %s", ErrFileRejected, file.Filename, reason)
+	}
+	return fileType, nil
+}
+
+// polyglotReason explains why a file that sniffed as fileType could also be
+// read as something else, or returns "" if it could not. Markers are only
+// looked for where readers look: the start of the file, image metadata and
+// anything after the end of an image or PDF. Compressed image, video and
+// PDF stream data is left alone; a few bytes of it match a marker by
+// chance often enough in large files.
+func polyglotReason(src multipart.File, size int64, fileType FileType) (string, error) {
+	markers := activeMarkers
+	if fileType.ContentType != typePDF.ContentType {
+		markers = append(markers[:len(markers):len(markers)], pdfMarker)
+	}
+	regions, reason, err := parsedRegions(src, size, fileType)
+	if err != nil || reason != "" {
+		return reason, err
+	}
+	for _, region := range regions {
+		if marker := findMarker(region, markers); marker != nil {
+			return fmt.Sprintf("contains %q and may be read as another format", marker), nil
+		}
+	}
+
+	tail, err := readRegion(src, size, -(zipTrailerSize + maxZipComment))
+	if err != nil {
+		return "", err
+	}
+	if endsInZip(tail) {
+		return "ends in a zip archive", nil
+	}
+	return "", nil
+}
+
+// readRegion reads up to n bytes of src, from its start if n is positive
+// and from its end if n is negative.
+func readRegion(src io.ReaderAt, size int64, n int64) ([]byte, error) {
+	offset := int64(0)
+	if n < 0 {
+		n = -n
+		if n > size {
+			n = size
+		}
+		offset = size - n
+	} else if n > size {
+		n = size
+	}
+	region := make([]byte, n)
+	if _, err := src.ReadAt(region, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return region, nil
+}
+
+// parsedRegions returns the parts of a file readers parse beyond its
+// format's own structures: its start, the metadata of images and what
+// follows the end of an image or PDF. Images are read whole; their size
+// limit keeps that small. reason is set for images too malformed to find
+// their end.
+func parsedRegions(src multipart.File, size int64, fileType FileType) ([][]byte, string, error) {
+	head, err := readRegion(src, size, polyglotHead)
+	if err != nil {
+		return nil, "", err
+	}
+	regions := [][]byte{head}
+	switch {
+	case fileType.Kind == KindImage:
+		data, err := readRegion(src, size, size)
+		if err != nil {
+			return nil, "", err
+		}
+		metadata, end, err := imageMetadata(data, fileType)
+		if err != nil {
+			return nil, "is not a well-formed image", nil
+		}
+		if fileType.ContentType == typePNG.ContentType && end != len(data) {
+			return nil, "has data after the end of the image", nil
+		}
+		regions = append(regions, metadata...)
+		regions = append(regions, data[end:])
+	case fileType.ContentType == typePDF.ContentType:
+		tail, err := readRegion(src, size, -polyglotHead)
+		if err != nil {
+			return nil, "", err
+		}
+		if i := bytes.LastIndex(tail, pdfEnd); i >= 0 {
+			regions = append(regions, tail[i+len(pdfEnd):])
+		}
+	}
+	return regions, "", nil
+}
+
+// imageMetadata returns the metadata an image carries, such as JPEG
+// comments and PNG text chunks, and where its last structure ends.
+func imageMetadata(data []byte, fileType FileType) ([][]byte, int, error) {
+	switch fileType.ContentType {
+	case typeJPEG.ContentType:
+		return jpegMetadata(data)
+	case typePNG.ContentType:
+		return pngMetadata(data)
+	case typeGIF.ContentType:
+		return gifMetadata(data)
+	case typeWebP.ContentType:
+		return webpMetadata(data)
+	}
+	return nil, len(data), nil
+}
+
+// jpegMetadata returns the payloads of a JPEG's application and comment
+// segments, and where its EOI marker ends.
+func jpegMetadata(data []byte) ([][]byte, int, error) {
+	var metadata [][]byte
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, 0, errBadImage
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, 0, errBadImage
+		}
+		if marker == markerSOS {
+			// As in stripJPEG, the first EOI after the scan data is the end.
+			end := bytes.Index(data[i:], []byte{0xFF, markerEOI})
+			if end < 0 {
+				return nil, 0, errBadImage
+			}
+			return metadata, i + end + 2, nil
+		}
+		if marker >= markerAPP0 && marker <= 0xEF || marker == markerCOM {
+			metadata = append(metadata, data[i+4:i+2+length])
+		}
+		i += 2 + length
+	}
+}
+
+// pngCompressedChunks are ancillary PNG chunks whose data is compressed.
+var pngCompressedChunks = map[string]bool{"zTXt": true, "iCCP": true}
+
+// pngMetadata returns the data of a PNG's uncompressed ancillary chunks,
+// such as tEXt, and where its IEND chunk ends.
+func pngMetadata(data []byte) ([][]byte, int, error) {
+	var metadata [][]byte
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, 0, errBadImage
+		}
+		kind := string(data[i+4 : i+8])
+		// Ancillary chunks have a lowercase first letter.
+		if kind[0] >= 'a' && kind[0] <= 'z' && !pngCompressedChunks[kind] {
+			metadata = append(metadata, data[i+8:i+8+length])
+		}
+		if kind == "IEND" {
+			return metadata, end, nil
+		}
+		i = end
+	}
+	return nil, 0, errBadImage
+}
+
+// GIF block introducers and extension labels.
+const (
+	gifExtension      = 0x21
+	gifImage          = 0x2C
+	gifTrailer        = 0x3B
+	gifPlainText      = 0x01
+	gifGraphicControl = 0xF9
+	gifCommentLabel   = 0xFE
+	gifApplicationExt = 0xFF
+)
+
+// gifMetadata returns the data of a GIF's comment, plain text and
+// application extensions, and where its trailer ends.
+func gifMetadata(data []byte) ([][]byte, int, error) {
+	if len(data) < 13 {
+		return nil, 0, errBadImage
+	}
+	i := 13
+	if data[10]&0x80 != 0 { // global color table
+		i += 3 << (data[10]&7 + 1)
+	}
+	var metadata [][]byte
+	for i < len(data) {
+		switch data[i] {
+		case gifTrailer:
+			return metadata, i + 1, nil
+		case gifExtension:
+			if i+2 > len(data) {
+				return nil, 0, errBadImage
+			}
+			label := data[i+1]
+			keep := label == gifCommentLabel || label == gifApplicationExt || label == gifPlainText
+			payload, end, err := gifSubBlocks(data, i+2, keep)
+			if err != nil {
+				return nil, 0, err
+			}
+			if keep {
+				metadata = append(metadata, payload)
+			}
+			i = end
+		case gifImage:
+			if i+11 > len(data) {
+				return nil, 0, errBadImage
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 { // local color table
+				i += 3 << (flags&7 + 1)
+			}
+			// Skip the LZW minimum code size, then the image data.
+			_, end, err := gifSubBlocks(data, i+1, false)
+			if err != nil {
+				return nil, 0, err
+			}
+			i = end
+		default:
+			return nil, 0, errBadImage
+		}
+	}
+	// Decoders accept a GIF that stops without its trailer.
+	return metadata, len(data), nil
+}
+
+// gifSubBlocks reads the data sub-blocks starting at i, returning their
+// joined payload if keep is set, and where the terminating block ends.
+func gifSubBlocks(data []byte, i int, keep bool) ([]byte, int, error) {
+	var payload []byte
+	for {
+		if i >= len(data) {
+			return nil, 0, errBadImage
+		}
+		n := int(data[i])
+		i++
+		if n == 0 {
+			return payload, i, nil
+		}
+		if i+n > len(data) {
+			return nil, 0, errBadImage
+		}
+		if keep {
+			payload = append(payload, data[i:i+n]...)
+		}
+		i += n
+	}
+}
+
+// webpImageChunks are the WebP chunks that hold image data.
+var webpImageChunks = map[string]bool{"VP8 ": true, "VP8L": true, "VP8X": true, "ALPH": true, "ANIM": true, "ANMF": true}
+
+// webpMetadata returns the data of a WebP file's chunks other than image
+// data, such as EXIF and XMP, and where its RIFF container ends.
+func webpMetadata(data []byte) ([][]byte, int, error) {
+	if len(data) < 12 {
+		return nil, 0, errBadImage
+	}
+	riffEnd := 8 + int(binary.LittleEndian.Uint32(data[4:]))
+	if riffEnd < 12 || riffEnd > len(data) {
+		return nil, 0, errBadImage
+	}
+	var metadata [][]byte
+	for i := 12; i+8 <= riffEnd; {
+		kind := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if length < 0 || i+8+length > riffEnd {
+			return nil, 0, errBadImage
+		}
+		if !webpImageChunks[kind] {
+			metadata = append(metadata, data[i+8:i+8+length])
+		}
+		i += 8 + length + length%2
+	}
+	return metadata, riffEnd, nil
+}
+
+// endsInZip reports whether tail, the end of a file, is the end of a zip
+// archive: it holds an end of central directory record whose comment runs
+// exactly to the end.
+func endsInZip(tail []byte) bool {
+	for end := len(tail); ; {
+		i := bytes.LastIndex(tail[:end], zipTrailer)
+		if i < 0 {
+			return false
+		}
+		if i+zipTrailerSize <= len(tail) && i+zipTrailerSize+int(binary.LittleEndian.Uint16(tail[i+20:])) == len(tail) {
+			return true
+		}
+		end = i
+	}
+}
+
+// findMarker returns the first of markers found in data, ignoring case, or
+// nil.
+func findMarker(data []byte, markers [][]byte) []byte {
+	lower := bytes.ToLower(data)
+	for _, m := range markers {
+		if bytes.Contains(lower, m) {
+			return m
+		}
+	}
+	return nil
+}
+
+func containsExt(extensions []string, ext string) bool {
+	for _, e := range extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
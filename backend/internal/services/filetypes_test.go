@@ -0,0 +1,153 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"complain/internal/config"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The samples in testdata are real encoder output: the images come from
+// Go's image/testdata and python.webp from CPython's test suite.
+
+// memoryFile is a multipart.File over a byte slice.
+type memoryFile struct{ *bytes.Reader }
+
+func (memoryFile) Close() error { return nil }
+
+func fileOf(name string, data []byte) File {
+	return File{Filename: name, Size: int64(len(data)), Open: func() (multipart.File, error) {
+		return memoryFile{bytes.NewReader(data)}, nil
+	}}
+}
+
+func testUploader() *Uploader {
+	return &Uploader{Limits: config.AttachmentConfig{MaxImageSize: 10 << 20, MaxDocumentSize: 10 << 20, MaxVideoSize: 50 << 20}}
+}
+
+func sample(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestValidateAcceptsRealImages(t *testing.T) {
+	u := testUploader()
+	for _, name := range []string{"video-001.jpeg", "video-001.progressive.jpeg", "video-001.png", "video-001.gif", "video-001.interlaced.gif", "python.webp"} {
+		if _, err := u.Validate(fileOf(name, sample(t, name))); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// insert returns data with extra inserted at i.
+func insert(data []byte, i int, extra []byte) []byte {
+	out := append([]byte{}, data[:i]...)
+	out = append(out, extra...)
+	return append(out, data[i:]...)
+}
+
+// pngChunk encodes a PNG chunk with its CRC.
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func zipArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, _ := w.Create("payload.html")
+	f.Write([]byte("<p>hello</p>"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidateIgnoresMarkersInImageData(t *testing.T) {
+	jpeg := sample(t, "video-001.jpeg")
+	// Overwrite a stretch of the entropy-coded data, as compressed bytes
+	// sometimes happen to spell a marker.
+	sos := bytes.Index(jpeg, []byte{0xFF, markerSOS})
+	at := sos + (len(jpeg)-sos)/2
+	for bytes.IndexByte(jpeg[at-1:at+6], 0xFF) >= 0 {
+		at++
+	}
+	scan := append([]byte{}, jpeg...)
+	copy(scan[at:], "<SvG")
+
+	if _, err := testUploader().Validate(fileOf("photo.jpg", scan)); err != nil {
+		t.Fatalf("marker in the scan data: %v", err)
+	}
+}
+
+func TestValidateRejectsPolyglots(t *testing.T) {
+	jpeg, png, gif := sample(t, "video-001.jpeg"), sample(t, "video-001.png"), sample(t, "video-001.gif")
+	comment := []byte{0xFF, markerCOM, 0, 0}
+	binary.BigEndian.PutUint16(comment[2:], uint16(len("<script>alert(1)</script>")+2))
+	comment = append(comment, "<script>alert(1)</script>"...)
+	gifComment := append([]byte{gifExtension, gifCommentLabel, 5}, "<?php"...)
+	gifComment = append(gifComment, 0)
+
+	for name, data := range map[string][]byte{
+		"jpeg comment":   insert(jpeg, 2, comment),
+		"after jpeg end": append(append([]byte{}, jpeg...), "<html><body>"...),
+		"png text chunk": insert(png, len(png)-12, pngChunk("tEXt", []byte("Comment\x00<svg onload=alert(1)>"))),
+		"after png end":  append(append([]byte{}, png...), 0),
+		"gif comment":    insert(gif, len(gif)-1, gifComment),
+		"gif with zip":   append(append([]byte{}, gif...), zipArchive(t)...),
+		"pdf header":     insert(jpeg, 2, append([]byte{0xFF, markerCOM, 0, 10}, "%PDF-1.7"...)),
+	} {
+		if _, err := testUploader().Validate(fileOf("photo", data)); !errors.Is(err, ErrFileRejected) {
+			t.Errorf("%s: %v, want the file rejected", name, err)
+		}
+	}
+}
+
+// mp4 is an MP4 file whose media data is size random bytes with marker
+// written at offset.
+func mp4(size, offset int, marker string) []byte {
+	data := []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2")
+	data = binary.BigEndian.AppendUint32(data, uint32(size+8))
+	data = append(data, "mdat"...)
+	media := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(media)
+	copy(media[offset:], marker)
+	return append(data, media...)
+}
+
+func TestValidateVideo(t *testing.T) {
+	u := testUploader()
+	if _, err := u.Validate(fileOf("clip.mp4", mp4(4<<20, 2<<20, "<svg%PDF-"))); err != nil {
+		t.Errorf("markers in the media data: %v", err)
+	}
+	if _, err := u.Validate(fileOf("clip.mp4", mp4(4<<20, 100, "<html>"))); !errors.Is(err, ErrFileRejected) {
+		t.Errorf("marker at the start: %v, want the file rejected", err)
+	}
+}
+
+func TestValidatePDF(t *testing.T) {
+	page := "%PDF-1.4\n" + string(bytes.Repeat([]byte("% padding\n"), 200)) +
+		"1 0 obj << /Length 44 >> stream\nBT (<script> and javascript: links) Tj ET\nendstream endobj\n" +
+		"trailer << /Root 1 0 R >>\n%%EOF\n"
+	u := testUploader()
+	if _, err := u.Validate(fileOf("letter.pdf", []byte(page))); err != nil {
+		t.Errorf("markup quoted in a page: %v", err)
+	}
+	if _, err := u.Validate(fileOf("letter.pdf", []byte(page+"<html><script>"))); !errors.Is(err, ErrFileRejected) {
+		t.Errorf("markup after %%%%EOF: %v, want the file rejected", err)
+	}
+}
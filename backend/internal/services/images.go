@@ -0,0 +1,443 @@
+package services
+
+import (
+	"bytes"
+	"complain/internal/config"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"time"
+)
+
+// ImagePipeline prepares photos for storage: it strips their metadata,
+// reads where and when they were taken, and makes smaller renditions for
+// dashboards.
+type ImagePipeline struct {
+	ThumbnailSize int
+	WebSize       int
+	// LocationTolerance is how far from its complaint, in meters, a photo
+	// may have been taken before it is flagged.
+	LocationTolerance float64
+}
+
+func NewImagePipeline(cfg config.ImageConfig) *ImagePipeline {
+	return &ImagePipeline{
+		ThumbnailSize:     cfg.ThumbnailSize,
+		WebSize:           cfg.WebSize,
+		LocationTolerance: cfg.LocationTolerance,
+	}
+}
+
+// maxRenditionPixels bounds the images we decode to make renditions, so a
+// small file claiming huge dimensions cannot exhaust memory: decoding and
+// flattening take about 5 bytes a pixel. Larger photos are stored without
+// renditions.
+const maxRenditionPixels = 24_000_000
+
+// ProcessedImage is a photo ready to be stored.
+type ProcessedImage struct {
+	// Data is the photo without its metadata.
+	Data []byte
+	// Thumbnail and Web are JPEG renditions, or nil for formats we cannot
+	// decode (WebP).
+	Thumbnail []byte
+	Web       []byte
+	TakenAt   *time.Time
+	Latitude  *float64
+	Longitude *float64
+}
+
+// Process strips a photo's metadata and makes its renditions. Photos whose
+// metadata cannot be parsed are rejected rather than stored with it.
+func (p *ImagePipeline) Process(data []byte, fileType FileType) (ProcessedImage, error) {
+	var out ProcessedImage
+	var exif []byte
+	var err error
+	switch fileType.ContentType {
+	case typeJPEG.ContentType:
+		out.Data, exif, err = stripJPEG(data)
+	case typePNG.ContentType:
+		out.Data, exif, err = stripPNG(data)
+	case typeWebP.ContentType:
+		out.Data, exif, err = stripWebP(data)
+	case typeGIF.ContentType:
+		// GIF has no standard place for camera metadata, but comments and
+		// XMP can still give the sender away.
+		out.Data, err = stripGIF(data)
+	default:
+		return out, errBadImage
+	}
+	if err != nil {
+		return out, err
+	}
+
+	info := exifInfo{Orientation: 1}
+	if exif != nil {
+		// Unreadable EXIF is already stripped; we just learn nothing from it.
+		info, _ = parseExif(exif)
+	}
+	out.TakenAt = info.TakenAt
+	if validCoordinate(info.Latitude, info.Longitude) {
+		out.Latitude, out.Longitude = info.Latitude, info.Longitude
+	}
+	if fileType.ContentType == typeJPEG.ContentType && info.Orientation != 1 {
+		out.Data = withOrientation(out.Data, info.Orientation)
+	}
+
+	out.Thumbnail, out.Web = p.renditions(out.Data, info.Orientation)
+	return out, nil
+}
+
+// renditions decodes a photo and makes its thumbnail and web-sized JPEGs.
+// It returns nils if the photo cannot be decoded.
+func (p *ImagePipeline) renditions(data []byte, orientation int) ([]byte, []byte) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxRenditionPixels {
+		return nil, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil
+	}
+	// Flatten onto white once; JPEG has no transparency.
+	src := image.NewRGBA(img.Bounds())
+	draw.Draw(src, src.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	web := orient(shrink(src, p.WebSize), orientation)
+	thumbnail := orient(shrink(src, p.ThumbnailSize), orientation)
+	return encodeJPEG(thumbnail, 80), encodeJPEG(web, 85)
+}
+
+func encodeJPEG(img image.Image, quality int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// shrink scales img down so its longer side is at most maxSize, averaging
+// the source pixels each target pixel covers. Smaller images are returned
+// as they are.
+func shrink(img *image.RGBA, maxSize int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	nw, nh := maxSize, h*maxSize/w
+	if h > w {
+		nw, nh = w*maxSize/h, maxSize
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := y*h/nh, (y+1)*h/nh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < nw; x++ {
+			x0, x1 := x*w/nw, (x+1)*w/nw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := img.Pix[sy*img.Stride+x0*4 : sy*img.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				d[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// orient turns img, which must start at the origin, upright according to
+// its EXIF orientation.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], img.Pix[sy*img.Stride+sx*4:])
+		}
+	}
+	return dst
+}
+
+var errBadImage = errors.New("malformed image")
+
+// JPEG markers.
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE // used by Adobe for its color transform flag
+	markerCOM   = 0xFE
+)
+
+// keepJPEGSegment reports whether a segment is needed to display the image
+// correctly. JFIF headers, ICC color profiles and Adobe's color transform
+// flag are kept; EXIF, XMP, IPTC, comments and maker data are not.
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == markerAPP0, marker == markerAPP14:
+		return true
+	case marker == markerAPP2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= markerAPP1 && marker <= 0xEF, marker == markerCOM:
+		return false
+	}
+	return true
+}
+
+// stripJPEG drops a JPEG's metadata segments and anything after its end,
+// where some cameras append extra data. It returns the EXIF segment's
+// payload, if any.
+func stripJPEG(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, nil, errBadImage
+	}
+	out := []byte{0xFF, markerSOI}
+	var exif []byte
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, nil, errBadImage
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, nil, errBadImage
+		}
+		payload := data[i+4 : i+2+length]
+		if marker == markerSOS {
+			// Entropy-coded data follows. 0xFF inside it is always followed
+			// by 0x00 or a restart marker, so the first EOI is the end.
+			end := bytes.Index(data[i:], []byte{0xFF, markerEOI})
+			if end < 0 {
+				return nil, nil, errBadImage
+			}
+			return append(out, data[i:i+end+2]...), exif, nil
+		}
+		if marker == markerAPP1 && exif == nil && bytes.HasPrefix(payload, exifPrefix) {
+			exif = payload
+		}
+		if keepJPEGSegment(marker, payload) {
+			out = append(out, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+}
+
+// withOrientation inserts an EXIF segment recording only the orientation
+// after the JFIF header of a stripped JPEG.
+func withOrientation(data []byte, orientation int) []byte {
+	exif := orientationExif(orientation)
+	segment := []byte{0xFF, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	at := 2
+	if len(data) > 6 && data[3] == markerAPP0 {
+		at += 2 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+	out := append([]byte{}, data[:at]...)
+	out = append(out, segment...)
+	return append(out, data[at:]...)
+}
+
+// pngMetadataChunks are the PNG chunks that carry metadata.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true,
+}
+
+// stripPNG drops a PNG's metadata chunks. It returns the eXIf chunk's data,
+// if any.
+func stripPNG(data []byte) ([]byte, []byte, error) {
+	const signatureLength = 8
+	out := append([]byte{}, data[:signatureLength]...)
+	var exif []byte
+	for i := signatureLength; i < len(data); {
+		if i+12 > len(data) {
+			return nil, nil, errBadImage
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, nil, errBadImage
+		}
+		kind := string(data[i+4 : i+8])
+		if kind == "eXIf" && exif == nil {
+			exif = data[i+8 : i+8+length]
+		}
+		if !pngMetadataChunks[kind] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if kind == "IEND" {
+			break
+		}
+	}
+	return out, exif, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP file and clears the
+// flags announcing them. It returns the EXIF chunk's data, if any.
+func stripWebP(data []byte) ([]byte, []byte, error) {
+	if len(data) < 12 {
+		return nil, nil, errBadImage
+	}
+	out := append([]byte{}, data[:12]...)
+	var exif []byte
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, nil, errBadImage
+		}
+		kind := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + length + length%2
+		if end > len(data) {
+			if i+8+length != len(data) {
+				return nil, nil, errBadImage
+			}
+			end = len(data) // an odd final chunk without padding
+		}
+		switch kind {
+		case "EXIF":
+			if exif == nil {
+				exif = data[i+8 : i+8+length]
+			}
+		case "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if length > 0 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, exif, nil
+}
+
+// gifLoopExtensions identify the application extensions that hold an
+// animated GIF's loop count. Other application extensions, such as XMP, are
+// metadata.
+var gifLoopExtensions = map[string]bool{"NETSCAPE2.0": true, "ANIMEXTS1.0": true}
+
+// stripGIF rebuilds a GIF from its header, frames, frame timing and loop
+// count, dropping comments, plain text, other application extensions and
+// anything after the trailer.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, errBadImage
+	}
+	i := 13
+	if data[10]&0x80 != 0 { // global color table
+		i += 3 << (data[10]&7 + 1)
+	}
+	if i > len(data) {
+		return nil, errBadImage
+	}
+	out := append([]byte{}, data[:i]...)
+	for i < len(data) && data[i] != gifTrailer {
+		start := i
+		switch data[i] {
+		case gifExtension:
+			if i+2 > len(data) {
+				return nil, errBadImage
+			}
+			label := data[i+1]
+			_, end, err := gifSubBlocks(data, i+2, false)
+			if err != nil {
+				return nil, err
+			}
+			keep := label == gifGraphicControl
+			if label == gifApplicationExt {
+				// The first sub-block is the 8 byte application name and
+				// its 3 byte authentication code.
+				keep = data[i+2] == 11 && i+14 <= end && gifLoopExtensions[string(data[i+3:i+14])]
+			}
+			i = end
+			if !keep {
+				continue
+			}
+		case gifImage:
+			if i+11 > len(data) {
+				return nil, errBadImage
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 { // local color table
+				i += 3 << (flags&7 + 1)
+			}
+			// Skip the LZW minimum code size, then the image data.
+			_, end, err := gifSubBlocks(data, i+1, false)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		default:
+			return nil, errBadImage
+		}
+		out = append(out, data[start:i]...)
+	}
+	return append(out, gifTrailer), nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/gif"
+	"testing"
+)
+
+func TestProcessStripsGIFMetadata(t *testing.T) {
+	original := sample(t, "video-001.gif")
+	loop := append([]byte{gifExtension, gifApplicationExt, 11}, "NETSCAPE2.0\x03\x01\x00\x00\x00"...)
+	comment := append([]byte{gifExtension, gifCommentLabel, 11}, "Sent by Ann\x00"...)
+	xmp := append([]byte{gifExtension, gifApplicationExt, 11}, "XMP DataXMP\x05<x:a>\x00"...)
+	// The extensions go before the first frame, after the header and the
+	// global color table.
+	at := 13 + 3<<(original[10]&7+1)
+	data := insert(original, at, append(append(append([]byte{}, loop...), comment...), xmp...))
+	data = append(data, "trailing"...)
+
+	out, err := (&ImagePipeline{ThumbnailSize: 32, WebSize: 64}).Process(data, typeGIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"Sent by Ann", "XMP DataXMP", "trailing"} {
+		if bytes.Contains(out.Data, []byte(leak)) {
+			t.Errorf("%q left in the GIF", leak)
+		}
+	}
+	if !bytes.Contains(out.Data, loop) {
+		t.Error("loop count dropped")
+	}
+	want, err := gif.DecodeAll(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gif.DecodeAll(bytes.NewReader(out.Data))
+	if err != nil {
+		t.Fatalf("stripped GIF does not decode: %v", err)
+	}
+	if len(got.Image) != len(want.Image) || got.LoopCount != 0 {
+		t.Errorf("stripped GIF has %d frames looping %d times, want %d looping forever", len(got.Image), got.LoopCount, len(want.Image))
+	}
+	if out.Thumbnail == nil || out.Web == nil {
+		t.Error("no renditions")
+	}
+}
+
+func TestProcessSkipsRenditionsOfHugeImages(t *testing.T) {
+	png := sample(t, "video-001.png")
+	// Claim 6000×5000 pixels in the header; only the header is read.
+	ihdr := append([]byte{}, png[16:29]...)
+	binary.BigEndian.PutUint32(ihdr, 6000)
+	binary.BigEndian.PutUint32(ihdr[4:], 5000)
+	huge := append(append(append([]byte{}, png[:8]...), pngChunk("IHDR", ihdr)...), png[33:]...)
+
+	out, err := (&ImagePipeline{ThumbnailSize: 32, WebSize: 64}).Process(huge, typePNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Thumbnail != nil || out.Web != nil {
+		t.Error("made renditions of a 30 megapixel image")
+	}
+}
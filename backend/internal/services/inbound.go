@@ -0,0 +1,379 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrReplyRejected is returned for mail that will never become a comment,
+// such as auto-replies or mail from someone other than the complaint's
+// owner. Other errors may go away if the mail is processed again.
+var ErrReplyRejected = errors.New("reply rejected")
+
+// inboundMaxSize bounds how much of a message is read.
+const inboundMaxSize = 10 << 20
+
+// InboundMail turns replies to complaint emails into comments on the
+// complaints. A reply is only accepted from the address the email was
+// sent to, to the reply address made for that address, so knowing a
+// complaint's ID is not enough to comment on it, and only if the mail
+// server vouches that it came from that address.
+type InboundMail struct {
+	Complaints repository.ComplaintRepository
+	Users      repository.UserRepository
+	Replies    *ReplyAddresses
+	Notifier   *Notifier
+	Events     EventBus
+	// AuthServID names the mail server whose Authentication-Results
+	// headers are trusted. The server must remove headers claiming its
+	// name from the messages it receives.
+	AuthServID string
+}
+
+func NewInboundMail(complaints repository.ComplaintRepository, users repository.UserRepository, replies *ReplyAddresses, notifier *Notifier, events EventBus, authServID string) *InboundMail {
+	return &InboundMail{Complaints: complaints, Users: users, Replies: replies, Notifier: notifier, Events: events, AuthServID: authServID}
+}
+
+func rejectReply(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrReplyRejected, fmt.Sprintf(format, a...))
+}
+
+// autoReply reports whether header marks a message as sent by a machine,
+// such as an out-of-office reply or a bounce.
+func autoReply(header mail.Header) bool {
+	if v := strings.ToLower(header.Get("Auto-Submitted")); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(header.Get("Precedence")) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	return header.Get("X-Autoreply") != "" || header.Get("X-Autorespond") != "" ||
+		strings.TrimSpace(header.Get("Return-Path")) == "<>"
+}
+
+// authenticated reports whether an Authentication-Results header written
+// by authServID says the message passed DMARC, or passed DKIM or SPF for
+// a domain aligned with the From address's domain.
+func authenticated(header mail.Header, authServID, from string) bool {
+	fromDomain := strings.ToLower(from[strings.LastIndex(from, "@")+1:])
+	for _, value := range header["Authentication-Results"] {
+		results := strings.Split(stripComments(value), ";")
+		if id := strings.Fields(results[0]); len(id) == 0 || !strings.EqualFold(id[0], authServID) {
+			continue
+		}
+		for _, result := range results[1:] {
+			fields := strings.Fields(strings.ToLower(result))
+			if len(fields) == 0 {
+				continue
+			}
+			props := map[string]string{}
+			for _, field := range fields[1:] {
+				if k, v, ok := strings.Cut(field, "="); ok {
+					props[k] = strings.Trim(v, `"`)
+				}
+			}
+			switch fields[0] {
+			case "dmarc=pass":
+				return true
+			case "dkim=pass":
+				if aligned(props["header.d"], fromDomain) {
+					return true
+				}
+			case "spf=pass":
+				mailFrom := props["smtp.mailfrom"]
+				if aligned(mailFrom[strings.LastIndex(mailFrom, "@")+1:], fromDomain) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// aligned reports whether fromDomain is domain or one of its subdomains.
+func aligned(domain, fromDomain string) bool {
+	return domain != "" && (fromDomain == domain || strings.HasSuffix(fromDomain, "."+domain))
+}
+
+// stripComments removes the (comments) from a header value.
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range value {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Process adds the reply in r as a comment by its sender on the complaint
+// it answers.
+func (in *InboundMail) Process(r io.Reader) (models.ComplaintUpdate, error) {
+	msg, err := mail.ReadMessage(io.LimitReader(r, inboundMaxSize))
+	if err != nil {
+		return models.ComplaintUpdate{}, rejectReply("unreadable message: %v", err)
+	}
+	if autoReply(msg.Header) {
+		return models.ComplaintUpdate{}, rejectReply("automatic reply")
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 {
+		return models.ComplaintUpdate{}, rejectReply("no single From address")
+	}
+	sender := from[0].Address
+	if !authenticated(msg.Header, in.AuthServID, sender) {
+		return models.ComplaintUpdate{}, rejectReply("not authenticated as sent from %s", sender)
+	}
+	messageID := strings.TrimSpace(msg.Header.Get("Message-ID"))
+	if messageID == "" {
+		return models.ComplaintUpdate{}, rejectReply("no Message-ID")
+	}
+
+	complaintID, ok := int64(0), false
+	for _, name := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		recipients, _ := msg.Header.AddressList(name)
+		for _, recipient := range recipients {
+			if complaintID, ok = in.Replies.Complaint(recipient.Address, sender); ok {
+				break
+			}
+		}
+		if ok {
+			break
+		}
+	}
+	if !ok {
+		return models.ComplaintUpdate{}, rejectReply("no reply address made for %s", sender)
+	}
+
+	user, err := in.Users.GetByEmail(sender)
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.ComplaintUpdate{}, rejectReply("no account for %s", sender)
+	}
+	if err != nil {
+		return models.ComplaintUpdate{}, err
+	}
+	if !user.IsActive {
+		return models.ComplaintUpdate{}, rejectReply("account %d is deactivated", user.ID)
+	}
+	filter := repository.ComplaintFilter{UserID: user.ID}
+	complaint, err := in.Complaints.Get(complaintID, filter)
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.ComplaintUpdate{}, rejectReply("complaint %d is not %s's", complaintID, sender)
+	}
+	if err != nil {
+		return models.ComplaintUpdate{}, err
+	}
+
+	text, err := messageText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return models.ComplaintUpdate{}, rejectReply("unreadable body: %v", err)
+	}
+	comment := StripReply(text)
+	if comment == "" {
+		return models.ComplaintUpdate{}, rejectReply("empty reply")
+	}
+
+	notifications, err := ReplyNotifications(in.Complaints, in.Users, complaint, user.Name, comment)
+	if err != nil {
+		return models.ComplaintUpdate{}, err
+	}
+	hooks, err := in.Notifier.Webhooks.Messages(EventComplaintUpdated, complaint, complaint.Status, comment)
+	if err != nil {
+		return models.ComplaintUpdate{}, err
+	}
+	notifications = append(notifications, hooks...)
+	update, err := in.Complaints.AddEmailedReply(complaintID, user.ID, comment, messageID, filter, notifications)
+	if errors.Is(err, repository.ErrDuplicate) {
+		return update, rejectReply("message %s was already added", messageID)
+	}
+	if err != nil {
+		return update, err
+	}
+	if len(notifications) > 0 {
+		in.Notifier.Wake()
+	}
+	in.Events.Publish(ComplaintEvent{Type: EventComplaintUpdated, ComplaintID: complaintID, OwnerID: user.ID, At: update.CreatedAt})
+	return update, nil
+}
+
+// messageText returns the text of a message body, preferring the plain
+// text of multipart messages to their HTML.
+func messageText(contentType, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	switch strings.ToLower(transferEncoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		var html string
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			text, err := messageText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case partType == "text/html":
+				if html == "" {
+					html = text
+				}
+			case text != "":
+				return text, nil
+			}
+		}
+		return html, nil
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", nil
+	}
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	text := decodeCharset(raw, params["charset"])
+	if mediaType == "text/html" {
+		text = htmlToText(text)
+	}
+	return text, nil
+}
+
+// decodeCharset converts text in charset to UTF-8. Only UTF-8 and Latin-1
+// are converted; anything else is kept as far as it is valid UTF-8.
+func decodeCharset(raw []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		runes := make([]rune, len(raw))
+		for i, b := range raw {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return strings.ToValidUTF8(string(raw), "�")
+}
+
+// Maildir feeds the replies a mail server delivers to a maildir to an
+// InboundMail.
+type Maildir struct {
+	Dir      string
+	Inbound  *InboundMail
+	Interval time.Duration
+}
+
+func NewMaildir(dir string, inbound *InboundMail, interval time.Duration) *Maildir {
+	return &Maildir{Dir: dir, Inbound: inbound, Interval: interval}
+}
+
+// ProcessNew handles every message in new/ and returns how many became
+// comments. A message is first claimed by moving it to cur/, so that when
+// several API instances poll the same maildir only one handles it. Handled
+// messages are flagged S (seen), rejected ones also T (trashed) so they can
+// still be looked at; messages that failed for a reason that may pass,
+// such as the database being down, go back to new/ to be tried at the next
+// poll.
+func (m *Maildir) ProcessNew() (int, error) {
+	entries, err := os.ReadDir(filepath.Join(m.Dir, "new"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	added := 0
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(m.Dir, "new", entry.Name())
+		claimed := filepath.Join(m.Dir, "cur", entry.Name()+":2,")
+		if err := os.Rename(path, claimed); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Another instance claimed it.
+				continue
+			}
+			return added, err
+		}
+		flags := "S"
+		err := m.processFile(claimed)
+		switch {
+		case err == nil:
+			added++
+		case errors.Is(err, ErrReplyRejected):
+			fmt.Printf("Rejected reply %s: %v\n", entry.Name(), err)
+			flags = "ST"
+		default:
+			fmt.Printf("Failed to process reply %s, will retry: %v\n", entry.Name(), err)
+			if err := os.Rename(claimed, path); err != nil {
+				return added, err
+			}
+			continue
+		}
+		if err := os.Rename(claimed, claimed+flags); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+func (m *Maildir) processFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = m.Inbound.Process(f)
+	return err
+}
+
+// Run polls the maildir every Interval. It never returns, so run it in its
+// own goroutine.
+func (m *Maildir) Run() {
+	for {
+		added, err := m.ProcessNew()
+		if err != nil {
+			fmt.Printf("Failed to read replies from %s: %v\n", m.Dir, err)
+		} else if added > 0 {
+			fmt.Printf("Added %d emailed replies as comments\n", added)
+		}
+		time.Sleep(m.Interval)
+	}
+}
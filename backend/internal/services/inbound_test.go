@@ -0,0 +1,107 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestInbound(t *testing.T) (*InboundMail, *repository.MemoryComplaintRepository, string) {
+	t.Helper()
+	env := newTestEnv(t)
+	citizen := env.user(t, models.User{Name: "Citizen", Email: "citizen@example.com", IsActive: true})
+	complaint, err := env.Complaints.Create(citizen.ID, models.CreateComplaintRequest{Title: "Pothole", Category: 1, IsPublic: true}, models.StatusPending, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := NewReplyAddresses("replies@city.example.org", "reply-secret")
+	inbound := NewInboundMail(env.Complaints, env.Users, replies, env.Notifier, NewMemoryEventBus(), "mx.city.example.org")
+	return inbound, env.Complaints, replies.For(complaint.ID, citizen.Email)
+}
+
+// reply makes a reply from the citizen to to, with the given
+// Authentication-Results header unless it is empty.
+func reply(to, messageID, results string) string {
+	var b strings.Builder
+	if results != "" {
+		b.WriteString("Authentication-Results: " + results + "\r\n")
+	}
+	b.WriteString("From: Citizen <citizen@example.com>\r\nTo: " + to + "\r\n")
+	b.WriteString("Message-ID: " + messageID + "\r\nSubject: Re: Pothole\r\n\r\nIt is still there.\r\n")
+	return b.String()
+}
+
+func TestInboundMailRequiresAuthentication(t *testing.T) {
+	inbound, _, to := newTestInbound(t)
+	for name, results := range map[string]string{
+		"no results":       "",
+		"other server":     "mx.attacker.example; dkim=pass header.d=example.com",
+		"dkim failed":      "mx.city.example.org; dkim=fail header.d=example.com",
+		"unaligned dkim":   "mx.city.example.org; dkim=pass header.d=attacker.example",
+		"commented out":    "mx.city.example.org; dkim=fail (dkim=pass) header.d=example.com",
+		"unaligned spf":    "mx.city.example.org; spf=pass smtp.mailfrom=bounce@attacker.example",
+		"dmarc of another": "mx.attacker.example; dmarc=pass header.from=example.com",
+	} {
+		if _, err := inbound.Process(strings.NewReader(reply(to, "<"+name+"@example.com>", results))); !errors.Is(err, ErrReplyRejected) {
+			t.Errorf("%s: %v, want the reply rejected", name, err)
+		}
+	}
+	for name, results := range map[string]string{
+		"dkim":  "mx.city.example.org; dkim=pass (good signature) header.d=example.com header.s=mail",
+		"spf":   "mx.city.example.org 1; spf=pass smtp.mailfrom=citizen@example.com",
+		"dmarc": "MX.City.Example.Org; dkim=fail; dmarc=pass header.from=example.com",
+	} {
+		if _, err := inbound.Process(strings.NewReader(reply(to, "<"+name+"@example.com>", results))); err != nil {
+			t.Errorf("%s: %v, want the reply added", name, err)
+		}
+	}
+}
+
+func TestInboundMailAddsMessageOnce(t *testing.T) {
+	inbound, complaints, to := newTestInbound(t)
+	message := reply(to, "<1@example.com>", "mx.city.example.org; dkim=pass header.d=example.com")
+	if _, err := inbound.Process(strings.NewReader(message)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inbound.Process(strings.NewReader(message)); !errors.Is(err, ErrReplyRejected) {
+		t.Fatalf("the same message again: %v, want it rejected", err)
+	}
+	if updates, _ := complaints.ListUpdates(1, true); len(updates) != 1 {
+		t.Fatalf("updates %+v, want one", updates)
+	}
+}
+
+func TestMaildirProcessNew(t *testing.T) {
+	inbound, _, to := newTestInbound(t)
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, results := range map[string]string{
+		"1.good": "mx.city.example.org; dkim=pass header.d=example.com",
+		"2.bad":  "",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, "new", name), []byte(reply(to, "<"+name+"@example.com>", results)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	added, err := NewMaildir(dir, inbound, 0).ProcessNew()
+	if err != nil || added != 1 {
+		t.Fatalf("ProcessNew() = %d, %v; want 1", added, err)
+	}
+	for _, name := range []string{"cur/1.good:2,S", "cur/2.bad:2,ST"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if left, _ := os.ReadDir(filepath.Join(dir, "new")); len(left) != 0 {
+		t.Errorf("left in new/: %v", left)
+	}
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitPolicy describes how failed login attempts for one key are throttled.
+type LimitPolicy struct {
+	// FreeAttempts failures are allowed before any delay is imposed.
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past FreeAttempts; it
+	// doubles with each further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter failures lock the key for LockoutDuration. Zero disables lockout.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Default policies. Accounts are locked fairly quickly; IPs get a higher
+// budget because several people can share one address.
+var (
+	DefaultAccountPolicy = LimitPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 30 * time.Minute,
+		Window:          time.Hour,
+	}
+	DefaultIPPolicy = LimitPolicy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        15 * time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+)
+
+// errThrottled aborts the reservation of a refused attempt.
+var errThrottled = errors.New("throttled")
+
+// LoginAttempt is a login attempt Allow let through. It counts as failed
+// until RecordSuccess says otherwise, so parallel guesses cannot all slip
+// past the limit before any of them is counted.
+type LoginAttempt struct {
+	email string
+	ip    string
+	// lockedUntil is set if this attempt, failed, locks the account.
+	lockedUntil time.Time
+	// blocked holds, per key, the block this attempt set and the one it
+	// replaced, so taking the attempt back can undo it.
+	blocked map[string][2]time.Time
+}
+
+// LoginLimiter tracks failed logins per account (email) and per client IP and
+// decides when further attempts are refused. Tracking is keyed on the
+// submitted email whether or not an account exists, so responses do not
+// reveal which emails are registered. The counters live in the database, so
+// they survive restarts and apply across every API process.
+type LoginLimiter struct {
+	Failures      repository.LoginFailureRepository
+	accountPolicy LimitPolicy
+	ipPolicy      LimitPolicy
+
+	mutex     sync.Mutex
+	lastPrune time.Time
+}
+
+func NewLoginLimiter(failures repository.LoginFailureRepository, accountPolicy, ipPolicy LimitPolicy) *LoginLimiter {
+	return &LoginLimiter{
+		Failures:      failures,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (l *LoginLimiter) policy(key string) LimitPolicy {
+	if strings.HasPrefix(key, "ip:") {
+		return l.ipPolicy
+	}
+	return l.accountPolicy
+}
+
+// Allow reserves a login attempt for email from ip. If the account or the
+// address is throttled or locked out it returns how long the caller should
+// wait instead, and nothing is counted. A reserved attempt counts as a
+// failure until RecordSuccess.
+func (l *LoginLimiter) Allow(email, ip string, now time.Time) (LoginAttempt, time.Duration, error) {
+	l.prune(now)
+
+	attempt := LoginAttempt{email: email, ip: ip, blocked: map[string][2]time.Time{}}
+	account := accountKey(email)
+	var wait time.Duration
+	err := l.Failures.Update([]string{account, ipKey(ip)}, func(counters []*models.LoginFailures) error {
+		for _, c := range counters {
+			if d := c.BlockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			return errThrottled
+		}
+		for _, c := range counters {
+			before := c.BlockedUntil
+			if l.fail(c, l.policy(c.Key), now) && c.Key == account {
+				attempt.lockedUntil = c.BlockedUntil
+			}
+			if !c.BlockedUntil.Equal(before) {
+				attempt.blocked[c.Key] = [2]time.Time{c.BlockedUntil, before}
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errThrottled) {
+		return attempt, wait, nil
+	}
+	return attempt, 0, err
+}
+
+// RecordFailure reports whether the failed attempt locked the account, and
+// until when, so the caller can notify the owner. Allow already counted it.
+func (l *LoginLimiter) RecordFailure(attempt LoginAttempt) (time.Time, bool) {
+	return attempt.lockedUntil, !attempt.lockedUntil.IsZero()
+}
+
+// RecordSuccess clears the account's failures and takes the attempt back
+// from the IP counter. The rest of the IP counter is left alone so one valid
+// account cannot be used to reset a password-spraying client.
+func (l *LoginLimiter) RecordSuccess(attempt LoginAttempt) error {
+	account := accountKey(attempt.email)
+	return l.Failures.Update([]string{account, ipKey(attempt.ip)}, func(counters []*models.LoginFailures) error {
+		for _, c := range counters {
+			if c.Key == account {
+				*c = models.LoginFailures{Key: c.Key}
+			} else {
+				attempt.takeBack(c)
+			}
+		}
+		return nil
+	})
+}
+
+// Release takes the attempt back from both counters without clearing the
+// failures before it, for a password that was right but still needs a
+// second factor.
+func (l *LoginLimiter) Release(attempt LoginAttempt) error {
+	return l.Failures.Update([]string{accountKey(attempt.email), ipKey(attempt.ip)}, func(counters []*models.LoginFailures) error {
+		for _, c := range counters {
+			attempt.takeBack(c)
+		}
+		return nil
+	})
+}
+
+// takeBack uncounts the attempt's failure on c, and the block it set unless
+// a later failure has moved it since.
+func (a LoginAttempt) takeBack(c *models.LoginFailures) {
+	if c.Failures > 0 {
+		c.Failures--
+	}
+	if change, ok := a.blocked[c.Key]; ok && c.BlockedUntil.Equal(change[0]) {
+		c.BlockedUntil = change[1]
+	}
+}
+
+// Unlock removes any lockout and failure history for the account.
+func (l *LoginLimiter) Unlock(email string) error {
+	return l.Failures.Delete(accountKey(email))
+}
+
+// fail counts a failure on c and reports whether it locked c out.
+func (l *LoginLimiter) fail(c *models.LoginFailures, policy LimitPolicy, now time.Time) bool {
+	if now.Sub(c.LastFailure) > policy.Window {
+		c.Failures = 0
+	}
+	c.Failures++
+	c.LastFailure = now
+
+	// Every failure past the limit locks again once the last lockout ran
+	// out, until the failures are forgotten after Window.
+	if policy.LockoutAfter > 0 && c.Failures >= policy.LockoutAfter {
+		c.BlockedUntil = now.Add(policy.LockoutDuration)
+		return true
+	}
+	if c.Failures > policy.FreeAttempts {
+		delay := policy.BaseDelay << (c.Failures - policy.FreeAttempts - 1)
+		if delay <= 0 || delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+		if until := now.Add(delay); until.After(c.BlockedUntil) {
+			c.BlockedUntil = until
+		}
+	}
+	return false
+}
+
+// prune drops stale counters at most once a minute per process.
+func (l *LoginLimiter) prune(now time.Time) {
+	l.mutex.Lock()
+	if now.Sub(l.lastPrune) < time.Minute {
+		l.mutex.Unlock()
+		return
+	}
+	l.lastPrune = now
+	l.mutex.Unlock()
+
+	window := l.accountPolicy.Window
+	if l.ipPolicy.Window > window {
+		window = l.ipPolicy.Window
+	}
+	if _, err := l.Failures.Prune(now.Add(-window)); err != nil {
+		fmt.Printf("Failed to prune login failures: %v\n", err)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"complain/internal/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = LimitPolicy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    5,
+	LockoutDuration: 10 * time.Minute,
+	Window:          time.Hour,
+}
+
+func newTestLimiter() *LoginLimiter {
+	return NewLoginLimiter(repository.NewMemoryLoginFailureRepository(), testPolicy, DefaultIPPolicy)
+}
+
+func TestLoginLimiterCountsParallelAttempts(t *testing.T) {
+	l := newTestLimiter()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, wait, err := l.Allow("a@example.com", "10.0.0.1", now); err == nil && wait == 0 {
+				mutex.Lock()
+				allowed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// The fourth reservation starts a delay, so only four get through.
+	if allowed != testPolicy.FreeAttempts+1 {
+		t.Fatalf("allowed %d parallel attempts, want %d", allowed, testPolicy.FreeAttempts+1)
+	}
+}
+
+func TestLoginLimiterLocksAgainAfterLockout(t *testing.T) {
+	l := newTestLimiter()
+	now := time.Now()
+	locks := 0
+	for i := 0; i < 8; i++ {
+		attempt, wait, err := l.Allow("a@example.com", "10.0.0.1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait > 0 {
+			now = now.Add(wait)
+			i--
+			continue
+		}
+		if _, locked := l.RecordFailure(attempt); locked {
+			locks++
+		}
+	}
+	// The fifth failure locks, and so does every one after the lockout ran out.
+	if locks != 4 {
+		t.Fatalf("got %d lockouts, want 4", locks)
+	}
+}
+
+func TestLoginLimiterSuccessClearsAccount(t *testing.T) {
+	l := newTestLimiter()
+	now := time.Now()
+	for i := 0; i < testPolicy.FreeAttempts; i++ {
+		if _, _, err := l.Allow("a@example.com", "10.0.0.1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempt, wait, err := l.Allow("a@example.com", "10.0.0.1", now)
+	if err != nil || wait > 0 {
+		t.Fatalf("fourth attempt refused: %v %v", wait, err)
+	}
+	if err := l.RecordSuccess(attempt); err != nil {
+		t.Fatal(err)
+	}
+	if _, wait, _ := l.Allow("a@example.com", "10.0.0.1", now); wait > 0 {
+		t.Fatalf("attempt after a success must not wait, got %v", wait)
+	}
+}
+
+func TestLoginLimiterReleaseUndoesItsBlock(t *testing.T) {
+	l := newTestLimiter()
+	now := time.Now()
+	for i := 0; i < testPolicy.FreeAttempts; i++ {
+		l.Allow("a@example.com", "10.0.0.1", now)
+	}
+	// A right password that still needs a second factor.
+	attempt, _, _ := l.Allow("a@example.com", "10.0.0.1", now)
+	if err := l.Release(attempt); err != nil {
+		t.Fatal(err)
+	}
+	if _, wait, _ := l.Allow("a@example.com", "10.0.0.1", now); wait > 0 {
+		t.Fatalf("released attempt still blocks for %v", wait)
+	}
+}
+
+func TestLoginLimiterUnlock(t *testing.T) {
+	l := newTestLimiter()
+	now := time.Now()
+	for failures := 0; failures < testPolicy.LockoutAfter; {
+		_, wait, _ := l.Allow("a@example.com", "10.0.0.1", now)
+		if wait > 0 {
+			now = now.Add(wait)
+		} else {
+			failures++
+		}
+	}
+	if _, wait, _ := l.Allow("a@example.com", "10.0.0.1", now); wait < time.Minute {
+		t.Fatalf("account not locked out, wait %v", wait)
+	}
+	if err := l.Unlock("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, wait, _ := l.Allow("a@example.com", "10.0.0.1", now); wait > 0 {
+		t.Fatalf("unlocked account still waits %v", wait)
+	}
+}
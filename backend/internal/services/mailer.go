@@ -2,47 +2,77 @@
 package services
 
 import (
-    "fmt"
-    "net/smtp"
+	"complain/internal/config"
+	"fmt"
+	"net/smtp"
+	"time"
 )
 
+// mailerName is the display name emails are sent from.
+const mailerName = "Complaint Management Team"
+
 type Mailer struct {
-    From     string
-    Password string
-    Host     string
-    Port     string
+	From     string
+	Password string
+	Host     string
+	Port     string
+	// AppURL is the frontend base URL used to build links in emails.
+	AppURL    string
+	Templates *EmailTemplates
+	// Replies makes the Reply-To addresses of complaint emails; nil when
+	// replies are not read.
+	Replies *ReplyAddresses
 }
 
-func NewMailer(from, password, host, port string) *Mailer {
-    return &Mailer{
-        From:     from,
-        Password: password,
-        Host:     host,
-        Port:     port,
-    }
+func NewMailer(cfg config.SMTPConfig, appURL string, templates *EmailTemplates, replies *ReplyAddresses) *Mailer {
+	return &Mailer{
+		From:      cfg.From,
+		Password:  cfg.Password,
+		Host:      cfg.Host,
+		Port:      cfg.Port,
+		AppURL:    appURL,
+		Templates: templates,
+		Replies:   replies,
+	}
 }
 
-func (m *Mailer) SendComplaintConfirmation(to string, title string, complaintID int64) error {
-    auth := smtp.PlainAuth("", m.From, m.Password, m.Host)
-    
-    subject := "Complaint Registered Successfully"
-    body := fmt.Sprintf(`
-Dear User,
-
-Your complaint "%s" has been successfully registered with ID: %d.
-We will process your complaint and keep you updated.
+// Encode builds the MIME message for a rendered email to to.
+func (m *Mailer) Encode(to string, email RenderedEmail) ([]byte, error) {
+	return m.encode(to, email, nil)
+}
 
-Thank you for using our service.
+func (m *Mailer) encode(to string, email RenderedEmail, headers map[string]string) ([]byte, error) {
+	msg, _, err := mimeMessage{FromName: mailerName, From: m.From, To: to, Date: time.Now(), Email: email, Headers: headers}.Bytes()
+	return msg, err
+}
 
-Best regards,
-Complaint Management Team
-`, title, complaintID)
+// Compose renders the template for event in language, falling back to the
+// default language, and encodes it for to. Emails about a complaint are
+// answered at a reply address only to can use.
+func (m *Mailer) Compose(event, language, to string, data EmailData) ([]byte, error) {
+	data.AppURL = m.AppURL
+	email, err := m.Templates.Render(event, language, data)
+	if err != nil {
+		return nil, err
+	}
+	var headers map[string]string
+	if data.ComplaintID != 0 && m.Replies != nil {
+		headers = map[string]string{"Reply-To": m.Replies.For(data.ComplaintID, to)}
+	}
+	return m.encode(to, email, headers)
+}
 
-    msg := []byte(fmt.Sprintf("To: %s\r\n"+
-        "Subject: %s\r\n"+
-        "\r\n"+
-        "%s\r\n", to, subject, body))
+// Send emails to the message for event in language.
+func (m *Mailer) Send(event, language, to string, data EmailData) error {
+	msg, err := m.Compose(event, language, to, data)
+	if err != nil {
+		return err
+	}
+	return m.send(to, msg)
+}
 
-    addr := fmt.Sprintf("%s:%s", m.Host, m.Port)
-    return smtp.SendMail(addr, auth, m.From, []string{to}, msg)
-}
\ No newline at end of file
+func (m *Mailer) send(to string, msg []byte) error {
+	auth := smtp.PlainAuth("", m.From, m.Password, m.Host)
+	addr := fmt.Sprintf("%s:%s", m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.From, []string{to}, msg)
+}
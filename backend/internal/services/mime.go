@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// mimeMessage is an email ready to be handed to an SMTP server.
+type mimeMessage struct {
+	FromName string
+	From     string
+	To       string
+	Date     time.Time
+	Email    RenderedEmail
+	// Headers are extra headers, such as In-Reply-To.
+	Headers map[string]string
+}
+
+// newMessageID returns a globally unique Message-ID in the domain of from.
+func newMessageID(from string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain), nil
+}
+
+// Bytes encodes the message: headers are MIME-encoded where they need to be,
+// and the bodies are quoted-printable UTF-8, as text/plain alone or as
+// multipart/alternative with text/html.
+func (m mimeMessage) Bytes() ([]byte, string, error) {
+	messageID, err := newMessageID(m.From)
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", (&mail.Address{Name: m.FromName, Address: m.From}).String())
+	header("To", (&mail.Address{Address: m.To}).String())
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Email.Subject))
+	header("Date", m.Date.Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+	// Tells mail servers not to answer with out-of-office replies.
+	header("Auto-Submitted", "auto-generated")
+	for name, value := range m.Headers {
+		header(textproto.CanonicalMIMEHeaderKey(name), strings.Join(strings.Fields(value), " "))
+	}
+
+	if m.Email.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Email.Text); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), messageID, nil
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()}))
+	buf.WriteString("\r\n")
+	// Clients show the last part they understand, so HTML goes last.
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", m.Email.Text},
+		{"text/html", m.Email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + `; charset="utf-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, "", err
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), messageID, nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	// Templates use bare newlines; mail wants CRLF.
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readMessage parses an encoded email and decodes its subject.
+func readMessage(t *testing.T, encoded []byte) (*mail.Message, string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, subject
+}
+
+func TestComposeMultipart(t *testing.T) {
+	mailer := newTestEnv(t).Notifier.Mailer
+	mailer.Replies = NewReplyAddresses("replies@city.example.org", "reply-secret")
+	data := EmailData{Name: "आशा", ComplaintID: 42, Title: "Pothole", Status: "in_progress",
+		Comment: "Crew booked. " + strings.Repeat("A long comment that needs soft line breaks. ", 5)}
+	encoded, err := mailer.Compose(NotifyComplaintUpdated, "hi", "citizen@example.com", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.AppURL = mailer.AppURL
+	want, err := mailer.Templates.Render(NotifyComplaintUpdated, "hi", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, subject := readMessage(t, encoded)
+	if subject != want.Subject {
+		t.Errorf("subject %q, want %q", subject, want.Subject)
+	}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err != nil || from.Address != "complaints@example.org" || from.Name != mailerName {
+		t.Errorf("From %q, %v", msg.Header.Get("From"), err)
+	}
+	if date, err := msg.Header.Date(); err != nil || time.Since(date) > time.Minute {
+		t.Errorf("Date %q, %v", msg.Header.Get("Date"), err)
+	}
+	if id := msg.Header.Get("Message-ID"); !regexp.MustCompile(`^<[0-9a-f]{32}@example\.org>$`).MatchString(id) {
+		t.Errorf("Message-ID %q", id)
+	}
+	if got := msg.Header.Get("Reply-To"); got != mailer.Replies.For(42, "citizen@example.com") {
+		t.Errorf("Reply-To %q, want the complaint's reply address", got)
+	}
+	if msg.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("MIME-Version %q", msg.Header.Get("MIME-Version"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type %q, %v", msg.Header.Get("Content-Type"), err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for _, wantPart := range []struct{ contentType, content string }{
+		{"text/plain", strings.ReplaceAll(want.Text, "\n", "\r\n")},
+		{"text/html", strings.ReplaceAll(want.HTML, "\n", "\r\n")},
+	} {
+		// The reader decodes quoted-printable parts.
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Type"); got != wantPart.contentType+`; charset="utf-8"` {
+			t.Errorf("part Content-Type %q, want %s", got, wantPart.contentType)
+		}
+		if string(content) != wantPart.content {
+			t.Errorf("%s part %q, want %q", wantPart.contentType, content, wantPart.content)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("more than two parts: %v", err)
+	}
+	for _, line := range strings.Split(string(encoded), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line of %d characters", len(line))
+		}
+	}
+}
+
+func TestMIMEMessageTextOnly(t *testing.T) {
+	email := RenderedEmail{Subject: "Plain", Text: "Zürich\nline two"}
+	encoded, _, err := mimeMessage{From: "complaints@example.org", To: "citizen@example.com", Date: time.Now(), Email: email,
+		Headers: map[string]string{"in-reply-to": "<1@example.org>\r\nBcc: attacker@example.com"}}.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, subject := readMessage(t, encoded)
+	if subject != "Plain" || msg.Header.Get("Content-Type") != `text/plain; charset="utf-8"` {
+		t.Fatalf("subject %q, Content-Type %q", subject, msg.Header.Get("Content-Type"))
+	}
+	if msg.Header.Get("Bcc") != "" || msg.Header.Get("In-Reply-To") != "<1@example.org> Bcc: attacker@example.com" {
+		t.Errorf("extra header broke out of its line: %v", msg.Header)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil || string(body) != "Zürich\r\nline two" {
+		t.Errorf("body %q, %v", body, err)
+	}
+}
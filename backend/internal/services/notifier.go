@@ -0,0 +1,400 @@
+package services
+
+import (
+	"complain/internal/config"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// deliveryLease is how long a worker may take over one notification before
+// another worker may try it again.
+const deliveryLease = 5 * time.Minute
+
+// errPermanent marks delivery failures that retrying cannot fix.
+var errPermanent = errors.New("permanent failure")
+
+// Notifier delivers the notifications queued in the outbox. Messages are
+// written to the outbox with the change they report, so they survive mail
+// server outages and restarts; failed deliveries are retried with
+// exponential backoff and marked dead after MaxAttempts.
+type Notifier struct {
+	Outbox repository.OutboxRepository
+	// Inbox receives the messages on the in-app channel.
+	Inbox  repository.NotificationRepository
+	Mailer *Mailer
+	// SMS sends the messages on the SMS channel; it is nil if no provider
+	// is configured.
+	SMS SMSSender
+	// Webhooks delivers the messages on the webhook channel.
+	Webhooks *Webhooks
+	Config   config.NotifyConfig
+
+	wake chan struct{}
+}
+
+// NewNotifier creates a new notifier; call Run to start delivering.
+func NewNotifier(outbox repository.OutboxRepository, inbox repository.NotificationRepository, mailer *Mailer, sms SMSSender, webhooks *Webhooks, cfg config.NotifyConfig) *Notifier {
+	return &Notifier{Outbox: outbox, Inbox: inbox, Mailer: mailer, SMS: sms, Webhooks: webhooks, Config: cfg, wake: make(chan struct{}, 1)}
+}
+
+// newMessage builds an email outbox message of kind for recipient, to be
+// written in language.
+func newMessage(kind, recipient, language string, data EmailData) models.OutboxMessage {
+	// EmailData always marshals.
+	payload, _ := json.Marshal(data)
+	return models.OutboxMessage{Kind: kind, Channel: models.ChannelEmail, Recipient: recipient, Language: language, Payload: payload}
+}
+
+// InAppNotification puts a notification of kind in user's in-app inbox. Its
+// summary is the subject of the email for kind, in the user's language.
+func InAppNotification(user models.User, kind string, data EmailData) models.OutboxMessage {
+	m := newMessage(kind, strconv.FormatInt(user.ID, 10), user.Language, data)
+	m.Channel = models.ChannelInApp
+	return m
+}
+
+// ComplaintNotifications tells owner about their complaint the way they
+// asked to be told: in their in-app inbox unless they want nothing at all,
+// by text at once if they chose SMS, and by email at once or in their
+// daily digest if they chose email. data describes the complaint; the
+// complaint ID is filled in when the notifications are stored with the
+// change.
+func ComplaintNotifications(owner models.User, event string, data EmailData) []models.OutboxMessage {
+	if owner.NotifyChannel == models.NotifyNone {
+		return nil
+	}
+	data.Name = owner.Name
+	messages := []models.OutboxMessage{InAppNotification(owner, event, data)}
+	if phone := owner.SMSPhone(); owner.NotifyChannel == models.NotifyBySMS && phone != "" {
+		m := newMessage(event, phone, owner.Language, data)
+		m.Channel = models.ChannelSMS
+		return append(messages, m)
+	}
+	// Without a verified number, SMS falls back to email.
+	if owner.NotifyChannel != models.NotifyInApp {
+		m := newMessage(event, owner.Email, owner.Language, data)
+		if owner.NotifyFrequency == models.NotifyDaily {
+			m.Status = models.OutboxHeld
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// ComplaintUpdateNotifications tells owner an official updated their
+// complaint, setting it to status, or leaving its status as it is if status
+// is empty.
+func ComplaintUpdateNotifications(owner models.User, complaint models.Complaint, official, status, comment string) []models.OutboxMessage {
+	event := NotifyComplaintUpdated
+	if status == models.StatusResolved {
+		event = NotifyComplaintResolved
+	}
+	if status == "" {
+		status = complaint.Status
+	}
+	return ComplaintNotifications(owner, event, EmailData{Title: complaint.Title, Status: status, Comment: comment, Official: official})
+}
+
+// AssignedNotifications tells owner their complaint was assigned, or
+// escalated, to official.
+func AssignedNotifications(owner models.User, complaint models.Complaint, official string) []models.OutboxMessage {
+	return ComplaintNotifications(owner, NotifyComplaintAssigned, EmailData{Title: complaint.Title, Status: complaint.Status, Official: official})
+}
+
+// ReplyNotifications tells the staff following complaint, in their in-app
+// inboxes, that its owner, citizen, replied with comment.
+func ReplyNotifications(complaints repository.ComplaintRepository, users repository.UserRepository, complaint models.Complaint, citizen, comment string) ([]models.OutboxMessage, error) {
+	ids, err := complaints.Staff(complaint.ID)
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.OutboxMessage
+	for _, id := range ids {
+		user, err := users.GetByID(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !user.IsActive {
+			continue
+		}
+		messages = append(messages, InAppNotification(user, NotifyComplaintReplied,
+			EmailData{Name: user.Name, Title: complaint.Title, Status: complaint.Status, Comment: comment, Citizen: citizen}))
+	}
+	return messages, nil
+}
+
+// AssignmentNotification tells assignee, in their in-app inbox, that
+// official gave them complaint, filed by citizen. reason is why the
+// complaint was escalated to them, or empty for a plain assignment.
+func AssignmentNotification(assignee models.User, complaint models.Complaint, official, citizen, reason string) models.OutboxMessage {
+	kind := NotifyStaffAssigned
+	if reason != "" {
+		kind = NotifyStaffEscalated
+	}
+	return InAppNotification(assignee, kind,
+		EmailData{Name: assignee.Name, Title: complaint.Title, Status: complaint.Status, Official: official, Citizen: citizen, Comment: reason})
+}
+
+// digestOf sums up one recipient's held notifications, oldest first, in a
+// single email.
+func digestOf(held []models.OutboxMessage) models.OutboxMessage {
+	var digest EmailData
+	language := models.DefaultLanguage
+	for _, m := range held {
+		var data EmailData
+		if json.Unmarshal(m.Payload, &data) != nil {
+			continue
+		}
+		item := DigestItem{Event: m.Kind, Title: data.Title, Status: data.Status, Comment: data.Comment, Official: data.Official, At: m.CreatedAt}
+		if m.ComplaintID != nil {
+			item.ComplaintID = *m.ComplaintID
+		}
+		digest.Items = append(digest.Items, item)
+		digest.Name, language = data.Name, m.Language
+	}
+	return newMessage(NotifyComplaintDigest, held[0].Recipient, language, digest)
+}
+
+// AccountLockedEmail tells an account owner their account has been locked.
+func AccountLockedEmail(user models.User, lockedUntil time.Time) models.OutboxMessage {
+	return newMessage(NotifyAccountLocked, user.Email, user.Language, EmailData{Name: user.Name, LockedUntil: &lockedUntil})
+}
+
+// StaffInviteEmail invites a new staff member to set their password. The
+// token is dropped from the outbox once the email has been sent.
+func StaffInviteEmail(to, name, role, token string) models.OutboxMessage {
+	return newMessage(NotifyStaffInvite, to, models.DefaultLanguage, EmailData{Name: name, Role: role, Token: token})
+}
+
+// PasswordResetEmail sends a link for choosing a new password.
+func PasswordResetEmail(user models.User, token string) models.OutboxMessage {
+	return newMessage(NotifyPasswordReset, user.Email, user.Language, EmailData{Name: user.Name, Token: token})
+}
+
+// Enqueue adds messages to the outbox and wakes a worker to deliver them.
+// Messages that belong with a database change should be written in its
+// transaction instead, followed by a call to Wake.
+func (n *Notifier) Enqueue(messages ...models.OutboxMessage) error {
+	if err := n.Outbox.Enqueue(messages); err != nil {
+		return err
+	}
+	n.Wake()
+	return nil
+}
+
+// Wake makes an idle worker look for due messages now rather than at its
+// next poll.
+func (n *Notifier) Wake() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver sends one message.
+func (n *Notifier) Deliver(m models.OutboxMessage) error {
+	if m.Channel == models.ChannelWebhook {
+		return n.Webhooks.Deliver(m)
+	}
+	var data EmailData
+	if err := json.Unmarshal(m.Payload, &data); err != nil {
+		return fmt.Errorf("%w: bad payload", errPermanent)
+	}
+	switch m.Channel {
+	case models.ChannelEmail:
+		return n.deliverEmail(m, data)
+	case models.ChannelInApp:
+		return n.deliverInApp(m, data)
+	case models.ChannelSMS:
+		return n.deliverSMS(m, data)
+	}
+	return fmt.Errorf("%w: unknown channel %q", errPermanent, m.Channel)
+}
+
+// deliverInApp adds a message to its recipient's inbox.
+func (n *Notifier) deliverInApp(m models.OutboxMessage, data EmailData) error {
+	userID, err := strconv.ParseInt(m.Recipient, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: recipient is not a user ID", errPermanent)
+	}
+	if m.ComplaintID != nil {
+		data.ComplaintID = *m.ComplaintID
+	}
+	data.AppURL = n.Mailer.AppURL
+	email, err := n.Mailer.Templates.Render(m.Kind, m.Language, data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	return n.Inbox.Create(&models.Notification{
+		UserID:      userID,
+		Kind:        m.Kind,
+		ComplaintID: m.ComplaintID,
+		Summary:     email.Subject,
+		Data:        m.Payload,
+		OutboxID:    &m.ID,
+	})
+}
+
+// deliverSMS texts a message: the subject of the email for its kind, in
+// the recipient's language, and a link to the complaint.
+func (n *Notifier) deliverSMS(m models.OutboxMessage, data EmailData) error {
+	if n.SMS == nil {
+		return fmt.Errorf("%w: %v", errPermanent, ErrSMSUnavailable)
+	}
+	if m.ComplaintID != nil {
+		data.ComplaintID = *m.ComplaintID
+	}
+	data.AppURL = n.Mailer.AppURL
+	email, err := n.Mailer.Templates.Render(m.Kind, m.Language, data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	body := SMSText(email.Subject)
+	if m.ComplaintID != nil {
+		body += " " + data.ComplaintURL()
+	}
+	return n.SMS.SendSMS(m.Recipient, body)
+}
+
+// deliverEmail emails a message.
+func (n *Notifier) deliverEmail(m models.OutboxMessage, data EmailData) error {
+	switch m.Kind {
+	case NotifyComplaintCreated, NotifyComplaintAssigned, NotifyComplaintUpdated, NotifyComplaintResolved:
+		if m.ComplaintID == nil {
+			return fmt.Errorf("%w: no complaint", errPermanent)
+		}
+		data.ComplaintID = *m.ComplaintID
+	case NotifyComplaintDigest:
+		if len(data.Items) == 0 {
+			return fmt.Errorf("%w: empty digest", errPermanent)
+		}
+	case NotifyStaffDigest:
+		if data.StaffDigest == nil {
+			return fmt.Errorf("%w: empty digest", errPermanent)
+		}
+	case NotifyStaffInvite, NotifyPasswordReset:
+		if data.Token == "" {
+			// Already sent once; the token is gone.
+			return fmt.Errorf("%w: no token", errPermanent)
+		}
+	}
+	err := n.Mailer.Send(m.Kind, m.Language, m.Recipient, data)
+	if errors.Is(err, ErrUnknownTemplate) {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	return err
+}
+
+// RetryDelay is the wait after the given failed attempt, counting from 1:
+// RetryBase doubled for each earlier failure, at most RetryMax, plus up to
+// 10% so retries after an outage do not all arrive at once.
+func (n *Notifier) RetryDelay(attempt int) time.Duration {
+	delay := n.Config.RetryBase
+	for i := 1; i < attempt && delay < n.Config.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > n.Config.RetryMax {
+		delay = n.Config.RetryMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// process delivers a claimed message and records the outcome.
+func (n *Notifier) process(m models.OutboxMessage) {
+	err := n.Deliver(m)
+	if err == nil {
+		if err := n.Outbox.MarkSent(m.ID); err != nil {
+			fmt.Printf("Failed to mark notification %d as sent: %v\n", m.ID, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if !errors.Is(err, errPermanent) && m.Attempts < n.Config.MaxAttempts {
+		at := time.Now().Add(n.RetryDelay(m.Attempts))
+		retryAt = &at
+		fmt.Printf("Failed to deliver %s notification %d (attempt %d), retrying at %s: %v\n",
+			m.Kind, m.ID, m.Attempts, at.Format(time.RFC3339), err)
+	} else {
+		fmt.Printf("Giving up on %s notification %d after %d attempts: %v\n", m.Kind, m.ID, m.Attempts, err)
+	}
+	if err := n.Outbox.MarkFailed(m.ID, err.Error(), retryAt); err != nil {
+		fmt.Printf("Failed to record failure of notification %d: %v\n", m.ID, err)
+	}
+}
+
+// work claims and delivers messages one at a time until there are none due,
+// then waits to be woken or for the next poll.
+func (n *Notifier) work() {
+	for {
+		messages, err := n.Outbox.Claim(1, deliveryLease)
+		if err != nil {
+			fmt.Printf("Failed to claim notifications: %v\n", err)
+		}
+		if len(messages) == 0 {
+			select {
+			case <-n.wake:
+			case <-time.After(n.Config.PollInterval):
+			}
+			continue
+		}
+		n.process(messages[0])
+	}
+}
+
+// SendDigests queues a digest for everyone with held notifications.
+func (n *Notifier) SendDigests() (int, error) {
+	digests, err := n.Outbox.ReleaseDigests(digestOf)
+	if digests > 0 {
+		n.Wake()
+	}
+	return digests, err
+}
+
+// nextDigest is the first DigestHour, in UTC, after now.
+func (n *Notifier) nextDigest(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), n.Config.DigestHour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// RunDigests sends the daily digests at DigestHour every day. It never
+// returns, so run it in its own goroutine.
+func (n *Notifier) RunDigests() {
+	for {
+		time.Sleep(time.Until(n.nextDigest(time.Now())))
+		digests, err := n.SendDigests()
+		if err != nil {
+			fmt.Printf("Failed to send daily digests: %v\n", err)
+			continue
+		}
+		fmt.Printf("Queued %d daily digests\n", digests)
+	}
+}
+
+// Run starts Workers workers and never returns, so run it in its own
+// goroutine. Several processes may run workers on the same outbox.
+func (n *Notifier) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < n.Config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.work()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRequest is a request a test webhook received.
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookServer starts a webhook receiver that answers with statuses in
+// turn, then with 204, and a webhook posting to it. Outbox messages are
+// retried 50ms after a failure.
+func (env *testEnv) newWebhookServer(t *testing.T, statuses ...int) (models.Webhook, func() []webhookRequest) {
+	t.Helper()
+	var (
+		mutex    sync.Mutex
+		received []webhookRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, webhookRequest{header: r.Header, body: body})
+		status := http.StatusNoContent
+		if len(received) <= len(statuses) {
+			status = statuses[len(received)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	// The test server is on loopback, which webhooks may not reach.
+	env.Notifier.Webhooks.Client = newWebhookClient(nil)
+	env.Notifier.Config.RetryBase, env.Notifier.Config.RetryMax = 50*time.Millisecond, 50*time.Millisecond
+	hook := models.Webhook{URL: server.URL, Secret: "webhook-secret", IsActive: true, Events: []string{EventComplaintCreated}}
+	if err := env.Webhooks.Create(&hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook, func() []webhookRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]webhookRequest(nil), received...)
+	}
+}
+
+// fileWithWebhooks files a public complaint with the webhook messages about
+// it, as the complaint handler does, and returns the complaint and the ID
+// of the message.
+func (env *testEnv) fileWithWebhooks(t *testing.T) (models.Complaint, int64) {
+	t.Helper()
+	complaint := models.Complaint{Title: "Pothole", Category: 1, IsPublic: true}
+	messages, err := env.Notifier.Webhooks.Messages(EventComplaintCreated, complaint, "", "")
+	if err != nil || len(messages) == 0 {
+		t.Fatalf("webhook messages %+v, %v", messages, err)
+	}
+	complaint, err = env.Complaints.Create(1, models.CreateComplaintRequest{Title: complaint.Title, Category: 1, IsPublic: true}, models.StatusPending, nil, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := env.Outbox.List(repository.OutboxFilter{ComplaintID: complaint.ID})
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("queued %+v, %v; want one webhook message", queued, err)
+	}
+	return complaint, queued[0].ID
+}
+
+// waitForRetry sleeps until messages that failed are due again.
+func waitForRetry() {
+	time.Sleep(80 * time.Millisecond)
+}
+
+func TestWebhookDeliveryRetriedUntilAccepted(t *testing.T) {
+	env := newTestEnv(t)
+	hook, received := env.newWebhookServer(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	complaint, id := env.fileWithWebhooks(t)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if delivered := env.deliverDue(t); delivered != 1 {
+			t.Fatalf("attempt %d: delivered %d messages, want 1", attempt, delivered)
+		}
+		m, _ := env.Outbox.Get(id)
+		want := models.OutboxPending
+		if attempt == 3 {
+			want = models.OutboxSent
+		}
+		if m.Status != want || m.Attempts != attempt {
+			t.Fatalf("after attempt %d: %s with %d attempts, want %s", attempt, m.Status, m.Attempts, want)
+		}
+		if attempt < 3 {
+			if m.LastError == "" || !m.NextAttemptAt.After(time.Now()) {
+				t.Fatalf("after attempt %d: error %q, next attempt at %v", attempt, m.LastError, m.NextAttemptAt)
+			}
+			if env.deliverDue(t) != 0 {
+				t.Fatal("a failed message was retried at once")
+			}
+			waitForRetry()
+		}
+	}
+
+	requests := received()
+	if len(requests) != 3 {
+		t.Fatalf("webhook received %d requests, want 3", len(requests))
+	}
+	for i, r := range requests {
+		if r.header.Get(WebhookDeliveryHeader) != strconv.FormatInt(id, 10) || r.header.Get(WebhookEventHeader) != EventComplaintCreated {
+			t.Errorf("request %d headers %v", i+1, r.header)
+		}
+		timestamp, _, _ := strings.Cut(strings.TrimPrefix(r.header.Get(WebhookSignatureHeader), "t="), ",")
+		unix, _ := strconv.ParseInt(timestamp, 10, 64)
+		if r.header.Get(WebhookSignatureHeader) != SignWebhook(hook.Secret, unix, r.body) {
+			t.Errorf("request %d signature %q does not match its body", i+1, r.header.Get(WebhookSignatureHeader))
+		}
+		var body webhookBody
+		if err := json.Unmarshal(r.body, &body); err != nil || body.Data.Complaint == nil || body.Data.Complaint.ID != complaint.ID {
+			t.Errorf("request %d body %s, %v", i+1, r.body, err)
+		}
+	}
+	if deliveries, _ := env.Webhooks.ListDeliveries(hook.ID, 10, 0); len(deliveries) != 3 {
+		t.Errorf("logged %d deliveries, want 3", len(deliveries))
+	}
+}
+
+func TestDeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+	env := newTestEnv(t)
+	hook, received := env.newWebhookServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	_, id := env.fileWithWebhooks(t)
+	for attempt := 1; attempt <= env.Notifier.Config.MaxAttempts; attempt++ {
+		env.deliverDue(t)
+		waitForRetry()
+	}
+	if m, _ := env.Outbox.Get(id); m.Status != models.OutboxDead || m.Attempts != env.Notifier.Config.MaxAttempts {
+		t.Fatalf("message %s after %d attempts, want dead after %d", m.Status, m.Attempts, env.Notifier.Config.MaxAttempts)
+	}
+	if env.deliverDue(t) != 0 || len(received()) != env.Notifier.Config.MaxAttempts {
+		t.Fatal("a dead message was delivered again")
+	}
+
+	// Failures retrying cannot fix are given up on at once.
+	_, id = env.fileWithWebhooks(t)
+	hook.IsActive = false
+	if err := env.Webhooks.Update(&hook); err != nil {
+		t.Fatal(err)
+	}
+	env.deliverDue(t)
+	if m, _ := env.Outbox.Get(id); m.Status != models.OutboxDead || m.Attempts != 1 {
+		t.Fatalf("message to a disabled webhook %s after %d attempts, want dead after 1", m.Status, m.Attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	n := newTestEnv(t).Notifier
+	n.Config.RetryBase, n.Config.RetryMax = time.Minute, time.Hour
+	for attempt, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 7: time.Hour, 30: time.Hour} {
+		// Up to 10% is added so retries spread out.
+		if got := n.RetryDelay(attempt); got < want || got > want+want/10 {
+			t.Errorf("RetryDelay(%d) = %v, want %v to %v", attempt, got, want, want+want/10)
+		}
+	}
+}
+
+func TestComplaintNotificationsBySMS(t *testing.T) {
+	phone := "+919812345678"
+	citizen := models.User{ID: 3, Email: "citizen@example.com", Phone: &phone, PhoneVerified: true,
+		NotifyChannel: models.NotifyBySMS, NotifyFrequency: models.NotifyDaily}
+
+	messages := ComplaintNotifications(citizen, NotifyComplaintUpdated, EmailData{Title: "Pothole"})
+	if len(messages) != 2 || messages[0].Channel != models.ChannelInApp {
+		t.Fatalf("messages %+v, want one in-app and one SMS", messages)
+	}
+	if sms := messages[1]; sms.Channel != models.ChannelSMS || sms.Recipient != phone || sms.Status == models.OutboxHeld {
+		t.Fatalf("SMS %+v, want it sent to %s at once", sms, phone)
+	}
+
+	citizen.PhoneVerified = false
+	messages = ComplaintNotifications(citizen, NotifyComplaintUpdated, EmailData{Title: "Pothole"})
+	if len(messages) != 2 || messages[1].Channel != models.ChannelEmail || messages[1].Recipient != citizen.Email {
+		t.Fatalf("messages without a verified number %+v, want email instead of SMS", messages)
+	}
+}
+
+func TestDeliverSMS(t *testing.T) {
+	env := newTestEnv(t)
+	phone := "+919812345678"
+	citizen := models.User{ID: 3, Phone: &phone, PhoneVerified: true, NotifyChannel: models.NotifyBySMS}
+	complaintID := int64(42)
+	m := ComplaintNotifications(citizen, NotifyComplaintUpdated, EmailData{Title: "Pothole", Status: models.StatusInProgress})[1]
+	m.ComplaintID = &complaintID
+
+	if err := env.Notifier.Deliver(m); err != nil {
+		t.Fatal(err)
+	}
+	sent := env.SMS.Sent()
+	if len(sent) != 1 || sent[0].To != phone {
+		t.Fatalf("sent %+v, want one text to %s", sent, phone)
+	}
+	if body := sent[0].Body; !strings.HasPrefix(body, "Update on complaint #42") || !strings.HasSuffix(body, " https://complaints.example.org/complaints/42") {
+		t.Errorf("text %q, want the subject and a link to the complaint", body)
+	}
+}
+
+func TestDeliverSMSWithoutProvider(t *testing.T) {
+	n := newTestEnv(t).Notifier
+	n.SMS = nil
+	m := newMessage(NotifyComplaintUpdated, "+919812345678", "en", EmailData{Title: "Pothole"})
+	m.Channel = models.ChannelSMS
+	if err := n.Deliver(m); !errors.Is(err, errPermanent) {
+		t.Fatalf("Deliver() = %v, want a permanent failure", err)
+	}
+}
+
+func TestSMSText(t *testing.T) {
+	if got := SMSText("Short"); got != "Short" {
+		t.Errorf("SMSText(short) = %q", got)
+	}
+	long := strings.Repeat("शिकायत ", 40)
+	got := []rune(SMSText(long))
+	if len(got) != smsMaxLength || got[len(got)-1] != '…' {
+		t.Errorf("SMSText(long) has %d runes, want %d ending in …", len(got), smsMaxLength)
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"complain/internal/repository"
+	"sync"
+	"time"
+)
+
+// Permission names. Routes are guarded by these instead of role names; roles
+// are just named bundles of permissions stored in the database.
+const (
+	PermComplaintCreate      = "complaint.create"
+	PermComplaintViewOwn     = "complaint.view_own"
+	PermComplaintReply       = "complaint.reply"
+	PermComplaintViewAll     = "complaint.view_all"
+	PermComplaintViewPrivate = "complaint.view_private"
+	PermComplaintUpdate      = "complaint.update"
+	PermComplaintAssign      = "complaint.assign"
+	PermComplaintScopeAll    = "complaint.scope_all"
+	PermUserView             = "user.view"
+	PermUserManage           = "user.manage"
+	PermRoleManage           = "role.manage"
+	PermDepartmentManage     = "department.manage"
+	PermAuditView            = "audit.view"
+	PermNotificationManage   = "notification.manage"
+	PermTemplateManage       = "template.manage"
+	PermWebhookManage        = "webhook.manage"
+)
+
+// PermissionInfo describes a permission for the admin UI.
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AllPermissions is the catalogue of permissions the code knows how to enforce.
+var AllPermissions = []PermissionInfo{
+	{PermComplaintCreate, "File new complaints"},
+	{PermComplaintViewOwn, "View complaints you filed"},
+	{PermComplaintReply, "Reply on complaints you filed"},
+	{PermComplaintViewAll, "View and filter all complaints"},
+	{PermComplaintViewPrivate, "See complaints that are not marked public"},
+	{PermComplaintUpdate, "Post updates and change complaint status"},
+	{PermComplaintAssign, "Assign complaints to officials"},
+	{PermComplaintScopeAll, "Work on complaints outside your own departments and districts"},
+	{PermUserView, "List users and officials"},
+	{PermUserManage, "Change user roles and unlock accounts"},
+	{PermRoleManage, "Define roles and their permissions"},
+	{PermDepartmentManage, "Manage departments and officials' jurisdictions"},
+	{PermAuditView, "Read, export and verify the audit log"},
+	{PermNotificationManage, "Inspect and retry outgoing notifications"},
+	{PermTemplateManage, "Edit and preview email templates"},
+	{PermWebhookManage, "Manage outbound webhooks"},
+}
+
+// IsKnownPermission reports whether name is in AllPermissions.
+func IsKnownPermission(name string) bool {
+	for _, p := range AllPermissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type roleEntry struct {
+	requiresMFA bool
+	permissions map[string]bool
+}
+
+// RoleStore resolves roles to permissions. Roles change rarely, so the whole
+// table is cached for a short time and reloaded on demand.
+type RoleStore struct {
+	Roles       repository.RoleRepository
+	cache       map[string]roleEntry
+	cacheExpiry time.Time
+	mutex       sync.Mutex
+}
+
+func NewRoleStore(roles repository.RoleRepository) *RoleStore {
+	return &RoleStore{Roles: roles}
+}
+
+// roleCacheTTL bounds how stale another replica's view of the roles can be.
+const roleCacheTTL = time.Minute
+
+func (s *RoleStore) load() (map[string]roleEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cache != nil && time.Now().Before(s.cacheExpiry) {
+		return s.cache, nil
+	}
+
+	roles, err := s.Roles.List()
+	if err != nil {
+		return nil, err
+	}
+	cache := make(map[string]roleEntry, len(roles))
+	for _, r := range roles {
+		entry := roleEntry{requiresMFA: r.RequiresMFA, permissions: map[string]bool{}}
+		for _, perm := range r.Permissions {
+			entry.permissions[perm] = true
+		}
+		cache[r.Name] = entry
+	}
+
+	s.cache = cache
+	s.cacheExpiry = time.Now().Add(roleCacheTTL)
+	return cache, nil
+}
+
+// Permissions returns the set of permissions granted to role.
+func (s *RoleStore) Permissions(role string) (map[string]bool, error) {
+	cache, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return cache[role].permissions, nil
+}
+
+// Exists reports whether role is defined.
+func (s *RoleStore) Exists(role string) (bool, error) {
+	cache, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	_, ok := cache[role]
+	return ok, nil
+}
+
+// RequiresMFA reports whether users with this role must log in with a second factor.
+func (s *RoleStore) RequiresMFA(role string) (bool, error) {
+	cache, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	return cache[role].requiresMFA, nil
+}
+
+// Invalidate drops the cache after roles are changed.
+func (s *RoleStore) Invalidate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cache = nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// phoneCodeTTL is how long a texted code can be used.
+	phoneCodeTTL = 10 * time.Minute
+	// phoneCodeResend is how long to wait before texting another code.
+	phoneCodeResend = time.Minute
+	// phoneCodeAttempts is how many guesses one code allows.
+	phoneCodeAttempts = 5
+)
+
+var (
+	// ErrSMSUnavailable is returned when no SMS provider is configured.
+	ErrSMSUnavailable = errors.New("SMS is not available")
+	// ErrInvalidPhone is returned for numbers not in E.164 format.
+	ErrInvalidPhone = errors.New("phone number must be in international format, e.g. +919812345678")
+	// ErrCodeRecentlySent is returned when a code was texted less than
+	// phoneCodeResend ago.
+	ErrCodeRecentlySent = errors.New("a code was sent recently, please wait before asking for another")
+	// ErrInvalidCode is returned for wrong, expired or used-up codes.
+	ErrInvalidCode = errors.New("invalid or expired code")
+)
+
+// PhoneVerifier proves users can receive texts at a number by sending it a
+// one-time code.
+type PhoneVerifier struct {
+	Verifications repository.PhoneVerificationRepository
+	Users         repository.UserRepository
+	// SMS is nil if no provider is configured.
+	SMS SMSSender
+}
+
+func NewPhoneVerifier(verifications repository.PhoneVerificationRepository, users repository.UserRepository, sms SMSSender) *PhoneVerifier {
+	return &PhoneVerifier{Verifications: verifications, Users: users, SMS: sms}
+}
+
+// newPhoneCode returns a random six-digit code.
+func newPhoneCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Start texts a code to phone for user to confirm it with.
+func (v *PhoneVerifier) Start(user models.User, phone string) error {
+	if v.SMS == nil {
+		return ErrSMSUnavailable
+	}
+	if !ValidPhone(phone) {
+		return ErrInvalidPhone
+	}
+	pending, err := v.Verifications.Get(user.ID)
+	if err == nil && time.Since(pending.CreatedAt) < phoneCodeResend {
+		return ErrCodeRecentlySent
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	code, err := newPhoneCode()
+	if err != nil {
+		return err
+	}
+	verification := models.PhoneVerification{
+		UserID:    user.ID,
+		Phone:     phone,
+		CodeHash:  HashOpaqueToken(code),
+		ExpiresAt: time.Now().Add(phoneCodeTTL),
+	}
+	if err := v.Verifications.Save(&verification); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(phoneCodeTTL.Minutes()))
+	if err := v.SMS.SendSMS(phone, body); err != nil {
+		v.Verifications.Delete(user.ID)
+		return err
+	}
+	return nil
+}
+
+// Confirm checks code against the user's pending verification and, if it
+// matches, makes the number theirs, verified. It returns the number.
+func (v *PhoneVerifier) Confirm(userID int64, code string) (string, error) {
+	pending, err := v.Verifications.Get(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", ErrInvalidCode
+	}
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(pending.ExpiresAt) {
+		return "", ErrInvalidCode
+	}
+	attempts, err := v.Verifications.RecordAttempt(userID)
+	if err != nil {
+		return "", err
+	}
+	if attempts > phoneCodeAttempts {
+		return "", ErrInvalidCode
+	}
+	hash := HashOpaqueToken(strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(pending.CodeHash)) != 1 {
+		return "", ErrInvalidCode
+	}
+
+	if err := v.Users.SetPhone(userID, pending.Phone, true); err != nil {
+		return "", err
+	}
+	if err := v.Verifications.Delete(userID); err != nil {
+		fmt.Printf("Failed to remove used phone verification of user %d: %v\n", userID, err)
+	}
+	return pending.Phone, nil
+}
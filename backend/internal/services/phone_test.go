@@ -0,0 +1,105 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// textedCode returns the code in the last text the fake sender sent.
+func (env *testEnv) textedCode(t *testing.T) string {
+	t.Helper()
+	sent := env.SMS.Sent()
+	if len(sent) == 0 {
+		t.Fatal("no code was texted")
+	}
+	code := regexp.MustCompile(`\b[0-9]{6}\b`).FindString(sent[len(sent)-1].Body)
+	if code == "" {
+		t.Fatalf("no code in %q", sent[len(sent)-1].Body)
+	}
+	return code
+}
+
+func newTestPhoneVerifier(t *testing.T) (*testEnv, *PhoneVerifier, models.User) {
+	t.Helper()
+	env := newTestEnv(t)
+	user := env.user(t, models.User{Name: "Citizen", Email: "citizen@example.com"})
+	return env, NewPhoneVerifier(repository.NewMemoryPhoneVerificationRepository(), env.Users, env.SMS), user
+}
+
+func TestPhoneVerification(t *testing.T) {
+	env, v, user := newTestPhoneVerifier(t)
+	const phone = "+919812345678"
+
+	if err := v.Start(user, "98123 45678"); !errors.Is(err, ErrInvalidPhone) {
+		t.Fatalf("Start() with a local number: %v, want ErrInvalidPhone", err)
+	}
+	if err := v.Start(user, phone); err != nil {
+		t.Fatal(err)
+	}
+	if sent := env.SMS.Sent(); len(sent) != 1 || sent[0].To != phone {
+		t.Fatalf("sent %+v, want a code to %s", sent, phone)
+	}
+	code := env.textedCode(t)
+	if err := v.Start(user, phone); !errors.Is(err, ErrCodeRecentlySent) {
+		t.Fatalf("Start() again at once: %v, want ErrCodeRecentlySent", err)
+	}
+
+	if _, err := v.Confirm(user.ID, "000000"+code); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("Confirm() with a wrong code: %v, want ErrInvalidCode", err)
+	}
+	if got, err := v.Confirm(user.ID, " "+code+" "); err != nil || got != phone {
+		t.Fatalf("Confirm() = %q, %v", got, err)
+	}
+	if user, _ := env.Users.GetByID(user.ID); user.SMSPhone() != phone {
+		t.Fatalf("user's phone %v, verified %v", user.Phone, user.PhoneVerified)
+	}
+	if _, err := v.Confirm(user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("Confirm() with a used code: %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestPhoneCodeGuessesAreLimited(t *testing.T) {
+	env, v, user := newTestPhoneVerifier(t)
+	if err := v.Start(user, "+919812345678"); err != nil {
+		t.Fatal(err)
+	}
+	code := env.textedCode(t)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < phoneCodeAttempts; i++ {
+		if _, err := v.Confirm(user.ID, wrong); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("guess %d: %v, want ErrInvalidCode", i+1, err)
+		}
+	}
+	if _, err := v.Confirm(user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("right code after %d guesses: %v, want ErrInvalidCode", phoneCodeAttempts, err)
+	}
+	if user, _ := env.Users.GetByID(user.ID); user.Phone != nil {
+		t.Fatalf("phone %v set after too many guesses", *user.Phone)
+	}
+}
+
+func TestExpiredPhoneCode(t *testing.T) {
+	_, v, user := newTestPhoneVerifier(t)
+	expired := models.PhoneVerification{UserID: user.ID, Phone: "+919812345678", CodeHash: HashOpaqueToken("123456"), ExpiresAt: time.Now().Add(-time.Second)}
+	if err := v.Verifications.Save(&expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Confirm(user.ID, "123456"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("Confirm() with an expired code: %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestPhoneVerificationWithoutProvider(t *testing.T) {
+	_, v, user := newTestPhoneVerifier(t)
+	v.SMS = nil
+	if err := v.Start(user, "+919812345678"); !errors.Is(err, ErrSMSUnavailable) {
+		t.Fatalf("Start() without a provider: %v, want ErrSMSUnavailable", err)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReplyAddresses makes the Reply-To addresses of complaint emails. Each is
+// the reply mailbox tagged with the complaint and a MAC of the complaint
+// and the address the email went to, so a reply can only be attributed to
+// the complaint from the address it was sent to.
+type ReplyAddresses struct {
+	// Address is the reply mailbox, e.g. replies@example.org.
+	Address string
+	secret  []byte
+}
+
+// NewReplyAddresses returns nil if address is empty, which turns replies
+// off.
+func NewReplyAddresses(address, secret string) *ReplyAddresses {
+	if address == "" {
+		return nil
+	}
+	return &ReplyAddresses{Address: address, secret: []byte(secret)}
+}
+
+// mac ties complaintID to email. Mail servers may change the case of the
+// local part, so it is lowercase hex.
+func (r *ReplyAddresses) mac(complaintID int64, email string) string {
+	h := hmac.New(sha256.New, r.secret)
+	fmt.Fprintf(h, "reply:%d:%s", complaintID, strings.ToLower(email))
+	return hex.EncodeToString(h.Sum(nil))[:24]
+}
+
+// For is the address for email's owner to reply to about complaintID.
+func (r *ReplyAddresses) For(complaintID int64, email string) string {
+	local, domain, _ := strings.Cut(r.Address, "@")
+	return fmt.Sprintf("%s+%d-%s@%s", local, complaintID, r.mac(complaintID, email), domain)
+}
+
+// Complaint returns the complaint recipient is the reply address of, if
+// it was made for sender.
+func (r *ReplyAddresses) Complaint(recipient, sender string) (int64, bool) {
+	local, domain, _ := strings.Cut(r.Address, "@")
+	rLocal, rDomain, ok := strings.Cut(recipient, "@")
+	if !ok || !strings.EqualFold(rDomain, domain) {
+		return 0, false
+	}
+	tag, ok := strings.CutPrefix(strings.ToLower(rLocal), strings.ToLower(local)+"+")
+	if !ok {
+		return 0, false
+	}
+	id, mac, ok := strings.Cut(tag, "-")
+	if !ok {
+		return 0, false
+	}
+	complaintID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return complaintID, hmac.Equal([]byte(mac), []byte(r.mac(complaintID, sender)))
+}
+
+// replyMaxLength bounds the comment a reply becomes.
+const replyMaxLength = 10000
+
+var (
+	// quoteHeaderPattern matches the line mail clients put above the quoted
+	// message, such as "On Mon, 3 Feb 2025, Asha <a@b.in> wrote:".
+	quoteHeaderPattern = regexp.MustCompile(`(?i)^(on\s.+|.+\s)(wrote|writes|a écrit|schrieb|escribió|ने लिखा):\s*$`)
+	// quoteSeparatorPattern matches the separators Outlook and others put
+	// above the quoted message.
+	quoteSeparatorPattern = regexp.MustCompile(`(?i)^(-{2,}\s*(original message|forwarded message)\s*-{2,}|_{10,})$`)
+	// quoteFromPattern and quoteSentPattern match the header block Outlook
+	// quotes the message with.
+	quoteFromPattern = regexp.MustCompile(`(?i)^\*?from:\*?\s`)
+	quoteSentPattern = regexp.MustCompile(`(?i)^\*?(sent|date|to|subject):\*?\s`)
+	// signaturePattern matches the usual signature delimiters and the lines
+	// phones add.
+	signaturePattern = regexp.MustCompile(`(?i)^(--\s*|sent from my .+|get outlook for .+)$`)
+
+	htmlBreakPattern = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr|/h[1-6])\b[^>]*>`)
+	htmlTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+	// htmlQuotePattern matches where clients start the quoted message in
+	// HTML replies.
+	htmlQuotePattern  = regexp.MustCompile(`(?i)<blockquote|<div[^>]+class="?[^">]*(gmail_quote|moz-cite-prefix|yahoo_quoted)|<div[^>]+id="?(appendonsend|divRplyFwdMsg)`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// StripReply returns what the sender of a reply wrote themselves: the
+// text above the quoted message and above their signature.
+func StripReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	end := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		next := ""
+		if i+1 < len(lines) {
+			next = strings.TrimSpace(lines[i+1])
+		}
+		if strings.HasPrefix(trimmed, ">") ||
+			quoteHeaderPattern.MatchString(trimmed) ||
+			// The header line is often wrapped in two.
+			(next != "" && quoteHeaderPattern.MatchString(trimmed+" "+next) && strings.HasPrefix(strings.ToLower(trimmed), "on ")) ||
+			quoteSeparatorPattern.MatchString(trimmed) ||
+			(quoteFromPattern.MatchString(trimmed) && quoteSentPattern.MatchString(next)) ||
+			signaturePattern.MatchString(line) {
+			end = i
+			break
+		}
+	}
+	reply := strings.TrimSpace(strings.Join(lines[:end], "\n"))
+	reply = blankLinesPattern.ReplaceAllString(reply, "\n\n")
+	if runes := []rune(reply); len(runes) > replyMaxLength {
+		reply = string(runes[:replyMaxLength])
+	}
+	return reply
+}
+
+// htmlToText turns an HTML reply into plain text, dropping the quoted
+// message.
+func htmlToText(body string) string {
+	if loc := htmlQuotePattern.FindStringIndex(body); loc != nil {
+		body = body[:loc[0]]
+	}
+	body = htmlBreakPattern.ReplaceAllString(body, "\n")
+	body = htmlTagPattern.ReplaceAllString(body, "")
+	lines := strings.Split(html.UnescapeString(body), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(strings.ReplaceAll(lines[i], " ", " "))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"bytes"
+	"complain/internal/config"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"complain/internal/storage"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"sync"
+	"time"
+)
+
+// Errors for resumable uploads. Uploads that do not exist, or belong to
+// someone else, give repository.ErrNotFound.
+var (
+	ErrOffsetMismatch   = errors.New("upload offset does not match")
+	ErrUploadExpired    = errors.New("upload has expired")
+	ErrUploadIncomplete = errors.New("upload is not complete")
+	ErrUploadTooLarge   = errors.New("upload is larger than allowed")
+)
+
+// ResumableUploads receives files in any number of requests, so large videos
+// survive flaky mobile connections. Bytes are streamed into a multipart
+// upload in the object store as they arrive; a finished upload is then
+// attached to a complaint like a file sent with it.
+type ResumableUploads struct {
+	Store   storage.ObjectStore
+	Uploads repository.UploadRepository
+	// PartSize is the size of the parts stored; bytes short of a part are
+	// kept in a pending object between requests.
+	PartSize int64
+	// MaxSize is the largest upload accepted.
+	MaxSize int64
+	// Expiry is how long an upload is kept after its last request.
+	Expiry time.Duration
+
+	// locks holds a *sync.Mutex per upload ID, so requests for one upload
+	// are handled one at a time by this instance. Across instances the
+	// database decides: see Uploads.Advance and Uploads.ClaimExpired.
+	locks sync.Map
+}
+
+// NewResumableUploads creates a new service for resumable uploads to store.
+func NewResumableUploads(store storage.ObjectStore, uploads repository.UploadRepository, limits config.AttachmentConfig) *ResumableUploads {
+	return &ResumableUploads{
+		Store:    store,
+		Uploads:  uploads,
+		PartSize: limits.UploadPartSize,
+		MaxSize:  limits.MaxFileSize,
+		Expiry:   limits.UploadExpiry,
+	}
+}
+
+// Start creates an upload of length bytes for userID.
+func (s *ResumableUploads) Start(userID int64, length int64, filename, contentType string) (models.Upload, error) {
+	if length <= 0 {
+		return models.Upload{}, errors.New("upload length must be positive")
+	}
+	if length > s.MaxSize {
+		return models.Upload{}, fmt.Errorf("%w: %d bytes is over the %d byte limit", ErrUploadTooLarge, length, s.MaxSize)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return models.Upload{}, err
+	}
+	upload := models.Upload{
+		ID:          hex.EncodeToString(random),
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Length:      length,
+		ExpiresAt:   time.Now().Add(s.Expiry),
+	}
+	upload.ObjectKey = "uploads/" + upload.ID
+
+	var err error
+	upload.MultipartID, err = s.Store.CreateMultipart(upload.ObjectKey, contentType)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if err := s.Uploads.Create(upload); err != nil {
+		s.Store.AbortMultipart(upload.ObjectKey, upload.MultipartID)
+		return models.Upload{}, err
+	}
+	return upload, nil
+}
+
+// Get returns an upload of userID's.
+func (s *ResumableUploads) Get(id string, userID int64) (models.Upload, error) {
+	upload, err := s.Uploads.Get(id)
+	if err != nil {
+		return upload, err
+	}
+	if upload.UserID != userID {
+		return models.Upload{}, repository.ErrNotFound
+	}
+	if upload.ExpiresAt.Before(time.Now()) {
+		return upload, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+func (s *ResumableUploads) lock(id string) func() {
+	mutex, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	return mutex.(*sync.Mutex).Unlock
+}
+
+// pendingKey is where bytes short of a part are kept between requests. The
+// key names the offset the bytes start at, so a request that fails after
+// storing new pending bytes leaves the recorded ones alone.
+func pendingKey(upload models.Upload) string {
+	return fmt.Sprintf("%s.pending.%d", upload.ObjectKey, upload.Offset-upload.PendingSize)
+}
+
+// Append adds the bytes read from body to an upload, which must have
+// received exactly offset bytes so far. Bytes received before body fails are
+// kept, so the client can resume from the returned upload's Offset. Bytes
+// past the upload's length are refused with ErrUploadTooLarge.
+func (s *ResumableUploads) Append(id string, userID int64, offset int64, body io.Reader) (models.Upload, error) {
+	defer s.lock(id)()
+
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return upload, err
+	}
+	if offset != upload.Offset || upload.CompletedAt != nil {
+		return upload, ErrOffsetMismatch
+	}
+
+	// Start from the bytes left over from the last request.
+	buf := make([]byte, 0, s.PartSize)
+	if upload.PendingSize > 0 {
+		pending, _, err := s.Store.Get(pendingKey(upload))
+		if err != nil {
+			return upload, err
+		}
+		buf, err = readInto(buf, pending, upload.PendingSize)
+		pending.Close()
+		if err != nil {
+			return upload, err
+		}
+		if int64(len(buf)) != upload.PendingSize {
+			return upload, fmt.Errorf("pending data of upload %s is incomplete", id)
+		}
+	}
+
+	remaining := upload.Length - upload.Offset
+	limited := &io.LimitedReader{R: body, N: remaining}
+	var parts []models.UploadPart
+	number := len(upload.Parts)
+	var readErr error
+	for {
+		buf, readErr = readInto(buf, limited, s.PartSize-int64(len(buf)))
+		if int64(len(buf)) < s.PartSize || (readErr == nil && limited.N == 0) {
+			// The last part is stored once the upload is complete, so it can
+			// be shorter than the others.
+			break
+		}
+		number++
+		etag, err := s.Store.UploadPart(upload.ObjectKey, upload.MultipartID, number, buf)
+		if err != nil {
+			return upload, err
+		}
+		parts = append(parts, models.UploadPart{Number: number, ETag: etag, Size: int64(len(buf))})
+		buf = buf[:0]
+	}
+
+	// Until the progress is recorded, errors report the upload as it was.
+	next := upload
+	next.Offset += remaining - limited.N
+	next.PendingSize = int64(len(buf))
+	next.ExpiresAt = time.Now().Add(s.Expiry)
+	next.Parts = append(upload.Parts[:len(upload.Parts):len(upload.Parts)], parts...)
+
+	if next.Offset == next.Length {
+		if len(buf) > 0 {
+			number++
+			etag, err := s.Store.UploadPart(next.ObjectKey, next.MultipartID, number, buf)
+			if err != nil {
+				return upload, err
+			}
+			part := models.UploadPart{Number: number, ETag: etag, Size: int64(len(buf))}
+			parts = append(parts, part)
+			next.Parts = append(next.Parts, part)
+		}
+		etags := make([]string, len(next.Parts))
+		for i, part := range next.Parts {
+			etags[i] = part.ETag
+		}
+		if err := s.Store.CompleteMultipart(next.ObjectKey, next.MultipartID, etags); err != nil {
+			return upload, err
+		}
+		now := time.Now()
+		next.CompletedAt = &now
+		next.PendingSize = 0
+	}
+
+	if next.PendingSize > 0 {
+		err := s.Store.Put(pendingKey(next), bytes.NewReader(buf), next.PendingSize, "application/octet-stream")
+		if err != nil {
+			return upload, err
+		}
+	}
+	if err := s.Uploads.Advance(next, upload.Offset, parts); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return upload, ErrOffsetMismatch
+		}
+		return upload, err
+	}
+	if upload.PendingSize > 0 && (next.PendingSize == 0 || pendingKey(next) != pendingKey(upload)) {
+		if err := s.Store.Delete(pendingKey(upload)); err != nil {
+			fmt.Printf("Failed to delete pending data of upload %s: %v\n", upload.ID, err)
+		}
+	}
+	upload = next
+
+	if readErr != nil {
+		return upload, readErr
+	}
+	if limited.N == 0 {
+		// Check for bytes past the declared length.
+		if n, _ := body.Read(make([]byte, 1)); n > 0 {
+			return upload, fmt.Errorf("%w: more than the declared %d bytes were sent", ErrUploadTooLarge, upload.Length)
+		}
+	}
+	return upload, nil
+}
+
+// readInto appends up to n bytes read from r to buf. Running out of bytes is
+// not an error: fewer than n bytes and a nil error means r is exhausted.
+func readInto(buf []byte, r io.Reader, n int64) ([]byte, error) {
+	b := bytes.NewBuffer(buf)
+	_, err := io.CopyN(b, r, n)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return b.Bytes(), err
+}
+
+// Finished returns a complete upload of userID's, ready to be attached.
+func (s *ResumableUploads) Finished(id string, userID int64) (models.Upload, error) {
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return upload, err
+	}
+	if upload.CompletedAt == nil {
+		return upload, ErrUploadIncomplete
+	}
+	return upload, nil
+}
+
+// File returns a finished upload as a file to validate and attach. It is
+// read from the store where it lies, a range at a time where validation
+// only needs a few bytes, and is copied from there when it is stored.
+func (s *ResumableUploads) File(upload models.Upload) File {
+	return File{
+		Filename:  upload.Filename,
+		Size:      upload.Length,
+		ObjectKey: upload.ObjectKey,
+		Open: func() (multipart.File, error) {
+			return &objectFile{store: s.Store, key: upload.ObjectKey, size: upload.Length}, nil
+		},
+	}
+}
+
+// objectFile reads a stored object of size bytes as a multipart.File.
+// Reading streams from one request, started at the current offset; ReadAt
+// requests just the range asked for.
+type objectFile struct {
+	store  storage.ObjectStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *objectFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.store.GetRange(f.key, f.offset, f.size-f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *objectFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= f.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	body, err := f.store.GetRange(f.key, offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *objectFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != f.offset {
+		f.Close()
+		f.offset = offset
+	}
+	return offset, nil
+}
+
+func (f *objectFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// Terminate deletes an upload and everything stored for it.
+func (s *ResumableUploads) Terminate(upload models.Upload) error {
+	defer s.lock(upload.ID)()
+	defer s.locks.Delete(upload.ID)
+
+	if upload.CompletedAt == nil {
+		if err := s.Store.AbortMultipart(upload.ObjectKey, upload.MultipartID); err != nil && !errors.Is(err, storage.ErrUnknownUpload) {
+			return err
+		}
+	}
+	keys := []string{upload.ObjectKey}
+	if upload.PendingSize > 0 {
+		keys = append(keys, pendingKey(upload))
+	}
+	for _, key := range keys {
+		if err := s.Store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return s.Uploads.Delete(upload.ID)
+}
+
+// Collection settings: uploads are claimed in batches of collectBatch, and
+// one that fails to be terminated is tried again after collectLease.
+const (
+	collectBatch = 100
+	collectLease = 10 * time.Minute
+)
+
+// Collect terminates uploads that expired before now and returns how many
+// were terminated. Every API instance collects; each upload is claimed in
+// the database first, so only one of them terminates it. Uploads that fail
+// to be terminated are logged and left for a later run.
+func (s *ResumableUploads) Collect(now time.Time) (int, error) {
+	terminated := 0
+	for {
+		uploads, err := s.Uploads.ClaimExpired(now, collectBatch, collectLease)
+		if err != nil {
+			return terminated, err
+		}
+		for _, upload := range uploads {
+			if err := s.Terminate(upload); err != nil {
+				fmt.Printf("Failed to terminate expired upload %s, will retry: %v\n", upload.ID, err)
+				continue
+			}
+			terminated++
+		}
+		if len(uploads) < collectBatch {
+			return terminated, nil
+		}
+	}
+}
+
+// CollectEvery runs Collect every interval. It never returns, so run it in
+// its own goroutine.
+func (s *ResumableUploads) CollectEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		n, err := s.Collect(now)
+		if err != nil {
+			fmt.Printf("Failed to collect expired uploads: %v\n", err)
+		}
+		if n > 0 {
+			fmt.Printf("Deleted %d expired uploads\n", n)
+		}
+	}
+}
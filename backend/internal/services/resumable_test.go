@@ -0,0 +1,151 @@
+package services
+
+import (
+	"bytes"
+	"complain/internal/config"
+	"complain/internal/repository"
+	"complain/internal/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// failingStore is a MemoryStore that cannot delete the object under key.
+type failingStore struct {
+	*storage.MemoryStore
+	key string
+}
+
+func (s *failingStore) Delete(key string) error {
+	if key == s.key {
+		return errors.New("store unavailable")
+	}
+	return s.MemoryStore.Delete(key)
+}
+
+func TestCollectSkipsUploadsItCannotTerminate(t *testing.T) {
+	store := &failingStore{MemoryStore: storage.NewMemoryStore(nil)}
+	uploads := repository.NewMemoryUploadRepository()
+	s := NewResumableUploads(store, uploads, config.AttachmentConfig{UploadPartSize: 5 << 20, MaxFileSize: 10 << 20, UploadExpiry: time.Hour})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		upload, err := s.Start(3, 100, "video.mp4", "video/mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, upload.ID)
+	}
+	stuck, _ := uploads.Get(ids[1])
+	store.key = stuck.ObjectKey
+
+	expired := time.Now().Add(2 * time.Hour)
+	if n, err := s.Collect(expired); err != nil || n != 2 {
+		t.Fatalf("Collect() = %d, %v; want the other 2 uploads terminated", n, err)
+	}
+	for i, id := range ids {
+		if _, err := uploads.Get(id); errors.Is(err, repository.ErrNotFound) != (i != 1) {
+			t.Errorf("upload %d after collecting: %v", i, err)
+		}
+	}
+	// The upload stays claimed, so collecting again at once leaves it be.
+	if n, err := s.Collect(expired); err != nil || n != 0 {
+		t.Fatalf("Collect() again = %d, %v; want 0", n, err)
+	}
+
+	store.key = ""
+	if n, err := s.Collect(expired.Add(collectLease + time.Second)); err != nil || n != 1 {
+		t.Fatalf("Collect() after the lease = %d, %v; want 1", n, err)
+	}
+}
+
+func TestAdvanceRefusesUploadsBeingCollected(t *testing.T) {
+	uploads := repository.NewMemoryUploadRepository()
+	s := NewResumableUploads(storage.NewMemoryStore(nil), uploads, config.AttachmentConfig{UploadPartSize: 5 << 20, MaxFileSize: 10 << 20, UploadExpiry: time.Hour})
+	upload, err := s.Start(3, 100, "video.mp4", "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := uploads.ClaimExpired(time.Now().Add(2*time.Hour), collectBatch, collectLease); err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimExpired() = %v, %v", claimed, err)
+	}
+	next := upload
+	next.Offset = 10
+	if err := uploads.Advance(next, 0, nil); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("Advance() = %v, want ErrConflict", err)
+	}
+}
+
+// putRecorder is a MemoryStore that remembers the keys objects were put
+// under.
+type putRecorder struct {
+	*storage.MemoryStore
+	puts []string
+}
+
+func (s *putRecorder) Put(key string, body io.Reader, size int64, contentType string) error {
+	s.puts = append(s.puts, key)
+	return s.MemoryStore.Put(key, body, size, contentType)
+}
+
+// finishedUpload uploads data resumably as filename and returns it as a
+// file to attach.
+func finishedUpload(t *testing.T, s *ResumableUploads, filename string, data []byte) File {
+	t.Helper()
+	upload, err := s.Start(3, int64(len(data)), filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Append(upload.ID, 3, 0, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if upload, err = s.Finished(upload.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	return s.File(upload)
+}
+
+func TestAttachFinishedUploadInPlace(t *testing.T) {
+	store := &putRecorder{MemoryStore: storage.NewMemoryStore(nil)}
+	limits := config.AttachmentConfig{MaxFiles: 5, MaxFileSize: 50 << 20, MaxImageSize: 10 << 20, MaxDocumentSize: 10 << 20,
+		MaxVideoSize: 50 << 20, UploadPartSize: 5 << 20, UploadExpiry: time.Hour}
+	s := NewResumableUploads(store, repository.NewMemoryUploadRepository(), limits)
+	u := NewUploader(store, time.Minute, limits, NopScanner{}, &ImagePipeline{ThumbnailSize: 32, WebSize: 64})
+
+	video := mp4(7<<20, 6<<20, "<svg")
+	attachment, err := u.UploadFile(finishedUpload(t, s, "clip.mp4", video))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.puts) != 0 {
+		t.Errorf("video uploaded again under %v, want it copied", store.puts)
+	}
+	sum := sha256.Sum256(video)
+	if attachment.Size != int64(len(video)) || attachment.Checksum != hex.EncodeToString(sum[:]) || attachment.ContentType != "video/mp4" {
+		t.Errorf("attachment %+v does not describe the video", attachment)
+	}
+	stored, _, err := store.Get(attachment.ObjectKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stored.Close()
+	if data, _ := io.ReadAll(stored); !bytes.Equal(data, video) {
+		t.Error("stored video differs from the upload")
+	}
+
+	// Photos are rewritten without their metadata, so they are put.
+	photo, err := u.UploadFile(finishedUpload(t, s, "photo.jpg", sample(t, "video-001.jpeg")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.puts) != 3 || store.puts[0] != photo.ObjectKey {
+		t.Errorf("photo put under %v, want it and its renditions", store.puts)
+	}
+
+	// The stored bytes are validated like any other file.
+	if _, err := u.UploadFile(finishedUpload(t, s, "clip.mp4", mp4(1<<20, 100, "<html>"))); !errors.Is(err, ErrFileRejected) {
+		t.Errorf("upload with markup at the start: %v, want it rejected", err)
+	}
+}
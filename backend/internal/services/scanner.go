@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bufio"
+	"complain/internal/config"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scanner checks an upload for malware before it is stored. Scan returns an
+// error wrapping ErrInfected if malware was found, and any other error if
+// the file could not be scanned.
+type Scanner interface {
+	Scan(r io.Reader) error
+}
+
+// NewScanner returns the scanner selected by cfg.
+func NewScanner(cfg config.ScannerConfig) Scanner {
+	if cfg.Backend == config.ScannerClamd {
+		return NewClamdScanner(cfg.Address, cfg.Timeout)
+	}
+	return NopScanner{}
+}
+
+// NopScanner accepts every file.
+type NopScanner struct{}
+
+func (NopScanner) Scan(r io.Reader) error {
+	return nil
+}
+
+// ClamdScanner streams files to a ClamAV daemon with the INSTREAM command.
+type ClamdScanner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner connects to clamd at a host:port, or at a unix socket if
+// address is a path.
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	return &ClamdScanner{Network: network, Address: address, Timeout: timeout}
+}
+
+// clamdChunkSize is the size of the chunks files are streamed in. clamd
+// accepts chunks up to its StreamMaxLength.
+const clamdChunkSize = 32 << 10
+
+func (s *ClamdScanner) Scan(r io.Reader) error {
+	conn, err := net.DialTimeout(s.Network, s.Address, s.Timeout)
+	if err != nil {
+		return fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return err
+	}
+
+	// The z prefix makes clamd expect and send NUL-terminated lines.
+	writeErr := writeInstream(conn, r)
+	// clamd hangs up on streams over its size limit and says so, so read
+	// the reply even if writing failed.
+	reply, readErr := bufio.NewReader(conn).ReadString(0)
+	reply = strings.TrimSuffix(reply, "\x00")
+	if reply == "" {
+		if writeErr != nil {
+			return fmt.Errorf("streaming to clamd: %w", writeErr)
+		}
+		return fmt.Errorf("reading clamd reply: %w", readErr)
+	}
+	return parseClamdReply(reply)
+}
+
+func writeInstream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := w.Write(append(size, buf[:n]...)); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// A zero-length chunk ends the stream.
+	binary.BigEndian.PutUint32(size, 0)
+	_, err := w.Write(size)
+	return err
+}
+
+// parseClamdReply reads a reply such as "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND".
+func parseClamdReply(reply string) error {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return fmt.Errorf("%w: %s", ErrInfected, strings.TrimSuffix(result, " FOUND"))
+	default:
+		return fmt.Errorf("clamd: %s", result)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the standard antivirus test file, which every scanner detects.
+var eicar = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+// fakeClamd is a small stand-in for clamd that speaks enough of its
+// protocol (PING and INSTREAM) to exercise ClamdScanner without ClamAV. It
+// reports streams containing the EICAR test string as infected.
+type fakeClamd struct {
+	listener net.Listener
+	// MaxStreamLength mimics clamd's StreamMaxLength; longer streams are
+	// refused.
+	MaxStreamLength int
+}
+
+// newFakeClamd starts a fakeClamd on a free local port until the test
+// ends.
+func newFakeClamd(t *testing.T) *fakeClamd {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeClamd{listener: listener, MaxStreamLength: 25 << 20}
+	go f.serve()
+	t.Cleanup(func() { f.listener.Close() })
+	return f
+}
+
+// Addr is the host:port to give NewClamdScanner.
+func (f *fakeClamd) Addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch strings.TrimSuffix(command, "\x00") {
+	case "zPING":
+		io.WriteString(conn, "PONG\x00")
+	case "zINSTREAM":
+		io.WriteString(conn, f.instream(r)+"\x00")
+	default:
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+	}
+}
+
+func (f *fakeClamd) instream(r io.Reader) string {
+	var data bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "stream: read error. ERROR"
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			break
+		}
+		if data.Len()+int(n) > f.MaxStreamLength {
+			return "INSTREAM size limit exceeded. ERROR"
+		}
+		if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+			return "stream: read error. ERROR"
+		}
+	}
+	if bytes.Contains(data.Bytes(), eicar) {
+		return "stream: Eicar-Test-Signature FOUND"
+	}
+	return "stream: OK"
+}
+
+func TestClamdScanner(t *testing.T) {
+	clamd := newFakeClamd(t)
+	clamd.MaxStreamLength = 4 * clamdChunkSize
+	scanner := NewClamdScanner(clamd.Addr(), 5*time.Second)
+
+	if err := scanner.Scan(strings.NewReader("A photo of a pothole")); err != nil {
+		t.Errorf("clean file: %v", err)
+	}
+	infected := append(bytes.Repeat([]byte{' '}, clamdChunkSize-10), eicar...)
+	if err := scanner.Scan(bytes.NewReader(infected)); !errors.Is(err, ErrInfected) || !strings.Contains(err.Error(), "Eicar-Test-Signature") {
+		t.Errorf("infected file: %v, want ErrInfected naming the signature", err)
+	}
+	err := scanner.Scan(bytes.NewReader(make([]byte, 8*clamdChunkSize)))
+	if err == nil || errors.Is(err, ErrInfected) || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Errorf("file over the size limit: %v, want clamd's error", err)
+	}
+}
+
+func TestClamdScannerUnreachable(t *testing.T) {
+	clamd := newFakeClamd(t)
+	address := clamd.Addr()
+	clamd.listener.Close()
+	if err := NewClamdScanner(address, time.Second).Scan(strings.NewReader("data")); err == nil || errors.Is(err, ErrInfected) {
+		t.Errorf("scanning with clamd down: %v, want a connection error", err)
+	}
+}
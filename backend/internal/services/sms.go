@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bytes"
+	"complain/internal/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// smsMaxLength keeps the text of a message, before any link, to a couple
+// of SMS segments however it is encoded.
+const smsMaxLength = 140
+
+// phonePattern matches E.164 numbers such as +919812345678.
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// ValidPhone reports whether number is in E.164 format.
+func ValidPhone(number string) bool {
+	return phonePattern.MatchString(number)
+}
+
+// SMSSender sends text messages. Errors wrapping errPermanent will not go
+// away by retrying.
+type SMSSender interface {
+	SendSMS(to, body string) error
+}
+
+// NewSMSSender returns the sender cfg selects, or nil if SMS is turned off.
+func NewSMSSender(cfg config.SMSConfig) SMSSender {
+	switch cfg.Backend {
+	case config.SMSHTTP:
+		return NewHTTPSMSSender(cfg)
+	case config.SMSFake:
+		return NewFakeSMSSender()
+	}
+	return nil
+}
+
+// SMSText shortens body to what is sensible to send as a text message.
+func SMSText(body string) string {
+	if utf8.RuneCountInString(body) <= smsMaxLength {
+		return body
+	}
+	runes := []rune(body)
+	return string(runes[:smsMaxLength-1]) + "…"
+}
+
+// HTTPSMSSender sends through a provider's REST API: a JSON POST of from,
+// to and body to URL, authorized with Token as a bearer token.
+type HTTPSMSSender struct {
+	URL    string
+	Token  string
+	From   string
+	Client *http.Client
+}
+
+func NewHTTPSMSSender(cfg config.SMSConfig) *HTTPSMSSender {
+	return &HTTPSMSSender{
+		URL:    cfg.ProviderURL,
+		Token:  cfg.ProviderToken,
+		From:   cfg.From,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *HTTPSMSSender) SendSMS(to, body string) error {
+	payload, err := json.Marshal(map[string]string{"from": s.From, "to": to, "body": body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("SMS provider responded %s: %s", resp.Status, response)
+	// The provider rejected the message itself, e.g. for a bad number.
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	return err
+}
+
+// SentSMS is a message the fake sender was asked to send.
+type SentSMS struct {
+	To   string
+	Body string
+}
+
+// FakeSMSSender only logs and remembers messages, for development and
+// tests.
+type FakeSMSSender struct {
+	sent  []SentSMS
+	mutex sync.Mutex
+}
+
+func NewFakeSMSSender() *FakeSMSSender {
+	return &FakeSMSSender{}
+}
+
+func (s *FakeSMSSender) SendSMS(to, body string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fmt.Printf("SMS to %s: %s\n", to, body)
+	s.sent = append(s.sent, SentSMS{To: to, Body: body})
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first.
+func (s *FakeSMSSender) Sent() []SentSMS {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]SentSMS(nil), s.sent...)
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"complain/internal/config"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHTTPSMSSender(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		status = http.StatusOK
+		got    map[string]string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer provider-token" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s with headers %v", r.Method, r.Header)
+		}
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sender := NewSMSSender(config.SMSConfig{Backend: config.SMSHTTP, ProviderURL: server.URL, ProviderToken: "provider-token", From: "CITYGOV"})
+	if err := sender.SendSMS("+919812345678", "Update on complaint #42"); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	if got["from"] != "CITYGOV" || got["to"] != "+919812345678" || got["body"] != "Update on complaint #42" {
+		t.Errorf("provider got %v", got)
+	}
+	mutex.Unlock()
+
+	// Only rejections of the message itself are worth giving up on.
+	for code, permanent := range map[int]bool{
+		http.StatusBadRequest:         true,
+		http.StatusUnauthorized:       true,
+		http.StatusTooManyRequests:    false,
+		http.StatusServiceUnavailable: false,
+	} {
+		mutex.Lock()
+		status = code
+		mutex.Unlock()
+		err := sender.SendSMS("+919812345678", "Update on complaint #42")
+		if err == nil || errors.Is(err, errPermanent) != permanent {
+			t.Errorf("provider responding %d: %v, want permanent %v", code, err, permanent)
+		}
+	}
+}
+
+func TestNewSMSSender(t *testing.T) {
+	if sender := NewSMSSender(config.SMSConfig{Backend: config.SMSNone}); sender != nil {
+		t.Errorf("SMS turned off gave %T", sender)
+	}
+	if _, ok := NewSMSSender(config.SMSConfig{Backend: config.SMSFake}).(*FakeSMSSender); !ok {
+		t.Error("fake backend did not give the fake sender")
+	}
+}
+
+func TestValidPhone(t *testing.T) {
+	for number, valid := range map[string]bool{
+		"+919812345678":  true,
+		"+14155550123":   true,
+		"9812345678":     false,
+		"+0123456789":    false,
+		"+91 98123 4567": false,
+		"+1234":          false,
+	} {
+		if ValidPhone(number) != valid {
+			t.Errorf("ValidPhone(%q) = %v", number, !valid)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrInvalidTimezone is returned for digest schedules in an unknown
+// timezone.
+var ErrInvalidTimezone = errors.New("unknown timezone")
+
+const (
+	// digestTick is how often due staff digests are looked for.
+	digestTick = time.Minute
+	// digestBatch bounds the digests built per look.
+	digestBatch = 100
+	// digestListLimit bounds each list in a digest; its count covers the
+	// rest.
+	digestListLimit = 20
+)
+
+// RolePermissions resolves a role to the permissions it grants, as
+// RoleStore does.
+type RolePermissions interface {
+	Permissions(role string) (map[string]bool, error)
+}
+
+// DigestComplaint is one complaint listed in a staff digest.
+type DigestComplaint struct {
+	ID      int64     `json:"id"`
+	Title   string    `json:"title"`
+	Status  string    `json:"status"`
+	FiledAt time.Time `json:"filed_at"`
+}
+
+// StatusText is Status written for people, e.g. "in progress".
+func (c DigestComplaint) StatusText() string {
+	return EmailData{Status: c.Status}.StatusText()
+}
+
+// DigestList is a section of a staff digest: how many complaints it counts
+// and the first digestListLimit of them.
+type DigestList struct {
+	Count      int               `json:"count"`
+	Complaints []DigestComplaint `json:"complaints,omitempty"`
+}
+
+// More is how many counted complaints are not listed.
+func (l DigestList) More() int {
+	return l.Count - len(l.Complaints)
+}
+
+func digestList(complaints []models.Complaint) DigestList {
+	list := DigestList{Count: len(complaints)}
+	for i, c := range complaints {
+		if i == digestListLimit {
+			break
+		}
+		list.Complaints = append(list.Complaints, DigestComplaint{ID: c.ID, Title: c.Title, Status: c.Status, FiledAt: c.CreatedAt})
+	}
+	return list
+}
+
+// StaffDigest sums up a staff member's complaints since the last digest.
+// Everyone gets the open complaints assigned to them. Officials also get
+// the complaints newly filed in their jurisdiction, those past the SLA and
+// those reopened; staff who work on every complaint get the totals per
+// district and category and the SLA breaches instead.
+type StaffDigest struct {
+	Frequency string    `json:"frequency"`
+	Since     time.Time `json:"since"`
+	// Timezone is the recipient's, for Local.
+	Timezone string     `json:"timezone"`
+	Assigned DigestList `json:"assigned"`
+	Filed    DigestList `json:"filed"`
+	Overdue  DigestList `json:"overdue"`
+	Reopened DigestList `json:"reopened"`
+	// Totals are the busiest digestListLimit districts and categories, and
+	// Sum adds up all of them.
+	Totals   []models.ComplaintTotals `json:"totals,omitempty"`
+	Sum      models.ComplaintTotals   `json:"sum"`
+	Breaches DigestList               `json:"breaches"`
+}
+
+// Empty reports whether there is nothing to tell.
+func (d StaffDigest) Empty() bool {
+	return d.Assigned.Count == 0 && d.Filed.Count == 0 && d.Overdue.Count == 0 && d.Reopened.Count == 0 &&
+		d.Sum.Filed == 0 && d.Sum.Open == 0 && d.Breaches.Count == 0
+}
+
+// Local formats t in the recipient's timezone.
+func (d StaffDigest) Local(t time.Time) string {
+	if loc, err := time.LoadLocation(d.Timezone); err == nil {
+		t = t.In(loc)
+	}
+	return t.Format("2 Jan 2006 15:04")
+}
+
+// NextDigest is the first time after t a digest on schedule is due: at its
+// hour on the next day, or the next of its weekday, in its timezone.
+func NextDigest(schedule models.DigestSchedule, t time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidTimezone, schedule.Timezone)
+	}
+	local := t.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), schedule.Hour, 0, 0, 0, loc)
+	days := 1
+	if schedule.Frequency == models.DigestWeekly {
+		next = next.AddDate(0, 0, (schedule.Weekday-int(next.Weekday())+7)%7)
+		days = 7
+	}
+	for !next.After(t) {
+		next = next.AddDate(0, 0, days)
+	}
+	return next, nil
+}
+
+// digestSince is when the period a digest due at due covers began.
+func digestSince(schedule models.DigestSchedule, due time.Time) time.Time {
+	if loc, err := time.LoadLocation(schedule.Timezone); err == nil {
+		due = due.In(loc)
+	}
+	if schedule.Frequency == models.DigestWeekly {
+		return due.AddDate(0, 0, -7)
+	}
+	return due.AddDate(0, 0, -1)
+}
+
+// StaffDigests sends staff their digests on the schedules they chose.
+// Digests are queued in the outbox and delivered by the Notifier.
+type StaffDigests struct {
+	Schedules  repository.DigestScheduleRepository
+	Complaints repository.ComplaintRepository
+	Users      repository.UserRepository
+	Roles      RolePermissions
+	Notifier   *Notifier
+	// SLA is how long a complaint may stay open before it is overdue.
+	SLA time.Duration
+}
+
+func NewStaffDigests(schedules repository.DigestScheduleRepository, complaints repository.ComplaintRepository, users repository.UserRepository, roles RolePermissions, notifier *Notifier, sla time.Duration) *StaffDigests {
+	return &StaffDigests{Schedules: schedules, Complaints: complaints, Users: users, Roles: roles, Notifier: notifier, SLA: sla}
+}
+
+// SetSchedule saves a user's schedule and works out when their next digest
+// is due.
+func (d *StaffDigests) SetSchedule(userID int64, req models.DigestScheduleRequest) (models.DigestSchedule, error) {
+	schedule := models.DigestSchedule{
+		UserID:    userID,
+		Frequency: req.Frequency,
+		Hour:      *req.Hour,
+		Weekday:   req.Weekday,
+		Timezone:  req.Timezone,
+	}
+	next, err := NextDigest(schedule, time.Now())
+	if err != nil {
+		return schedule, err
+	}
+	if schedule.Frequency != models.DigestOff {
+		schedule.NextDueAt = &next
+	}
+	if err := d.Schedules.Save(schedule); err != nil {
+		return schedule, err
+	}
+	return d.Schedules.Get(userID)
+}
+
+// Build sums up the complaints user may see from since until now. It is
+// empty for users no longer allowed to view complaints.
+func (d *StaffDigests) Build(user models.User, frequency, timezone string, since, now time.Time) (StaffDigest, error) {
+	digest := StaffDigest{Frequency: frequency, Since: since, Timezone: timezone}
+	perms, err := d.Roles.Permissions(user.Role)
+	if err != nil || !user.IsActive || !perms[PermComplaintViewAll] {
+		return digest, err
+	}
+	// The same complaints the user's own listings show.
+	filter := repository.ComplaintFilter{Open: true, PublicOnly: !perms[PermComplaintViewPrivate]}
+	if !perms[PermComplaintScopeAll] {
+		filter.ScopeUserID = user.ID
+	}
+	overdueBefore := now.Add(-d.SLA)
+
+	// Whoever assigned them checked they may work on them, so the
+	// jurisdiction does not narrow these.
+	assigned := filter
+	assigned.ScopeUserID, assigned.AssignedTo = 0, user.ID
+	assignedComplaints, err := d.Complaints.List(assigned)
+	if err != nil {
+		return digest, err
+	}
+	digest.Assigned = digestList(assignedComplaints)
+
+	overdue := filter
+	overdue.FiledBefore = overdueBefore
+	overdueComplaints, err := d.Complaints.List(overdue)
+	if err != nil {
+		return digest, err
+	}
+	// Longest overdue first.
+	sort.SliceStable(overdueComplaints, func(i, j int) bool {
+		return overdueComplaints[i].CreatedAt.Before(overdueComplaints[j].CreatedAt)
+	})
+
+	if perms[PermComplaintScopeAll] {
+		totalsFilter := filter
+		totalsFilter.Open = false
+		totals, err := d.Complaints.Totals(totalsFilter, since, overdueBefore)
+		if err != nil {
+			return digest, err
+		}
+		for _, t := range totals {
+			digest.Sum.Filed += t.Filed
+			digest.Sum.Open += t.Open
+			digest.Sum.Overdue += t.Overdue
+		}
+		if len(totals) > digestListLimit {
+			totals = totals[:digestListLimit]
+		}
+		digest.Totals = totals
+		digest.Breaches = digestList(overdueComplaints)
+		return digest, nil
+	}
+
+	filed := filter
+	filed.FiledAfter = since
+	filedComplaints, err := d.Complaints.List(filed)
+	if err != nil {
+		return digest, err
+	}
+	reopened := filter
+	reopened.ReopenedAfter = since
+	reopenedComplaints, err := d.Complaints.List(reopened)
+	if err != nil {
+		return digest, err
+	}
+	digest.Filed = digestList(filedComplaints)
+	digest.Overdue = digestList(overdueComplaints)
+	digest.Reopened = digestList(reopenedComplaints)
+	return digest, nil
+}
+
+// send queues the digest due on schedule, unless there is nothing to tell,
+// and moves the schedule on. Periods missed while no process was running
+// are skipped rather than sent late one after another.
+func (d *StaffDigests) send(schedule models.DigestSchedule, now time.Time) (bool, error) {
+	due := *schedule.NextDueAt
+	next, err := NextDigest(schedule, now)
+	if err != nil {
+		return false, err
+	}
+	user, err := d.Users.GetByID(schedule.UserID)
+	if err != nil {
+		return false, err
+	}
+	digest, err := d.Build(user, schedule.Frequency, schedule.Timezone, digestSince(schedule, due), now)
+	if err != nil {
+		return false, err
+	}
+	var messages []models.OutboxMessage
+	if !digest.Empty() {
+		messages = append(messages, newMessage(NotifyStaffDigest, user.Email, user.Language, EmailData{Name: user.Name, StaffDigest: &digest}))
+	}
+	return len(messages) > 0, d.Schedules.Advance(user.ID, due, next, messages)
+}
+
+// SendDue queues the digests due at now and returns how many were queued.
+func (d *StaffDigests) SendDue(now time.Time) (int, error) {
+	schedules, err := d.Schedules.Due(now, digestBatch)
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, schedule := range schedules {
+		sent, err := d.send(schedule, now)
+		switch {
+		case errors.Is(err, repository.ErrConflict):
+			// Another process sent it, or the user changed their schedule.
+		case errors.Is(err, repository.ErrNotFound), errors.Is(err, ErrInvalidTimezone):
+			// Retrying cannot help, and the schedule would stay first in
+			// line for every batch.
+			fmt.Printf("Stopping the digests of user %d: %v\n", schedule.UserID, err)
+			if err := d.Schedules.Disable(schedule.UserID, *schedule.NextDueAt); err != nil && !errors.Is(err, repository.ErrConflict) {
+				fmt.Printf("Failed to stop the digests of user %d: %v\n", schedule.UserID, err)
+			}
+		case err != nil:
+			fmt.Printf("Failed to send the digest of user %d: %v\n", schedule.UserID, err)
+		case sent:
+			queued++
+		}
+	}
+	if queued > 0 {
+		d.Notifier.Wake()
+	}
+	return queued, nil
+}
+
+// Run sends digests as they fall due. It never returns, so run it in its
+// own goroutine. Several processes may run it on the same database.
+func (d *StaffDigests) Run() {
+	for {
+		queued, err := d.SendDue(time.Now())
+		if err != nil {
+			fmt.Printf("Failed to look for due staff digests: %v\n", err)
+		} else if queued > 0 {
+			fmt.Printf("Queued %d staff digests\n", queued)
+		}
+		time.Sleep(digestTick)
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"testing"
+	"time"
+)
+
+// testRoles grants each role the permissions listed for it.
+type testRoles map[string]map[string]bool
+
+func (r testRoles) Permissions(role string) (map[string]bool, error) {
+	return r[role], nil
+}
+
+func newTestDigests(t *testing.T) (*StaffDigests, *repository.MemoryDigestScheduleRepository, *repository.MemoryComplaintRepository) {
+	t.Helper()
+	env := newTestEnv(t)
+	schedules := repository.NewMemoryDigestScheduleRepository()
+	schedules.Outbox = env.Outbox
+	env.user(t, models.User{Name: "Official", Email: "official@example.com", Role: "official"})
+	roles := testRoles{"official": {PermComplaintViewAll: true, PermComplaintUpdate: true}}
+	return NewStaffDigests(schedules, env.Complaints, env.Users, roles, env.Notifier, 72*time.Hour), schedules, env.Complaints
+}
+
+func TestStaffDigestListsAssignedApartFromFiled(t *testing.T) {
+	d, _, complaints := newTestDigests(t)
+	complaints.Jurisdictions[1] = repository.Jurisdiction{CategoryIDs: []int{1}}
+	for _, category := range []int{1, 2} {
+		if _, err := complaints.Create(2, models.CreateComplaintRequest{Title: "Pothole", Category: category, IsPublic: true}, models.StatusPending, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Escalated to the official from outside their jurisdiction.
+	if _, err := complaints.Assign(2, 1, true, repository.ComplaintFilter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := d.Users.GetByID(1)
+	now := time.Now()
+	digest, err := d.Build(user, models.DigestDaily, "UTC", now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Assigned.Count != 1 || digest.Assigned.Complaints[0].ID != 2 {
+		t.Errorf("assigned %+v, want complaint 2", digest.Assigned)
+	}
+	if digest.Filed.Count != 1 || digest.Filed.Complaints[0].ID != 1 {
+		t.Errorf("filed %+v, want complaint 1", digest.Filed)
+	}
+}
+
+func TestSendDueStopsSchedulesThatCannotBeSent(t *testing.T) {
+	d, schedules, _ := newTestDigests(t)
+	now := time.Now()
+	due := now.Add(-time.Minute)
+	for _, s := range []models.DigestSchedule{
+		{UserID: 1, Frequency: models.DigestDaily, Hour: 8, Timezone: "Mars/Olympus_Mons", NextDueAt: &due},
+		{UserID: 7, Frequency: models.DigestDaily, Hour: 8, Timezone: "UTC", NextDueAt: &due},
+	} {
+		if err := schedules.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := d.SendDue(now); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := schedules.Due(now, digestBatch); len(left) != 0 {
+		t.Fatalf("schedules still due %+v", left)
+	}
+	for _, id := range []int64{1, 7} {
+		if s, _ := schedules.Get(id); s.NextDueAt != nil || s.Frequency != models.DigestDaily {
+			t.Errorf("schedule of user %d %+v, want it stopped", id, s)
+		}
+	}
+}
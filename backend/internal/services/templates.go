@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bytes"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Events emails are sent for. Each is also the kind of its outbox message.
+const (
+	NotifyComplaintCreated  = "complaint.created"
+	NotifyComplaintAssigned = "complaint.assigned"
+	NotifyComplaintUpdated  = "complaint.updated"
+	NotifyComplaintResolved = "complaint.resolved"
+	NotifyComplaintReplied  = "complaint.replied"
+	NotifyComplaintDigest   = "complaint.digest"
+	NotifyAccountLocked     = "account.locked"
+	NotifyStaffInvite       = "staff.invite"
+	NotifyPasswordReset     = "password.reset"
+	NotifyStaffDigest       = "staff.digest"
+	NotifyStaffAssigned     = "staff.assigned"
+	NotifyStaffEscalated    = "staff.escalated"
+)
+
+// EmailEvents describes the events for the admin UI.
+var EmailEvents = []struct {
+	Event       string `json:"event"`
+	Description string `json:"description"`
+}{
+	{NotifyComplaintCreated, "Sent to the citizen when their complaint is registered"},
+	{NotifyComplaintAssigned, "Sent to the citizen when an official is assigned their complaint or it is escalated to one"},
+	{NotifyComplaintUpdated, "Sent to the citizen when their complaint's status changes or is commented on"},
+	{NotifyComplaintResolved, "Sent to the citizen when their complaint is resolved"},
+	{NotifyComplaintReplied, "Tells the staff following a complaint, in their in-app inbox, that the citizen replied"},
+	{NotifyComplaintDigest, "Sums up a day's complaint notifications for citizens who asked for a daily digest"},
+	{NotifyAccountLocked, "Sent to the account owner when failed logins lock the account"},
+	{NotifyStaffInvite, "Invites a new staff member to choose a password"},
+	{NotifyPasswordReset, "Asks a user to choose a new password after an administrator forced a reset"},
+	{NotifyStaffDigest, "Sums up assigned, new, overdue and reopened complaints, or totals and SLA breaches, for staff on their digest schedule"},
+	{NotifyStaffAssigned, "Tells an official, in their in-app inbox, that a complaint was assigned to them"},
+	{NotifyStaffEscalated, "Tells an official, in their in-app inbox, that a complaint was escalated to them and why"},
+}
+
+// IsEmailEvent reports whether event is in EmailEvents.
+func IsEmailEvent(event string) bool {
+	for _, e := range EmailEvents {
+		if e.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnknownTemplate is returned when there is no template for an event in
+// any language.
+var ErrUnknownTemplate = errors.New("no email template")
+
+// languagePattern matches language codes such as en, hi or pt-BR.
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// ValidLanguage reports whether code looks like a language code.
+func ValidLanguage(code string) bool {
+	return languagePattern.MatchString(code)
+}
+
+// EmailData is what templates can refer to. Which fields are set depends on
+// the event.
+type EmailData struct {
+	// AppURL is the frontend's base URL.
+	AppURL string `json:"-"`
+	// Name is the recipient's name.
+	Name        string `json:"name,omitempty"`
+	ComplaintID int64  `json:"-"`
+	Title       string `json:"title,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	Official    string `json:"official,omitempty"`
+	// Citizen is the complaint owner's name, in notifications to staff.
+	Citizen     string     `json:"citizen,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	Role        string     `json:"role,omitempty"`
+	// Token is a one-time token for SetPasswordURL.
+	Token string `json:"token,omitempty"`
+	// Items are the notifications a digest sums up.
+	Items []DigestItem `json:"items,omitempty"`
+	// StaffDigest is the summary in a staff digest.
+	StaffDigest *StaffDigest `json:"staff_digest,omitempty"`
+}
+
+// DigestItem is one notification in a digest.
+type DigestItem struct {
+	Event       string    `json:"event"`
+	ComplaintID int64     `json:"complaint_id"`
+	Title       string    `json:"title"`
+	Status      string    `json:"status,omitempty"`
+	Comment     string    `json:"comment,omitempty"`
+	Official    string    `json:"official,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// StatusText is Status written for people, e.g. "in progress".
+func (i DigestItem) StatusText() string {
+	return EmailData{Status: i.Status}.StatusText()
+}
+
+// ComplaintURL links to the complaint in the frontend.
+func (d EmailData) ComplaintURL() string {
+	return d.URLForComplaint(d.ComplaintID)
+}
+
+// URLForComplaint links to complaint id, such as a digest item's.
+func (d EmailData) URLForComplaint(id int64) string {
+	return fmt.Sprintf("%s/complaints/%d", d.AppURL, id)
+}
+
+// SetPasswordURL links to the page that redeems Token.
+func (d EmailData) SetPasswordURL() string {
+	return d.AppURL + "/set-password?token=" + d.Token
+}
+
+// StatusText is Status written for people, e.g. "in progress".
+func (d EmailData) StatusText() string {
+	return strings.ToLower(strings.ReplaceAll(d.Status, "_", " "))
+}
+
+// SampleEmailData is made-up data for previewing and checking templates.
+func SampleEmailData(appURL string) EmailData {
+	lockedUntil := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	complaints := []DigestComplaint{
+		{ID: 1042, Title: "Streetlight not working on MG Road", Status: "In_Progress", FiledAt: time.Date(2025, 1, 2, 9, 15, 0, 0, time.UTC)},
+		{ID: 1057, Title: "Open manhole near Central Bus Stand", Status: "pending", FiledAt: time.Date(2025, 1, 14, 17, 20, 0, 0, time.UTC)},
+	}
+	return EmailData{
+		AppURL:      appURL,
+		Name:        "Asha Kumar",
+		ComplaintID: 1042,
+		Title:       "Streetlight not working on MG Road",
+		Status:      "In_Progress",
+		Comment:     "A crew has been scheduled to replace the lamp this week.",
+		Official:    "R. Menon, Public Works",
+		Citizen:     "Vikram Rao",
+		LockedUntil: &lockedUntil,
+		Role:        "official",
+		Token:       "sample-token",
+		Items: []DigestItem{
+			{Event: NotifyComplaintUpdated, ComplaintID: 1042, Title: "Streetlight not working on MG Road",
+				Status: "In_Progress", Comment: "A crew has been scheduled to replace the lamp this week.",
+				Official: "R. Menon, Public Works", At: time.Date(2025, 1, 14, 16, 5, 0, 0, time.UTC)},
+			{Event: NotifyComplaintAssigned, ComplaintID: 1057, Title: "Open manhole near Central Bus Stand",
+				Status: "pending", Official: "R. Menon, Public Works", At: time.Date(2025, 1, 14, 17, 45, 0, 0, time.UTC)},
+			{Event: NotifyComplaintResolved, ComplaintID: 987, Title: "Garbage not collected in Sector 4",
+				Status: "Resolved", Official: "S. Iyer, Sanitation", At: time.Date(2025, 1, 14, 18, 40, 0, 0, time.UTC)},
+		},
+		StaffDigest: &StaffDigest{
+			Frequency: models.DigestDaily,
+			Since:     time.Date(2025, 1, 14, 8, 0, 0, 0, time.UTC),
+			Timezone:  "Asia/Kolkata",
+			Assigned:  DigestList{Count: 1, Complaints: complaints[:1]},
+			Filed:     DigestList{Count: 3, Complaints: complaints[1:]},
+			Overdue:   DigestList{Count: 1, Complaints: complaints[:1]},
+			Reopened:  DigestList{Count: 1, Complaints: complaints[:1]},
+			Totals: []models.ComplaintTotals{
+				{District: "Bengaluru Urban", CategoryID: 2, Category: "Streetlights", Filed: 4, Open: 9, Overdue: 2},
+				{District: "Mysuru", CategoryID: 5, Category: "Sanitation", Filed: 1, Open: 3, Overdue: 0},
+			},
+			Sum:      models.ComplaintTotals{Filed: 5, Open: 12, Overdue: 2},
+			Breaches: DigestList{Count: 2, Complaints: complaints[:1]},
+		},
+	}
+}
+
+// RenderedEmail is a template filled in with data.
+type RenderedEmail struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+//go:embed templates/email
+var builtinTemplateFiles embed.FS
+
+// EmailTemplates finds the template for an event in a language: an admin's
+// override from the database if there is one, else the built-in template.
+// Languages without a template fall back to models.DefaultLanguage.
+type EmailTemplates struct {
+	Overrides repository.EmailTemplateRepository
+	builtin   map[[2]string]models.EmailTemplate
+}
+
+// NewEmailTemplates loads the built-in templates and checks that they
+// render.
+func NewEmailTemplates(overrides repository.EmailTemplateRepository) (*EmailTemplates, error) {
+	t := &EmailTemplates{Overrides: overrides, builtin: map[[2]string]models.EmailTemplate{}}
+	// Files are templates/email/<language>/<event>.{subject,txt,html}.
+	err := fs.WalkDir(builtinTemplateFiles, "templates/email", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := builtinTemplateFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		language := path.Base(path.Dir(name))
+		ext := path.Ext(name)
+		key := [2]string{strings.TrimSuffix(path.Base(name), ext), language}
+		tmpl := t.builtin[key]
+		tmpl.Event, tmpl.Language = key[0], key[1]
+		switch ext {
+		case ".subject":
+			tmpl.Subject = strings.TrimSpace(string(data))
+		case ".txt":
+			tmpl.TextBody = string(data)
+		case ".html":
+			tmpl.HTMLBody = string(data)
+		default:
+			return fmt.Errorf("unexpected template file %s", name)
+		}
+		t.builtin[key] = tmpl
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range EmailEvents {
+		if _, ok := t.builtin[[2]string{e.Event, models.DefaultLanguage}]; !ok {
+			return nil, fmt.Errorf("no built-in %s template for %s", models.DefaultLanguage, e.Event)
+		}
+	}
+	for key, tmpl := range t.builtin {
+		if _, err := RenderTemplate(tmpl, SampleEmailData("")); err != nil {
+			return nil, fmt.Errorf("built-in template %s/%s: %w", key[1], key[0], err)
+		}
+	}
+	return t, nil
+}
+
+// Builtin returns the built-in template for event in language, if any.
+func (t *EmailTemplates) Builtin(event, language string) (models.EmailTemplate, bool) {
+	tmpl, ok := t.builtin[[2]string{event, language}]
+	return tmpl, ok
+}
+
+// BuiltinLanguages lists the languages there are built-in templates in.
+func (t *EmailTemplates) BuiltinLanguages() []string {
+	seen := map[string]bool{}
+	var languages []string
+	for key := range t.builtin {
+		if !seen[key[1]] {
+			seen[key[1]] = true
+			languages = append(languages, key[1])
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// exact returns the override or built-in template for event in exactly
+// language.
+func (t *EmailTemplates) exact(event, language string) (models.EmailTemplate, bool, error) {
+	tmpl, err := t.Overrides.Get(event, language)
+	if err == nil {
+		return tmpl, true, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return tmpl, false, err
+	}
+	tmpl, ok := t.Builtin(event, language)
+	return tmpl, ok, nil
+}
+
+// Lookup returns the template to use for event in language.
+func (t *EmailTemplates) Lookup(event, language string) (models.EmailTemplate, error) {
+	candidates := []string{language}
+	// pt-BR falls back to pt before the default language.
+	if base, _, ok := strings.Cut(language, "-"); ok {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, models.DefaultLanguage)
+	for _, lang := range candidates {
+		tmpl, ok, err := t.exact(event, lang)
+		if err != nil {
+			return tmpl, err
+		}
+		if ok {
+			return tmpl, nil
+		}
+	}
+	return models.EmailTemplate{}, fmt.Errorf("%w for %s", ErrUnknownTemplate, event)
+}
+
+// Render fills in the template for event in language.
+func (t *EmailTemplates) Render(event, language string, data EmailData) (RenderedEmail, error) {
+	tmpl, err := t.Lookup(event, language)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	return RenderTemplate(tmpl, data)
+}
+
+// RenderTemplate fills in tmpl. Templates that do not parse, or refer to
+// fields EmailData does not have, give an error.
+func RenderTemplate(tmpl models.EmailTemplate, data EmailData) (RenderedEmail, error) {
+	var rendered RenderedEmail
+	for _, part := range []struct {
+		name   string
+		source string
+		out    *string
+	}{{"subject", tmpl.Subject, &rendered.Subject}, {"text", tmpl.TextBody, &rendered.Text}} {
+		t, err := texttemplate.New(part.name).Option("missingkey=error").Parse(part.source)
+		if err != nil {
+			return rendered, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return rendered, err
+		}
+		*part.out = buf.String()
+	}
+	// The subject goes into a header and must be a single line.
+	rendered.Subject = strings.Join(strings.Fields(rendered.Subject), " ")
+
+	if tmpl.HTMLBody != "" {
+		t, err := htmltemplate.New("html").Option("missingkey=error").Parse(tmpl.HTMLBody)
+		if err != nil {
+			return rendered, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return rendered, err
+		}
+		rendered.HTML = buf.String()
+	}
+	if rendered.Subject == "" || strings.TrimSpace(rendered.Text) == "" {
+		return rendered, errors.New("subject and text body must not be empty")
+	}
+	return rendered, nil
+}
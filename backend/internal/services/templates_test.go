@@ -0,0 +1,81 @@
+package services
+
+import (
+	"complain/internal/models"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTemplateLanguageFallback(t *testing.T) {
+	templates := newTestEnv(t).Notifier.Mailer.Templates
+	for _, tc := range []struct {
+		event, language, want string
+	}{
+		{NotifyComplaintUpdated, "hi", "hi"},
+		{NotifyComplaintUpdated, "hi-IN", "hi"},
+		{NotifyComplaintUpdated, "fr", models.DefaultLanguage},
+		// Staff invites have no Hindi template.
+		{NotifyStaffInvite, "hi", models.DefaultLanguage},
+	} {
+		tmpl, err := templates.Lookup(tc.event, tc.language)
+		if err != nil || tmpl.Language != tc.want {
+			t.Errorf("Lookup(%s, %s) = %s, %v; want the %s template", tc.event, tc.language, tmpl.Language, err, tc.want)
+		}
+	}
+	if _, err := templates.Render("complaint.deleted", "en", SampleEmailData("")); !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("Render() of an unknown event: %v, want ErrUnknownTemplate", err)
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	templates := newTestEnv(t).Notifier.Mailer.Templates
+	override := models.EmailTemplate{Event: NotifyComplaintCreated, Language: "hi", Subject: "शिकायत #{{.ComplaintID}} दर्ज हुई", TextBody: "{{.Title}}"}
+	if err := templates.Overrides.Save(override); err != nil {
+		t.Fatal(err)
+	}
+	email, err := templates.Render(NotifyComplaintCreated, "hi-IN", EmailData{ComplaintID: 7, Title: "Pothole"})
+	if err != nil || email.Subject != "शिकायत #7 दर्ज हुई" || email.Text != "Pothole" || email.HTML != "" {
+		t.Fatalf("rendered override %+v, %v", email, err)
+	}
+	if err := templates.Overrides.Delete(NotifyComplaintCreated, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	builtin, _ := templates.Builtin(NotifyComplaintCreated, "hi")
+	if tmpl, err := templates.Lookup(NotifyComplaintCreated, "hi"); err != nil || tmpl.Subject != builtin.Subject {
+		t.Fatalf("template after removing the override %+v, %v", tmpl, err)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl := models.EmailTemplate{
+		Subject:  "Update on\n {{.Title}}",
+		TextBody: "{{.Comment}}",
+		HTMLBody: "<p>{{.Comment}}</p>",
+	}
+	email, err := RenderTemplate(tmpl, EmailData{Title: "Pothole", Comment: `<script>alert("hi")</script>`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != "Update on Pothole" {
+		t.Errorf("subject %q, want it on one line", email.Subject)
+	}
+	if email.Text != `<script>alert("hi")</script>` {
+		t.Errorf("text %q, want the comment as written", email.Text)
+	}
+	if strings.Contains(email.HTML, "<script>") {
+		t.Errorf("HTML %q, want the comment escaped", email.HTML)
+	}
+
+	for name, bad := range map[string]models.EmailTemplate{
+		"unknown field": {Subject: "{{.Nickname}}", TextBody: "text"},
+		"syntax error":  {Subject: "subject", TextBody: "{{if .Title}}"},
+		"empty subject": {Subject: "{{.Comment}}", TextBody: "text"},
+		"empty text":    {Subject: "subject", TextBody: " \n"},
+		"bad HTML":      {Subject: "subject", TextBody: "text", HTMLBody: "{{.Nickname}}"},
+	} {
+		if _, err := RenderTemplate(bad, EmailData{Title: "Pothole"}); err == nil {
+			t.Errorf("%s: rendered without an error", name)
+		}
+	}
+}
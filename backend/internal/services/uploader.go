@@ -1,84 +1,227 @@
 package services
 
 import (
+	"bytes"
+	"complain/internal/config"
+	"complain/internal/models"
+	"complain/internal/storage"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"path/filepath"
+	"strings"
 	"time"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-// Uploader holds the configuration for our S3-compatible client (like MinIO).
+// Uploader stores uploaded files in the configured object store. Objects are
+// private; clients get short-lived signed URLs for them.
 type Uploader struct {
-	s3Client   *s3.S3
-	bucketName string
-	publicURL  string
+	Store storage.ObjectStore
+	// URLTTL is how long the URLs returned by SignedURL stay valid.
+	URLTTL time.Duration
+	// Limits bounds the files accepted in one request.
+	Limits config.AttachmentConfig
+	// Scanner checks every file for malware before it is stored.
+	Scanner Scanner
+	// Images strips and resizes photos before they are stored.
+	Images *ImagePipeline
 }
 
-// NewUploader creates a new service for uploading files.
-// It takes all the necessary configuration to connect to the storage service.
-func NewUploader(accessKey, secretKey, endpoint, region, bucketName, publicURL string) (*Uploader, error) {
-	// Create the configuration for the S3 client.
-	s3Config := &aws.Config{
-		// Provide the credentials for authentication.
-		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
-		// The endpoint is the address of our storage server (e.g., http://localhost:9000 for MinIO).
-		Endpoint:         aws.String(endpoint),
-		// The region is required, but for MinIO, it can be a default value.
-		Region:           aws.String(region),
-		// This setting is required for MinIO and other non-AWS S3 services.
-		S3ForcePathStyle: aws.Bool(true),
-	}
-
-	// Create a new session with the configuration.
-	newSession, err := session.NewSession(s3Config)
-	if err != nil {
-		return nil, err
-	}
+// NewUploader creates a new service for uploading files to store.
+func NewUploader(store storage.ObjectStore, urlTTL time.Duration, limits config.AttachmentConfig, scanner Scanner, images *ImagePipeline) *Uploader {
+	return &Uploader{Store: store, URLTTL: urlTTL, Limits: limits, Scanner: scanner, Images: images}
+}
 
-	// Create an S3 client from the session. This is the object we use to interact with the service.
-	s3Client := s3.New(newSession)
+// File is a file to be stored: one sent in a multipart form, or a finished
+// resumable upload.
+type File struct {
+	Filename string
+	Size     int64
+	// Open may be called more than once; each call reads from the start.
+	Open func() (multipart.File, error)
+	// ObjectKey is where the file already lies in the store, for a finished
+	// resumable upload. Files that are stored as they are get copied from
+	// there instead of uploaded again.
+	ObjectKey string
+}
 
-	// Return a new Uploader instance with all the necessary components.
-	return &Uploader{
-		s3Client:   s3Client,
-		bucketName: bucketName,
-		publicURL:  publicURL,
-	}, nil
+// FormFile wraps a file sent in a multipart form.
+func FormFile(header *multipart.FileHeader) File {
+	return File{Filename: header.Filename, Size: header.Size, Open: header.Open}
 }
 
-// UploadFile takes a file from a request, uploads it to the bucket, and returns its public URL.
-func (u *Uploader) UploadFile(file *multipart.FileHeader) (string, error) {
+// maxFilenameLength bounds the original filename we keep.
+const maxFilenameLength = 255
+
+// UploadFile takes a file from a request, validates and scans it, uploads it
+// to the store, and returns its metadata as an attachment that is not yet
+// saved. Files that fail the checks give an error wrapping ErrFileRejected.
+// Photos are stored without their metadata and with resized renditions.
+// Only the object keys should be stored; see SignedURL.
+func (u *Uploader) UploadFile(file File) (models.Attachment, error) {
+	var attachment models.Attachment
+
+	fileType, err := u.Validate(file)
+	if err != nil {
+		return attachment, err
+	}
+	if err := u.scan(file); err != nil {
+		return attachment, err
+	}
+
 	// Open the file to access its content.
 	src, err := file.Open()
 	if err != nil {
-		return "", err
+		return attachment, err
 	}
 	// 'defer' ensures the file is closed when the function finishes, even if an error occurs.
 	defer src.Close()
 
-	// Create a unique filename to prevent files with the same name from overwriting each other.
-	// We combine the current time in nanoseconds with the original file's extension.
-	// Example: 1678886400123456789.jpg
-	filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), filepath.Ext(file.Filename))
-
-	// Use the S3 client to upload the file.
-	_, err = u.s3Client.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(u.bucketName), // The name of the bucket to upload to.
-		Key:    aws.String(filename),    // The unique name for the file in the bucket.
-		Body:   src,                     // The actual content of the file.
-		ACL:    aws.String("public-read"), // Access Control List: makes the file viewable by anyone with the link.
-	})
+	// Random keys cannot be guessed, unlike the timestamps used before.
+	// Example: evidence/3f9a0c1e5b7d2a4c6e8f0a1b3c5d7e9f.jpg
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return attachment, err
+	}
+	// Validate made sure the extension, if any, matches the content.
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext == "" {
+		ext = fileType.Extensions[0]
+	}
+	base := "evidence/" + hex.EncodeToString(random)
+	key := base + ext
+
+	// The sniffed type, not whatever the client claimed.
+	contentType := fileType.ContentType
+
+	var body io.Reader = src
+	size := file.Size
+	var photo ProcessedImage
+	if fileType.Kind == KindImage {
+		// Photos are at most MaxImageSize, so they can be held in memory.
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return attachment, err
+		}
+		photo, err = u.Images.Process(data, fileType)
+		if err != nil {
+			return attachment, fmt.Errorf("%w: %s: %v", ErrFileRejected, file.Filename, err)
+		}
+		body, size = bytes.NewReader(photo.Data), int64(len(photo.Data))
+	}
+
+	// Hash and count the bytes while they stream to the store.
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(body, hash)}
+	if file.ObjectKey != "" && fileType.Kind != KindImage {
+		// Already stored as it is: read it only for the checksum.
+		if _, err := io.Copy(io.Discard, counter); err != nil {
+			return attachment, err
+		}
+		err = u.Store.Copy(file.ObjectKey, key, contentType)
+	} else {
+		err = u.Store.Put(key, counter, size, contentType)
+	}
+	if err != nil {
+		return attachment, err
+	}
+
+	filename := filepath.Base(strings.ReplaceAll(file.Filename, "\\", "/"))
+	if len(filename) > maxFilenameLength {
+		filename = filename[:maxFilenameLength]
+	}
+	attachment = models.Attachment{
+		ObjectKey:      key,
+		Filename:       filename,
+		ContentType:    contentType,
+		Size:           counter.n,
+		Checksum:       hex.EncodeToString(hash.Sum(nil)),
+		TakenAt:        photo.TakenAt,
+		PhotoLatitude:  photo.Latitude,
+		PhotoLongitude: photo.Longitude,
+	}
+
+	for _, r := range []struct {
+		data []byte
+		key  *string
+		name string
+	}{
+		{photo.Thumbnail, &attachment.ThumbnailKey, "_thumb.jpg"},
+		{photo.Web, &attachment.WebKey, "_web.jpg"},
+	} {
+		if r.data == nil {
+			continue
+		}
+		if err := u.Store.Put(base+r.name, bytes.NewReader(r.data), int64(len(r.data)), "image/jpeg"); err != nil {
+			u.Discard([]models.Attachment{attachment})
+			return models.Attachment{}, err
+		}
+		*r.key = base + r.name
+	}
+	return attachment, nil
+}
+
+// scan passes a file to the malware scanner.
+func (u *Uploader) scan(file File) error {
+	src, err := file.Open()
 	if err != nil {
-		return "", err
+		return err
+	}
+	defer src.Close()
+	if err := u.Scanner.Scan(src); err != nil {
+		if errors.Is(err, ErrInfected) {
+			return fmt.Errorf("%s: %w", file.Filename, err)
+		}
+		return fmt.Errorf("scanning %s: %w", file.Filename, err)
 	}
+	return nil
+}
+
+// CheckFiles enforces the per-request limits before anything is uploaded.
+func (u *Uploader) CheckFiles(files []File) error {
+	if len(files) > u.Limits.MaxFiles {
+		return fmt.Errorf("at most %d files may be uploaded at once", u.Limits.MaxFiles)
+	}
+	for _, file := range files {
+		if file.Size > u.Limits.MaxFileSize {
+			return fmt.Errorf("%s is larger than the %d byte limit", file.Filename, u.Limits.MaxFileSize)
+		}
+	}
+	return nil
+}
+
+// Discard deletes the objects of attachments, with their renditions, that
+// could not be saved or are no longer referenced.
+func (u *Uploader) Discard(attachments []models.Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.ObjectKey, a.ThumbnailKey, a.WebKey} {
+			if key == "" {
+				continue
+			}
+			if err := u.Store.Delete(key); err != nil {
+				fmt.Printf("Failed to delete orphaned object %s: %v\n", key, err)
+			}
+		}
+	}
+}
+
+// SignedURL returns a short-lived URL for the object under key. Callers must
+// have checked that the requester may see it.
+func (u *Uploader) SignedURL(key string) (string, error) {
+	return u.Store.SignedURL(key, u.URLTTL)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	// Construct the full, public URL for the newly uploaded file.
-	// Example: "http://localhost:9000/complaints/1678886400123456789.jpg"
-	fileURL := fmt.Sprintf("%s/%s", u.publicURL, filename)
-	return fileURL, nil
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bytes"
+	"complain/internal/models"
+	"complain/internal/repository"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Webhook events besides the complaint events they share names with.
+const (
+	EventComplaintStatusChanged = "complaint.status_changed"
+	// EventWebhookPing is sent by the test-ping endpoint only.
+	EventWebhookPing = "webhook.ping"
+)
+
+// WebhookEvents describes the events webhooks can subscribe to.
+var WebhookEvents = []struct {
+	Event       string `json:"event"`
+	Description string `json:"description"`
+}{
+	{EventComplaintCreated, "A complaint was filed"},
+	{EventComplaintUpdated, "An update was posted on a complaint, whether or not its status changed"},
+	{EventComplaintStatusChanged, "A complaint's status changed"},
+}
+
+// IsWebhookEvent reports whether event is in WebhookEvents.
+func IsWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers sent with every webhook request.
+const (
+	// WebhookSignatureHeader is "t=<unix time>,v1=<hex HMAC-SHA256>" of
+	// "<unix time>.<body>" keyed with the webhook's secret. Receivers should
+	// check it and reject old timestamps.
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	// WebhookDeliveryHeader is the same for every attempt at one delivery,
+	// so receivers can ignore repeats.
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	// webhookResponseLimit is how much of a response is kept in the log.
+	webhookResponseLimit = 2048
+)
+
+// WebhookComplaint is the complaint as described to webhooks. It leaves out
+// who filed it.
+type WebhookComplaint struct {
+	ID          int64   `json:"id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	CategoryID  int     `json:"category_id"`
+	Status      string  `json:"status"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	IsPublic    bool    `json:"is_public"`
+}
+
+// WebhookData is the outbox payload of a webhook message and the data of
+// the request body. The complaint ID is filled in on delivery.
+type WebhookData struct {
+	Complaint      *WebhookComplaint `json:"complaint,omitempty"`
+	PreviousStatus string            `json:"previous_status,omitempty"`
+	Comment        string            `json:"comment,omitempty"`
+}
+
+// webhookBody is what is posted to a webhook.
+type webhookBody struct {
+	ID         string      `json:"id"`
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       WebhookData `json:"data"`
+}
+
+// ErrPrivateAddress is returned when a webhook would connect to an
+// address that is not public.
+var ErrPrivateAddress = errors.New("address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// IsPrivate leaves out.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublicAddress reports whether webhooks may connect to ip: it is not
+// loopback, private, link-local, multicast or unspecified.
+func IsPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// publicOnly refuses connections to addresses that are not public. It runs
+// on the resolved address, so a host name cannot be pointed at the
+// internal network once the webhook has been checked.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublicAddress(addr.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addr.Addr())
+	}
+	return nil
+}
+
+// newWebhookClient makes the client webhooks are posted with. It does not
+// follow redirects or use a proxy, so every connection is made, and checked
+// by control, here.
+func newWebhookClient(control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: control}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Webhooks tells partner systems about complaint events. Messages for them
+// go through the outbox like emails, so they are retried with backoff.
+type Webhooks struct {
+	Repo   repository.WebhookRepository
+	Client *http.Client
+}
+
+func NewWebhooks(repo repository.WebhookRepository) *Webhooks {
+	return &Webhooks{Repo: repo, Client: newWebhookClient(publicOnly)}
+}
+
+// NewWebhookSecret returns a random secret for signing a webhook's payloads.
+func NewWebhookSecret() (string, error) {
+	secret, _, err := NewOpaqueToken()
+	return secret, err
+}
+
+// SignWebhook returns the WebhookSignatureHeader value for body sent at
+// timestamp.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Messages builds an outbox message for every webhook that wants event
+// about complaint. Complaints not marked public only go to webhooks that
+// asked for them. A complaint that has not been stored yet has no ID; it
+// is filled in when the messages are stored with it.
+func (w *Webhooks) Messages(event string, complaint models.Complaint, previousStatus, comment string) ([]models.OutboxMessage, error) {
+	matching, err := w.Repo.Matching(event, complaint.Category, complaint.Latitude, complaint.Longitude)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []models.Webhook
+	for _, hook := range matching {
+		if complaint.IsPublic || hook.IncludePrivate {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	payload, err := json.Marshal(WebhookData{
+		Complaint: &WebhookComplaint{
+			Title:       complaint.Title,
+			Description: complaint.Description,
+			CategoryID:  complaint.Category,
+			Status:      complaint.Status,
+			Latitude:    complaint.Latitude,
+			Longitude:   complaint.Longitude,
+			IsPublic:    complaint.IsPublic,
+		},
+		PreviousStatus: previousStatus,
+		Comment:        comment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]models.OutboxMessage, 0, len(hooks))
+	for _, hook := range hooks {
+		messages = append(messages, models.OutboxMessage{
+			Kind:      event,
+			Channel:   models.ChannelWebhook,
+			Recipient: strconv.FormatInt(hook.ID, 10),
+			Language:  models.DefaultLanguage,
+			Payload:   payload,
+		})
+	}
+	return messages, nil
+}
+
+// Deliver posts an outbox message to its webhook and logs the attempt.
+// Messages for webhooks that were deleted or disabled are given up on.
+func (w *Webhooks) Deliver(m models.OutboxMessage) error {
+	id, err := strconv.ParseInt(m.Recipient, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: recipient is not a webhook ID", errPermanent)
+	}
+	hook, err := w.Repo.Get(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("%w: webhook deleted", errPermanent)
+	}
+	if err != nil {
+		return err
+	}
+	if !hook.IsActive {
+		return fmt.Errorf("%w: webhook disabled", errPermanent)
+	}
+
+	var data WebhookData
+	if err := json.Unmarshal(m.Payload, &data); err != nil {
+		return fmt.Errorf("%w: bad payload", errPermanent)
+	}
+	// The webhook may have stopped taking private complaints since.
+	if data.Complaint != nil && !data.Complaint.IsPublic && !hook.IncludePrivate {
+		return fmt.Errorf("%w: webhook does not take private complaints", errPermanent)
+	}
+	if data.Complaint != nil && m.ComplaintID != nil {
+		data.Complaint.ID = *m.ComplaintID
+	}
+	delivery, err := w.post(hook, webhookBody{ID: strconv.FormatInt(m.ID, 10), Event: m.Kind, OccurredAt: m.CreatedAt, Data: data}, m.Attempts)
+	delivery.OutboxID = &m.ID
+	if err := w.Repo.RecordDelivery(&delivery); err != nil {
+		fmt.Printf("Failed to log delivery of notification %d to webhook %d: %v\n", m.ID, hook.ID, err)
+	}
+	return err
+}
+
+// Ping posts a webhook.ping event to hook at once, whether or not it is
+// active, and logs the attempt.
+func (w *Webhooks) Ping(hook models.Webhook) (models.WebhookDelivery, error) {
+	now := time.Now()
+	body := webhookBody{ID: fmt.Sprintf("ping-%d", now.UnixNano()), Event: EventWebhookPing, OccurredAt: now}
+	delivery, err := w.post(hook, body, 1)
+	if err := w.Repo.RecordDelivery(&delivery); err != nil {
+		fmt.Printf("Failed to log ping of webhook %d: %v\n", hook.ID, err)
+	}
+	return delivery, err
+}
+
+// post signs and sends body to hook. The returned delivery describes the
+// attempt whether or not it succeeded; any response but 2xx is an error.
+func (w *Webhooks) post(hook models.Webhook, body webhookBody, attempt int) (models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{WebhookID: hook.ID, Event: body.Event, Attempt: attempt}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery, fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(encoded))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery, fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "complain-webhooks/1")
+	req.Header.Set(WebhookEventHeader, body.Event)
+	req.Header.Set(WebhookDeliveryHeader, body.ID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, time.Now().Unix(), encoded))
+
+	start := time.Now()
+	resp, err := w.Client.Do(req)
+	delivery.DurationMS = int(time.Since(start).Milliseconds())
+	if err != nil {
+		delivery.Error = err.Error()
+		if errors.Is(err, ErrPrivateAddress) {
+			return delivery, fmt.Errorf("%w: %v", errPermanent, err)
+		}
+		return delivery, err
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	delivery.ResponseStatus = &resp.StatusCode
+	delivery.ResponseBody = string(response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("webhook responded %s", resp.Status)
+		if location := resp.Header.Get("Location"); location != "" {
+			err = fmt.Errorf("webhook responded %s to %s; redirects are not followed", resp.Status, location)
+		}
+		delivery.Error = err.Error()
+		return delivery, err
+	}
+	return delivery, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"complain/internal/models"
+	"complain/internal/repository"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"100.64.0.1":      false,
+		"169.254.169.254": false,
+		"0.0.0.0":         false,
+		"224.0.0.1":       false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := IsPublicAddress(netip.MustParseAddr(address)); got != public {
+			t.Errorf("IsPublicAddress(%s) = %v, want %v", address, got, public)
+		}
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks(repository.NewMemoryWebhookRepository())
+	_, err := webhooks.Ping(models.Webhook{ID: 1, URL: server.URL, Secret: "secret"})
+	if !errors.Is(err, errPermanent) {
+		t.Fatalf("ping of a loopback address: %v, want a permanent failure", err)
+	}
+	if hits.Load() != 0 {
+		t.Fatal("the loopback server was reached")
+	}
+}
+
+func TestWebhookDoesNotFollowRedirects(t *testing.T) {
+	var followed atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		followed.Store(true)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	webhooks := NewWebhooks(repository.NewMemoryWebhookRepository())
+	// The test server is on loopback, so only the redirect policy is tested.
+	webhooks.Client = newWebhookClient(nil)
+	delivery, err := webhooks.Ping(models.Webhook{ID: 1, URL: server.URL + "/hook", Secret: "secret"})
+	if err == nil || delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Fatalf("ping answered with a redirect: %+v, %v", delivery, err)
+	}
+	if followed.Load() {
+		t.Fatal("the redirect was followed")
+	}
+}
+
+func TestWebhookMessagesLeaveOutPrivateComplaints(t *testing.T) {
+	repo := repository.NewMemoryWebhookRepository()
+	for _, includePrivate := range []bool{false, true} {
+		hook := models.Webhook{URL: "https://example.com/hook", Secret: "secret", IsActive: true,
+			IncludePrivate: includePrivate, Events: []string{EventComplaintCreated}}
+		if err := repo.Create(&hook); err != nil {
+			t.Fatal(err)
+		}
+	}
+	webhooks := NewWebhooks(repo)
+
+	public, err := webhooks.Messages(EventComplaintCreated, models.Complaint{Title: "Pothole", IsPublic: true}, "", "")
+	if err != nil || len(public) != 2 {
+		t.Fatalf("messages about a public complaint: %d, %v", len(public), err)
+	}
+	private, err := webhooks.Messages(EventComplaintCreated, models.Complaint{Title: "Pothole"}, "", "")
+	if err != nil || len(private) != 1 || private[0].Recipient != "2" {
+		t.Fatalf("messages about a private complaint: %+v, %v", private, err)
+	}
+}
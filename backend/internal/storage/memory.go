@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// MemoryStore keeps objects in memory. Everything is lost on restart.
+type MemoryStore struct {
+	signer     *URLSigner
+	objects    map[string]memoryObject
+	multiparts map[string]*memoryMultipart
+	mutex      sync.Mutex
+}
+
+func NewMemoryStore(signer *URLSigner) *MemoryStore {
+	return &MemoryStore{signer: signer, objects: map[string]memoryObject{}, multiparts: map[string]*memoryMultipart{}}
+}
+
+// Keys lists the stored keys in order.
+func (s *MemoryStore) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *MemoryStore) Put(key string, body io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects[key] = memoryObject{
+		data: data,
+		info: ObjectInfo{Key: key, Size: int64(len(data)), ContentType: contentType, LastModified: time.Now()},
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(key string) (io.ReadCloser, ObjectInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info, nil
+}
+
+func (s *MemoryStore) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data := obj.data[offset:]
+	if int64(len(data)) > length {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStore) Copy(src, dst, contentType string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	obj, ok := s.objects[src]
+	if !ok {
+		return ErrNotFound
+	}
+	// Objects are never changed in place, so the copy can share the data.
+	s.objects[dst] = memoryObject{
+		data: obj.data,
+		info: ObjectInfo{Key: dst, Size: obj.info.Size, ContentType: contentType, LastModified: time.Now()},
+	}
+	return nil
+}
+
+func (s *MemoryStore) Stat(key string) (ObjectInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return obj.info, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) SignedURL(key string, ttl time.Duration) (string, error) {
+	return s.signer.Sign(key, ttl), nil
+}
+
+type memoryMultipart struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+func (s *MemoryStore) CreateMultipart(key, contentType string) (string, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.multiparts[id] = &memoryMultipart{key: key, contentType: contentType, parts: map[int][]byte{}}
+	return id, nil
+}
+
+func (s *MemoryStore) UploadPart(key, uploadID string, number int, data []byte) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	upload, ok := s.multiparts[uploadID]
+	if !ok || upload.key != key {
+		return "", ErrUnknownUpload
+	}
+	upload.parts[number] = append([]byte(nil), data...)
+	return partETag(data), nil
+}
+
+func (s *MemoryStore) CompleteMultipart(key, uploadID string, etags []string) error {
+	s.mutex.Lock()
+	upload, ok := s.multiparts[uploadID]
+	if !ok || upload.key != key {
+		s.mutex.Unlock()
+		return ErrUnknownUpload
+	}
+	var data []byte
+	for i, etag := range etags {
+		part, ok := upload.parts[i+1]
+		if !ok || partETag(part) != etag {
+			s.mutex.Unlock()
+			return fmt.Errorf("part %d does not match its ETag", i+1)
+		}
+		data = append(data, part...)
+	}
+	delete(s.multiparts, uploadID)
+	s.mutex.Unlock()
+	return s.Put(key, bytes.NewReader(data), int64(len(data)), upload.contentType)
+}
+
+func (s *MemoryStore) AbortMultipart(key, uploadID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.multiparts, uploadID)
+	return nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// URLSigner creates and checks the time-limited URLs of backends whose
+// objects the API serves itself. A URL carries its expiry and an HMAC over
+// the key and expiry, so it cannot be altered or reused for another object.
+type URLSigner struct {
+	baseURL string
+	secret  []byte
+}
+
+func NewURLSigner(baseURL, secret string) *URLSigner {
+	return &URLSigner{baseURL: baseURL, secret: []byte(secret)}
+}
+
+func (s *URLSigner) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns a URL for key that stops working after ttl, or after
+// MaxURLTTL if that is sooner.
+func (s *URLSigner) Sign(key string, ttl time.Duration) string {
+	expires := time.Now().Add(capTTL(ttl)).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", s.signature(key, expires))
+	return joinURL(s.baseURL, key) + "?" + q.Encode()
+}
+
+// Verify reports whether query holds a valid, unexpired signature for key.
+// Signatures expiring further ahead than Sign allows are not accepted.
+func (s *URLSigner) Verify(key string, query url.Values, now time.Time) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires || expires > now.Add(MaxURLTTL).Unix() {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get("signature")), []byte(s.signature(key, expires)))
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signedQuery signs key and returns the path and query of the URL.
+func signedQuery(t *testing.T, s *URLSigner, key string, ttl time.Duration) (string, url.Values) {
+	t.Helper()
+	u, err := url.Parse(s.Sign(key, ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Path, u.Query()
+}
+
+func TestURLSigner(t *testing.T) {
+	s := NewURLSigner("https://api.example.org/files", "signing-secret")
+	path, query := signedQuery(t, s, "evidence/abc.jpg", 15*time.Minute)
+	if path != "/files/evidence/abc.jpg" {
+		t.Errorf("signed URL path %q", path)
+	}
+	now := time.Now()
+
+	if !s.Verify("evidence/abc.jpg", query, now) {
+		t.Fatal("fresh URL rejected")
+	}
+	if s.Verify("evidence/abc.jpg", query, now.Add(16*time.Minute)) {
+		t.Error("expired URL accepted")
+	}
+	if s.Verify("evidence/abd.jpg", query, now) {
+		t.Error("URL accepted for another key")
+	}
+	if NewURLSigner("https://api.example.org/files", "other-secret").Verify("evidence/abc.jpg", query, now) {
+		t.Error("URL accepted with another secret")
+	}
+
+	for name, change := range map[string]func(url.Values){
+		"tampered signature": func(q url.Values) {
+			sig := []byte(q.Get("signature"))
+			sig[0] ^= 1
+			q.Set("signature", string(sig))
+		},
+		"extended expiry": func(q url.Values) {
+			expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+			q.Set("expires", strconv.FormatInt(expires+3600, 10))
+		},
+		"missing expires":   func(q url.Values) { q.Del("expires") },
+		"missing signature": func(q url.Values) { q.Del("signature") },
+	} {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = append([]string{}, v...)
+		}
+		change(q)
+		if s.Verify("evidence/abc.jpg", q, now) {
+			t.Errorf("%s: URL accepted", name)
+		}
+	}
+}
+
+func TestURLSignerCapsTTL(t *testing.T) {
+	s := NewURLSigner("https://api.example.org/files", "signing-secret")
+	_, query := signedQuery(t, s, "evidence/abc.jpg", 30*24*time.Hour)
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if max := now.Add(MaxURLTTL).Unix(); expires > max {
+		t.Errorf("URL expires in %v, want at most %v", time.Until(time.Unix(expires, 0)), MaxURLTTL)
+	}
+	if s.Verify("evidence/abc.jpg", query, now.Add(MaxURLTTL+time.Minute)) {
+		t.Error("URL accepted past MaxURLTTL")
+	}
+
+	// A URL signed for longer, as before the cap, is not accepted.
+	long := now.Add(30 * 24 * time.Hour).Unix()
+	q := url.Values{"expires": {strconv.FormatInt(long, 10)}, "signature": {s.signature("evidence/abc.jpg", long)}}
+	if s.Verify("evidence/abc.jpg", q, now) {
+		t.Error("URL valid for 30 days accepted")
+	}
+}
@@ -0,0 +1,120 @@
+// Package storage keeps uploaded files in an object store. The backend is
+// chosen by configuration: an S3-compatible service such as MinIO, a local
+// directory, or memory for development and tests.
+package storage
+
+import (
+	"complain/internal/config"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when no object exists under a key.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+type ObjectStore interface {
+	// Put stores body under key, replacing any existing object. size may be
+	// -1 if unknown.
+	Put(key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object for reading; the caller must close it.
+	Get(key string) (io.ReadCloser, ObjectInfo, error)
+	// GetRange opens up to length bytes of the object, starting at offset,
+	// for reading; the caller must close it. offset must be within the
+	// object and length positive.
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	Stat(key string) (ObjectInfo, error)
+	// Copy stores a copy of the object under src as dst, without the data
+	// leaving the store.
+	Copy(src, dst, contentType string) error
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(key string) error
+	// SignedURL returns a URL that grants read access to the object until
+	// ttl, at most MaxURLTTL, has passed. Objects are never publicly
+	// readable otherwise.
+	SignedURL(key string, ttl time.Duration) (string, error)
+
+	// CreateMultipart starts storing an object in numbered parts and returns
+	// the ID of the multipart upload. Every part but the last must be at
+	// least MinPartSize.
+	CreateMultipart(key, contentType string) (string, error)
+	// UploadPart stores part number (counting from 1), replacing any earlier
+	// part with that number, and returns its ETag.
+	UploadPart(key, uploadID string, number int, data []byte) (string, error)
+	// CompleteMultipart joins the parts, given by their ETags in order, into
+	// the object.
+	CompleteMultipart(key, uploadID string, etags []string) error
+	// AbortMultipart discards a multipart upload and its parts.
+	AbortMultipart(key, uploadID string) error
+}
+
+// MaxURLTTL bounds how long a signed URL stays valid, whatever the caller
+// asks for, so a leaked link does not grant access for long.
+const MaxURLTTL = 24 * time.Hour
+
+// capTTL limits ttl to MaxURLTTL.
+func capTTL(ttl time.Duration) time.Duration {
+	if ttl > MaxURLTTL {
+		return MaxURLTTL
+	}
+	return ttl
+}
+
+// MinPartSize is the smallest part S3 accepts in a multipart upload, other
+// than the last.
+const MinPartSize = 5 << 20
+
+// ErrUnknownUpload is returned for multipart uploads that do not exist.
+var ErrUnknownUpload = errors.New("unknown multipart upload")
+
+// New returns the object store selected by cfg.Backend.
+func New(cfg config.StorageConfig) (ObjectStore, error) {
+	switch cfg.Backend {
+	case config.StorageS3:
+		return NewS3Store(cfg)
+	case config.StorageLocal:
+		return NewLocalStore(cfg.LocalDir, NewURLSigner(cfg.PublicURL, cfg.SigningSecret))
+	case config.StorageMemory:
+		return NewMemoryStore(NewURLSigner(cfg.PublicURL, cfg.SigningSecret)), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// partETag is the ETag of a part for the backends that make their own,
+// computed the way S3 does.
+func partETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newUploadID returns a random multipart upload ID.
+func newUploadID() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// joinURL appends key to a base URL.
+func joinURL(base, key string) string {
+	return base + "/" + key
+}
+
+var (
+	_ ObjectStore = (*S3Store)(nil)
+	_ ObjectStore = (*LocalStore)(nil)
+	_ ObjectStore = (*MemoryStore)(nil)
+)